@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ngonx/lib/parsers/nginx"
+)
+
+// Exit codes shared by all subcommands
+const (
+	exitClean    = 0 // Nothing to report
+	exitFindings = 1 // Lint findings, differences or unformatted input
+	exitError    = 2 // Invalid usage or unreadable configuration
+)
+
+// usage describes the available subcommands
+const usage = `usage: ngonx <command> [flags] <file>
+
+commands:
+  parse  [-f tree|json] [-comments] file    print the parsed configuration
+  fmt    [-w] [-check] [-indent N] [-compact] file
+                                            format the configuration
+  lint   [-format text|json] [-openresty] file
+                                            report configuration problems
+  diff   [-u] [-format text|json] old.conf new.conf
+                                            show configuration changes
+  trace  [-method M] [-referer R] [-client ADDR] -url URL file
+                                            show how a request is routed
+
+Use - as the file name to read from stdin. All commands accept
+  -I         inline include directives before processing
+  -p prefix  directory relative include paths resolve against
+`
+
+// commands maps subcommand names to their implementations
+var commands = map[string]func(args []string, stdout, stderr io.Writer) int{
+	"parse": runParse,
+	"fmt":   runFmt,
+	"lint":  runLint,
+	"diff":  runDiff,
+	"trace": runTrace,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run dispatches to a subcommand and returns the process exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitError
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "ngonx: unknown command %q\n\n%s", args[0], usage)
+		return exitError
+	}
+
+	return command(args[1:], stdout, stderr)
+}
+
+// includeFlags holds the include resolution and parsing flags shared by all subcommands
+type includeFlags struct {
+	inline   bool
+	prefix   string
+	template string
+}
+
+// newFlagSet creates a subcommand flag set with the shared include flags
+func newFlagSet(name string, stderr io.Writer) (*flag.FlagSet, *includeFlags) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	includes := &includeFlags{}
+	fs.BoolVar(&includes.inline, "I", false, "inline include directives before processing")
+	fs.StringVar(&includes.prefix, "p", "", "directory relative include paths resolve against (default: directory of the file)")
+	fs.StringVar(&includes.template, "template", "", "template delimiters whose spans are kept verbatim, e.g. \"{{ }}\"")
+
+	return fs, includes
+}
+
+// parseArgs parses flags that may appear before or after positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// loadConfig reads and parses a configuration file, or stdin for "-", returning the raw input as well
+func loadConfig(path string, includes *includeFlags) (*nginx.Config, []byte, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var opts nginx.ParseOptions
+	if includes.template != "" {
+		delimiters := strings.Fields(includes.template)
+		if len(delimiters) != 2 {
+			return nil, nil, fmt.Errorf("-template takes the opening and closing delimiters separated by a space, e.g. \"{{ }}\"")
+		}
+		opts.TemplateDelimiters = [2]string{delimiters[0], delimiters[1]}
+	}
+
+	config, err := nginx.ParseReaderWithOptions(bytes.NewReader(data), path, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if includes.inline {
+		if err := config.InlineIncludes(includes.baseDir(path)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return config, data, nil
+}
+
+// baseDir returns the directory relative include paths resolve against
+func (includes *includeFlags) baseDir(path string) string {
+	if includes.prefix != "" {
+		return includes.prefix
+	}
+	if path == "-" {
+		if cwd, err := os.Getwd(); err == nil {
+			return cwd
+		}
+		return "."
+	}
+	return filepath.Dir(path)
+}
+
+// runParse prints the parsed configuration as a tree or crossplane JSON
+func runParse(args []string, stdout, stderr io.Writer) int {
+	fs, includes := newFlagSet("parse", stderr)
+	format := fs.String("f", "tree", "output format: tree or json")
+	comments := fs.Bool("comments", false, "include comments in json output")
+	files, err := parseArgs(fs, args)
+	if err != nil || len(files) != 1 {
+		return usageError(stderr, "parse", err)
+	}
+
+	config, _, err := loadConfig(files[0], includes)
+	if err != nil {
+		return fail(stderr, err)
+	}
+
+	switch *format {
+	case "tree":
+		config.FprintTree(stdout)
+	case "json":
+		data, err := config.CrossplaneJSON(*comments)
+		if err != nil {
+			return fail(stderr, err)
+		}
+		fmt.Fprintln(stdout, string(data))
+	default:
+		return usageError(stderr, "parse", fmt.Errorf("unknown format %q", *format))
+	}
+
+	return exitClean
+}
+
+// runFmt formats a configuration, writing it back or checking it for CI
+func runFmt(args []string, stdout, stderr io.Writer) int {
+	fs, includes := newFlagSet("fmt", stderr)
+	write := fs.Bool("w", false, "write the formatted configuration back to the file")
+	check := fs.Bool("check", false, "exit with status 1 if the file is not formatted")
+	indent := fs.Int("indent", 4, "number of spaces per nesting level")
+	compact := fs.Bool("compact", false, "write blocks holding a single directive on one line when they fit in 80 columns")
+	files, err := parseArgs(fs, args)
+	if err != nil || len(files) != 1 {
+		return usageError(stderr, "fmt", err)
+	}
+	if *write && (files[0] == "-" || includes.inline) {
+		return usageError(stderr, "fmt", errors.New("-w cannot be used with stdin or -I"))
+	}
+
+	config, original, err := loadConfig(files[0], includes)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	if *indent < 0 {
+		*indent = 0
+	}
+	var formatted string
+	if *compact {
+		formatted = config.Format(nginx.FormatOptions{Indent: strings.Repeat(" ", *indent), CompactShortBlocks: true})
+	} else {
+		formatted = config.AutoIndent(*indent)
+	}
+
+	switch {
+	case *check:
+		if formatted != string(original) {
+			fmt.Fprintf(stderr, "%s is not formatted\n", files[0])
+			return exitFindings
+		}
+	case *write:
+		if formatted != string(original) {
+			// Keep the permissions of the file written back
+			info, err := os.Stat(files[0])
+			if err != nil {
+				return fail(stderr, err)
+			}
+			if err := os.WriteFile(files[0], []byte(formatted), info.Mode().Perm()); err != nil {
+				return fail(stderr, err)
+			}
+		}
+	default:
+		fmt.Fprint(stdout, formatted)
+	}
+
+	return exitClean
+}
+
+// runLint reports lint findings as text or JSON
+func runLint(args []string, stdout, stderr io.Writer) int {
+	fs, includes := newFlagSet("lint", stderr)
+	format := fs.String("format", "text", "output format: text or json")
+	openResty := fs.Bool("openresty", false, "check OpenResty directives")
+	files, err := parseArgs(fs, args)
+	if err != nil || len(files) != 1 {
+		return usageError(stderr, "lint", err)
+	}
+
+	config, _, err := loadConfig(files[0], includes)
+	if err != nil {
+		return fail(stderr, err)
+	}
+
+	opts := nginx.LintOptions{}
+	if *openResty {
+		opts.Registry = nginx.DefaultDirectiveRegistry()
+		nginx.RegisterOpenResty(opts.Registry)
+	}
+	if !includes.inline {
+		opts.BaseDir = includes.baseDir(files[0])
+	}
+	findings := config.Lint(opts)
+
+	switch *format {
+	case "text":
+		for _, finding := range findings {
+			fmt.Fprintf(stdout, "%s: %s [%s]\n", files[0], finding.Error(), finding.Rule)
+		}
+	case "json":
+		if findings == nil {
+			findings = []nginx.ValidationError{}
+		}
+		data, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			return fail(stderr, err)
+		}
+		fmt.Fprintln(stdout, string(data))
+	default:
+		return usageError(stderr, "lint", fmt.Errorf("unknown format %q", *format))
+	}
+
+	if len(findings) > 0 {
+		return exitFindings
+	}
+	return exitClean
+}
+
+// runDiff prints the changes between two configurations
+func runDiff(args []string, stdout, stderr io.Writer) int {
+	fs, includes := newFlagSet("diff", stderr)
+	unified := fs.Bool("u", false, "print a unified diff of the formatted configurations")
+	format := fs.String("format", "text", "output format of the changes: text or json")
+	files, err := parseArgs(fs, args)
+	if err != nil || len(files) != 2 {
+		return usageError(stderr, "diff", err)
+	}
+	if *format != "text" && *format != "json" {
+		return usageError(stderr, "diff", fmt.Errorf("unknown format %q", *format))
+	}
+	if *unified && *format == "json" {
+		return usageError(stderr, "diff", errors.New("-u cannot be used with -format json"))
+	}
+	if files[0] == "-" && files[1] == "-" {
+		return usageError(stderr, "diff", errors.New("only one file can be read from stdin"))
+	}
+
+	before, _, err := loadConfig(files[0], includes)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	after, _, err := loadConfig(files[1], includes)
+	if err != nil {
+		return fail(stderr, err)
+	}
+
+	if *unified {
+		patch := before.UnifiedDiff(after)
+		fmt.Fprint(stdout, patch)
+		if patch != "" {
+			return exitFindings
+		}
+		return exitClean
+	}
+
+	changes := before.Diff(after)
+	if *format == "json" {
+		data, err := nginx.DiffJSON(before, after)
+		if err != nil {
+			return fail(stderr, err)
+		}
+		fmt.Fprintln(stdout, string(data))
+	} else {
+		for _, change := range changes {
+			fmt.Fprintln(stdout, change.String())
+		}
+	}
+
+	if len(changes) > 0 {
+		return exitFindings
+	}
+	return exitClean
+}
+
+// runTrace shows how a request URL is routed through the configuration
+func runTrace(args []string, stdout, stderr io.Writer) int {
+	fs, includes := newFlagSet("trace", stderr)
+	rawURL := fs.String("url", "", "request URL to trace")
+	method := fs.String("method", "GET", "request method to trace")
+	referer := fs.String("referer", "", "Referer header of the traced request")
+	client := fs.String("client", "", "client address checked against allow and deny rules, unix: for a UNIX socket")
+	files, err := parseArgs(fs, args)
+	if err != nil || len(files) != 1 || *rawURL == "" {
+		return usageError(stderr, "trace", err)
+	}
+
+	config, _, err := loadConfig(files[0], includes)
+	if err != nil {
+		return fail(stderr, err)
+	}
+
+	trace, err := config.TraceRequestWithOptions(*rawURL, nginx.TraceOptions{Method: *method, Referer: *referer, ClientAddr: *client})
+	if err != nil {
+		return fail(stderr, err)
+	}
+	for i, step := range trace.Steps {
+		fmt.Fprintf(stdout, "%d. %s\n", i+1, step)
+	}
+
+	if trace.Server == nil || trace.Location == nil {
+		return exitFindings
+	}
+	return exitClean
+}
+
+// usageError reports invalid usage of a subcommand
+func usageError(stderr io.Writer, command string, err error) int {
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(stderr, "ngonx %s: %v\n", command, err)
+	}
+	fmt.Fprint(stderr, usage)
+	return exitError
+}
+
+// fail reports an error that prevented the command from running
+func fail(stderr io.Writer, err error) int {
+	fmt.Fprintf(stderr, "ngonx: %v\n", err)
+	return exitError
+}
@@ -2,4 +2,13 @@ module ngonx
 
 go 1.20
 
-require github.com/davecgh/go-spew v1.1.1 // indirect
+require (
+	github.com/blang/semver/v4 v4.0.0
+	github.com/rogpeppe/go-internal v1.12.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f // indirect
+	golang.org/x/tools v0.1.12 // indirect
+)
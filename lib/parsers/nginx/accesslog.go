@@ -0,0 +1,56 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnableAccessLogBuffer adds buffer=bufferSize flush=flushInterval to every
+// access_log directive writing to a file without a buffer, so entries are written
+// in batches instead of one write per request. Logs that are off, sent to syslog or
+// to files named with variables cannot be buffered and are left alone. As the
+// format comes before the buffer, combined is added to http logs naming none;
+// stream logs must name theirs. An existing flush is kept. Returns the number of
+// directives changed
+func (config *Config) EnableAccessLogBuffer(bufferSize, flushInterval string) (int, error) {
+	if size, err := ParseSize(bufferSize); err != nil || size == 0 {
+		return 0, fmt.Errorf("invalid buffer size %q", bufferSize)
+	}
+	if _, err := ParseDuration(flushInterval); err != nil {
+		return 0, fmt.Errorf("invalid flush interval %q", flushInterval)
+	}
+
+	count := 0
+	config.WalkBlocks(func(block *Block) {
+		stream := block.Name == "stream" || block.Ancestor("stream") != nil
+		for _, line := range block.FindLines("access_log") {
+			if len(line.Params) == 0 {
+				continue
+			}
+			path := unquote(line.Params[0])
+			if path == "off" || strings.HasPrefix(path, "syslog:") || strings.Contains(path, "$") {
+				continue
+			}
+			params := line.KeyValueParams()
+			if _, ok := params["buffer"]; ok {
+				continue
+			}
+
+			if len(line.Params) == 1 {
+				if stream {
+					continue
+				}
+				line.Params = append(line.Params, "combined")
+			}
+			line.Params = append(line.Params, "buffer="+bufferSize)
+			if _, ok := params["flush"]; !ok {
+				line.Params = append(line.Params, "flush="+flushInterval)
+			}
+			count++
+		}
+	})
+	if count > 0 {
+		config.Dirty = true
+	}
+	return count, nil
+}
@@ -0,0 +1,256 @@
+package nginx
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ACLRule is a single allow or deny directive of ngx_http_access_module
+type ACLRule struct {
+	Allow   bool       // Whether the rule allows access
+	Address string     // Address as written: an address, a CIDR network, "unix:" or "all"
+	Network *net.IPNet // Network matched by the rule, nil for "all" and "unix:"
+	Line    *Line      // Directive defining the rule
+}
+
+// Matches reports whether the rule applies to a client address. The zero Addr
+// stands for a client connected over a UNIX socket. Networks only match addresses
+// of their own family, see EvaluateACL for IPv4-mapped IPv6 clients
+func (rule ACLRule) Matches(addr netip.Addr) bool {
+	switch {
+	case rule.Address == "all":
+		return true
+	case rule.Address == "unix:":
+		return !addr.IsValid()
+	case rule.Network == nil || !addr.IsValid():
+		return false
+	}
+	prefix, ok := rulePrefix(rule)
+	return ok && prefix.Addr().Is4() == addr.Is4() && prefix.Contains(addr)
+}
+
+// ACLRules returns the allow and deny rules written directly in the block, in order
+func (block *Block) ACLRules() []ACLRule {
+	var rules []ACLRule
+	for _, line := range block.Lines {
+		if line.Type != LineTypeDirective || (line.Name != "allow" && line.Name != "deny") || len(line.Params) == 0 {
+			continue
+		}
+		rules = append(rules, parseACLRule(line))
+	}
+	return rules
+}
+
+// EffectiveACL returns the rules that apply in the block. Like nginx, a block that
+// has no allow or deny directives of its own inherits all rules of its parent: a
+// location or limit_except block with rules replaces those of the server, which
+// replace those of http (or stream)
+func (block *Block) EffectiveACL() []ACLRule {
+	if acl := block.ACL(); acl != nil {
+		return acl.Rules
+	}
+	return nil
+}
+
+// ACL is the ordered list of allow and deny rules in effect in an http or stream
+// context, as EffectiveACL resolves it
+type ACL struct {
+	Rules []ACLRule // Rules in the order nginx checks them
+	Block *Block    // Block defining the rules
+}
+
+// ACL returns the access rules in effect in the block, nil when none apply and
+// every client is allowed
+func (block *Block) ACL() *ACL {
+	for current := block; current != nil; current = current.ParentRef {
+		if rules := current.ACLRules(); len(rules) > 0 {
+			return &ACL{Rules: rules, Block: current}
+		}
+	}
+	return nil
+}
+
+// Permits reports whether a client address is allowed and the allow or deny
+// directive deciding it, nil when no rule matches and access is allowed. It checks
+// the rules as EvaluateACL does. A nil ACL permits everyone
+func (acl *ACL) Permits(addr netip.Addr) (bool, *Line) {
+	if acl == nil {
+		return true, nil
+	}
+	allowed, rule := EvaluateACL(acl.Rules, addr)
+	if rule == nil {
+		return allowed, nil
+	}
+	return allowed, rule.Line
+}
+
+// rulePrefix returns the network of a rule as a netip.Prefix
+func rulePrefix(rule ACLRule) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(rule.Network.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ones, bits := rule.Network.Mask.Size()
+	if bits == 8*net.IPv4len {
+		addr = addr.Unmap()
+	}
+	return netip.PrefixFrom(addr, ones), true
+}
+
+// EvaluateACL checks a client address against rules in order and returns whether
+// access is allowed and the deciding rule, nil when no rule matches and access is
+// allowed. The zero Addr stands for a client connected over a UNIX socket. Like
+// nginx, an IPv4-mapped IPv6 address is checked as the IPv4 address it maps when
+// there are IPv4 rules, and against the IPv6 rules otherwise
+func EvaluateACL(rules []ACLRule, addr netip.Addr) (bool, *ACLRule) {
+	if addr.Is4In6() && hasIPv4Rules(rules) {
+		addr = addr.Unmap()
+	}
+	for i := range rules {
+		if rules[i].Matches(addr) {
+			return rules[i].Allow, &rules[i]
+		}
+	}
+	return true, nil
+}
+
+// hasIPv4Rules reports whether any rule applies to IPv4 clients
+func hasIPv4Rules(rules []ACLRule) bool {
+	for _, rule := range rules {
+		if rule.Address == "all" || (rule.Network != nil && len(rule.Network.Mask) == net.IPv4len) {
+			return true
+		}
+	}
+	return false
+}
+
+// EvaluateAccess decides whether a request with the given method from addr may access
+// the location, applying the access rules of its limit_except block when the method
+// is not excepted. Returns the deciding rule, nil when no rule matches
+func (location *Location) EvaluateAccess(method string, addr netip.Addr) (bool, *ACLRule) {
+	scope := location.Block
+	if limit := location.LimitExcept(); limit != nil && !limit.Allows(method) {
+		scope = limit.Block
+	}
+	return EvaluateACL(scope.EffectiveACL(), addr)
+}
+
+// parseACLRule parses the address of an allow or deny directive
+func parseACLRule(line *Line) ACLRule {
+	rule := ACLRule{Allow: line.Name == "allow", Address: unquote(line.Params[0]), Line: line}
+
+	switch address := rule.Address; {
+	case address == "all" || address == "unix:":
+	case strings.Contains(address, "/"):
+		if _, network, err := net.ParseCIDR(address); err == nil {
+			rule.Network = network
+		}
+	default:
+		if ip := net.ParseIP(address); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			rule.Network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+	}
+	return rule
+}
+
+// ACLIssue is a problem found in the allow and deny rules of a block
+type ACLIssue struct {
+	ValidationError
+	ShadowedBy *Line // Earlier rule that makes the rule unreachable, nil for other problems
+}
+
+// AnalyzeACL checks the allow and deny rules of the block in order. It flags
+// addresses that do not parse, networks with host bits set, and rules that can never
+// match because an earlier rule covers every address they match
+func (block *Block) AnalyzeACL() []ACLIssue {
+	var issues []ACLIssue
+	var reachable []ACLRule
+
+	for _, rule := range block.ACLRules() {
+		issue := func(severity Severity, shadowedBy *Line, format string, args ...interface{}) {
+			issues = append(issues, ACLIssue{
+				ValidationError: ValidationError{
+					Severity:   severity,
+					Directive:  rule.Line.Name,
+					Message:    fmt.Sprintf(format, args...),
+					LineNumber: rule.Line.LineNumber,
+				},
+				ShadowedBy: shadowedBy,
+			})
+		}
+
+		if rule.Network == nil && rule.Address != "all" && rule.Address != "unix:" {
+			issue(SeverityError, nil, "invalid address %q", rule.Address)
+			continue
+		}
+		if rule.Network != nil && !rule.Network.IP.Equal(networkAddress(rule.Address)) {
+			issue(SeverityWarning, nil, "low address bits of %s are meaningless, the rule matches %s", rule.Address, rule.Network)
+		}
+
+		var shadow *ACLRule
+		for i := range reachable {
+			if coversRule(reachable[i], rule) {
+				shadow = &reachable[i]
+				break
+			}
+		}
+		switch {
+		case shadow == nil:
+			reachable = append(reachable, rule)
+		case shadow.Address == "all" && !shadow.Allow && rule.Allow:
+			issue(SeverityWarning, shadow.Line, "allow %s never applies, deny all on line %d is checked first: nginx uses the first matching rule, move the allow above it", rule.Address, shadow.Line.LineNumber)
+		case shadow.Address == "all":
+			issue(SeverityWarning, shadow.Line, "unreachable, %s all on line %d matches every client first", shadow.Line.Name, shadow.Line.LineNumber)
+		case shadow.Allow == rule.Allow:
+			issue(SeverityWarning, shadow.Line, "redundant, already covered by %s %s on line %d", shadow.Line.Name, shadow.Address, shadow.Line.LineNumber)
+		default:
+			issue(SeverityWarning, shadow.Line, "unreachable, %s %s on line %d matches these clients first", shadow.Line.Name, shadow.Address, shadow.Line.LineNumber)
+		}
+	}
+
+	return issues
+}
+
+// ValidateACL analyzes the allow and deny rules of every block in the configuration
+func (config *Config) ValidateACL() []ValidationIssue {
+	var issues []ValidationIssue
+	config.WalkBlocks(func(block *Block) {
+		for _, issue := range block.AnalyzeACL() {
+			issues = append(issues, issue.ValidationError)
+		}
+	})
+	return issues
+}
+
+// coversRule reports whether every client matched by rule is matched by earlier first
+func coversRule(earlier, rule ACLRule) bool {
+	switch {
+	case earlier.Address == "all":
+		return true
+	case rule.Address == "all":
+		return false
+	case earlier.Address == "unix:" || rule.Address == "unix:":
+		return earlier.Address == rule.Address
+	}
+
+	earlierOnes, earlierBits := earlier.Network.Mask.Size()
+	ruleOnes, ruleBits := rule.Network.Mask.Size()
+	return earlierBits == ruleBits && earlierOnes <= ruleOnes && earlier.Network.Contains(rule.Network.IP)
+}
+
+// networkAddress returns the address part of a rule address as written, normalized like net.IPNet
+func networkAddress(address string) net.IP {
+	host, _, _ := strings.Cut(address, "/")
+	ip := net.ParseIP(host)
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
@@ -0,0 +1,150 @@
+package nginx
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateACL(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		client  string // Empty for a UNIX socket client
+		allowed bool
+		line    int // Line of the deciding rule, 0 if none matches
+	}{
+		{"ipv4 network", "allow 10.0.0.0/8;\ndeny all;", "10.1.2.3", true, 1},
+		{"ipv4 outside network", "allow 10.0.0.0/8;\ndeny all;", "192.168.1.1", false, 2},
+		{"first match wins", "deny 10.1.0.0/16;\nallow 10.0.0.0/8;\ndeny all;", "10.1.2.3", false, 1},
+		{"no rule matches", "deny 10.0.0.0/8;", "192.168.1.1", true, 0},
+		{"ipv6 network", "allow 2001:db8::/32;\ndeny all;", "2001:db8::1", true, 1},
+		{"ipv6 outside network", "allow 2001:db8::/32;\ndeny all;", "2001:db9::1", false, 2},
+		{"ipv6 single address", "deny ::1;\nallow all;", "::1", false, 1},
+		{"ipv4 rule skips ipv6 client", "allow 0.0.0.0/0;\ndeny all;", "2001:db8::1", false, 2},
+		{"ipv6 rule skips ipv4 client", "allow ::/0;\ndeny all;", "10.1.2.3", false, 2},
+		{"4in6 checked as ipv4", "allow 10.0.0.0/8;\ndeny all;", "::ffff:10.1.2.3", true, 1},
+		{"4in6 outside ipv4 network", "allow 10.0.0.0/8;\ndeny all;", "::ffff:192.168.1.1", false, 2},
+		{"4in6 without ipv4 rules", "allow ::ffff:10.0.0.0/104;\ndeny ::/0;", "::ffff:10.1.2.3", true, 1},
+		{"4in6 ipv6 rule with ipv4 rules", "allow ::ffff:10.0.0.0/104;\ndeny 10.0.0.0/8;", "::ffff:10.1.2.3", false, 2},
+		{"unix socket", "allow unix:;\ndeny all;", "", true, 1},
+		{"unix socket skips networks", "allow 0.0.0.0/0;\nallow ::/0;\ndeny all;", "", false, 3},
+		{"unix rule skips ip client", "deny unix:;", "127.0.0.1", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseReader(strings.NewReader(tt.rules), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var addr netip.Addr
+			if tt.client != "" {
+				addr = netip.MustParseAddr(tt.client)
+			}
+
+			allowed, rule := EvaluateACL(config.RootBlock.EffectiveACL(), addr)
+			line := 0
+			if rule != nil {
+				line = rule.Line.LineNumber
+			}
+			if allowed != tt.allowed || line != tt.line {
+				t.Fatalf("EvaluateACL(%s) = %v by line %d, want %v by line %d", tt.client, allowed, line, tt.allowed, tt.line)
+			}
+		})
+	}
+}
+
+func TestEffectiveACLInheritance(t *testing.T) {
+	input := `
+http {
+    deny 192.168.0.0/16;
+    server {
+        location / {
+        }
+        location /admin {
+            allow 2001:db8::/32;
+            deny all;
+            limit_except GET {
+                deny all;
+            }
+        }
+    }
+}
+`
+	config, err := ParseReader(strings.NewReader(input), "test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := config.FindBlocksByName("server")[0]
+
+	root := server.FindLocationByURI("/")
+	if allowed, rule := root.EvaluateAccess("GET", netip.MustParseAddr("::ffff:192.168.1.1")); allowed || rule == nil || rule.Line.LineNumber != 3 {
+		t.Fatalf("location / inherits the http rules, got %v by %+v", allowed, rule)
+	}
+
+	admin := server.FindLocationByURI("/admin")
+	if allowed, _ := admin.EvaluateAccess("GET", netip.MustParseAddr("2001:db8::5")); !allowed {
+		t.Fatal("location /admin replaces the http rules and allows 2001:db8::/32")
+	}
+	if allowed, rule := admin.EvaluateAccess("POST", netip.MustParseAddr("2001:db8::5")); allowed || rule.Line.LineNumber != 11 {
+		t.Fatalf("limit_except denies POST, got %v by %+v", allowed, rule)
+	}
+}
+
+func TestACLPermits(t *testing.T) {
+	input := `
+stream {
+    server {
+        allow 10.0.0.0/8;
+        allow unix:;
+        deny all;
+    }
+    server {
+    }
+}
+`
+	config, err := ParseReader(strings.NewReader(input), "test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := config.FindBlocksByName("server")
+
+	acl := servers[0].ACL()
+	if acl == nil || acl.Block != servers[0] || len(acl.Rules) != 3 {
+		t.Fatalf("unexpected ACL %+v", acl)
+	}
+	tests := []struct {
+		client  string // Empty for a UNIX socket client
+		allowed bool
+		line    int // Line of the deciding rule, 0 if none matches
+	}{
+		{"10.1.2.3", true, 4},
+		{"::ffff:10.1.2.3", true, 4},
+		{"", true, 5},
+		{"192.168.1.1", false, 6},
+		{"2001:db8::1", false, 6},
+	}
+	for _, tt := range tests {
+		var addr netip.Addr
+		if tt.client != "" {
+			addr = netip.MustParseAddr(tt.client)
+		}
+		allowed, rule := acl.Permits(addr)
+		line := 0
+		if rule != nil {
+			line = rule.LineNumber
+		}
+		if allowed != tt.allowed || line != tt.line {
+			t.Errorf("Permits(%s) = %v by line %d, want %v by line %d", tt.client, allowed, line, tt.allowed, tt.line)
+		}
+	}
+
+	if acl := servers[1].ACL(); acl != nil {
+		t.Fatalf("server without rules has ACL %+v", acl)
+	}
+	var none *ACL
+	if allowed, rule := none.Permits(netip.MustParseAddr("192.168.1.1")); !allowed || rule != nil {
+		t.Fatalf("nil ACL decided %v by %+v, want allowed", allowed, rule)
+	}
+}
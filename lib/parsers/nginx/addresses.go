@@ -0,0 +1,89 @@
+package nginx
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// addressDirectives lists the directives whose first parameter is an address
+// CanonicalizeAddresses rewrites
+var addressDirectives = map[string]bool{
+	"listen":           true,
+	"allow":            true,
+	"deny":             true,
+	"set_real_ip_from": true,
+}
+
+// CanonicalizeAddresses rewrites the IP addresses of listen, allow, deny and
+// set_real_ip_from directives in canonical form, so equal addresses compare equal:
+// IPv6 addresses compressed and lowercased (0:0:0:0:0:0:0:1 becomes ::1) and
+// networks reduced to their network address (10.1.2.3/8 becomes 10.0.0.0/8, which
+// nginx uses anyway). IPv4-mapped IPv6 addresses stay IPv6. Ports, host names,
+// unix sockets and the other parameters are left as written, and so are directives
+// an ngonx:keep pragma protects
+func (config *Config) CanonicalizeAddresses() {
+	config.WalkBlocks(func(block *Block) {
+		for _, line := range block.Lines {
+			if line.Type != LineTypeDirective || !addressDirectives[line.Name] || len(line.Params) == 0 || keptLine(block, line) {
+				continue
+			}
+			address := unquote(line.Params[0])
+			canonical := canonicalAddress(address)
+			if line.Name == "listen" {
+				canonical = canonicalListenAddress(address)
+			}
+			if canonical != address {
+				line.Params[0] = canonical
+				config.Dirty = true
+			}
+		}
+	})
+}
+
+// canonicalListenAddress canonicalizes the IP address of a listen address, with or
+// without a port
+func canonicalListenAddress(address string) string {
+	if !strings.HasPrefix(address, "[") {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return canonicalAddress(address)
+		}
+		if canonical := canonicalAddress(host); canonical != host {
+			return net.JoinHostPort(canonical, port)
+		}
+		return address
+	}
+
+	end := strings.Index(address, "]")
+	if end < 0 {
+		return address
+	}
+	return "[" + canonicalAddress(address[1:end]) + "]" + address[end+1:]
+}
+
+// canonicalAddress returns the canonical form of an IP address or CIDR network,
+// anything else unchanged
+func canonicalAddress(address string) string {
+	if ip, _, ok := strings.Cut(address, "/"); ok {
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return address
+		}
+		ones, _ := network.Mask.Size()
+		return canonicalIP(network.IP, ip) + "/" + strconv.Itoa(ones)
+	}
+	if ip := net.ParseIP(address); ip != nil {
+		return canonicalIP(ip, address)
+	}
+	return address
+}
+
+// canonicalIP formats an IP address, keeping IPv4-mapped IPv6 addresses in IPv6
+// notation as net.IP prints them as IPv4
+func canonicalIP(ip net.IP, written string) string {
+	if v4 := ip.To4(); v4 != nil && strings.Contains(written, ":") {
+		return "::ffff:" + v4.String()
+	}
+	return ip.String()
+}
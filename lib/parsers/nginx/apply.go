@@ -0,0 +1,598 @@
+package nginx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of ApplyOptions
+const (
+	defaultBackupSuffix = ".bak"
+	defaultVerifyDelay  = time.Second
+	healthCheckTimeout  = 10 * time.Second
+)
+
+// Names of the steps of Apply, in the order they run
+const (
+	ApplyStepValidate = "validate" // Native checks of the configuration
+	ApplyStepTest     = "test"     // nginx -t against a staged copy
+	ApplyStepWrite    = "write"    // Atomic writes with backups
+	ApplyStepSignal   = "signal"   // Reload of the running nginx
+	ApplyStepVerify   = "verify"   // Master process check and health probe
+	ApplyStepRollback = "rollback" // Restore of the previous files and reload
+)
+
+// ApplyOptions configures Apply
+type ApplyOptions struct {
+	Fragments []*Config // Further files written along, such as included server files, each to its FilePath
+	DryRun    bool      // Validate and report what would be written and signaled, without writing or signaling
+
+	SkipValidation bool   // Skip the native checks, lint findings of error severity otherwise abort
+	Test           bool   // Run nginx -t against a staged copy of the configuration directory
+	NginxBinary    string // Binary run for nginx -t and nginx -s reload, nginx if empty
+	BackupSuffix   string // Appended to the path of replaced files to back them up, .bak if empty
+
+	// Reload reloads nginx, for setups where this process cannot signal it such as
+	// containers. Otherwise the master process of PIDFile is sent SIGHUP, or nginx
+	// -s reload runs when there is no pid file
+	Reload  func() error
+	PIDFile string // pid file of the master process, the pid directive of the configuration if empty
+
+	VerifyDelay    time.Duration // Wait before verifying the reload, one second if zero
+	HealthCheckURL string        // Probed after the reload, which fails unless it answers below 400
+}
+
+// ApplyStep is a step of Apply with its timing and outcome
+type ApplyStep struct {
+	Name     string
+	Started  time.Time
+	Duration time.Duration
+	OK       bool
+	Detail   string // What the step did or found
+	Err      error  // Why the step failed, nil if it succeeded
+}
+
+// ApplyFile is a file written by Apply
+type ApplyFile struct {
+	Path    string // File path
+	Backup  string // Copy of the previous content, empty for new files
+	Created bool   // The file did not exist before
+	Content string // Content written, or that would be in a dry run
+}
+
+// ApplyReport records what Apply did, step by step
+type ApplyReport struct {
+	DryRun     bool
+	Steps      []ApplyStep
+	Files      []ApplyFile
+	Signal     string // Process signaled or how nginx is reloaded, e.g. "SIGHUP to master process 1234"
+	RolledBack bool   // The previous files were restored after a failure
+}
+
+// String lists the steps with their timing and outcome
+func (report *ApplyReport) String() string {
+	var builder strings.Builder
+	if report.DryRun {
+		builder.WriteString("dry run, nothing was written or signaled\n")
+	}
+	for _, step := range report.Steps {
+		status := "ok"
+		if !step.OK {
+			status = "failed"
+		}
+		detail := step.Detail
+		switch {
+		case step.Err != nil && detail == "":
+			detail = step.Err.Error()
+		case step.Err != nil:
+			detail += ": " + step.Err.Error()
+		}
+		fmt.Fprintf(&builder, "%-8s %-6s %8s  %s", step.Name, status, step.Duration.Round(time.Millisecond), detail)
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// step runs a step of Apply and records it in the report
+func (report *ApplyReport) step(name string, run func() (string, error)) error {
+	started := time.Now()
+	detail, err := run()
+	report.Steps = append(report.Steps, ApplyStep{
+		Name:     name,
+		Started:  started,
+		Duration: time.Since(started),
+		OK:       err == nil,
+		Detail:   detail,
+		Err:      err,
+	})
+	return err
+}
+
+// Apply deploys a configuration to the running nginx: the configuration and
+// opts.Fragments are validated (natively, and with nginx -t against a staged copy
+// if opts.Test is set), written atomically next to backups of the files they
+// replace, and nginx is reloaded. The reload is verified by checking the master
+// process is still running and probing opts.HealthCheckURL; if it or the reload
+// fails, the previous files are restored and nginx is reloaded again. nginx keeps
+// its old configuration when a reload fails, so the master check alone does not
+// prove the new one is loaded, the health probe does.
+//
+// Every step is recorded in the report, which is returned along with the error of
+// the step that failed. In a dry run the report lists the files with the content
+// that would be written and the process that would be signaled
+func Apply(config *Config, opts ApplyOptions) (*ApplyReport, error) {
+	if opts.NginxBinary == "" {
+		opts.NginxBinary = "nginx"
+	}
+	if opts.BackupSuffix == "" {
+		opts.BackupSuffix = defaultBackupSuffix
+	}
+	if opts.VerifyDelay <= 0 {
+		opts.VerifyDelay = defaultVerifyDelay
+	}
+
+	report := &ApplyReport{DryRun: opts.DryRun}
+	configs := append([]*Config{config}, opts.Fragments...)
+	for _, file := range configs {
+		if file.FilePath == "" {
+			return report, errors.New("configuration to apply has no file path")
+		}
+		report.Files = append(report.Files, ApplyFile{Path: file.FilePath, Content: file.String()})
+	}
+
+	if !opts.SkipValidation {
+		if err := report.step(ApplyStepValidate, func() (string, error) { return validateForApply(configs) }); err != nil {
+			return report, err
+		}
+	}
+	if opts.Test {
+		if err := report.step(ApplyStepTest, func() (string, error) { return testStaged(config, report.Files, opts.NginxBinary) }); err != nil {
+			return report, err
+		}
+	}
+
+	reloader := newApplyReloader(config, opts)
+	report.Signal = reloader.describe()
+	if opts.DryRun {
+		for i := range report.Files {
+			file := &report.Files[i]
+			if _, err := os.Stat(file.Path); os.IsNotExist(err) {
+				file.Created = true
+			} else {
+				file.Backup = file.Path + opts.BackupSuffix
+			}
+		}
+		return report, nil
+	}
+
+	signaled := false
+	err := report.step(ApplyStepWrite, func() (string, error) {
+		return writeForApply(report.Files, opts.BackupSuffix)
+	})
+	if err == nil {
+		signaled = true
+		err = report.step(ApplyStepSignal, reloader.reload)
+	}
+	if err == nil {
+		err = report.step(ApplyStepVerify, func() (string, error) { return reloader.verify(opts) })
+	}
+	if err == nil {
+		return report, nil
+	}
+
+	report.step(ApplyStepRollback, func() (string, error) {
+		detail, rollbackErr := rollbackFiles(report.Files)
+		if rollbackErr != nil {
+			return detail, rollbackErr
+		}
+		report.RolledBack = true
+		if !signaled {
+			return detail, nil
+		}
+		if _, reloadErr := reloader.reload(); reloadErr != nil {
+			return detail, fmt.Errorf("files restored but the reload failed: %v", reloadErr)
+		}
+		return detail + ", nginx reloaded", nil
+	})
+	return report, err
+}
+
+// validateForApply runs the lint rules on the files to apply, failing on findings
+// of error severity
+func validateForApply(configs []*Config) (string, error) {
+	var errorsFound []string
+	warnings := 0
+	for _, config := range configs {
+		for _, finding := range config.Lint(LintOptions{BaseDir: filepath.Dir(config.FilePath)}) {
+			switch finding.Severity {
+			case SeverityError:
+				errorsFound = append(errorsFound, fmt.Sprintf("%s: %v", config.FilePath, finding))
+			case SeverityWarning:
+				warnings++
+			}
+		}
+	}
+	detail := fmt.Sprintf("%d files checked, %d warnings", len(configs), warnings)
+	if len(errorsFound) > 0 {
+		return detail, fmt.Errorf("%d errors:\n%s", len(errorsFound), strings.Join(errorsFound, "\n"))
+	}
+	return detail, nil
+}
+
+// testStaged copies the directory of the configuration to a temporary one, puts
+// the files to apply in place and runs nginx -t on the copy. Absolute includes of
+// files in the directory are pointed at their staged copies. Files to apply outside
+// the directory cannot be staged, the test reads their current version and the
+// detail says so
+func testStaged(config *Config, files []ApplyFile, binary string) (string, error) {
+	confDir, err := filepath.Abs(filepath.Dir(config.FilePath))
+	if err != nil {
+		return "", err
+	}
+	staging, err := os.MkdirTemp("", "ngonx-apply-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+
+	if err := copyTree(confDir, staging); err != nil {
+		return "", fmt.Errorf("staging %s: %v", confDir, err)
+	}
+	var unstaged []string
+	for _, file := range files {
+		path, err := filepath.Abs(file.Path)
+		if err != nil {
+			return "", err
+		}
+		rel, ok := relativeTo(confDir, path)
+		if !ok {
+			unstaged = append(unstaged, file.Path)
+			continue
+		}
+		target := filepath.Join(staging, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return "", err
+		}
+		// A staged file may be a link to a live file outside the directory
+		os.Remove(target)
+		if err := os.WriteFile(target, []byte(file.Content), 0o644); err != nil {
+			return "", err
+		}
+	}
+
+	main := filepath.Join(staging, filepath.Base(config.FilePath))
+	if err := stageIncludes(main, confDir, staging, map[string]bool{}); err != nil {
+		return "", fmt.Errorf("staging includes: %v", err)
+	}
+	output, err := exec.Command(binary, "-t", "-c", main).CombinedOutput()
+	detail := fmt.Sprintf("%s -t on a staged copy of %s", binary, confDir)
+	if len(unstaged) > 0 {
+		detail += fmt.Sprintf(", current version used for %s outside it", strings.Join(unstaged, ", "))
+	}
+	if err != nil {
+		return detail, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return detail, nil
+}
+
+// stageIncludes rewrites the absolute includes of files in confDir found in a
+// staged configuration file to their staged copies, then does the same in the
+// staged files it includes. Relative includes resolve against the staging
+// directory already, nginx reads them relative to the directory of the main file
+func stageIncludes(path, confDir, staging string, seen map[string]bool) error {
+	if seen[path] {
+		return nil
+	}
+	seen[path] = true
+
+	config, err := ParseConfigWithOptions(path, ParseOptions{Lossless: true})
+	if err != nil {
+		return err
+	}
+	modified := false
+	var included []string
+	for _, line := range config.FindLinesByName("include") {
+		if len(line.Params) == 0 {
+			continue
+		}
+		pattern := filepath.FromSlash(resolveIncludePath(confDir, unquote(line.Params[0])))
+		rel, ok := relativeTo(confDir, pattern)
+		if !ok {
+			continue
+		}
+		staged := filepath.Join(staging, rel)
+		if isAbsConfigPath(unquote(line.Params[0])) {
+			line.Params[0] = formatParam(filepath.ToSlash(staged))
+			modified = true
+		}
+		matches := []string{staged}
+		if isGlobPattern(staged) {
+			if matches, err = filepath.Glob(staged); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		included = append(included, matches...)
+	}
+	if modified {
+		if err := os.WriteFile(path, []byte(config.String()), 0o644); err != nil {
+			return err
+		}
+	}
+
+	stagingDir, err := filepath.EvalSymlinks(staging)
+	if err != nil {
+		return err
+	}
+	for _, file := range included {
+		// Missing files are for nginx -t to report. Links to live files outside the
+		// staging directory are read as they are, never written to
+		resolved, err := filepath.EvalSymlinks(file)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(resolved); err != nil || info.IsDir() {
+			continue
+		}
+		if _, ok := relativeTo(stagingDir, resolved); !ok {
+			continue
+		}
+		if err := stageIncludes(resolved, confDir, staging, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relativeTo returns the path of a file relative to dir, false when it is outside
+func relativeTo(dir, path string) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// copyTree copies the regular files and directories under src to dst. Symbolic
+// links are recreated, those pointing into src pointing at the copy, so a
+// sites-enabled directory of links to sites-available sees the staged files
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(filepath.Dir(path), link)
+			}
+			if linkRel, ok := relativeTo(src, link); ok {
+				link = filepath.Join(dst, linkRel)
+			}
+			return os.Symlink(link, target)
+		case entry.Type().IsRegular():
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, data, 0o644)
+		}
+		return nil
+	})
+}
+
+// writeForApply backs up and replaces each file. A file is written to a temporary
+// file in its directory and renamed over the original, so nginx never reads it half
+// written. Files written before a failure are recorded for the rollback
+func writeForApply(files []ApplyFile, backupSuffix string) (string, error) {
+	for i := range files {
+		file := &files[i]
+		mode := os.FileMode(0o644)
+		info, err := os.Stat(file.Path)
+		switch {
+		case os.IsNotExist(err):
+			file.Created = true
+		case err != nil:
+			return fmt.Sprintf("%d of %d files written", i, len(files)), err
+		default:
+			mode = info.Mode().Perm()
+			file.Backup = file.Path + backupSuffix
+			if err := copyFile(file.Path, file.Backup, mode); err != nil {
+				file.Backup = ""
+				return fmt.Sprintf("%d of %d files written", i, len(files)), fmt.Errorf("backing up %s: %v", file.Path, err)
+			}
+		}
+		if err := writeFileAtomic(file.Path, []byte(file.Content), mode); err != nil {
+			return fmt.Sprintf("%d of %d files written", i, len(files)), err
+		}
+	}
+	return fmt.Sprintf("%d files written", len(files)), nil
+}
+
+// writeFileAtomic replaces a file through a temporary file renamed over it
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// copyFile copies a file with the given permissions
+func copyFile(src, dst string, mode os.FileMode) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, data, mode)
+}
+
+// rollbackFiles restores the backups of the files written and removes those created
+func rollbackFiles(files []ApplyFile) (string, error) {
+	restored, removed := 0, 0
+	var failures []string
+	for _, file := range files {
+		switch {
+		case file.Created:
+			if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+				failures = append(failures, err.Error())
+				continue
+			}
+			removed++
+		case file.Backup != "":
+			info, err := os.Stat(file.Backup)
+			if err == nil {
+				err = copyFile(file.Backup, file.Path, info.Mode().Perm())
+			}
+			if err != nil {
+				failures = append(failures, err.Error())
+				continue
+			}
+			restored++
+		}
+	}
+	detail := fmt.Sprintf("%d files restored, %d new files removed", restored, removed)
+	if len(failures) > 0 {
+		return detail, errors.New(strings.Join(failures, "; "))
+	}
+	return detail, nil
+}
+
+// applyReloader reloads nginx the way the options ask
+type applyReloader struct {
+	config  *Config
+	binary  string
+	custom  func() error
+	pidFile string
+}
+
+// newApplyReloader picks how to reload nginx: the caller function, SIGHUP to the
+// master process of the pid file, or nginx -s reload
+func newApplyReloader(config *Config, opts ApplyOptions) *applyReloader {
+	reloader := &applyReloader{config: config, binary: opts.NginxBinary, custom: opts.Reload, pidFile: opts.PIDFile}
+	if reloader.pidFile == "" {
+		if pid := config.Main().PID; pid != "" {
+			reloader.pidFile = resolveIncludePath(filepath.Dir(config.FilePath), pid)
+		}
+	}
+	return reloader
+}
+
+// pid reads the process ID of the master process from the pid file
+func (reloader *applyReloader) pid() (int, error) {
+	if reloader.pidFile == "" {
+		return 0, errors.New("no pid file")
+	}
+	data, err := os.ReadFile(reloader.pidFile)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("pid file %s does not hold a process ID", reloader.pidFile)
+	}
+	return pid, nil
+}
+
+// describe tells which process would be signaled, or how nginx would be reloaded
+func (reloader *applyReloader) describe() string {
+	if reloader.custom != nil {
+		return "reload function supplied by the caller"
+	}
+	if pid, err := reloader.pid(); err == nil {
+		return fmt.Sprintf("SIGHUP to master process %d (pid file %s)", pid, reloader.pidFile)
+	}
+	return reloader.reloadCommand().String()
+}
+
+// reloadCommand returns nginx -s reload for the configuration
+func (reloader *applyReloader) reloadCommand() *exec.Cmd {
+	path, err := filepath.Abs(reloader.config.FilePath)
+	if err != nil {
+		path = reloader.config.FilePath
+	}
+	return exec.Command(reloader.binary, "-s", "reload", "-c", path)
+}
+
+// reload reloads nginx
+func (reloader *applyReloader) reload() (string, error) {
+	if reloader.custom != nil {
+		return "reload function called", reloader.custom()
+	}
+	if pid, err := reloader.pid(); err == nil {
+		return fmt.Sprintf("SIGHUP sent to master process %d", pid), signalReload(pid)
+	}
+
+	command := reloader.reloadCommand()
+	var output bytes.Buffer
+	command.Stdout, command.Stderr = &output, &output
+	if err := command.Run(); err != nil {
+		return command.String(), fmt.Errorf("%v: %s", err, strings.TrimSpace(output.String()))
+	}
+	return command.String(), nil
+}
+
+// verify waits for the reload to take effect, then checks that the master
+// process is still running and that the health check URL answers
+func (reloader *applyReloader) verify(opts ApplyOptions) (string, error) {
+	time.Sleep(opts.VerifyDelay)
+
+	var checks []string
+	if pid, err := reloader.pid(); err == nil {
+		if !processAlive(pid) {
+			return "", fmt.Errorf("master process %d is no longer running", pid)
+		}
+		checks = append(checks, fmt.Sprintf("master process %d running", pid))
+	}
+	if opts.HealthCheckURL != "" {
+		client := &http.Client{Timeout: healthCheckTimeout}
+		response, err := client.Get(opts.HealthCheckURL)
+		if err != nil {
+			return strings.Join(checks, ", "), fmt.Errorf("health check %s: %v", opts.HealthCheckURL, err)
+		}
+		response.Body.Close()
+		if response.StatusCode >= 400 {
+			return strings.Join(checks, ", "), fmt.Errorf("health check %s answered %s", opts.HealthCheckURL, response.Status)
+		}
+		checks = append(checks, fmt.Sprintf("%s answered %s", opts.HealthCheckURL, response.Status))
+	}
+	if len(checks) == 0 {
+		return "nothing to verify without a pid file or health check URL", nil
+	}
+	return strings.Join(checks, ", "), nil
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package nginx
+
+import "errors"
+
+// signalReload fails, signals are not available on this platform; use
+// ApplyOptions.Reload or nginx -s reload
+func signalReload(pid int) error {
+	return errors.New("cannot signal processes on this platform")
+}
+
+// processAlive reports whether a process is running, assumed true here
+func processAlive(pid int) bool {
+	return true
+}
@@ -0,0 +1,277 @@
+package nginx
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// applyFixture writes nginx.conf to a temporary directory and returns a new
+// version of it to apply, with a fragment for a new file next to it
+func applyFixture(t *testing.T) (dir string, config, fragment *Config) {
+	t.Helper()
+	dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "nginx.conf"), []byte("events {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ParseReader(strings.NewReader("events {}\nhttp { include site.conf; }\n"), filepath.Join(dir, "nginx.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragment, err = ParseReader(strings.NewReader("server { listen 80; }\n"), filepath.Join(dir, "site.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, config, fragment
+}
+
+// readFile returns the content of a file, "<missing>" if it does not exist
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "<missing>"
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// stepNames lists the names of the steps of a report with their outcome
+func stepNames(report *ApplyReport) string {
+	var names []string
+	for _, step := range report.Steps {
+		status := "ok"
+		if !step.OK {
+			status = "failed"
+		}
+		names = append(names, step.Name+" "+status)
+	}
+	return strings.Join(names, ", ")
+}
+
+func TestApply(t *testing.T) {
+	dir, config, fragment := applyFixture(t)
+	reloads := 0
+
+	report, err := Apply(config, ApplyOptions{
+		Fragments:      []*Config{fragment},
+		SkipValidation: true,
+		Reload:         func() error { reloads++; return nil },
+		VerifyDelay:    time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("%v\n%s", err, report)
+	}
+	if got, want := stepNames(report), "write ok, signal ok, verify ok"; got != want {
+		t.Fatalf("steps %s, want %s", got, want)
+	}
+	if reloads != 1 {
+		t.Fatalf("reloaded %d times, want once", reloads)
+	}
+	if got := readFile(t, filepath.Join(dir, "nginx.conf")); got != config.String() {
+		t.Fatalf("nginx.conf holds %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "nginx.conf.bak")); got != "events {}\n" {
+		t.Fatalf("backup holds %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "site.conf")); got != fragment.String() {
+		t.Fatalf("site.conf holds %q", got)
+	}
+	info, err := os.Stat(filepath.Join(dir, "nginx.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("nginx.conf mode %v, want the original 0600", info.Mode().Perm())
+	}
+}
+
+func TestApplyWriteFailureRollsBack(t *testing.T) {
+	dir, config, fragment := applyFixture(t)
+	// The directory of the last file does not exist, its write fails after the
+	// others were written
+	broken, err := ParseReader(strings.NewReader("server { listen 81; }\n"), filepath.Join(dir, "missing", "other.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := 0
+
+	report, err := Apply(config, ApplyOptions{
+		Fragments:      []*Config{fragment, broken},
+		SkipValidation: true,
+		Reload:         func() error { reloads++; return nil },
+		VerifyDelay:    time.Millisecond,
+	})
+	if err == nil {
+		t.Fatalf("expected the write to fail\n%s", report)
+	}
+	if got, want := stepNames(report), "write failed, rollback ok"; got != want {
+		t.Fatalf("steps %s, want %s", got, want)
+	}
+	if !report.RolledBack {
+		t.Fatal("report does not record the rollback")
+	}
+	if reloads != 0 {
+		t.Fatalf("reloaded %d times, nginx was never signaled and needs no reload", reloads)
+	}
+	if got := readFile(t, filepath.Join(dir, "nginx.conf")); got != "events {}\n" {
+		t.Fatalf("nginx.conf not restored, holds %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "site.conf")); got != "<missing>" {
+		t.Fatalf("site.conf created by the failed apply was left behind: %q", got)
+	}
+}
+
+func TestApplyReloadFailureRollsBack(t *testing.T) {
+	dir, config, fragment := applyFixture(t)
+	var reloaded []string
+
+	report, err := Apply(config, ApplyOptions{
+		Fragments:      []*Config{fragment},
+		SkipValidation: true,
+		Reload: func() error {
+			// Record which configuration each reload would load
+			reloaded = append(reloaded, readFile(t, filepath.Join(dir, "nginx.conf")))
+			if len(reloaded) == 1 {
+				return errors.New("reload refused")
+			}
+			return nil
+		},
+		VerifyDelay: time.Millisecond,
+	})
+	if err == nil || !strings.Contains(err.Error(), "reload refused") {
+		t.Fatalf("got error %v, want the reload failure\n%s", err, report)
+	}
+	if got, want := stepNames(report), "write ok, signal failed, rollback ok"; got != want {
+		t.Fatalf("steps %s, want %s", got, want)
+	}
+	if want := []string{config.String(), "events {}\n"}; strings.Join(reloaded, "|") != strings.Join(want, "|") {
+		t.Fatalf("reloads saw %q, want the new configuration then the restored one", reloaded)
+	}
+	if rollback := report.Steps[len(report.Steps)-1]; !strings.Contains(rollback.Detail, "nginx reloaded") {
+		t.Fatalf("rollback detail %q does not mention the second reload", rollback.Detail)
+	}
+	if got := readFile(t, filepath.Join(dir, "site.conf")); got != "<missing>" {
+		t.Fatalf("site.conf was left behind: %q", got)
+	}
+}
+
+func TestApplyDryRun(t *testing.T) {
+	dir, config, fragment := applyFixture(t)
+	reloads := 0
+
+	report, err := Apply(config, ApplyOptions{
+		Fragments:      []*Config{fragment},
+		DryRun:         true,
+		SkipValidation: true,
+		Reload:         func() error { reloads++; return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || len(report.Steps) != 0 || reloads != 0 {
+		t.Fatalf("dry run ran steps or reloaded:\n%s", report)
+	}
+	if report.Signal != "reload function supplied by the caller" {
+		t.Fatalf("signal %q", report.Signal)
+	}
+
+	want := []ApplyFile{
+		{Path: filepath.Join(dir, "nginx.conf"), Backup: filepath.Join(dir, "nginx.conf.bak"), Content: config.String()},
+		{Path: filepath.Join(dir, "site.conf"), Created: true, Content: fragment.String()},
+	}
+	if len(report.Files) != len(want) {
+		t.Fatalf("got %d files, want %d", len(report.Files), len(want))
+	}
+	for i, file := range report.Files {
+		if file != want[i] {
+			t.Fatalf("file %d = %+v, want %+v", i, file, want[i])
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || readFile(t, filepath.Join(dir, "nginx.conf")) != "events {}\n" {
+		t.Fatalf("dry run touched the directory: %v", entries)
+	}
+}
+
+func TestApplyTestStaged(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake nginx binary is a shell script")
+	}
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"nginx.conf":                 "events {}\n",
+		"sites-available/site.conf":  "server { listen 80; }\n",
+		"sites-available/other.conf": "server { listen 81; }\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("sites-available", filepath.Join(dir, "sites-enabled")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake nginx -t records the main file it is given and the files its
+	// include reads, then fails if asked to
+	capture := filepath.Join(t.TempDir(), "capture")
+	binary := filepath.Join(t.TempDir(), "nginx")
+	script := "#!/bin/sh\n" +
+		"include=$(sed -n 's/.*include \\(.*\\);.*/\\1/p' \"$3\")\n" +
+		"{ echo \"main $3\"; echo \"include $include\"; cat $include; } > " + capture + "\n" +
+		"[ ! -e " + filepath.Join(dir, "fail") + " ]\n"
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ParseReader(strings.NewReader("events {}\nhttp { include "+dir+"/sites-enabled/*.conf; }\n"), filepath.Join(dir, "nginx.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragment, err := ParseReader(strings.NewReader("server { listen 8080; }\n"), filepath.Join(dir, "sites-available", "site.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := ApplyOptions{Fragments: []*Config{fragment}, DryRun: true, SkipValidation: true, Test: true, NginxBinary: binary}
+
+	report, err := Apply(config, opts)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, report)
+	}
+	lines := strings.Split(readFile(t, capture), "\n")
+	main := strings.TrimPrefix(lines[0], "main ")
+	staging := filepath.Dir(main)
+	if staging == dir || !strings.HasPrefix(lines[1], "include "+filepath.ToSlash(staging)+"/sites-enabled/") {
+		t.Fatalf("include not pointed at the staged copy:\n%s", readFile(t, capture))
+	}
+	if got, want := strings.Join(lines[2:], "\n"), "server { listen 81; }\nserver {\n    listen 8080;\n}\n"; got != want {
+		t.Fatalf("staged includes hold\n%s\nwant the applied fragment\n%s", got, want)
+	}
+	if got := readFile(t, filepath.Join(dir, "sites-available", "site.conf")); got != "server { listen 80; }\n" {
+		t.Fatalf("the test wrote to the live file: %q", got)
+	}
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Fatalf("staging directory %s left behind", staging)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "fail"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := Apply(config, opts); err == nil || stepNames(report) != "test failed" {
+		t.Fatalf("got error %v after steps %s, want the test to fail", err, stepNames(report))
+	}
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package nginx
+
+import "syscall"
+
+// signalReload sends SIGHUP to the nginx master process, which reloads its configuration
+func signalReload(pid int) error {
+	return syscall.Kill(pid, syscall.SIGHUP)
+}
+
+// processAlive reports whether a process is running
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
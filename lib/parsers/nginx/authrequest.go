@@ -0,0 +1,172 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// authResponseHandlers lists the directives that let an auth_request location answer
+// the subrequest with a status
+var authResponseHandlers = []string{"return", "proxy_pass", "fastcgi_pass", "uwsgi_pass", "scgi_pass", "grpc_pass"}
+
+// AuthRequestVariable is a variable set from the auth subrequest by auth_request_set
+type AuthRequestVariable struct {
+	Name  string // Variable set, without the $
+	Value string // Value expression, e.g. $upstream_http_x_user
+	Line  *Line  // Underlying auth_request_set directive
+}
+
+// AuthRequest describes the auth_request subrequest guarding a block: the location
+// it is sent to, what answers it and the variables captured from its response
+type AuthRequest struct {
+	Line      *Line                 // auth_request directive in effect
+	Scope     *Block                // Protected block
+	Server    *Block                // Server the subrequest is resolved in, nil if the scope is outside one
+	URI       string                // Subrequest URI
+	Target    *Location             // Location handling the subrequest, nil if none matches
+	Handler   *Line                 // Directive answering the subrequest in the target, nil if it has none
+	Variables []AuthRequestVariable // Variables set from the subrequest response
+}
+
+// AuthRequest returns the auth_request guarding the block, nil if none is in effect
+// or it is turned off. auth_request_set directives are inherited as a whole from
+// the closest block defining any
+func (block *Block) AuthRequest() *AuthRequest {
+	line := block.EffectiveDirective("auth_request")
+	if line == nil || len(line.Params) == 0 || unquote(line.Params[0]) == "off" {
+		return nil
+	}
+
+	auth := &AuthRequest{Line: line, Scope: block, URI: unquote(line.Params[0]), Server: block}
+	if block.Name != "server" {
+		auth.Server = block.Ancestor("server")
+	}
+	if auth.Server != nil {
+		path, _, _ := strings.Cut(auth.URI, "?")
+		auth.Target = auth.Server.FindLocationByURI(path)
+	}
+	if auth.Target != nil {
+		for _, name := range authResponseHandlers {
+			if lines := auth.Target.Block.FindLines(name); len(lines) > 0 {
+				auth.Handler = lines[0]
+				break
+			}
+		}
+	}
+
+	for current := block; current != nil; current = current.ParentRef {
+		lines := current.FindLines("auth_request_set")
+		if len(lines) == 0 {
+			continue
+		}
+		for _, set := range lines {
+			if len(set.Params) == 2 {
+				auth.Variables = append(auth.Variables, AuthRequestVariable{Name: strings.TrimPrefix(unquote(set.Params[0]), "$"), Value: unquote(set.Params[1]), Line: set})
+			}
+		}
+		break
+	}
+	return auth
+}
+
+// AuthRequests returns the auth_request guarding each location of every http server,
+// skipping unprotected locations
+func (config *Config) AuthRequests() []*AuthRequest {
+	var auths []*AuthRequest
+	for _, server := range config.FindBlocksByName("server") {
+		if serverProtocol(server) != "http" {
+			continue
+		}
+		walkBlock(server, func(block *Block) {
+			if block.Name != "location" {
+				return
+			}
+			if auth := block.AuthRequest(); auth != nil {
+				auths = append(auths, auth)
+			}
+		})
+	}
+	return auths
+}
+
+// ValidateAuthRequests checks the wiring of auth_request: its URI must select a
+// location, which must be internal so clients cannot call it directly and must
+// answer with a status by proxying or returning. auth_request_set without an
+// auth_request in effect is reported as well, the variables stay empty
+func (config *Config) ValidateAuthRequests() []ValidationIssue {
+	var issues []ValidationIssue
+	checked := map[*Line]map[*Block]bool{}
+
+	for _, auth := range config.AuthRequests() {
+		if checked[auth.Line] == nil {
+			checked[auth.Line] = map[*Block]bool{}
+		}
+		if checked[auth.Line][auth.Server] {
+			continue
+		}
+		checked[auth.Line][auth.Server] = true
+
+		var messages []string
+		if auth.Target == nil {
+			messages = append(messages, fmt.Sprintf("auth_request %s matches no location", auth.URI))
+		} else {
+			target := fmt.Sprintf("auth_request location %s at line %d", strings.Join(auth.Target.Block.Params, " "), auth.Target.Block.LineNumber)
+			if len(auth.Target.Block.FindLines("internal")) == 0 {
+				messages = append(messages, target+" is not internal, clients can request it directly")
+			}
+			if auth.Handler == nil {
+				messages = append(messages, target+" neither proxies nor returns a status")
+			}
+		}
+		for _, message := range messages {
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityError,
+				Directive:  auth.Line.Name,
+				Message:    message,
+				LineNumber: auth.Line.LineNumber,
+			})
+		}
+	}
+
+	config.WalkBlocks(func(block *Block) {
+		lines := block.FindLines("auth_request_set")
+		if len(lines) == 0 || block.AuthRequest() != nil || hasProtectedLocation(block) {
+			return
+		}
+		for _, line := range lines {
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityWarning,
+				Directive:  line.Name,
+				Message:    "auth_request_set has no effect without auth_request",
+				LineNumber: line.LineNumber,
+			})
+		}
+	})
+
+	return issues
+}
+
+// hasProtectedLocation reports whether a location nested in the block is guarded by
+// an auth_request of its own
+func hasProtectedLocation(block *Block) bool {
+	protected := false
+	walkBlock(block, func(child *Block) {
+		if child != block && child.Name == "location" && child.AuthRequest() != nil {
+			protected = true
+		}
+	})
+	return protected
+}
+
+// Describe summarizes where the subrequest goes, e.g. "auth_request /auth (line 12)
+// is handled by location = /auth at line 20 with proxy_pass http://auth"
+func (auth *AuthRequest) Describe() string {
+	subject := fmt.Sprintf("auth_request %s (line %d)", auth.URI, auth.Line.LineNumber)
+	switch {
+	case auth.Target == nil:
+		return subject + " matches no location"
+	case auth.Handler == nil:
+		return fmt.Sprintf("%s is handled by location %s at line %d, which neither proxies nor returns", subject, strings.Join(auth.Target.Block.Params, " "), auth.Target.Block.LineNumber)
+	}
+	return fmt.Sprintf("%s is handled by location %s at line %d with %s", subject, strings.Join(auth.Target.Block.Params, " "), auth.Target.Block.LineNumber, formatStatement(auth.Handler.Name, auth.Handler.Params))
+}
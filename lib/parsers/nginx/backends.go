@@ -0,0 +1,221 @@
+package nginx
+
+import (
+	"net"
+	"strings"
+)
+
+// backendDirectives lists the directives passing requests or connections to a backend
+var backendDirectives = map[string]bool{
+	"proxy_pass":     true,
+	"grpc_pass":      true,
+	"fastcgi_pass":   true,
+	"uwsgi_pass":     true,
+	"scgi_pass":      true,
+	"memcached_pass": true,
+}
+
+// backendSchemes maps the URL schemes of pass directives to the protocol they speak
+// and whether it runs over TLS
+var backendSchemes = map[string]struct {
+	Protocol string
+	TLS      bool
+}{
+	"http":   {"http", false},
+	"https":  {"http", true},
+	"grpc":   {"grpc", false},
+	"grpcs":  {"grpc", true},
+	"uwsgi":  {"uwsgi", false},
+	"suwsgi": {"uwsgi", true},
+}
+
+// backendPorts are the ports nginx connects to when a URL names none
+var backendPorts = map[string]string{"http": "80", "https": "443", "grpc": "80", "grpcs": "443"}
+
+// BackendRoute is a directive routing requests or connections to a backend
+type BackendRoute struct {
+	Line *Line
+	Path []string // Enclosing blocks from the outermost (e.g. ["http", "server example.com", "location /api/"])
+}
+
+// Backend is a server nginx passes requests or connections to
+type Backend struct {
+	Address  string         // host:port, IP:port or unix:/path, as the upstream lists it or the pass directive names it
+	Protocol string         // Protocol spoken to the backend: http, grpc, fastcgi, uwsgi, scgi, memcached, or tcp and udp in stream
+	TLS      bool           // Whether nginx connects to the backend with TLS
+	Upstream string         // Upstream block listing the address, empty when the directive names it directly
+	Routes   []BackendRoute // Directives routing to the backend in configuration order
+}
+
+// Backends lists the services the configuration depends on: every distinct target
+// of proxy_pass, grpc_pass, fastcgi_pass, uwsgi_pass, scgi_pass and memcached_pass,
+// and of proxy_pass in stream servers, with the directives routing to it. Targets
+// naming an upstream are resolved to its servers, including backup and down ones.
+// A target that is a map variable resolves to the values of the map; targets built
+// from other variables are only known at runtime and are left out. TLS is read from
+// the https, grpcs and suwsgi schemes, and from proxy_ssl in stream. Backends are
+// listed in order of first appearance
+func (config *Config) Backends() []Backend {
+	upstreams := map[string]*Upstream{}
+	for _, upstream := range config.Upstreams() {
+		upstreams[upstreamContext(upstream.Block)+" "+upstream.Name] = upstream
+	}
+
+	var backends []*Backend
+	index := map[string]*Backend{}
+	add := func(backend Backend, route BackendRoute) {
+		key := strings.Join([]string{backend.Protocol, backend.Upstream, backend.Address}, " ")
+		if backend.TLS {
+			key += " tls"
+		}
+		if index[key] == nil {
+			index[key] = &backend
+			backends = append(backends, index[key])
+		}
+		index[key].Routes = append(index[key].Routes, route)
+	}
+
+	var collect func(block *Block, path []string)
+	collect = func(block *Block, path []string) {
+		if foreignDirectiveBlocks[block.Name] {
+			return
+		}
+		context := upstreamContext(block)
+		for _, line := range block.Lines {
+			if line.Type != LineTypeDirective || !backendDirectives[line.Name] || len(line.Params) == 0 {
+				continue
+			}
+			route := BackendRoute{Line: line, Path: path}
+			for _, target := range passTargets(block, unquote(line.Params[0])) {
+				backend, host, ok := parseBackendTarget(line.Name, context, target)
+				if !ok {
+					continue
+				}
+				if context == "stream" {
+					backend.Protocol = "tcp"
+					if serverListensUDP(block) {
+						backend.Protocol = "udp"
+					}
+					if ssl := block.EffectiveDirective("proxy_ssl"); ssl != nil && len(ssl.Params) > 0 && unquote(ssl.Params[0]) == "on" {
+						backend.TLS = true
+					}
+				}
+				if upstream := upstreams[context+" "+host]; upstream != nil && host != "" {
+					for _, server := range upstream.Servers {
+						add(Backend{Address: server.Address, Protocol: backend.Protocol, TLS: backend.TLS, Upstream: upstream.Name}, route)
+					}
+					continue
+				}
+				add(backend, route)
+			}
+		}
+		for _, child := range block.Blocks {
+			collect(child, appendPath(path, blockKey(child)))
+		}
+	}
+	collect(config.RootBlock, nil)
+
+	result := make([]Backend, 0, len(backends))
+	for _, backend := range backends {
+		result = append(result, *backend)
+	}
+	return result
+}
+
+// passTargets returns the targets of a pass directive parameter: the parameter
+// itself, or one for each value of the map when it references a map variable.
+// Targets still holding variables are left out
+func passTargets(block *Block, param string) []string {
+	matches := variablePattern.FindAllStringSubmatch(param, -1)
+	if len(matches) == 0 {
+		return []string{param}
+	}
+	if len(matches) > 1 {
+		return nil
+	}
+	m := block.variableMap(matches[0][1] + matches[0][2])
+	if m == nil {
+		return nil
+	}
+
+	// A value with a scheme only makes a target substituted at the start
+	atStart := strings.HasPrefix(param, matches[0][0])
+	var targets []string
+	values := []string{m.Default}
+	for _, entry := range m.Entries {
+		values = append(values, entry.Value)
+	}
+	for _, value := range values {
+		if value == "" || strings.Contains(value, "$") || !atStart && strings.Contains(value, "://") {
+			continue
+		}
+		targets = appendUnique(targets, strings.Replace(param, matches[0][0], value, 1))
+	}
+	return targets
+}
+
+// parseBackendTarget parses the target of a pass directive into a backend and the
+// host naming an upstream, empty when the target has a port or is a unix socket
+func parseBackendTarget(directive, context, target string) (Backend, string, bool) {
+	backend := Backend{Protocol: strings.TrimSuffix(directive, "_pass")}
+	scheme := ""
+	if before, after, ok := strings.Cut(target, "://"); ok {
+		scheme, target = strings.ToLower(before), after
+		known, ok := backendSchemes[scheme]
+		if !ok {
+			return backend, "", false
+		}
+		backend.Protocol, backend.TLS = known.Protocol, known.TLS
+	} else if directive == "proxy_pass" && context != "stream" {
+		return backend, "", false
+	}
+
+	if strings.HasPrefix(target, "unix:") {
+		// unix:/path, followed by :/uri in URLs
+		socket, _, _ := strings.Cut(strings.TrimPrefix(target, "unix:"), ":")
+		backend.Address = "unix:" + socket
+		return backend, "", socket != ""
+	}
+	if end := strings.IndexAny(target, "/?"); end >= 0 {
+		target = target[:end]
+	}
+	if target == "" {
+		return backend, "", false
+	}
+
+	if _, _, err := net.SplitHostPort(target); err == nil {
+		backend.Address = target
+		return backend, "", true
+	}
+	backend.Address = target
+	if port := backendPorts[scheme]; port != "" {
+		backend.Address = net.JoinHostPort(strings.Trim(target, "[]"), port)
+	}
+	return backend, target, true
+}
+
+// upstreamContext returns the module an upstream or pass directive of the block
+// belongs to, "stream" or "http", upstreams of the two being distinct
+func upstreamContext(block *Block) string {
+	if block.Name == "stream" || block.Ancestor("stream") != nil {
+		return "stream"
+	}
+	return "http"
+}
+
+// serverListensUDP reports whether the stream server holding a block listens on UDP
+func serverListensUDP(block *Block) bool {
+	server := block
+	if server.Name != "server" {
+		server = block.Ancestor("server")
+	}
+	if server == nil {
+		return false
+	}
+	for _, line := range server.FindLines("listen") {
+		if hasParam(line.Params, "udp") {
+			return true
+		}
+	}
+	return false
+}
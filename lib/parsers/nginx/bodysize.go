@@ -0,0 +1,149 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Defaults of the request body settings reported by BodySizeReport
+const (
+	defaultClientMaxBodySize    = "1m"
+	defaultClientBodyBufferSize = "16k" // 8k on 32-bit platforms
+)
+
+// LocationBodySize holds the request body settings in effect in a location
+type LocationBodySize struct {
+	Location *Block
+	Path     []string // Enclosing blocks from the outermost, ending with the location
+
+	MaxBodySize CacheValue // client_max_body_size, larger bodies are rejected with 413
+	MaxBytes    int64      // Parsed client_max_body_size, -1 when it does not parse
+	Unlimited   bool       // client_max_body_size 0 disables the check, any body is accepted
+
+	BufferSize       CacheValue // client_body_buffer_size, larger bodies are written to a temporary file
+	RequestBuffering CacheValue // proxy_request_buffering, off streams the body upstream without buffering it first
+	Handler          *Line      // Directive passing the request upstream, nil if the location does not proxy
+}
+
+// BodySizeReport lists the request body settings in effect in every externally
+// reachable location of the http servers: client_max_body_size (1m unless set,
+// 0 for unlimited), and client_body_buffer_size and proxy_request_buffering which
+// decide whether bodies are buffered in memory, spilled to disk or streamed
+// upstream. Each value is annotated with the block it is inherited from
+func (config *Config) BodySizeReport() []LocationBodySize {
+	var report []LocationBodySize
+	var collect func(block *Block, path []string)
+	collect = func(block *Block, path []string) {
+		if location := NewLocation(block); location != nil && !location.IsNamed() && block.Ancestor("http") != nil && len(block.FindLines("internal")) == 0 {
+			report = append(report, block.locationBodySize(path))
+		}
+		for _, child := range block.Blocks {
+			collect(child, appendPath(path, blockKey(child)))
+		}
+	}
+	collect(config.RootBlock, nil)
+
+	return report
+}
+
+// BodySizeReportMatching narrows BodySizeReport to locations passing requests
+// upstream whose pattern or upstream address contains one of the hints, compared
+// case-insensitively, e.g. "upload", "/api" or "import" for upload and API endpoints
+func (config *Config) BodySizeReportMatching(hints []string) []LocationBodySize {
+	var report []LocationBodySize
+	for _, entry := range config.BodySizeReport() {
+		if entry.Handler == nil {
+			continue
+		}
+		subject := strings.ToLower(strings.Join(entry.Location.Params, " ") + " " + strings.Join(entry.Handler.Params, " "))
+		for _, hint := range hints {
+			if hint != "" && strings.Contains(subject, strings.ToLower(hint)) {
+				report = append(report, entry)
+				break
+			}
+		}
+	}
+	return report
+}
+
+// locationBodySize resolves the request body settings of a location
+func (block *Block) locationBodySize(path []string) LocationBodySize {
+	entry := LocationBodySize{
+		Location:         block,
+		Path:             path,
+		MaxBodySize:      block.cacheValue("client_max_body_size", defaultClientMaxBodySize),
+		BufferSize:       block.cacheValue("client_body_buffer_size", defaultClientBodyBufferSize),
+		RequestBuffering: block.cacheValue("proxy_request_buffering", "on"),
+	}
+
+	entry.MaxBytes = -1
+	if size, err := ParseSize(unquote(entry.MaxBodySize.Value)); err == nil {
+		entry.MaxBytes = size
+		entry.Unlimited = size == 0
+	}
+	for _, directive := range []string{"proxy_pass", "grpc_pass", "fastcgi_pass", "uwsgi_pass", "scgi_pass"} {
+		if lines := block.FindLines(directive); len(lines) > 0 {
+			entry.Handler = lines[0]
+			break
+		}
+	}
+	return entry
+}
+
+// CheckLargeClientBodySize reports the client_max_body_size directives accepting
+// request bodies larger than maxAllowedBytes, unlimited ones (0) included, as
+// warnings since large bodies let clients tie up memory, disk and upstreams. http
+// servers with no client_max_body_size in effect get nginx's default of 1m, which
+// is reported as info, or as a warning when it exceeds maxAllowedBytes too
+func (config *Config) CheckLargeClientBodySize(maxAllowedBytes int64) []ValidationError {
+	var issues []ValidationError
+	for _, line := range config.FindLinesByName("client_max_body_size") {
+		if len(line.Params) == 0 {
+			continue
+		}
+		value := unquote(line.Params[0])
+		size, err := ParseSize(value)
+		var message string
+		switch {
+		case err != nil:
+			issues = append(issues, ValidationError{
+				Severity:   SeverityError,
+				Directive:  line.Name,
+				Message:    fmt.Sprintf("client_max_body_size %s is not a valid size", value),
+				LineNumber: line.LineNumber,
+			})
+			continue
+		case size == 0:
+			message = "client_max_body_size 0 accepts request bodies of any size"
+		case size > maxAllowedBytes:
+			message = fmt.Sprintf("client_max_body_size %s (%d bytes) exceeds the %d bytes allowed", value, size, maxAllowedBytes)
+		default:
+			continue
+		}
+		issues = append(issues, ValidationError{
+			Severity:   SeverityWarning,
+			Directive:  line.Name,
+			Message:    message,
+			LineNumber: line.LineNumber,
+		})
+	}
+
+	defaultSize, _ := ParseSize(defaultClientMaxBodySize)
+	for _, server := range config.FindBlocksByName("server") {
+		if serverProtocol(server) != "http" || server.EffectiveDirective("client_max_body_size") != nil {
+			continue
+		}
+		issue := ValidationError{
+			Severity:   SeverityInfo,
+			Directive:  "server",
+			Message:    fmt.Sprintf("server sets no client_max_body_size, the default %s applies", defaultClientMaxBodySize),
+			LineNumber: server.LineNumber,
+		}
+		if defaultSize > maxAllowedBytes {
+			issue.Severity = SeverityWarning
+			issue.Message = fmt.Sprintf("server sets no client_max_body_size, the default %s (%d bytes) exceeds the %d bytes allowed", defaultClientMaxBodySize, defaultSize, maxAllowedBytes)
+		}
+		issues = append(issues, issue)
+	}
+	return issues
+}
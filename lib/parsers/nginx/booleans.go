@@ -0,0 +1,144 @@
+package nginx
+
+import "strings"
+
+// booleanDirectives lists directives whose only parameter is an on/off flag
+var booleanDirectives = map[string]bool{
+	// Core, events and http core
+	"absolute_redirect":         true,
+	"accept_mutex":              true,
+	"chunked_transfer_encoding": true,
+	"daemon":                    true,
+	"etag":                      true,
+	"ignore_invalid_headers":    true,
+	"log_not_found":             true,
+	"log_subrequest":            true,
+	"master_process":            true,
+	"merge_slashes":             true,
+	"msie_padding":              true,
+	"msie_refresh":              true,
+	"multi_accept":              true,
+	"open_file_cache_errors":    true,
+	"port_in_redirect":          true,
+	"recursive_error_pages":     true,
+	"reset_timedout_connection": true,
+	"sendfile":                  true,
+	"server_name_in_redirect":   true,
+	"tcp_nodelay":               true,
+	"tcp_nopush":                true,
+	"underscores_in_headers":    true,
+
+	// Content and filter modules
+	"autoindex":                true,
+	"autoindex_exact_size":     true,
+	"autoindex_localtime":      true,
+	"gunzip":                   true,
+	"gzip":                     true,
+	"gzip_vary":                true,
+	"override_charset":         true,
+	"ssi":                      true,
+	"ssi_silent_errors":        true,
+	"sub_filter_last_modified": true,
+	"sub_filter_once":          true,
+
+	// SSL, HTTP/2 and HTTP/3
+	"http2":                     true,
+	"http3":                     true,
+	"http3_hq":                  true,
+	"quic_gso":                  true,
+	"quic_retry":                true,
+	"ssl_early_data":            true,
+	"ssl_prefer_server_ciphers": true,
+	"ssl_preread":               true,
+	"ssl_session_tickets":       true,
+	"ssl_stapling":              true,
+	"ssl_stapling_verify":       true,
+
+	// Limits
+	"limit_conn_dry_run": true,
+	"limit_req_dry_run":  true,
+
+	// Upstream handlers
+	"fastcgi_buffering":               true,
+	"fastcgi_cache_background_update": true,
+	"fastcgi_cache_lock":              true,
+	"fastcgi_cache_revalidate":        true,
+	"fastcgi_ignore_client_abort":     true,
+	"fastcgi_intercept_errors":        true,
+	"fastcgi_keep_conn":               true,
+	"fastcgi_pass_request_body":       true,
+	"fastcgi_pass_request_headers":    true,
+	"fastcgi_request_buffering":       true,
+	"fastcgi_socket_keepalive":        true,
+	"grpc_intercept_errors":           true,
+	"grpc_socket_keepalive":           true,
+	"grpc_ssl_server_name":            true,
+	"grpc_ssl_verify":                 true,
+	"proxy_buffering":                 true,
+	"proxy_cache_background_update":   true,
+	"proxy_cache_convert_head":        true,
+	"proxy_cache_lock":                true,
+	"proxy_cache_revalidate":          true,
+	"proxy_force_ranges":              true,
+	"proxy_ignore_client_abort":       true,
+	"proxy_intercept_errors":          true,
+	"proxy_pass_request_body":         true,
+	"proxy_pass_request_headers":      true,
+	"proxy_protocol":                  true,
+	"proxy_request_buffering":         true,
+	"proxy_socket_keepalive":          true,
+	"proxy_ssl_server_name":           true,
+	"proxy_ssl_session_reuse":         true,
+	"proxy_ssl_verify":                true,
+	"scgi_buffering":                  true,
+	"scgi_intercept_errors":           true,
+	"scgi_pass_request_body":          true,
+	"scgi_pass_request_headers":       true,
+	"scgi_request_buffering":          true,
+	"uwsgi_buffering":                 true,
+	"uwsgi_intercept_errors":          true,
+	"uwsgi_pass_request_body":         true,
+	"uwsgi_pass_request_headers":      true,
+	"uwsgi_request_buffering":         true,
+
+	// Third-party modules
+	"brotli":               true,
+	"lua_code_cache":       true,
+	"modsecurity":          true,
+	"vhost_traffic_status": true,
+}
+
+// booleanValues maps the spellings of flags found in configurations to on or off
+var booleanValues = map[string]string{
+	"on":       "on",
+	"off":      "off",
+	"1":        "on",
+	"0":        "off",
+	"yes":      "on",
+	"no":       "off",
+	"true":     "on",
+	"false":    "off",
+	"enable":   "on",
+	"disable":  "off",
+	"enabled":  "on",
+	"disabled": "off",
+}
+
+// NormalizeBooleans rewrites the flag of every known boolean directive to the
+// canonical on or off, e.g. "gzip ON" or "sendfile 1" become "gzip on" and
+// "sendfile on". Directives not listed as boolean and values that are not a
+// recognized spelling of a flag, such as variables, are left untouched, as are
+// directives an ngonx:keep pragma protects
+func (config *Config) NormalizeBooleans() {
+	config.WalkBlocks(func(block *Block) {
+		for _, line := range block.Lines {
+			if line.Type != LineTypeDirective || !booleanDirectives[line.Name] || len(line.Params) != 1 || keptLine(block, line) {
+				continue
+			}
+			if value, ok := booleanValues[strings.ToLower(unquote(line.Params[0]))]; ok && value != line.Params[0] {
+				line.Params[0] = value
+				config.Dirty = true
+			}
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package nginx
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// bufferModules lists the upstream modules sharing the buffer directives and their constraints
+var bufferModules = []string{"proxy", "fastcgi", "uwsgi", "scgi"}
+
+// Defaults of the buffer directives, one memory page on x86-64 Linux
+const (
+	defaultBufferSize         = "4k"
+	defaultBuffers            = "8 4k"
+	defaultMaxTempFileSize    = "1024m"
+	defaultConnectionPoolSize = "512" // 256 on 32-bit platforms
+)
+
+// BufferSettings are the response buffer sizes of an upstream module in effect in a
+// block, in bytes. Busy buffers and temporary file writes default to twice the
+// largest buffer
+type BufferSettings struct {
+	Module            string // proxy, fastcgi, uwsgi or scgi
+	BufferSize        int64  // *_buffer_size, holding the response header
+	Buffers           int64  // Number of *_buffers
+	BuffersSize       int64  // Size of one of the *_buffers
+	BusyBuffersSize   int64  // *_busy_buffers_size
+	TempFileWriteSize int64  // *_temp_file_write_size
+	MaxTempFileSize   int64  // *_max_temp_file_size, 0 disables temporary files
+
+	Lines map[string]*Line // Directives in effect by name, missing when the default applies
+}
+
+// MaxBuffer returns the larger of the header buffer and one response buffer, the
+// size nginx checks the busy buffers and temporary file settings against
+func (settings *BufferSettings) MaxBuffer() int64 {
+	if settings.BufferSize > settings.BuffersSize {
+		return settings.BufferSize
+	}
+	return settings.BuffersSize
+}
+
+// BufferSettings resolves the buffer sizes of an upstream module (proxy, fastcgi,
+// uwsgi or scgi) in effect in the block. Values that do not parse are reported as errors
+func (block *Block) BufferSettings(module string) (*BufferSettings, error) {
+	settings := &BufferSettings{Module: module, Lines: map[string]*Line{}}
+	value := func(name, defaultValue string) []string {
+		if line := block.EffectiveDirective(module + "_" + name); line != nil {
+			settings.Lines[name] = line
+			return line.Params
+		}
+		return strings.Fields(defaultValue)
+	}
+	size := func(name string, params []string, index int) (int64, error) {
+		if len(params) <= index {
+			return 0, fmt.Errorf("%s_%s is missing a size", module, name)
+		}
+		bytes, err := ParseSize(unquote(params[index]))
+		if err != nil {
+			return 0, fmt.Errorf("%s_%s: %v", module, name, err)
+		}
+		return bytes, nil
+	}
+
+	var err error
+	if settings.BufferSize, err = size("buffer_size", value("buffer_size", defaultBufferSize), 0); err != nil {
+		return nil, err
+	}
+	buffers := value("buffers", defaultBuffers)
+	if settings.Buffers, err = size("buffers", buffers, 0); err != nil {
+		return nil, err
+	}
+	if settings.BuffersSize, err = size("buffers", buffers, 1); err != nil {
+		return nil, err
+	}
+	maxBuffer := fmt.Sprint(int64(math.MaxInt64))
+	if settings.MaxBuffer() <= math.MaxInt64/2 {
+		maxBuffer = fmt.Sprint(2 * settings.MaxBuffer())
+	}
+	if settings.BusyBuffersSize, err = size("busy_buffers_size", value("busy_buffers_size", maxBuffer), 0); err != nil {
+		return nil, err
+	}
+	if settings.TempFileWriteSize, err = size("temp_file_write_size", value("temp_file_write_size", maxBuffer), 0); err != nil {
+		return nil, err
+	}
+	if settings.MaxTempFileSize, err = size("max_temp_file_size", value("max_temp_file_size", defaultMaxTempFileSize), 0); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// ValidateBuffers checks the buffer sizes nginx refuses to start with, in every
+// block setting any of them: at least two *_buffers, *_busy_buffers_size between
+// the largest buffer and all *_buffers but one, *_temp_file_write_size no smaller
+// than the largest buffer and *_max_temp_file_size either 0 or no smaller than it.
+// The classic failure is proxy_buffer_size 128k with the default busy buffers size,
+// twice 128k, exceeding seven 4k buffers. large_client_header_buffers must not be
+// smaller than connection_pool_size
+func (config *Config) ValidateBuffers() []ValidationIssue {
+	var issues []ValidationIssue
+	report := func(line *Line, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{
+			Severity:   SeverityError,
+			Directive:  line.Name,
+			Message:    fmt.Sprintf(format, args...),
+			LineNumber: line.LineNumber,
+		})
+	}
+
+	config.WalkBlocks(func(block *Block) {
+		if block.Name != "http" && block.Ancestor("http") == nil {
+			return
+		}
+		for _, module := range bufferModules {
+			line := firstBufferDirective(block, module)
+			if line == nil {
+				continue
+			}
+			settings, err := block.BufferSettings(module)
+			if err != nil {
+				report(line, "%v", err)
+				continue
+			}
+			for _, violation := range settings.violations() {
+				report(line, "%s", violation)
+			}
+		}
+
+		large := block.FindLines("large_client_header_buffers")
+		pool := block.FindLines("connection_pool_size")
+		if len(large) == 0 && len(pool) == 0 {
+			return
+		}
+		largeLine := block.EffectiveDirective("large_client_header_buffers")
+		if largeLine == nil || len(largeLine.Params) < 2 {
+			return
+		}
+		largeSize, err := ParseSize(unquote(largeLine.Params[1]))
+		if err != nil {
+			return
+		}
+		poolValue := defaultConnectionPoolSize
+		if poolLine := block.EffectiveDirective("connection_pool_size"); poolLine != nil && len(poolLine.Params) > 0 {
+			poolValue = unquote(poolLine.Params[0])
+		}
+		if poolSize, err := ParseSize(poolValue); err == nil && largeSize < poolSize {
+			line := largeLine
+			if len(large) == 0 {
+				line = pool[0]
+			}
+			report(line, "large_client_header_buffers size must be equal to or greater than connection_pool_size: %d < %d bytes", largeSize, poolSize)
+		}
+	})
+
+	return issues
+}
+
+// violations lists the constraints nginx enforces on the settings that they break,
+// each with the inequality and the sizes in bytes
+func (settings *BufferSettings) violations() []string {
+	var messages []string
+	module := settings.Module
+	maxBuffer := settings.MaxBuffer()
+	describe := func(name string, value int64) string {
+		if settings.Lines[name] == nil {
+			return fmt.Sprintf("%s_%s %d (default)", module, name, value)
+		}
+		return fmt.Sprintf("%s_%s %d", module, name, value)
+	}
+	largest := fmt.Sprintf("max(%s_buffer_size %d, one of %s_buffers %d) = %d", module, settings.BufferSize, module, settings.BuffersSize, maxBuffer)
+
+	if settings.Buffers < 2 {
+		messages = append(messages, fmt.Sprintf("there must be at least 2 %s_buffers: %d < 2", module, settings.Buffers))
+	}
+	if settings.BusyBuffersSize < maxBuffer {
+		messages = append(messages, fmt.Sprintf("%s_busy_buffers_size must be equal to or greater than the largest buffer: %s < %s bytes",
+			module, describe("busy_buffers_size", settings.BusyBuffersSize), largest))
+	}
+	// All buffers but one too large for an int64 hold any busy_buffers_size
+	overflows := settings.Buffers >= 2 && settings.BuffersSize > math.MaxInt64/(settings.Buffers-1)
+	if allButOne := (settings.Buffers - 1) * settings.BuffersSize; settings.Buffers >= 2 && !overflows && settings.BusyBuffersSize > allButOne {
+		messages = append(messages, fmt.Sprintf("%s_busy_buffers_size must be less than the size of all %s_buffers minus one buffer: %s > (%d - 1) * %d = %d bytes",
+			module, module, describe("busy_buffers_size", settings.BusyBuffersSize), settings.Buffers, settings.BuffersSize, allButOne))
+	}
+	if settings.TempFileWriteSize < maxBuffer {
+		messages = append(messages, fmt.Sprintf("%s_temp_file_write_size must be equal to or greater than the largest buffer: %s < %s bytes",
+			module, describe("temp_file_write_size", settings.TempFileWriteSize), largest))
+	}
+	if settings.MaxTempFileSize != 0 && settings.MaxTempFileSize < maxBuffer {
+		messages = append(messages, fmt.Sprintf("%s_max_temp_file_size must be 0 to disable temporary files or equal to or greater than the largest buffer: %s < %s bytes",
+			module, describe("max_temp_file_size", settings.MaxTempFileSize), largest))
+	}
+	return messages
+}
+
+// firstBufferDirective returns the first buffer directive of a module set in the block itself, nil if none
+func firstBufferDirective(block *Block, module string) *Line {
+	for _, line := range block.Lines {
+		if line.Type != LineTypeDirective || !strings.HasPrefix(line.Name, module+"_") {
+			continue
+		}
+		switch strings.TrimPrefix(line.Name, module+"_") {
+		case "buffer_size", "buffers", "busy_buffers_size", "temp_file_write_size", "max_temp_file_size":
+			return line
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package nginx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBufferSettings(t *testing.T) {
+	tests := []struct {
+		name       string
+		directives string
+		want       BufferSettings
+	}{
+		{
+			name: "defaults",
+			want: BufferSettings{BufferSize: 4096, Buffers: 8, BuffersSize: 4096, BusyBuffersSize: 8192, TempFileWriteSize: 8192, MaxTempFileSize: 1024 * 1024 * 1024},
+		},
+		{
+			name:       "busy buffers default to twice the header buffer",
+			directives: "proxy_buffer_size 128k;",
+			want:       BufferSettings{BufferSize: 128 * 1024, Buffers: 8, BuffersSize: 4096, BusyBuffersSize: 256 * 1024, TempFileWriteSize: 256 * 1024, MaxTempFileSize: 1024 * 1024 * 1024},
+		},
+		{
+			name:       "busy buffers default to twice a response buffer",
+			directives: "proxy_buffers 4 64k; proxy_max_temp_file_size 0;",
+			want:       BufferSettings{BufferSize: 4096, Buffers: 4, BuffersSize: 64 * 1024, BusyBuffersSize: 128 * 1024, TempFileWriteSize: 128 * 1024},
+		},
+		{
+			name:       "explicit sizes",
+			directives: "proxy_buffer_size 16k; proxy_buffers 16 16k; proxy_busy_buffers_size 64k; proxy_temp_file_write_size 32k; proxy_max_temp_file_size 1g;",
+			want:       BufferSettings{BufferSize: 16 * 1024, Buffers: 16, BuffersSize: 16 * 1024, BusyBuffersSize: 64 * 1024, TempFileWriteSize: 32 * 1024, MaxTempFileSize: 1024 * 1024 * 1024},
+		},
+		{
+			name:       "default of twice the largest buffer capped",
+			directives: "proxy_buffer_size 8589934591g;",
+			want:       BufferSettings{BufferSize: 8589934591 * 1024 * 1024 * 1024, Buffers: 8, BuffersSize: 4096, BusyBuffersSize: 1<<63 - 1, TempFileWriteSize: 1<<63 - 1, MaxTempFileSize: 1024 * 1024 * 1024},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "http { " + tt.directives + " server { location / { } } }"
+			config, err := ParseReader(strings.NewReader(input), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			location := config.FindBlocksByName("location")[0]
+
+			settings, err := location.BufferSettings("proxy")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := *settings
+			got.Module, got.Lines = "", nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("BufferSettings = %+v, want %+v", got, tt.want)
+			}
+			if got, want := len(settings.Lines), strings.Count(tt.directives, ";"); got != want {
+				t.Fatalf("%d directives in effect, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestBufferSettingsErrors(t *testing.T) {
+	for _, directives := range []string{"proxy_buffers 8;", "proxy_buffer_size 4x;", "proxy_busy_buffers_size 99999999999g;"} {
+		config, err := ParseReader(strings.NewReader("http { "+directives+" }"), "test.conf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := config.FindBlocksByName("http")[0].BufferSettings("proxy"); err == nil {
+			t.Errorf("%s: expected an error", directives)
+		}
+	}
+}
+
+func TestValidateBuffers(t *testing.T) {
+	tests := []struct {
+		name       string
+		directives string
+		want       []string
+	}{
+		{name: "defaults", directives: "proxy_buffering on;"},
+		{name: "valid sizes", directives: "proxy_buffer_size 16k; proxy_buffers 8 16k; proxy_busy_buffers_size 32k;"},
+		{
+			name:       "large header buffer with default buffers",
+			directives: "proxy_buffer_size 128k;",
+			want:       []string{"proxy_busy_buffers_size 262144 (default) > (8 - 1) * 4096 = 28672 bytes"},
+		},
+		{
+			name:       "busy buffers equal to all buffers but one",
+			directives: "fastcgi_buffers 4 8k; fastcgi_busy_buffers_size 24k;",
+		},
+		{
+			name:       "busy buffers above all buffers but one",
+			directives: "fastcgi_buffers 4 8k; fastcgi_busy_buffers_size 25k;",
+			want:       []string{"fastcgi_busy_buffers_size 25600 > (4 - 1) * 8192 = 24576 bytes"},
+		},
+		{
+			name:       "single buffer",
+			directives: "uwsgi_buffers 1 8k; uwsgi_busy_buffers_size 8k;",
+			want:       []string{"there must be at least 2 uwsgi_buffers: 1 < 2"},
+		},
+		{
+			name:       "busy buffers below the largest buffer",
+			directives: "scgi_buffer_size 16k; scgi_busy_buffers_size 8k;",
+			want:       []string{"scgi_busy_buffers_size 8192 < max(scgi_buffer_size 16384, one of scgi_buffers 4096) = 16384 bytes"},
+		},
+		{
+			name:       "temporary files",
+			directives: "proxy_temp_file_write_size 2k; proxy_max_temp_file_size 1k;",
+			want: []string{
+				"proxy_temp_file_write_size 2048 < max(proxy_buffer_size 4096, one of proxy_buffers 4096) = 4096 bytes",
+				"proxy_max_temp_file_size 1024 < max(proxy_buffer_size 4096, one of proxy_buffers 4096) = 4096 bytes",
+			},
+		},
+		{
+			name:       "temporary files disabled",
+			directives: "proxy_max_temp_file_size 0;",
+		},
+		{
+			name:       "buffers too large to add up",
+			directives: "proxy_buffers 4 4611686018427387904; proxy_busy_buffers_size 9223372036854775807; proxy_max_temp_file_size 0;",
+		},
+		{
+			name:       "invalid size",
+			directives: "proxy_buffers 8 99999999999g;",
+			want:       []string{`proxy_buffers: size "99999999999g" is too large`},
+		},
+		{
+			name:       "connection pool",
+			directives: "large_client_header_buffers 4 256;",
+			want:       []string{"large_client_header_buffers size must be equal to or greater than connection_pool_size: 256 < 512 bytes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "http { " + tt.directives + " }"
+			config, err := ParseReader(strings.NewReader(input), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			issues := config.ValidateBuffers()
+			var messages []string
+			for _, issue := range issues {
+				messages = append(messages, issue.Message)
+			}
+			if len(issues) != len(tt.want) {
+				t.Fatalf("got %d issues, want %d:\n%s", len(issues), len(tt.want), strings.Join(messages, "\n"))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(messages[i], want) {
+					t.Errorf("issue %q does not mention %q", messages[i], want)
+				}
+			}
+		})
+	}
+}
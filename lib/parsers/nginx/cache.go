@@ -0,0 +1,256 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultCacheKey is the proxy_cache_key nginx uses unless it is overridden
+const defaultCacheKey = "$scheme$proxy_host$request_uri"
+
+// defaultCacheValidCodes are the status codes a proxy_cache_valid without codes applies to
+var defaultCacheValidCodes = []string{"200", "301", "302"}
+
+// queryVariables are the variables that make a cache key vary on the query string
+var queryVariables = map[string]bool{"args": true, "query_string": true, "request_uri": true}
+
+// CacheValue is a caching setting and where it is defined
+type CacheValue struct {
+	Value string // Parameters as written, joined by spaces, or nginx's default
+	Line  *Line  // Defining directive, nil when the default applies
+	Block *Block // Block holding the directive, nil when the default applies
+}
+
+// CacheValidRule is a proxy_cache_valid directive: how long responses with the codes are cached
+type CacheValidRule struct {
+	Codes    []string // Status codes or "any", 200 301 302 when the directive lists none
+	Duration string   // Caching time as written
+	CacheValue
+}
+
+// LocationCache is the effective proxy_cache configuration of a location
+type LocationCache struct {
+	Location      *Block
+	Path          []string         // Enclosing blocks from the outermost, ending with the location
+	Zone          CacheValue       // Cache zone, may be a variable
+	Key           CacheValue       // Cache key, defaultCacheKey unless overridden
+	KeyVariables  []string         // Variables the key is built from, without the $
+	Valid         []CacheValidRule // Caching times by status code
+	Bypass        []CacheValue     // proxy_cache_bypass conditions, served from upstream when any is non-empty and not "0"
+	NoCache       []CacheValue     // proxy_no_cache conditions, responses not stored when any is non-empty and not "0"
+	IgnoreHeaders CacheValue       // proxy_ignore_headers, empty when upstream caching headers are honored
+
+	IgnoresSetCookie    bool // Responses carrying Set-Cookie are cached
+	IgnoresCacheControl bool // Upstream Cache-Control does not limit caching
+	HidesSetCookie      bool // proxy_hide_header Set-Cookie keeps cached cookies from clients
+
+	MinUses     CacheValue // Requests before a response is cached
+	Lock        CacheValue // Whether concurrent misses wait for a single upstream request
+	LockTimeout CacheValue
+	LockAge     CacheValue
+}
+
+// Origin describes where the value is defined, e.g. "location /api/ at line 12",
+// or "default" when nginx's default applies
+func (value CacheValue) Origin() string {
+	if value.Line == nil {
+		return "default"
+	}
+	name := blockKey(value.Block)
+	if value.Block.ParentRef == nil {
+		name = "main context"
+	}
+	return fmt.Sprintf("%s at line %d", name, value.Line.LineNumber)
+}
+
+// CacheReport summarizes the effective caching settings of every location with
+// proxy_cache in effect, each value annotated by the block it is inherited from
+func (config *Config) CacheReport() []LocationCache {
+	var report []LocationCache
+	var collect func(block *Block, path []string)
+	collect = func(block *Block, path []string) {
+		if block.Name == "location" {
+			if cache := block.locationCache(path); cache != nil {
+				report = append(report, *cache)
+			}
+		}
+		for _, child := range block.Blocks {
+			collect(child, appendPath(path, blockKey(child)))
+		}
+	}
+	collect(config.RootBlock, nil)
+
+	return report
+}
+
+// locationCache resolves the caching settings in effect in a location, nil if
+// proxy_cache is not set or turned off
+func (block *Block) locationCache(path []string) *LocationCache {
+	zone := block.cacheValue("proxy_cache", "off")
+	if zone.Line == nil || zone.Value == "off" {
+		return nil
+	}
+
+	cache := &LocationCache{
+		Location:    block,
+		Path:        path,
+		Zone:        zone,
+		Key:         block.cacheValue("proxy_cache_key", defaultCacheKey),
+		MinUses:     block.cacheValue("proxy_cache_min_uses", "1"),
+		Lock:        block.cacheValue("proxy_cache_lock", "off"),
+		LockTimeout: block.cacheValue("proxy_cache_lock_timeout", "5s"),
+		LockAge:     block.cacheValue("proxy_cache_lock_age", "5s"),
+	}
+	for _, match := range variablePattern.FindAllStringSubmatch(cache.Key.Value, -1) {
+		cache.KeyVariables = append(cache.KeyVariables, match[1]+match[2])
+	}
+
+	lines, owner := block.inheritedLines("proxy_cache_valid")
+	for _, line := range lines {
+		if len(line.Params) == 0 {
+			continue
+		}
+		rule := CacheValidRule{
+			Codes:      line.Params[:len(line.Params)-1],
+			Duration:   line.Params[len(line.Params)-1],
+			CacheValue: CacheValue{Value: strings.Join(line.Params, " "), Line: line, Block: owner},
+		}
+		if len(rule.Codes) == 0 {
+			rule.Codes = defaultCacheValidCodes
+		}
+		cache.Valid = append(cache.Valid, rule)
+	}
+	cache.Bypass = block.inheritedValues("proxy_cache_bypass")
+	cache.NoCache = block.inheritedValues("proxy_no_cache")
+
+	cache.IgnoreHeaders = block.cacheValue("proxy_ignore_headers", "")
+	if cache.IgnoreHeaders.Line != nil {
+		for _, header := range cache.IgnoreHeaders.Line.Params {
+			switch strings.ToLower(unquote(header)) {
+			case "set-cookie":
+				cache.IgnoresSetCookie = true
+			case "cache-control":
+				cache.IgnoresCacheControl = true
+			}
+		}
+	}
+	hidden, _ := block.inheritedLines("proxy_hide_header")
+	for _, line := range hidden {
+		if len(line.Params) > 0 && strings.EqualFold(unquote(line.Params[0]), "Set-Cookie") {
+			cache.HidesSetCookie = true
+		}
+	}
+
+	return cache
+}
+
+// cacheValue returns the value of a directive in effect in the block, or the default
+func (block *Block) cacheValue(name, defaultValue string) CacheValue {
+	for current := block; current != nil; current = current.ParentRef {
+		if lines := current.FindLines(name); len(lines) > 0 {
+			line := lines[len(lines)-1]
+			return CacheValue{Value: strings.Join(line.Params, " "), Line: line, Block: current}
+		}
+	}
+	return CacheValue{Value: defaultValue}
+}
+
+// inheritedLines returns the directives with the name from the closest block
+// defining any, the way nginx inherits array directives as a whole, and that block
+func (block *Block) inheritedLines(name string) ([]*Line, *Block) {
+	for current := block; current != nil; current = current.ParentRef {
+		if lines := current.FindLines(name); len(lines) > 0 {
+			return lines, current
+		}
+	}
+	return nil, nil
+}
+
+// inheritedValues returns each parameter of the inherited directives with the name
+// as a value of its own
+func (block *Block) inheritedValues(name string) []CacheValue {
+	var values []CacheValue
+	lines, owner := block.inheritedLines(name)
+	for _, line := range lines {
+		for _, param := range line.Params {
+			values = append(values, CacheValue{Value: param, Line: line, Block: owner})
+		}
+	}
+	return values
+}
+
+// ValidateCaching flags cached locations that may serve one user's response to
+// another: a cache key leaving out the query string while the upstream request
+// carries it ($request_uri or $args in proxy_pass), and cached locations setting
+// cookies, either with add_header or by caching upstream Set-Cookie responses
+func (config *Config) ValidateCaching() []ValidationIssue {
+	var issues []ValidationIssue
+	for _, cache := range config.CacheReport() {
+		location := strings.Join(cache.Location.Params, " ")
+
+		if pass := cache.Location.EffectiveDirective("proxy_pass"); pass != nil && len(pass.Params) > 0 && forwardsQuery(pass.Params[0]) && !cache.keyHasQuery() {
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityWarning,
+				Directive:  "proxy_cache_key",
+				Message:    fmt.Sprintf("location %s passes the query string upstream but the cache key %s (%s) omits $args, responses for different queries are shared", location, cache.Key.Value, cache.Key.Origin()),
+				LineNumber: cacheIssueLine(cache.Key, cache.Location),
+			})
+		}
+
+		if cache.IgnoresSetCookie && !cache.HidesSetCookie {
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityWarning,
+				Directive:  "proxy_ignore_headers",
+				Message:    fmt.Sprintf("location %s caches upstream responses with Set-Cookie and replays the cookies to every client, add proxy_hide_header Set-Cookie", location),
+				LineNumber: cache.IgnoreHeaders.Line.LineNumber,
+			})
+		}
+		for current := cache.Location; current != nil; current = current.ParentRef {
+			lines := current.FindLines("add_header")
+			if len(lines) == 0 {
+				continue
+			}
+			for _, line := range lines {
+				if len(line.Params) > 0 && strings.EqualFold(unquote(line.Params[0]), "Set-Cookie") {
+					issues = append(issues, ValidationIssue{
+						Severity:   SeverityWarning,
+						Directive:  line.Name,
+						Message:    fmt.Sprintf("location %s is cached with proxy_cache but sets cookies", location),
+						LineNumber: line.LineNumber,
+					})
+				}
+			}
+			break
+		}
+	}
+	return issues
+}
+
+// keyHasQuery reports whether the cache key varies on the query string
+func (cache *LocationCache) keyHasQuery() bool {
+	for _, variable := range cache.KeyVariables {
+		if queryVariables[variable] {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardsQuery reports whether a proxy_pass address carries the client query string
+func forwardsQuery(address string) bool {
+	for _, match := range variablePattern.FindAllStringSubmatch(address, -1) {
+		if queryVariables[match[1]+match[2]] {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheIssueLine returns the line to report a setting at: its directive, or the
+// location when the default applies
+func cacheIssueLine(value CacheValue, location *Block) int {
+	if value.Line != nil {
+		return value.Line.LineNumber
+	}
+	return location.LineNumber
+}
@@ -0,0 +1,130 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cacheDirectives maps the directives using a cache zone to the directives defining it
+var cacheDirectives = map[string]string{
+	"proxy_cache":   "proxy_cache_path",
+	"fastcgi_cache": "fastcgi_cache_path",
+	"uwsgi_cache":   "uwsgi_cache_path",
+	"scgi_cache":    "scgi_cache_path",
+}
+
+// defaultCacheInactive is how long cached data that is not accessed is kept unless
+// inactive is set
+const defaultCacheInactive = 10 * time.Minute
+
+// CacheUsage is a proxy_cache directive using a cache zone
+type CacheUsage struct {
+	Line *Line
+	Path []string // Enclosing blocks from the outermost (e.g. ["http", "server example.com", "location /api/"])
+}
+
+// CacheDef is a proxy_cache_path definition and the proxy_cache directives using it
+type CacheDef struct {
+	Zone     string        // keys_zone name
+	Module   string        // Defining directive, e.g. proxy_cache_path, zones of each module are distinct
+	Path     string        // Cache directory
+	Size     int64         // keys_zone size in bytes, 0 if not specified
+	Levels   string        // Directory hierarchy as written, e.g. 1:2, empty for a single directory
+	Inactive time.Duration // Time data not accessed stays cached, 10m unless set
+	MaxSize  int64         // max_size in bytes, 0 if unset
+	Line     *Line         // Defining directive, nil when the zone is used but never defined
+	Usages   []CacheUsage  // Directives using the zone in configuration order
+}
+
+// CacheConfig pairs proxy_cache_path definitions, and those of the fastcgi, uwsgi
+// and scgi modules, with the directives using their zone. Usages naming the zone
+// with variables cannot be resolved and are left out. Caches are listed in
+// definition order, followed by zones that are used but never defined
+func (config *Config) CacheConfig() []CacheDef {
+	var caches []*CacheDef
+	index := map[string]*CacheDef{}
+	lookup := func(module, zone string) *CacheDef {
+		key := module + " " + zone
+		if index[key] == nil {
+			index[key] = &CacheDef{Zone: zone, Module: module, Inactive: defaultCacheInactive}
+			caches = append(caches, index[key])
+		}
+		return index[key]
+	}
+
+	var collect func(block *Block, path []string)
+	collect = func(block *Block, path []string) {
+		for _, line := range block.Lines {
+			if line.Type != LineTypeDirective || len(line.Params) == 0 {
+				continue
+			}
+			if cachePathDirectives[line.Name] {
+				zone, ok := parseZoneParam(line, "keys_zone", cacheKeysPerMB)
+				if !ok {
+					continue
+				}
+				cache := lookup(line.Name, zone.Name)
+				cache.Line, cache.Size, cache.Path = line, zone.Size, unquote(line.Params[0])
+				params := line.KeyValueParams()
+				cache.Levels = params["levels"]
+				if inactive, err := ParseDuration(params["inactive"]); err == nil {
+					cache.Inactive = inactive
+				}
+				if maxSize, err := ParseSize(params["max_size"]); err == nil {
+					cache.MaxSize = maxSize
+				}
+				continue
+			}
+
+			module, ok := cacheDirectives[line.Name]
+			zone := unquote(line.Params[0])
+			if !ok || zone == "off" || strings.Contains(zone, "$") {
+				continue
+			}
+			cache := lookup(module, zone)
+			cache.Usages = append(cache.Usages, CacheUsage{Line: line, Path: path})
+		}
+		for _, child := range block.Blocks {
+			collect(child, appendPath(path, blockKey(child)))
+		}
+	}
+	collect(config.RootBlock, nil)
+
+	var defined, undefined []CacheDef
+	for _, cache := range caches {
+		if cache.Line != nil {
+			defined = append(defined, *cache)
+		} else {
+			undefined = append(undefined, *cache)
+		}
+	}
+	return append(defined, undefined...)
+}
+
+// ValidateCacheZones reports caches defined but never used and proxy_cache
+// directives referring to zones that are not defined, which nginx rejects at startup
+func (config *Config) ValidateCacheZones() []ValidationIssue {
+	var issues []ValidationIssue
+	for _, cache := range config.CacheConfig() {
+		switch {
+		case cache.Line == nil:
+			for _, usage := range cache.Usages {
+				issues = append(issues, ValidationIssue{
+					Severity:   SeverityError,
+					Directive:  usage.Line.Name,
+					Message:    fmt.Sprintf("cache zone %q is not defined by %s", cache.Zone, cache.Module),
+					LineNumber: usage.Line.LineNumber,
+				})
+			}
+		case len(cache.Usages) == 0:
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityWarning,
+				Directive:  cache.Module,
+				Message:    fmt.Sprintf("cache zone %q is defined but never used", cache.Zone),
+				LineNumber: cache.Line.LineNumber,
+			})
+		}
+	}
+	return issues
+}
@@ -0,0 +1,142 @@
+package nginx
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GenerateChangeLog describes the changes from previous to this configuration in
+// plain English, e.g. "Added server block for api.example.com on port 443;
+// Changed proxy_pass for /service from upstream_v1 to upstream_v2."
+func (config *Config) GenerateChangeLog(previous *Config) string {
+	changes := previous.Diff(config)
+	if len(changes) == 0 {
+		return "No changes."
+	}
+
+	sentences := make([]string, 0, len(changes))
+	for _, change := range changes {
+		sentences = append(sentences, describeChange(change))
+	}
+	return strings.Join(sentences, "; ") + "."
+}
+
+// describeChange renders a single change as a sentence fragment
+func describeChange(change Change) string {
+	context := describeContext(change.Context)
+
+	if change.IsBlock {
+		subject := describeBlock(change.Block)
+		if change.Kind == ChangeAdded {
+			if context == "" {
+				return "Added " + subject
+			}
+			return fmt.Sprintf("Added %s to %s", subject, context)
+		}
+		if context == "" {
+			return "Removed " + subject
+		}
+		return fmt.Sprintf("Removed %s from %s", subject, context)
+	}
+
+	scope := ""
+	if context != "" {
+		scope = " in " + context
+	}
+	switch change.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("Added %s%s", formatStatement(change.Name, change.After), scope)
+	case ChangeRemoved:
+		return fmt.Sprintf("Removed %s%s", formatStatement(change.Name, change.Before), scope)
+	default:
+		target := ""
+		if context != "" {
+			target = " for " + describeTarget(change.Context)
+		}
+		return fmt.Sprintf("Changed %s%s from %s to %s", change.Name, target,
+			strings.Join(change.Before, " "), strings.Join(change.After, " "))
+	}
+}
+
+// describeContext names the block a change happened in, "" for the main and http contexts
+func describeContext(block *Block) string {
+	if block == nil || block.Name == "root" || block.Name == "http" {
+		return ""
+	}
+	switch block.Name {
+	case "server":
+		return "server " + describeServer(block)
+	case "location":
+		description := describeBlock(block)
+		if server := block.Ancestor("server"); server != nil {
+			description += " of server " + describeServer(server)
+		}
+		return description
+	}
+	return describeBlock(block)
+}
+
+// describeTarget names the block a changed directive applies to: the location
+// path for locations, the server names for servers
+func describeTarget(block *Block) string {
+	switch block.Name {
+	case "location":
+		if location := NewLocation(block); location != nil {
+			return location.Pattern
+		}
+	case "server":
+		return describeServer(block)
+	}
+	return describeBlock(block)
+}
+
+// describeBlock names a block for humans
+func describeBlock(block *Block) string {
+	switch block.Name {
+	case "server":
+		description := "server block for " + describeServer(block)
+		if ports := describePorts(block); ports != "" {
+			description += " on " + ports
+		}
+		return description
+	case "location":
+		return formatStatement("location", block.Params)
+	case "upstream":
+		return "upstream " + strings.Join(block.Params, " ")
+	}
+	return formatStatement(block.Name, block.Params) + " block"
+}
+
+// describeServer names a server block by its server names
+func describeServer(server *Block) string {
+	names := ServerNames(server)
+	if len(names) == 0 {
+		return "server"
+	}
+	return strings.Join(names, ", ")
+}
+
+// describePorts lists the ports a server listens on, e.g. "port 443" or "ports 80, 443"
+func describePorts(server *Block) string {
+	ports, _ := serverListenPorts(server)
+	if len(ports) == 0 {
+		return ""
+	}
+
+	numbers := make([]int, 0, len(ports))
+	for port := range ports {
+		numbers = append(numbers, port)
+	}
+	sort.Ints(numbers)
+
+	values := make([]string, 0, len(numbers))
+	for _, port := range numbers {
+		values = append(values, strconv.Itoa(port))
+	}
+	if len(values) == 1 {
+		return "port " + values[0]
+	}
+	return "ports " + strings.Join(values, ", ")
+}
@@ -0,0 +1,76 @@
+package nginx
+
+import (
+	"regexp"
+	"strings"
+)
+
+// directiveNamePattern matches names that look like nginx directives
+var directiveNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// CommentedDirective is a directive found inside a comment, typically disabled configuration
+type CommentedDirective struct {
+	Name       string   // Directive name
+	Params     []string // Directive parameters
+	IsBlock    bool     // Whether the comment opens a block (e.g. "#server {")
+	Line       *Line    // Comment line containing the directive
+	Block      *Block   // Block containing the comment
+	LineNumber int      // Line number of the comment
+}
+
+// CommentedDirectives returns the directives that have been commented out, such
+// as "#listen 80;", distinguishing disabled configuration from prose comments
+func (config *Config) CommentedDirectives() []CommentedDirective {
+	var directives []CommentedDirective
+
+	config.WalkBlocks(func(block *Block) {
+		for _, line := range block.Lines {
+			if line.Type != LineTypeComment {
+				continue
+			}
+			for _, directive := range parseCommentedDirectives(strings.Join(line.Comments, " ")) {
+				directive.Line = line
+				directive.Block = block
+				directive.LineNumber = line.LineNumber
+				directives = append(directives, directive)
+			}
+		}
+	})
+
+	return directives
+}
+
+// parseCommentedDirectives parses comment text as directives, returning nothing
+// if the text does not read like configuration
+func parseCommentedDirectives(text string) []CommentedDirective {
+	// Nested comments ("#	# auth_http ...") are disabled directives too
+	text = strings.TrimSpace(strings.TrimLeft(text, "# \t"))
+	if !strings.HasSuffix(text, ";") && !strings.HasSuffix(text, "{") {
+		return nil
+	}
+	if commentStart, unterminated := scanComment(text); commentStart >= 0 || unterminated {
+		return nil
+	}
+
+	var directives []CommentedDirective
+	for _, statement := range splitDirectives(text) {
+		isBlock := opensBlock(statement)
+		if isBlock {
+			statement = strings.TrimSuffix(statement, "{")
+		}
+		parts := splitParams(statement)
+		if len(parts) == 0 {
+			continue
+		}
+		if !directiveNamePattern.MatchString(parts[0]) {
+			return nil
+		}
+		directives = append(directives, CommentedDirective{
+			Name:    parts[0],
+			Params:  parts[1:],
+			IsBlock: isBlock,
+		})
+	}
+
+	return directives
+}
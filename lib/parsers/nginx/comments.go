@@ -0,0 +1,58 @@
+package nginx
+
+import "strings"
+
+// separateComments removes every comment from the tree under root and returns
+// their text keyed by the line number they appear on, see ParseOptions.SeparateComments
+func separateComments(root *Block) map[int]string {
+	comments := map[int]string{}
+	add := func(lineNumber int, texts []string) {
+		if len(texts) == 0 {
+			return
+		}
+		text := strings.Join(texts, " ")
+		if existing, ok := comments[lineNumber]; ok {
+			text = existing + " " + text
+		}
+		comments[lineNumber] = text
+	}
+
+	walkBlock(root, func(block *Block) {
+		lines := block.Lines[:0]
+		for _, line := range block.Lines {
+			add(line.LineNumber, line.Comments)
+			line.Comments = nil
+			if line.Type == LineTypeComment {
+				continue
+			}
+			if line.BlockRef != nil {
+				line.BlockRef.Comments = nil
+			}
+			lines = append(lines, line)
+		}
+		block.Lines = lines
+
+		if len(block.ClosingComments) > 0 {
+			add(closingCommentLine(block), block.ClosingComments)
+			block.ClosingComments = nil
+		}
+	})
+
+	return comments
+}
+
+// closingCommentLine returns the line number of the comment closing a block: the
+// line of the closing brace, or the next one for an end marker on its own line
+func closingCommentLine(block *Block) int {
+	raw := strings.SplitAfter(block.RawClosing, "\n")
+	for i, text := range raw {
+		if !strings.Contains(text, "}") {
+			continue
+		}
+		if !strings.Contains(text, "#") && i < len(raw)-1 && strings.Contains(raw[i+1], "#") {
+			return block.EndLineNumber + 1
+		}
+		break
+	}
+	return block.EndLineNumber
+}
@@ -0,0 +1,186 @@
+package nginx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// compressionModule describes the directives of an on-the-fly compression module
+type compressionModule struct {
+	Name      string // Directive enabling compression, also the prefix of the others
+	MinLevel  int    // Lowest valid compression level
+	MaxLevel  int    // Highest valid compression level
+	SaneLevel int    // Highest level worth its CPU cost for dynamic compression
+}
+
+// compressionModules lists the modules audited by CompressionAudit
+var compressionModules = []compressionModule{
+	{Name: "gzip", MinLevel: 1, MaxLevel: 9, SaneLevel: 6},
+	{Name: "brotli", MinLevel: 0, MaxLevel: 11, SaneLevel: 6},
+}
+
+// compressedTypes lists MIME types whose content is already compressed
+var compressedTypes = map[string]bool{
+	"application/zip":              true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/vnd.rar":          true,
+	"application/zstd":             true,
+	"application/octet-stream":     true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+	"application/font-woff":        true,
+	"application/font-woff2":       true,
+}
+
+// isCompressedType reports whether responses of a MIME type are already compressed:
+// archives, WOFF fonts and media other than SVG images
+func isCompressedType(mimeType string) bool {
+	mimeType = strings.ToLower(mimeType)
+	switch {
+	case compressedTypes[mimeType]:
+		return true
+	case strings.HasPrefix(mimeType, "image/"):
+		return mimeType != "image/svg+xml" && mimeType != "image/x-icon" && mimeType != "image/vnd.microsoft.icon" && mimeType != "image/bmp"
+	case strings.HasPrefix(mimeType, "video/"), strings.HasPrefix(mimeType, "audio/"):
+		return true
+	}
+	return false
+}
+
+// CompressionAudit checks the gzip and brotli settings: compression enabled without
+// a types list, which compresses text/html only, levels outside the valid range or
+// too costly for compression on the fly, and already compressed types such as
+// images and archives listed for compression
+func (config *Config) CompressionAudit() []ValidationIssue {
+	var issues []ValidationIssue
+	for _, module := range compressionModules {
+		typesName := module.Name + "_types"
+		levelName := module.Name + "_comp_level"
+
+		config.WalkBlocks(func(block *Block) {
+			for _, line := range block.FindLines(module.Name) {
+				if len(line.Params) == 0 || unquote(line.Params[0]) != "on" || block.EffectiveDirective(typesName) != nil {
+					continue
+				}
+				issues = append(issues, ValidationIssue{
+					Severity:   SeverityWarning,
+					Directive:  line.Name,
+					Message:    fmt.Sprintf("%s is on without %s, only text/html responses are compressed", module.Name, typesName),
+					LineNumber: line.LineNumber,
+				})
+			}
+
+			for _, line := range block.FindLines(levelName) {
+				if len(line.Params) == 0 {
+					continue
+				}
+				level, err := strconv.Atoi(unquote(line.Params[0]))
+				switch {
+				case err != nil || level < module.MinLevel || level > module.MaxLevel:
+					issues = append(issues, ValidationIssue{
+						Severity:   SeverityError,
+						Directive:  line.Name,
+						Message:    fmt.Sprintf("%s %s is not a level between %d and %d", levelName, line.Params[0], module.MinLevel, module.MaxLevel),
+						LineNumber: line.LineNumber,
+					})
+				case level > module.SaneLevel:
+					issues = append(issues, ValidationIssue{
+						Severity:   SeverityWarning,
+						Directive:  line.Name,
+						Message:    fmt.Sprintf("%s %d costs much more CPU than level %d for a marginally smaller response", levelName, level, module.SaneLevel),
+						LineNumber: line.LineNumber,
+					})
+				}
+			}
+
+			for _, line := range block.FindLines(typesName) {
+				for _, param := range line.Params {
+					mimeType := unquote(param)
+					var message string
+					switch {
+					case mimeType == "*":
+						message = fmt.Sprintf("%s * compresses every response, including images and archives that are already compressed", typesName)
+					case isCompressedType(mimeType):
+						message = fmt.Sprintf("%s lists %s, whose content is already compressed", typesName, mimeType)
+					case strings.EqualFold(mimeType, "text/html"):
+						message = fmt.Sprintf("%s lists text/html, which is always compressed", typesName)
+					default:
+						continue
+					}
+					issues = append(issues, ValidationIssue{
+						Severity:   SeverityWarning,
+						Directive:  line.Name,
+						Message:    message,
+						LineNumber: line.LineNumber,
+					})
+				}
+			}
+		})
+	}
+	return issues
+}
+
+// defaultGzipTypes lists the MIME types AutoAddGzipConfig compresses when given
+// none: text/html is always compressed and cannot be listed
+var defaultGzipTypes = []string{
+	"text/plain",
+	"text/css",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"image/svg+xml",
+	"application/xml",
+	"text/xml",
+}
+
+// AutoAddGzipConfig enables gzip in the first http block with the usual settings:
+// gzip on, gzip_vary on, gzip_min_length 1024 and gzip_types with the given types,
+// or defaultGzipTypes when nil. text/html is dropped from the types, nginx always
+// compresses it and warns about the duplicate. Nothing is changed when gzip is
+// already on; when it is off, it is turned on and the gzip directives already set
+// are kept
+func (config *Config) AutoAddGzipConfig(types []string) error {
+	https := config.RootBlock.FindBlocks("http")
+	if len(https) == 0 {
+		return fmt.Errorf("%w: http", ErrBlockNotFound)
+	}
+	http := https[0]
+	for _, line := range http.FindLines("gzip") {
+		if len(line.Params) > 0 && unquote(line.Params[0]) == "on" {
+			return nil
+		}
+	}
+
+	if types == nil {
+		types = defaultGzipTypes
+	}
+	var params []string
+	for _, mimeType := range types {
+		if !strings.EqualFold(unquote(mimeType), "text/html") {
+			params = append(params, mimeType)
+		}
+	}
+
+	http.SetDirective("gzip", "on")
+	for _, directive := range []struct {
+		name   string
+		params []string
+	}{
+		{"gzip_vary", []string{"on"}},
+		{"gzip_min_length", []string{"1024"}},
+		{"gzip_types", params},
+	} {
+		if len(directive.params) > 0 && len(http.FindLines(directive.name)) == 0 {
+			http.SetDirective(directive.name, directive.params...)
+		}
+	}
+	config.Dirty = true
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package nginx
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// rootFS is the local file system in the layout include resolution uses. Paths
+// written in configurations are handled separator agnostic: backslashes, as in
+// configurations of nginx for Windows, are read as slashes and a drive letter starts
+// an absolute path. Resolved paths use forward slashes, which nginx and Go both
+// accept on Windows, and configurations are written back as they were read. Files
+// are then looked up through an fs.FS holding absolute paths without their leading
+// slash, e.g. etc/nginx/nginx.conf, and drive-letter paths as they are, e.g.
+// C:/nginx/conf/nginx.conf. The *FS variants of the include and file reference
+// checks take any fs.FS in that layout, such as a testing/fstest.MapFS
+type rootFS struct{}
+
+// Open opens the named file
+func (rootFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Open(osPath(name))
+}
+
+// Stat returns the file information of the named file
+func (rootFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Stat(osPath(name))
+}
+
+// ReadDir reads the named directory
+func (rootFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.ReadDir(osPath(name))
+}
+
+// osPath returns the local path of a rootFS name. Drive-letter paths only exist on
+// Windows, elsewhere they are looked up under / and not found
+func osPath(name string) string {
+	if runtime.GOOS == "windows" && hasDriveLetter(name) {
+		return filepath.FromSlash(name[:2] + "/" + strings.TrimPrefix(name[2:], "/"))
+	}
+	if name == "." {
+		return string(filepath.Separator)
+	}
+	return filepath.FromSlash("/" + name)
+}
+
+// localPath returns the local path of a resolved path, for the checks that need
+// the file system itself such as permissions
+func localPath(p string) string {
+	name, err := fsName(p)
+	if err != nil {
+		return p
+	}
+	return osPath(name)
+}
+
+// configPath normalizes a path written in a configuration to forward slashes
+func configPath(p string) string {
+	return strings.ReplaceAll(p, `\`, "/")
+}
+
+// hasDriveLetter reports whether a normalized path starts with a drive letter, as C:
+func hasDriveLetter(p string) bool {
+	return len(p) >= 2 && p[1] == ':' && ('a' <= p[0] && p[0] <= 'z' || 'A' <= p[0] && p[0] <= 'Z') && (len(p) == 2 || p[2] == '/')
+}
+
+// isAbsConfigPath reports whether a path written in a configuration is absolute,
+// rooted or starting with a drive letter
+func isAbsConfigPath(p string) bool {
+	p = configPath(p)
+	return strings.HasPrefix(p, "/") || hasDriveLetter(p)
+}
+
+// resolveIncludePath makes a path written in a configuration absolute against
+// baseDir, both separator agnostic, and returns it cleaned with forward slashes.
+// The path stays relative when baseDir is
+func resolveIncludePath(baseDir, p string) string {
+	p = configPath(p)
+	if isAbsConfigPath(p) {
+		return path.Clean(p)
+	}
+	return path.Join(configPath(baseDir), p)
+}
+
+// fsName returns the name of a resolved path in the fs.FS layout, relative paths
+// being made absolute against the working directory
+func fsName(p string) (string, error) {
+	if !isAbsConfigPath(p) {
+		absolute, err := filepath.Abs(filepath.FromSlash(p))
+		if err != nil {
+			return "", err
+		}
+		p = configPath(absolute)
+	}
+	name := strings.TrimPrefix(path.Clean(p), "/")
+	if name == "" {
+		return ".", nil
+	}
+	return name, nil
+}
+
+// fsPath returns the resolved path of a name in the fs.FS layout
+func fsPath(name string) string {
+	if hasDriveLetter(name) {
+		return name
+	}
+	return path.Clean("/" + name)
+}
+
+// statPath returns the file information of a resolved path in fsys
+func statPath(fsys fs.FS, p string) (fs.FileInfo, error) {
+	name, err := fsName(p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(fsys, name)
+}
+
+// globPaths returns the paths of fsys matching a resolved glob pattern, sorted.
+// Matches of a relative pattern are relative to the working directory too
+func globPaths(fsys fs.FS, pattern string) ([]string, error) {
+	name, err := fsName(pattern)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := fs.Glob(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := ""
+	if !isAbsConfigPath(pattern) {
+		if dir, err := fsName("."); err == nil {
+			prefix = strings.TrimSuffix(fsPath(dir), "/") + "/"
+		}
+	}
+	paths := make([]string, 0, len(matches))
+	for _, match := range matches {
+		p := fsPath(match)
+		if prefix != "" {
+			p = strings.TrimPrefix(p, prefix)
+		}
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// parsePath parses the configuration file at a resolved path in fsys
+func parsePath(fsys fs.FS, p string) (*Config, error) {
+	name, err := fsName(p)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseReader(file, p)
+}
@@ -0,0 +1,157 @@
+package nginx
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// includeFS lays out a configuration tree the way rootFS does, absolute paths
+// without their leading slash
+var includeFS = fstest.MapFS{
+	"etc/nginx/mime.types":             {Data: []byte("types { text/html html; }\n")},
+	"etc/nginx/conf.d/a.conf":          {Data: []byte("server { listen 80; }\n")},
+	"etc/nginx/conf.d/b.conf":          {Data: []byte("server { listen 81; include snippets/ssl.conf; }\n")},
+	"etc/nginx/conf.d/readme.txt":      {Data: []byte("not a configuration\n")},
+	"etc/nginx/snippets/ssl.conf":      {Data: []byte("ssl_protocols TLSv1.3;\n")},
+	"etc/nginx/snippets/loop.conf":     {Data: []byte("include snippets/loop.conf;\n")},
+	"C:/nginx/conf/mime.types":         {Data: []byte("types { text/css css; }\n")},
+	"C:/nginx/conf/sites/default.conf": {Data: []byte("server { listen 8080; }\n")},
+}
+
+func TestResolveIncludePath(t *testing.T) {
+	tests := []struct {
+		baseDir, path, want string
+	}{
+		{"/etc/nginx", "mime.types", "/etc/nginx/mime.types"},
+		{"/etc/nginx", "conf.d/*.conf", "/etc/nginx/conf.d/*.conf"},
+		{"/etc/nginx", "../shared/./x.conf", "/etc/shared/x.conf"},
+		{"/etc/nginx", "/usr/share/nginx/mime.types", "/usr/share/nginx/mime.types"},
+		{`C:\nginx\conf`, `sites\default.conf`, "C:/nginx/conf/sites/default.conf"},
+		{"/etc/nginx", `D:\nginx\mime.types`, "D:/nginx/mime.types"},
+		{"conf", "mime.types", "conf/mime.types"},
+	}
+
+	for _, tt := range tests {
+		if got := resolveIncludePath(tt.baseDir, tt.path); got != tt.want {
+			t.Errorf("resolveIncludePath(%q, %q) = %q, want %q", tt.baseDir, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFSName(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"/etc/nginx/nginx.conf", "etc/nginx/nginx.conf"},
+		{"/", "."},
+		{"C:/nginx/conf/nginx.conf", "C:/nginx/conf/nginx.conf"},
+	}
+
+	for _, tt := range tests {
+		got, err := fsName(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("fsName(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+		if back := fsPath(got); back != tt.path {
+			t.Errorf("fsPath(%q) = %q, want %q", got, back, tt.path)
+		}
+	}
+}
+
+func TestValidateIncludesFS(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseDir string
+		include string
+		want    string // Message of the single issue, empty for none
+	}{
+		{name: "relative file", baseDir: "/etc/nginx", include: "mime.types"},
+		{name: "absolute file", baseDir: "/srv", include: "/etc/nginx/mime.types"},
+		{name: "glob", baseDir: "/etc/nginx", include: "conf.d/*.conf"},
+		{name: "drive letter", baseDir: `C:\nginx\conf`, include: `sites\default.conf`},
+		{name: "missing file", baseDir: "/etc/nginx", include: "fastcgi_params", want: `included file "/etc/nginx/fastcgi_params" does not exist`},
+		{name: "missing directory", baseDir: "/etc/nginx", include: "/opt/nginx/mime.types", want: `included file "/opt/nginx/mime.types" does not exist`},
+		{name: "glob without matches", baseDir: "/etc/nginx", include: "sites-enabled/*", want: `include pattern "/etc/nginx/sites-enabled/*" matches no files`},
+		{name: "invalid glob", baseDir: "/etc/nginx", include: "conf.d/[a", want: `invalid include pattern "/etc/nginx/conf.d/[a"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseReader(strings.NewReader("http { include "+tt.include+"; }"), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			issues := config.ValidateIncludesFS(includeFS, tt.baseDir)
+			if tt.want == "" {
+				if len(issues) != 0 {
+					t.Fatalf("unexpected issues %+v", issues)
+				}
+				return
+			}
+			if len(issues) != 1 || !strings.HasPrefix(issues[0].Message, tt.want) {
+				t.Fatalf("got issues %+v, want one starting with %q", issues, tt.want)
+			}
+		})
+	}
+}
+
+func TestInlineIncludesFS(t *testing.T) {
+	config, err := ParseReader(strings.NewReader("http {\n    include mime.types;\n    include conf.d/*.conf;\n}\n"), "nginx.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.InlineIncludesFS(includeFS, "/etc/nginx"); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(config.FindLinesByName("include")); got != 0 {
+		t.Fatalf("%d include directives left", got)
+	}
+	var listens []string
+	for _, line := range config.FindLinesByName("listen") {
+		listens = append(listens, line.Params[0])
+	}
+	if got := strings.Join(listens, " "); got != "80 81" {
+		t.Fatalf("listen %s, want the servers of a.conf then b.conf", got)
+	}
+	if len(config.FindBlocksByName("types")) != 1 || len(config.FindLinesByName("ssl_protocols")) != 1 {
+		t.Fatalf("mime.types or the nested snippet were not inlined:\n%s", config.AutoIndent(4))
+	}
+	for _, server := range config.FindBlocksByName("server") {
+		if server.ParentRef != config.FindBlocksByName("http")[0] {
+			t.Fatalf("server at line %d is not attached to the http block", server.LineNumber)
+		}
+	}
+}
+
+func TestInlineIncludesFSErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		include string
+		check   func(err error) bool
+	}{
+		{"missing file", "fastcgi_params", func(err error) bool { return errors.Is(err, fs.ErrNotExist) }},
+		{"include cycle", "snippets/loop.conf", func(err error) bool { return strings.Contains(err.Error(), "nested deeper") }},
+		{"invalid glob", "conf.d/[a", func(err error) bool { return strings.Contains(err.Error(), "invalid include pattern") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseReader(strings.NewReader("http { include "+tt.include+"; }"), "nginx.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = config.InlineIncludesFS(includeFS, "/etc/nginx")
+			if err == nil || !tt.check(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
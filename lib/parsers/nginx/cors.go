@@ -0,0 +1,383 @@
+package nginx
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CORS origin kinds
+const (
+	CORSOriginLiteral   = "literal"   // A fixed origin
+	CORSOriginWildcard  = "wildcard"  // *, any origin without credentials
+	CORSOriginReflected = "reflected" // The request Origin sent back, see CORSOrigin.Condition
+	CORSOriginUnknown   = "unknown"   // A variable that cannot be resolved statically
+)
+
+// untrustedOrigin is an origin no allowlist should accept, used to tell whether an
+// origin check actually restricts reflection
+const untrustedOrigin = "https://untrusted.invalid"
+
+// CORSOrigin is an origin a location allows
+type CORSOrigin struct {
+	Kind      string `json:"kind"`                // CORSOriginLiteral, CORSOriginWildcard, CORSOriginReflected or CORSOriginUnknown
+	Value     string `json:"value"`               // Origin or expression as written
+	Condition string `json:"condition,omitempty"` // Map key or if condition the origin depends on, empty when unconditional
+	Validated bool   `json:"validated"`           // Whether a reflected origin is restricted to an allowlist
+	Line      int    `json:"line"`                // Directive defining the origin
+}
+
+// CORSPreflight is the handling of OPTIONS preflight requests
+type CORSPreflight struct {
+	ShortCircuited bool     `json:"shortCircuited"`    // Answered by return in an if on $request_method
+	Status         int      `json:"status,omitempty"`  // Status returned, 204 usually
+	Line           int      `json:"line,omitempty"`    // if block answering preflights
+	Headers        []string `json:"headers,omitempty"` // Access-Control-* headers of the preflight response
+}
+
+// CORSPolicy is the effective CORS posture of a location
+type CORSPolicy struct {
+	Location        *Block        `json:"-"`
+	Path            []string      `json:"path"`                     // Enclosing blocks from the outermost, ending with the location
+	Line            int           `json:"line"`                     // Line of the location block
+	Origins         []CORSOrigin  `json:"origins"`                  // Allowed origins, empty if Access-Control-Allow-Origin is not sent
+	Methods         []string      `json:"methods,omitempty"`        // Access-Control-Allow-Methods
+	Headers         []string      `json:"headers,omitempty"`        // Access-Control-Allow-Headers
+	ExposedHeaders  []string      `json:"exposedHeaders,omitempty"` // Access-Control-Expose-Headers
+	Credentials     bool          `json:"credentials"`              // Access-Control-Allow-Credentials: true
+	MaxAge          string        `json:"maxAge,omitempty"`         // Access-Control-Max-Age
+	Preflight       CORSPreflight `json:"preflight"`
+	ResponseHeaders []string      `json:"responseHeaders"`   // Access-Control-* headers of regular responses
+	Dropped         []string      `json:"dropped,omitempty"` // Access-Control-* headers of enclosing blocks not sent, because the location sets add_header of its own
+}
+
+// CORSReport resolves the CORS headers in effect in every location of the http
+// servers that sends or drops any Access-Control-* header. add_header directives
+// are inherited as a whole from the closest block defining any, so headers set
+// around a location that adds headers of its own are reported as dropped
+func (config *Config) CORSReport() []CORSPolicy {
+	var policies []CORSPolicy
+	var collect func(block *Block, path []string)
+	collect = func(block *Block, path []string) {
+		if block.Name == "location" && block.Ancestor("http") != nil {
+			if policy := block.corsPolicy(path); policy != nil {
+				policies = append(policies, *policy)
+			}
+		}
+		for _, child := range block.Blocks {
+			collect(child, appendPath(path, blockKey(child)))
+		}
+	}
+	collect(config.RootBlock, nil)
+
+	return policies
+}
+
+// corsPolicy resolves the CORS posture of a location, nil if CORS is not involved
+func (block *Block) corsPolicy(path []string) *CORSPolicy {
+	policy := &CORSPolicy{Location: block, Path: path, Line: block.LineNumber, Origins: []CORSOrigin{}, ResponseHeaders: []string{}}
+
+	lines, owner := block.inheritedLines("add_header")
+	main := corsHeaders(lines)
+	for _, name := range sortedCORSNames(main) {
+		policy.ResponseHeaders = append(policy.ResponseHeaders, unquote(main[name].Params[0]))
+	}
+	if owner != nil {
+		for current := owner.ParentRef; current != nil; current = current.ParentRef {
+			for name, line := range corsHeaders(current.FindLines("add_header")) {
+				if main[name] == nil && !containsFold(policy.Dropped, unquote(line.Params[0])) {
+					policy.Dropped = append(policy.Dropped, unquote(line.Params[0]))
+				}
+			}
+		}
+		sort.Strings(policy.Dropped)
+	}
+
+	var preflight map[string]*Line
+	for _, condition := range block.FindBlocks("if") {
+		if !isPreflightCondition(strings.Join(condition.Params, " ")) {
+			continue
+		}
+		policy.Preflight.Line = condition.LineNumber
+		if returns := condition.FindLines("return"); len(returns) > 0 && len(returns[0].Params) > 0 {
+			policy.Preflight.ShortCircuited = true
+			policy.Preflight.Status, _ = strconv.Atoi(returns[0].Params[0])
+		}
+		// An if block inherits the add_header of the location unless it sets its own
+		lines, _ := condition.inheritedLines("add_header")
+		preflight = corsHeaders(lines)
+		for _, name := range sortedCORSNames(preflight) {
+			policy.Preflight.Headers = append(policy.Preflight.Headers, unquote(preflight[name].Params[0]))
+		}
+		break
+	}
+
+	if len(main) == 0 && len(preflight) == 0 && len(policy.Dropped) == 0 {
+		return nil
+	}
+
+	header := func(name string) *Line {
+		if line := main[name]; line != nil {
+			return line
+		}
+		return preflight[name]
+	}
+	if line := header("access-control-allow-origin"); line != nil {
+		policy.Origins = block.corsOrigins(line)
+	}
+	if line := header("access-control-allow-methods"); line != nil {
+		policy.Methods = corsList(line)
+	}
+	if line := header("access-control-allow-headers"); line != nil {
+		policy.Headers = corsList(line)
+	}
+	if line := header("access-control-expose-headers"); line != nil {
+		policy.ExposedHeaders = corsList(line)
+	}
+	if line := header("access-control-allow-credentials"); line != nil {
+		policy.Credentials = strings.EqualFold(unquote(line.Params[1]), "true")
+	}
+	if line := header("access-control-max-age"); line != nil {
+		policy.MaxAge = unquote(line.Params[1])
+	}
+
+	return policy
+}
+
+// corsOrigins resolves the origins an Access-Control-Allow-Origin header allows:
+// literal values, *, $http_origin, and variables set from $http_origin by a map or
+// by set, inside an if on $http_origin or not
+func (block *Block) corsOrigins(line *Line) []CORSOrigin {
+	value := unquote(line.Params[1])
+	switch {
+	case value == "*":
+		return []CORSOrigin{{Kind: CORSOriginWildcard, Value: value, Line: line.LineNumber}}
+	case value == "$http_origin":
+		return []CORSOrigin{{Kind: CORSOriginReflected, Value: value, Line: line.LineNumber}}
+	case !strings.HasPrefix(value, "$"):
+		return []CORSOrigin{{Kind: CORSOriginLiteral, Value: value, Line: line.LineNumber}}
+	}
+
+	name := strings.TrimPrefix(strings.Trim(value, "${}"), "$")
+	if m := block.variableMap(name); m != nil {
+		var origins []CORSOrigin
+		add := func(key, result string, lineNumber int, validated, captures bool) {
+			origin := CORSOrigin{Kind: CORSOriginLiteral, Value: result, Condition: key, Line: lineNumber}
+			switch {
+			case result == "":
+				// An empty value sends no header
+				return
+			case result == "*":
+				origin.Kind = CORSOriginWildcard
+			case strings.Contains(result, "$http_origin") || captures:
+				origin.Kind = CORSOriginReflected
+				origin.Validated = validated
+			case strings.HasPrefix(result, "$"):
+				origin.Kind = CORSOriginUnknown
+			}
+			origins = append(origins, origin)
+		}
+		for _, entry := range m.Entries {
+			// Regex captures of the origin reflect it as well
+			fromOrigin := m.Source == "$http_origin"
+			captures := fromOrigin && entry.isRegex && rewriteCapturePattern.MatchString(entry.Value)
+			validated := fromOrigin && (entry.Regex == nil || !entry.Regex.MatchString(untrustedOrigin))
+			add(entry.Key, entry.Value, entry.Line.LineNumber, validated, captures)
+		}
+		if defaults := m.Block.FindLines("default"); len(defaults) > 0 {
+			add("default", m.Default, defaults[len(defaults)-1].LineNumber, false, false)
+		}
+		return origins
+	}
+
+	var origins []CORSOrigin
+	for current := block; current != nil && len(origins) == 0; current = current.ParentRef {
+		scopes := append([]*Block{current}, current.FindBlocks("if")...)
+		for _, scope := range scopes {
+			for _, set := range scope.FindLines("set") {
+				if len(set.Params) != 2 || strings.TrimPrefix(unquote(set.Params[0]), "$") != name {
+					continue
+				}
+				result := unquote(set.Params[1])
+				origin := CORSOrigin{Kind: CORSOriginLiteral, Value: result, Line: set.LineNumber}
+				if scope.Name == "if" {
+					origin.Condition = strings.Join(scope.Params, " ")
+				}
+				switch {
+				case result == "":
+					continue
+				case result == "*":
+					origin.Kind = CORSOriginWildcard
+				case strings.Contains(result, "$http_origin"):
+					origin.Kind = CORSOriginReflected
+					origin.Validated = restrictsOrigin(origin.Condition)
+				case strings.HasPrefix(result, "$"):
+					origin.Kind = CORSOriginUnknown
+				}
+				origins = append(origins, origin)
+			}
+		}
+	}
+	if len(origins) == 0 {
+		origins = append(origins, CORSOrigin{Kind: CORSOriginUnknown, Value: value, Line: line.LineNumber})
+	}
+	return origins
+}
+
+// restrictsOrigin reports whether an if condition only holds for some origins: a
+// comparison or regex match on $http_origin rejecting an untrusted origin
+func restrictsOrigin(condition string) bool {
+	condition = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(condition), "("), ")"))
+	fields := strings.Fields(condition)
+	if len(fields) != 3 || fields[0] != "$http_origin" {
+		return false
+	}
+	pattern := unquote(fields[2])
+	switch fields[1] {
+	case "=":
+		return pattern != untrustedOrigin
+	case "~", "~*":
+		if fields[1] == "~*" {
+			pattern = "(?i)" + pattern
+		}
+		regex, err := regexp.Compile(pattern)
+		return err == nil && !regex.MatchString(untrustedOrigin)
+	}
+	return false
+}
+
+// isPreflightCondition reports whether an if condition selects OPTIONS requests
+func isPreflightCondition(condition string) bool {
+	fields := strings.Fields(strings.Trim(condition, "() "))
+	if len(fields) != 3 || fields[0] != "$request_method" {
+		return false
+	}
+	value := unquote(fields[2])
+	switch fields[1] {
+	case "=":
+		return value == "OPTIONS"
+	case "~", "~*":
+		return strings.Contains(strings.ToUpper(value), "OPTIONS")
+	}
+	return false
+}
+
+// corsHeaders returns the Access-Control-* add_header lines by lowercase header name
+func corsHeaders(lines []*Line) map[string]*Line {
+	headers := map[string]*Line{}
+	for _, line := range lines {
+		if len(line.Params) < 2 {
+			continue
+		}
+		name := strings.ToLower(unquote(line.Params[0]))
+		if strings.HasPrefix(name, "access-control-") {
+			headers[name] = line
+		}
+	}
+	return headers
+}
+
+// sortedCORSNames returns the header names of corsHeaders in sorted order
+func sortedCORSNames(headers map[string]*Line) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// corsList splits a comma separated header value
+func corsList(line *Line) []string {
+	var values []string
+	for _, value := range strings.Split(unquote(line.Params[1]), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// containsFold reports whether values holds value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateCORS flags wildcard origins combined with credentials, origins reflected
+// without an allowlist, preflight responses missing Access-Control-* headers the
+// location sends otherwise and headers dropped by add_header inheritance
+func (config *Config) ValidateCORS() []ValidationIssue {
+	var issues []ValidationIssue
+	for _, policy := range config.CORSReport() {
+		location := "location " + strings.Join(policy.Location.Params, " ")
+		for _, origin := range policy.Origins {
+			switch {
+			case origin.Kind == CORSOriginWildcard && policy.Credentials:
+				issues = append(issues, ValidationIssue{
+					Severity:   SeverityError,
+					Directive:  "add_header",
+					Message:    location + " allows any origin with credentials, browsers reject the response",
+					LineNumber: origin.Line,
+				})
+			case origin.Kind == CORSOriginReflected && !origin.Validated:
+				severity := SeverityWarning
+				message := location + " reflects the request Origin without validating it, any site can read the responses"
+				if policy.Credentials {
+					severity = SeverityError
+					message = location + " reflects the request Origin without validating it and allows credentials, any site can read authenticated responses"
+				}
+				issues = append(issues, ValidationIssue{Severity: severity, Directive: "add_header", Message: message, LineNumber: origin.Line})
+			}
+		}
+
+		if policy.Preflight.ShortCircuited {
+			var missing []string
+			for _, name := range policy.ResponseHeaders {
+				if !strings.EqualFold(name, "Access-Control-Expose-Headers") && !containsFold(policy.Preflight.Headers, name) {
+					missing = append(missing, name)
+				}
+			}
+			if len(missing) > 0 {
+				issues = append(issues, ValidationIssue{
+					Severity:   SeverityWarning,
+					Directive:  "if",
+					Message:    fmt.Sprintf("preflight response of %s lacks %s sent by the location, add_header in the if block replaces the inherited ones", location, strings.Join(missing, ", ")),
+					LineNumber: policy.Preflight.Line,
+				})
+			}
+		}
+
+		if len(policy.Dropped) > 0 {
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityWarning,
+				Directive:  "add_header",
+				Message:    fmt.Sprintf("%s sets add_header of its own, %s from enclosing blocks are not sent", location, strings.Join(policy.Dropped, ", ")),
+				LineNumber: policy.Line,
+			})
+		}
+	}
+	return issues
+}
+
+// CORSJSON returns the CORS report and its findings as JSON for review tooling
+func (config *Config) CORSJSON() ([]byte, error) {
+	payload := struct {
+		Locations []CORSPolicy      `json:"locations"`
+		Issues    []ValidationIssue `json:"issues"`
+	}{Locations: config.CORSReport(), Issues: config.ValidateCORS()}
+	if payload.Locations == nil {
+		payload.Locations = []CORSPolicy{}
+	}
+	if payload.Issues == nil {
+		payload.Issues = []ValidationIssue{}
+	}
+	return json.MarshalIndent(payload, "", "  ")
+}
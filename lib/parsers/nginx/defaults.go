@@ -0,0 +1,57 @@
+package nginx
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"path"
+)
+
+// DefaultConfigVersion is the nginx release the embedded default configuration was taken from
+const DefaultConfigVersion = "1.26"
+
+//go:embed defaults
+var defaultFiles embed.FS
+
+// standardIncludes lists the include files shipped with the default configuration
+var standardIncludes = []string{"mime.types", "fastcgi_params", "proxy_params", "scgi_params", "uwsgi_params"}
+
+// DefaultConfig returns the stock nginx.conf shipped with DefaultConfigVersion
+func DefaultConfig() *Config {
+	config, err := parseDefaultFile("nginx.conf")
+	if err != nil {
+		// The embedded files are part of the build, failing to parse them is a bug
+		panic(err)
+	}
+	return config
+}
+
+// StandardInclude returns the parsed contents of a stock include file such as
+// "mime.types" or "fastcgi_params" as a root block
+func StandardInclude(name string) (*Block, error) {
+	for _, include := range standardIncludes {
+		if include == name {
+			config, err := parseDefaultFile(name)
+			if err != nil {
+				return nil, err
+			}
+			return config.RootBlock, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown standard include %q", name)
+}
+
+// DeviationsFromDefault returns the changes between the stock nginx.conf and this configuration
+func (config *Config) DeviationsFromDefault() []Change {
+	return DefaultConfig().Diff(config)
+}
+
+// parseDefaultFile parses an embedded file of the current default version
+func parseDefaultFile(name string) (*Config, error) {
+	filePath := path.Join("defaults", DefaultConfigVersion, name)
+	data, err := defaultFiles.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseReader(bytes.NewReader(data), filePath)
+}
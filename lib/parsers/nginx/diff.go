@@ -0,0 +1,253 @@
+package nginx
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ChangeKind represents the kind of difference between two configurations
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"    // Present only in the new configuration
+	ChangeRemoved  ChangeKind = "removed"  // Present only in the old configuration
+	ChangeModified ChangeKind = "modified" // Present in both with different parameters
+)
+
+// Change represents a single difference between two configurations
+type Change struct {
+	Kind       ChangeKind // Kind of change
+	Path       []string   // Path of enclosing blocks (e.g. ["http", "server", "location /"])
+	Name       string     // Directive or block name
+	IsBlock    bool       // Whether the change affects a whole block
+	Before     []string   // Parameters in the old configuration, nil when added
+	After      []string   // Parameters in the new configuration, nil when removed
+	LineBefore int        // Line number in the old configuration, 0 when added
+	LineAfter  int        // Line number in the new configuration, 0 when removed
+
+	Context *Block // Enclosing block, from the new configuration unless the change is a removal
+	Block   *Block // Added or removed block when IsBlock is set
+}
+
+// String returns a one-line description of the change
+func (change Change) String() string {
+	location := strings.Join(change.Path, " > ")
+	if location == "" {
+		location = "main"
+	}
+
+	subject := change.Name
+	if change.IsBlock {
+		subject += " block"
+	}
+
+	switch change.Kind {
+	case ChangeAdded:
+		return strings.TrimSpace(fmt.Sprintf("%s: added %s %s", location, subject, strings.Join(change.After, " ")))
+	case ChangeRemoved:
+		return strings.TrimSpace(fmt.Sprintf("%s: removed %s %s", location, subject, strings.Join(change.Before, " ")))
+	default:
+		return fmt.Sprintf("%s: changed %s from %q to %q", location, subject,
+			strings.Join(change.Before, " "), strings.Join(change.After, " "))
+	}
+}
+
+// Diff compares the configuration against other and returns the changes needed
+// to turn this configuration into other. Comments are ignored
+func (config *Config) Diff(other *Config) []Change {
+	var changes []Change
+	diffBlocks(nil, config.RootBlock, other.RootBlock, &changes)
+	return changes
+}
+
+// jsonChange is a change in the form DiffJSON writes it
+type jsonChange struct {
+	Kind        ChangeKind `json:"kind"`
+	Path        []string   `json:"path"`
+	Name        string     `json:"name"`
+	Block       bool       `json:"block"`
+	Before      []string   `json:"before"`
+	After       []string   `json:"after"`
+	LineBefore  int        `json:"line_before"`
+	LineAfter   int        `json:"line_after"`
+	Description string     `json:"description"`
+}
+
+// DiffJSON returns the changes turning a into b (see Config.Diff) as a JSON array,
+// for tools rendering them such as a review UI. Each change holds its kind (added,
+// removed or modified), the path of enclosing blocks, the directive or block name,
+// the parameters before and after (null when added or removed), the line numbers
+// in a and b (0 when absent) and a one-line description. Changes are in the
+// deterministic order of Diff and every field is always present, an empty path
+// standing for the main context
+func DiffJSON(a, b *Config) ([]byte, error) {
+	changes := []jsonChange{}
+	for _, change := range a.Diff(b) {
+		path := change.Path
+		if path == nil {
+			path = []string{}
+		}
+		changes = append(changes, jsonChange{
+			Kind:        change.Kind,
+			Path:        path,
+			Name:        change.Name,
+			Block:       change.IsBlock,
+			Before:      change.Before,
+			After:       change.After,
+			LineBefore:  change.LineBefore,
+			LineAfter:   change.LineAfter,
+			Description: change.String(),
+		})
+	}
+	return json.MarshalIndent(changes, "", "  ")
+}
+
+// diffBlocks compares the directives and child blocks of two matching blocks
+func diffBlocks(path []string, before, after *Block, changes *[]Change) {
+	// Directives are matched by name, identical parameters first
+	for _, name := range directiveNames(before, after) {
+		oldLines := before.FindLines(name)
+		newLines := after.FindLines(name)
+
+		matchedOld := map[int]bool{}
+		var unmatchedNew []*Line
+		for _, newLine := range newLines {
+			found := false
+			for i, oldLine := range oldLines {
+				if !matchedOld[i] && equalParams(oldLine.Params, newLine.Params) {
+					matchedOld[i] = true
+					found = true
+					break
+				}
+			}
+			if !found {
+				unmatchedNew = append(unmatchedNew, newLine)
+			}
+		}
+
+		var unmatchedOld []*Line
+		for i, oldLine := range oldLines {
+			if !matchedOld[i] {
+				unmatchedOld = append(unmatchedOld, oldLine)
+			}
+		}
+
+		for i := 0; i < len(unmatchedOld) || i < len(unmatchedNew); i++ {
+			change := Change{Path: path, Name: name, Context: after}
+			if i < len(unmatchedOld) {
+				change.Before = unmatchedOld[i].Params
+				change.LineBefore = unmatchedOld[i].LineNumber
+			}
+			if i < len(unmatchedNew) {
+				change.After = unmatchedNew[i].Params
+				change.LineAfter = unmatchedNew[i].LineNumber
+			}
+			switch {
+			case change.Before == nil:
+				change.Kind = ChangeAdded
+			case change.After == nil:
+				change.Kind = ChangeRemoved
+				change.Context = before
+			default:
+				change.Kind = ChangeModified
+			}
+			*changes = append(*changes, change)
+		}
+	}
+
+	// Child blocks are matched by name and parameters, in order of appearance
+	newByKey := map[string][]*Block{}
+	for _, child := range after.Blocks {
+		newByKey[blockKey(child)] = append(newByKey[blockKey(child)], child)
+	}
+
+	for _, child := range before.Blocks {
+		key := blockKey(child)
+		if len(newByKey[key]) == 0 {
+			*changes = append(*changes, Change{
+				Kind:       ChangeRemoved,
+				Path:       path,
+				Name:       child.Name,
+				IsBlock:    true,
+				Before:     child.Params,
+				LineBefore: child.LineNumber,
+				Context:    before,
+				Block:      child,
+			})
+			continue
+		}
+		match := newByKey[key][0]
+		newByKey[key] = newByKey[key][1:]
+		diffBlocks(appendPath(path, key), child, match, changes)
+	}
+
+	for _, child := range after.Blocks {
+		for _, unmatched := range newByKey[blockKey(child)] {
+			if unmatched == child {
+				*changes = append(*changes, Change{
+					Kind:      ChangeAdded,
+					Path:      path,
+					Name:      child.Name,
+					IsBlock:   true,
+					After:     child.Params,
+					LineAfter: child.LineNumber,
+					Context:   after,
+					Block:     child,
+				})
+			}
+		}
+	}
+}
+
+// directiveNames returns the directive names used in either block, in order of first appearance
+func directiveNames(blocks ...*Block) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, block := range blocks {
+		for _, line := range block.Lines {
+			if line.Type != LineTypeDirective && line.Type != LineTypeInclude {
+				continue
+			}
+			if !seen[line.Name] {
+				seen[line.Name] = true
+				names = append(names, line.Name)
+			}
+		}
+	}
+	return names
+}
+
+// blockKey identifies a block by its name and parameters. Server blocks have no
+// parameters and are identified by their server names instead
+func blockKey(block *Block) string {
+	if block.Name == "server" && len(block.Params) == 0 {
+		if names := ServerNames(block); len(names) > 0 {
+			return "server " + strings.Join(names, " ")
+		}
+	}
+	if len(block.Params) == 0 {
+		return block.Name
+	}
+	return block.Name + " " + strings.Join(block.Params, " ")
+}
+
+// appendPath returns a copy of path with element appended
+func appendPath(path []string, element string) []string {
+	result := make([]string, 0, len(path)+1)
+	result = append(result, path...)
+	return append(result, element)
+}
+
+// equalParams reports whether two parameter lists are identical
+func equalParams(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,279 @@
+package nginx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dumpFileHeader introduces each file in the output of nginx -T
+const dumpFileHeader = "# configuration file "
+
+// DriftKind tells how a file differs between the running configuration and the disk
+type DriftKind string
+
+const (
+	DriftChanged DriftKind = "changed" // File edited since the running configuration was loaded
+	DriftAdded   DriftKind = "added"   // File in the include set on disk that was not loaded
+	DriftRemoved DriftKind = "removed" // File loaded that is no longer in the include set on disk
+)
+
+// FileDrift is a configuration file that differs between the running
+// configuration and the disk
+type FileDrift struct {
+	Path    string    // Absolute path of the file
+	Kind    DriftKind // How the file differs
+	Changes []Change  // Changes turning the loaded file into the one on disk, for changed files
+}
+
+// DriftReport lists the files whose content on disk differs from what the running
+// nginx loaded
+type DriftReport struct {
+	Files []FileDrift // Loaded files in dump order, then files added on disk
+}
+
+// Empty reports whether the running configuration matches the files on disk
+func (report *DriftReport) Empty() bool {
+	return len(report.Files) == 0
+}
+
+// String describes the drift file by file, or says there is none
+func (report *DriftReport) String() string {
+	if report.Empty() {
+		return "no drift: the running configuration matches the files on disk\n"
+	}
+
+	var builder strings.Builder
+	for _, file := range report.Files {
+		switch file.Kind {
+		case DriftChanged:
+			fmt.Fprintf(&builder, "%s: changed since the running configuration was loaded\n", file.Path)
+			for _, change := range file.Changes {
+				fmt.Fprintf(&builder, "  %s\n", change)
+			}
+		case DriftAdded:
+			fmt.Fprintf(&builder, "%s: added to the include set since the running configuration was loaded\n", file.Path)
+		case DriftRemoved:
+			fmt.Fprintf(&builder, "%s: removed from the include set since the running configuration was loaded\n", file.Path)
+		}
+	}
+	return builder.String()
+}
+
+// DetectDrift compares the configuration on disk with the one a running nginx
+// loaded, given as the output of nginx -T captured from it, and reports the files
+// that differ. disk is the main file as parsed, its includes are followed on disk
+// relative to its directory. Files are compared directive by directive, so
+// comments, whitespace and the framing nginx -T adds around each file are ignored
+func DetectDrift(disk *Config, runningDump io.Reader) (*DriftReport, error) {
+	if disk.FilePath == "" {
+		return nil, fmt.Errorf("configuration on disk has no file path")
+	}
+	running, runningOrder, err := parseDump(runningDump)
+	if err != nil {
+		return nil, err
+	}
+	onDisk, diskOrder, err := diskIncludeSet(disk)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DriftReport{}
+	for _, path := range runningOrder {
+		diskFile, ok := onDisk[path]
+		if !ok {
+			report.Files = append(report.Files, FileDrift{Path: path, Kind: DriftRemoved})
+			continue
+		}
+		if changes := running[path].Diff(diskFile); len(changes) > 0 {
+			report.Files = append(report.Files, FileDrift{Path: path, Kind: DriftChanged, Changes: changes})
+		}
+	}
+	for _, path := range diskOrder {
+		if running[path] == nil {
+			report.Files = append(report.Files, FileDrift{Path: path, Kind: DriftAdded})
+		}
+	}
+	return report, nil
+}
+
+// parseDump splits the output of nginx -T into its files and parses each one.
+// Lines before the first file, such as the result of the syntax check when stderr
+// was captured too, are skipped
+func parseDump(r io.Reader) (map[string]*Config, []string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var order []string
+	contents := map[string][]string{}
+	current := ""
+	for _, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(line, dumpFileHeader) && strings.HasSuffix(line, ":") {
+			current = absolutePath(strings.TrimSuffix(strings.TrimPrefix(line, dumpFileHeader), ":"))
+			if _, seen := contents[current]; !seen {
+				order = append(order, current)
+			}
+			contents[current] = nil
+			continue
+		}
+		if current != "" {
+			contents[current] = append(contents[current], line)
+		}
+	}
+	if len(order) == 0 {
+		return nil, nil, fmt.Errorf("no configuration files in the dump, expected the output of nginx -T")
+	}
+
+	configs := map[string]*Config{}
+	for _, path := range order {
+		config, err := ParseReader(strings.NewReader(strings.Join(contents[path], "\n")), path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("running configuration: %v", err)
+		}
+		configs[path] = config
+	}
+	return configs, order, nil
+}
+
+// diskIncludeSet parses the files a configuration includes on disk, recursively,
+// keyed by absolute path. Included files that cannot be read are left out of the set
+func diskIncludeSet(disk *Config) (map[string]*Config, []string, error) {
+	main := absolutePath(disk.FilePath)
+	baseDir := filepath.Dir(main)
+	configs := map[string]*Config{main: disk}
+	order := []string{main}
+
+	var follow func(config *Config, depth int) error
+	follow = func(config *Config, depth int) error {
+		if depth > maxIncludeDepth {
+			return fmt.Errorf("includes nested deeper than %d levels", maxIncludeDepth)
+		}
+		for _, line := range config.FindLinesByName("include") {
+			files, err := includeFiles(rootFS{}, baseDir, line)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				path := absolutePath(file)
+				if configs[path] != nil {
+					continue
+				}
+				included, err := ParseConfig(path)
+				if os.IsNotExist(err) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				configs[path] = included
+				order = append(order, path)
+				if err := follow(included, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := follow(disk, 0); err != nil {
+		return nil, nil, err
+	}
+	return configs, order, nil
+}
+
+// absolutePath returns path made absolute and cleaned, as written if that fails
+func absolutePath(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		return absolute
+	}
+	return path
+}
+
+// DumpConfig runs nginx -T with the given binary and returns its output, for
+// DetectDrift. With a pid, the -c, -p and -g options of that process are read from
+// /proc (Linux only) and passed on, and the binary defaults to the one the process
+// runs. nginx -T reads the files from disk when it runs: the dump shows what the
+// process loaded only when captured at the time it started or was reloaded, so keep
+// the dump taken then and compare it later
+func DumpConfig(binary string, pid int) ([]byte, error) {
+	var args []string
+	if pid > 0 {
+		cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the command line of process %d: %v", pid, err)
+		}
+		args = processOptions(processArguments(string(cmdline)))
+		if binary == "" {
+			if binary, err = os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err != nil {
+				return nil, fmt.Errorf("cannot find the binary of process %d: %v", pid, err)
+			}
+		}
+	}
+	if binary == "" {
+		binary = "nginx"
+	}
+
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(binary, append([]string{"-T"}, args...)...)
+	command.Stdout, command.Stderr = &stdout, &stderr
+	if err := command.Run(); err != nil {
+		return nil, fmt.Errorf("%s -T: %v: %s", binary, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// processArguments splits the contents of /proc/PID/cmdline. The master process
+// overwrites its arguments with a title, "nginx: master process nginx -c file",
+// whose words are taken as the arguments. The directives of -g hold spaces, as in
+// the title "nginx: master process /usr/sbin/nginx -g daemon on; master_process on;"
+// of the stock systemd unit, so the words up to the next option are joined again
+func processArguments(cmdline string) []string {
+	arguments := strings.Split(strings.TrimRight(cmdline, "\x00"), "\x00")
+	if len(arguments) > 1 {
+		return arguments
+	}
+
+	words := strings.Fields(strings.TrimPrefix(arguments[0], "nginx: master process "))
+	var argv []string
+	for i := 0; i < len(words); i++ {
+		argument := words[i]
+		if strings.HasPrefix(argument, "-g") {
+			end := i + 1
+			for end < len(words) && !strings.HasPrefix(words[end], "-") {
+				end++
+			}
+			if argument == "-g" && end > i+1 {
+				argv = append(argv, argument)
+				argument = strings.Join(words[i+1:end], " ")
+			} else if end > i+1 {
+				argument = strings.Join(words[i:end], " ")
+			}
+			i = end - 1
+		}
+		argv = append(argv, argument)
+	}
+	return argv
+}
+
+// processOptions picks the options locating the configuration from the command
+// line of an nginx process: -c file, -p prefix and -g directives
+func processOptions(argv []string) []string {
+	var options []string
+	for i := 1; i < len(argv); i++ {
+		switch argument := argv[i]; {
+		case argument == "-c" || argument == "-p" || argument == "-g":
+			if i+1 < len(argv) {
+				options = append(options, argument, argv[i+1])
+				i++
+			}
+		case len(argument) > 2 && (strings.HasPrefix(argument, "-c") || strings.HasPrefix(argument, "-p") || strings.HasPrefix(argument, "-g")):
+			options = append(options, argument)
+		}
+	}
+	return options
+}
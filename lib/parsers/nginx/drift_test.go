@@ -0,0 +1,52 @@
+package nginx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProcessOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmdline string
+		want    []string
+	}{
+		{
+			name:    "systemd master process title",
+			cmdline: "nginx: master process /usr/sbin/nginx -g daemon on; master_process on;",
+			want:    []string{"-g", "daemon on; master_process on;"},
+		},
+		{
+			name:    "title with configuration file",
+			cmdline: "nginx: master process /usr/sbin/nginx -c /etc/nginx/nginx.conf -g daemon off;",
+			want:    []string{"-c", "/etc/nginx/nginx.conf", "-g", "daemon off;"},
+		},
+		{
+			name:    "title with attached directives",
+			cmdline: "nginx: master process nginx -gdaemon off; -p /opt/nginx/",
+			want:    []string{"-gdaemon off;", "-p", "/opt/nginx/"},
+		},
+		{
+			name:    "title without options",
+			cmdline: "nginx: master process nginx",
+		},
+		{
+			name:    "arguments",
+			cmdline: "/usr/sbin/nginx\x00-g\x00daemon on; master_process on;\x00-c\x00/etc/nginx/my conf.conf\x00",
+			want:    []string{"-g", "daemon on; master_process on;", "-c", "/etc/nginx/my conf.conf"},
+		},
+		{
+			name:    "test option dropped",
+			cmdline: "nginx\x00-t\x00-q\x00-p\x00/srv/nginx\x00",
+			want:    []string{"-p", "/srv/nginx"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := processOptions(processArguments(tt.cmdline)); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("options %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
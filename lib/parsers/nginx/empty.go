@@ -0,0 +1,64 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// emptyBlockAllowed lists blocks that are meaningful without a body: events runs
+// with its defaults and an empty types block clears the MIME types so that every
+// response gets default_type
+var emptyBlockAllowed = map[string]bool{
+	"events": true,
+	"types":  true,
+}
+
+// EmptyBlocks returns every block without directives or child blocks, usually a
+// mistake or configuration left behind. Blocks holding only comments are kept
+// out, their comments tend to explain a placeholder; see EmptyBlocksIncludingCommented
+func (config *Config) EmptyBlocks() []*Block {
+	return config.emptyBlocks(false)
+}
+
+// EmptyBlocksIncludingCommented returns the blocks EmptyBlocks does plus those
+// holding only comments
+func (config *Config) EmptyBlocksIncludingCommented() []*Block {
+	return config.emptyBlocks(true)
+}
+
+// emptyBlocks returns the blocks without statements, with or without comments
+func (config *Config) emptyBlocks(commented bool) []*Block {
+	var blocks []*Block
+	config.WalkBlocks(func(block *Block) {
+		if block == config.RootBlock || emptyBlockAllowed[block.Name] || strings.TrimSpace(block.Verbatim) != "" {
+			return
+		}
+		for _, line := range block.Lines {
+			if line.Type != LineTypeComment || !commented {
+				return
+			}
+		}
+		blocks = append(blocks, block)
+	})
+	return blocks
+}
+
+// ValidateEmptyBlocks reports the blocks found by EmptyBlocks. An empty upstream
+// is an error, nginx refuses to start without servers in it
+func (config *Config) ValidateEmptyBlocks() []ValidationIssue {
+	var issues []ValidationIssue
+	for _, block := range config.EmptyBlocks() {
+		issue := ValidationIssue{
+			Severity:   SeverityInfo,
+			Directive:  block.Name,
+			Message:    fmt.Sprintf("empty %s block, remove it or fill it in", formatStatement(block.Name, block.Params)),
+			LineNumber: block.LineNumber,
+		}
+		if block.Name == "upstream" {
+			issue.Severity = SeverityError
+			issue.Message = fmt.Sprintf("upstream %s has no servers, nginx refuses to start", strings.Join(block.Params, " "))
+		}
+		issues = append(issues, issue)
+	}
+	return issues
+}
@@ -0,0 +1,221 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// EnvVariable is an environment variable the env directive passes to the worker
+// processes, all others are removed from their environment
+type EnvVariable struct {
+	Name     string // Variable name
+	Value    string // Value set by the directive, empty when it is inherited from nginx
+	HasValue bool   // The directive sets a value (env NAME=value) rather than inheriting it
+	Line     *Line  // env directive, nil for TZ which nginx always keeps
+}
+
+// Sources of the environment variable references found by EnvironmentDependencies
+const (
+	EnvSourceLua      = "lua"      // os.getenv in Lua code
+	EnvSourcePerl     = "perl"     // %ENV in Perl code
+	EnvSourceTemplate = "template" // ${NAME} placeholder substituted before nginx reads the file
+)
+
+// EnvReference is a place where the configuration reads an environment variable
+type EnvReference struct {
+	Name       string // Variable name
+	Source     string // EnvSourceLua, EnvSourcePerl or EnvSourceTemplate
+	Line       *Line  // Directive or verbatim block holding the reference
+	LineNumber int    // Line number of the reference
+}
+
+// EnvironmentDependency is an environment variable the configuration depends on
+type EnvironmentDependency struct {
+	Name       string
+	Env        *EnvVariable   // env directive passing the variable to the workers, nil if none
+	References []EnvReference // Places reading the variable, empty for variables only passed
+}
+
+// Passed reports whether worker processes see the variable. Template placeholders
+// are substituted by the deployment before nginx starts and need no env directive
+func (dependency *EnvironmentDependency) Passed() bool {
+	if dependency.Env != nil {
+		return true
+	}
+	for _, reference := range dependency.References {
+		if reference.Source != EnvSourceTemplate {
+			return false
+		}
+	}
+	return true
+}
+
+// envReferencePatterns match the ways code reads an environment variable, the
+// name being the first non-empty group
+var envReferencePatterns = map[string]*regexp.Regexp{
+	EnvSourceLua:  regexp.MustCompile(`os\.getenv\s*\(?\s*["']([A-Za-z_][A-Za-z0-9_]*)["']`),
+	EnvSourcePerl: regexp.MustCompile(`\$ENV\s*\{\s*(?:["']([A-Za-z_][A-Za-z0-9_]*)["']|([A-Za-z_][A-Za-z0-9_]*))\s*\}`),
+}
+
+// templatePlaceholderPattern matches ${NAME} placeholders of templates rendered with
+// envsubst or the like. Upper case tells them from nginx ${name} variable references
+var templatePlaceholderPattern = regexp.MustCompile(`\$\{([A-Z][A-Z0-9_]*)\}`)
+
+// EnvVariables lists the environment variables the env directives of the main
+// context pass to the worker processes, TZ first as nginx keeps it unless an env
+// directive sets it
+func (config *Config) EnvVariables() []EnvVariable {
+	variables := []EnvVariable{{Name: "TZ"}}
+	for _, line := range config.RootBlock.FindLines("env") {
+		if len(line.Params) == 0 {
+			continue
+		}
+		variable := EnvVariable{Line: line}
+		variable.Name, variable.Value, variable.HasValue = strings.Cut(unquote(line.Params[0]), "=")
+		switch variable.Name {
+		case "":
+			continue
+		case "TZ":
+			variables[0] = variable
+			continue
+		}
+		variables = append(variables, variable)
+	}
+	return variables
+}
+
+// EnvironmentDependencies lists the environment variables the configuration
+// depends on, sorted by name, for deployment documentation: those passed with env
+// and those read by inline code (os.getenv in *_by_lua blocks and strings, %ENV in
+// perl and perl_set code) or appearing as ${NAME} template placeholders. Code is
+// found with a textual heuristic, so names built at runtime are missed. njs has no
+// inline form, its code lives in the files js_import loads and is not read
+func (config *Config) EnvironmentDependencies() []EnvironmentDependency {
+	dependencies := map[string]*EnvironmentDependency{}
+	dependency := func(name string) *EnvironmentDependency {
+		if dependencies[name] == nil {
+			dependencies[name] = &EnvironmentDependency{Name: name}
+		}
+		return dependencies[name]
+	}
+
+	for _, variable := range config.EnvVariables() {
+		variable := variable
+		if variable.Line != nil || variable.Name != "TZ" {
+			dependency(variable.Name).Env = &variable
+		}
+	}
+	for _, reference := range config.envReferences() {
+		entry := dependency(reference.Name)
+		entry.References = append(entry.References, reference)
+	}
+	if tz := dependencies["TZ"]; tz != nil && tz.Env == nil {
+		tz.Env = &EnvVariable{Name: "TZ"}
+	}
+
+	names := make([]string, 0, len(dependencies))
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]EnvironmentDependency, 0, len(names))
+	for _, name := range names {
+		result = append(result, *dependencies[name])
+	}
+	return result
+}
+
+// envReferences finds the environment variables read by inline code and template
+// placeholders, block by block
+func (config *Config) envReferences() []EnvReference {
+	var references []EnvReference
+	scan := func(source, code string, line *Line, lineNumber int) {
+		pattern := templatePlaceholderPattern
+		if source != EnvSourceTemplate {
+			pattern = envReferencePatterns[source]
+		}
+		for _, match := range pattern.FindAllStringSubmatchIndex(code, -1) {
+			for group := 1; 2*group < len(match); group++ {
+				if start := match[2*group]; start >= 0 {
+					references = append(references, EnvReference{
+						Name:       code[start:match[2*group+1]],
+						Source:     source,
+						Line:       line,
+						LineNumber: lineNumber + strings.Count(code[:start], "\n"),
+					})
+					break
+				}
+			}
+		}
+	}
+
+	config.WalkBlocks(func(block *Block) {
+		for _, line := range block.Lines {
+			if line.Type == LineTypeComment || line.Name == "env" {
+				continue
+			}
+			if source := inlineCodeSource(line.Name); source != "" {
+				if line.Type == LineTypeBlock && line.BlockRef != nil {
+					scan(source, line.BlockRef.Verbatim, line, line.LineNumber)
+				} else {
+					for _, param := range line.Params {
+						scan(source, unquote(param), line, line.LineNumber)
+					}
+				}
+			}
+			for _, param := range line.Params {
+				scan(EnvSourceTemplate, param, line, line.LineNumber)
+			}
+		}
+	})
+	return references
+}
+
+// inlineCodeSource returns the language of the code a directive holds inline,
+// empty for directives without inline code
+func inlineCodeSource(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_by_lua") || strings.HasSuffix(name, "_by_lua_block"):
+		return EnvSourceLua
+	case name == "perl" || name == "perl_set":
+		return EnvSourcePerl
+	}
+	return ""
+}
+
+// ValidateEnvironment flags environment variables inline Lua or Perl code reads
+// although no env directive passes them to the worker processes, where they are
+// unset, and env directives naming no variable
+func (config *Config) ValidateEnvironment() []ValidationIssue {
+	var issues []ValidationIssue
+	for _, line := range config.RootBlock.FindLines("env") {
+		if len(line.Params) == 0 || strings.HasPrefix(unquote(line.Params[0]), "=") {
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityError,
+				Directive:  "env",
+				Message:    "env directive without a variable name",
+				LineNumber: line.LineNumber,
+			})
+		}
+	}
+
+	for _, dependency := range config.EnvironmentDependencies() {
+		if dependency.Passed() {
+			continue
+		}
+		for _, reference := range dependency.References {
+			if reference.Source == EnvSourceTemplate {
+				continue
+			}
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityWarning,
+				Directive:  reference.Line.Name,
+				Message:    fmt.Sprintf("%s code reads environment variable %s, which worker processes do not inherit unless the main context has env %s;", reference.Source, dependency.Name, dependency.Name),
+				LineNumber: reference.LineNumber,
+			})
+		}
+	}
+	return issues
+}
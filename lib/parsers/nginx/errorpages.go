@@ -0,0 +1,64 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CheckMissingErrorPages checks that the URI every error_page directive redirects
+// to matches a location of the server handling the error, the one it is written
+// in or, for error_page directives of the http block, each server inheriting them.
+// Without a location the error page is served with the server settings, and nginx
+// falls back to its built-in page when that fails. Named locations (see
+// ValidateNamedLocations), redirects to other URLs and URIs built from variables
+// are not checked
+func (config *Config) CheckMissingErrorPages() []ValidationError {
+	var issues []ValidationError
+	check := func(line *Line, server *Block, inherited bool) {
+		target, ok := errorPageURI(line)
+		if !ok || server.FindLocationByURI(target) != nil {
+			return
+		}
+		where := "this server"
+		if inherited {
+			where = blockKey(server)
+		}
+		issues = append(issues, ValidationError{
+			Severity:   SeverityWarning,
+			Directive:  line.Name,
+			Message:    fmt.Sprintf("error_page target %q matches no location in %s, nginx returns its generic error page if the file cannot be served", target, where),
+			LineNumber: line.LineNumber,
+		})
+	}
+
+	for _, http := range config.FindBlocksByName("http") {
+		inherited := http.FindLines("error_page")
+		for _, server := range http.FindBlocks("server") {
+			if len(server.FindLines("error_page")) == 0 {
+				for _, line := range inherited {
+					check(line, server, true)
+				}
+			}
+			walkBlock(server, func(block *Block) {
+				for _, line := range block.FindLines("error_page") {
+					check(line, server, false)
+				}
+			})
+		}
+	}
+	return issues
+}
+
+// errorPageURI returns the local URI an error_page directive redirects to. ok is
+// false for named locations, redirects to URLs and URIs built from variables
+func errorPageURI(line *Line) (string, bool) {
+	if len(line.Params) < 2 {
+		return "", false
+	}
+	target := unquote(line.Params[len(line.Params)-1])
+	target, _, _ = strings.Cut(target, "?")
+	if !strings.HasPrefix(target, "/") || strings.Contains(target, "$") {
+		return "", false
+	}
+	return target, true
+}
@@ -0,0 +1,379 @@
+package nginx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxExpires is the caching time of "expires max", ten years
+const maxExpires = 315360000 * time.Second
+
+// Expires is a parsed expires value
+type Expires struct {
+	Off       bool          // expires off, the Expires and Cache-Control headers are left alone
+	Epoch     bool          // expires epoch, Cache-Control no-cache
+	Max       bool          // expires max, cached for ten years
+	Modified  bool          // Offset counts from the file modification time instead of the request time
+	TimeOfDay bool          // Offset is the time of day (@18h30m) the response expires at every day
+	Offset    time.Duration // Caching time, negative for no-cache, or the time of day since midnight
+}
+
+// ParseExpires parses the parameters of an expires directive: off, epoch, max, a
+// time optionally prefixed with + or -, "modified" followed by a time, or a time of
+// day such as @18h30m
+func ParseExpires(params []string) (Expires, error) {
+	var expires Expires
+	if len(params) == 2 {
+		if params[0] != "modified" {
+			return expires, fmt.Errorf("invalid expires %q", strings.Join(params, " "))
+		}
+		expires.Modified = true
+		params = params[1:]
+	}
+	if len(params) != 1 {
+		return expires, fmt.Errorf("expires takes one or two parameters")
+	}
+
+	value := unquote(params[0])
+	if !expires.Modified {
+		switch value {
+		case "off":
+			expires.Off = true
+			return expires, nil
+		case "epoch":
+			expires.Epoch = true
+			return expires, nil
+		case "max":
+			expires.Max = true
+			return expires, nil
+		}
+	}
+
+	negative := false
+	switch {
+	case strings.HasPrefix(value, "@"):
+		if expires.Modified {
+			return expires, fmt.Errorf("daily time %s cannot be used with modified", value)
+		}
+		expires.TimeOfDay = true
+		value = value[1:]
+	case strings.HasPrefix(value, "+"):
+		value = value[1:]
+	case strings.HasPrefix(value, "-"):
+		negative = true
+		value = value[1:]
+	}
+
+	offset, err := ParseDuration(value)
+	if err != nil {
+		return expires, err
+	}
+	if expires.TimeOfDay && offset >= 24*time.Hour {
+		return expires, fmt.Errorf("daily time @%s is not within a day", value)
+	}
+	if negative {
+		offset = -offset
+	}
+	expires.Offset = offset
+	return expires, nil
+}
+
+// CacheControl returns the Cache-Control header nginx sends for the value, empty for
+// off. Values depending on the request time or the file are described rather than
+// computed, e.g. "max-age=<until 18:00 daily>"
+func (expires Expires) CacheControl() string {
+	switch {
+	case expires.Off:
+		return ""
+	case expires.Epoch, expires.Offset < 0 && !expires.TimeOfDay:
+		return "no-cache"
+	case expires.Max:
+		return "max-age=" + strconv.FormatInt(int64(maxExpires/time.Second), 10)
+	case expires.TimeOfDay:
+		return "max-age=<until " + timeOfDay(expires.Offset) + " daily>"
+	case expires.Modified:
+		return "max-age=<" + expires.Offset.String() + " after last modification>"
+	}
+	return "max-age=" + strconv.FormatInt(int64(expires.Offset/time.Second), 10)
+}
+
+// MaxAge describes how long browsers cache the response, e.g. "1h0m0s", "no-cache"
+// or "until 18:00 daily"
+func (expires Expires) MaxAge() string {
+	switch {
+	case expires.Off:
+		return "unspecified"
+	case expires.Epoch, expires.Offset < 0 && !expires.TimeOfDay:
+		return "no-cache"
+	case expires.Max:
+		return "10 years"
+	case expires.TimeOfDay:
+		return "until " + timeOfDay(expires.Offset) + " daily"
+	case expires.Modified:
+		return expires.Offset.String() + " after last modification"
+	}
+	return expires.Offset.String()
+}
+
+// timeOfDay formats a duration since midnight as HH:MM
+func timeOfDay(offset time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(offset/time.Hour), int(offset%time.Hour/time.Minute))
+}
+
+// BrowserCacheRule is the browser caching of the responses of a content type
+type BrowserCacheRule struct {
+	ContentType  string   // Content type or map key such as ~image/, "*" for every other type
+	Expires      *Expires // expires value for the type, nil when unset or not statically known
+	CacheControl []string // Cache-Control headers sent, from expires first, then from add_header
+	MaxAge       string   // Browser caching time, e.g. "1h0m0s", "no-cache" or "unspecified"
+}
+
+// BrowserCachePolicy is the effective browser caching of a location
+type BrowserCachePolicy struct {
+	Location     *Block
+	Path         []string           // Enclosing blocks from the outermost, ending with the location
+	Expires      *Line              // expires directive in effect, nil if none
+	CacheControl *Line              // add_header Cache-Control in effect, nil if none
+	Rules        []BrowserCacheRule // Caching by content type
+}
+
+// BrowserCachePolicy resolves the expires directive and add_header Cache-Control in
+// effect in every location of the http servers, skipping locations with neither.
+// expires set from a map on $sent_http_content_type yields a rule per map entry and
+// one for the default; other variables yield a single rule without a known caching time
+func (config *Config) BrowserCachePolicy() []BrowserCachePolicy {
+	var policies []BrowserCachePolicy
+	var collect func(block *Block, path []string)
+	collect = func(block *Block, path []string) {
+		if block.Name == "location" && block.Ancestor("http") != nil {
+			if policy := block.browserCachePolicy(path); policy != nil {
+				policies = append(policies, *policy)
+			}
+		}
+		for _, child := range block.Blocks {
+			collect(child, appendPath(path, blockKey(child)))
+		}
+	}
+	collect(config.RootBlock, nil)
+
+	return policies
+}
+
+// browserCachePolicy resolves the browser caching of a location, nil if it sets none
+func (block *Block) browserCachePolicy(path []string) *BrowserCachePolicy {
+	policy := &BrowserCachePolicy{Location: block, Path: path, Expires: block.EffectiveDirective("expires")}
+	headers, _ := block.inheritedLines("add_header")
+	for _, line := range headers {
+		if len(line.Params) >= 2 && strings.EqualFold(unquote(line.Params[0]), "Cache-Control") {
+			policy.CacheControl = line
+		}
+	}
+	if policy.Expires == nil && policy.CacheControl == nil {
+		return nil
+	}
+
+	explicit := ""
+	if policy.CacheControl != nil {
+		explicit = unquote(policy.CacheControl.Params[1])
+	}
+	rule := func(contentType string, expires *Expires, maxAge string) BrowserCacheRule {
+		rule := BrowserCacheRule{ContentType: contentType, Expires: expires, MaxAge: maxAge}
+		if expires != nil {
+			if header := expires.CacheControl(); header != "" {
+				rule.CacheControl = append(rule.CacheControl, header)
+			}
+			if !expires.Off {
+				rule.MaxAge = expires.MaxAge()
+			}
+		}
+		if explicit != "" {
+			rule.CacheControl = append(rule.CacheControl, explicit)
+			if rule.MaxAge == "unspecified" {
+				rule.MaxAge = cacheControlMaxAge(explicit)
+			}
+		}
+		return rule
+	}
+
+	if policy.Expires == nil || len(policy.Expires.Params) == 0 {
+		policy.Rules = append(policy.Rules, rule("*", nil, "unspecified"))
+		return policy
+	}
+
+	value := unquote(policy.Expires.Params[0])
+	if len(policy.Expires.Params) != 1 || !strings.HasPrefix(value, "$") {
+		expires, err := ParseExpires(policy.Expires.Params)
+		if err != nil {
+			policy.Rules = append(policy.Rules, rule("*", nil, "invalid expires"))
+			return policy
+		}
+		policy.Rules = append(policy.Rules, rule("*", &expires, "unspecified"))
+		return policy
+	}
+
+	m := block.variableMap(strings.TrimPrefix(value, "$"))
+	if m == nil || m.Source != "$sent_http_content_type" {
+		varies := "varies with " + value
+		if m != nil {
+			varies = "varies with " + m.Source
+		}
+		policy.Rules = append(policy.Rules, rule("*", nil, varies))
+		return policy
+	}
+	mapped := func(contentType, result string) {
+		expires, err := ParseExpires(strings.Fields(result))
+		if err != nil {
+			policy.Rules = append(policy.Rules, rule(contentType, nil, "not statically known"))
+			return
+		}
+		policy.Rules = append(policy.Rules, rule(contentType, &expires, "unspecified"))
+	}
+	for _, entry := range m.Entries {
+		mapped(entry.Key, entry.Value)
+	}
+	// A map without a default yields an empty string, which turns expires off
+	defaultValue := m.Default
+	if defaultValue == "" {
+		defaultValue = "off"
+	}
+	mapped("*", defaultValue)
+
+	return policy
+}
+
+// variableMap returns the map defining a variable in the block or its ancestors
+func (block *Block) variableMap(name string) *Map {
+	for current := block; current != nil; current = current.ParentRef {
+		for _, m := range current.Maps() {
+			if m.Variable == name {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// cacheControlMaxAge describes the browser caching time of a Cache-Control header value
+func cacheControlMaxAge(value string) string {
+	for _, field := range strings.Split(value, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		switch {
+		case field == "no-store" || field == "no-cache":
+			return field
+		case strings.HasPrefix(field, "max-age="):
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(field, "max-age=")); err == nil {
+				return (time.Duration(seconds) * time.Second).String()
+			}
+		}
+	}
+	return "unspecified"
+}
+
+// ValidateBrowserCaching reports locations whose expires directive and
+// add_header Cache-Control set different caching times: nginx sends both
+// Cache-Control headers and browsers resolve the conflict differently
+func (config *Config) ValidateBrowserCaching() []ValidationIssue {
+	var issues []ValidationIssue
+	for _, policy := range config.BrowserCachePolicy() {
+		if policy.Expires == nil || policy.CacheControl == nil {
+			continue
+		}
+		explicit := unquote(policy.CacheControl.Params[1])
+		for _, rule := range policy.Rules {
+			if rule.Expires == nil || rule.Expires.Off || rule.Expires.MaxAge() == cacheControlMaxAge(explicit) {
+				continue
+			}
+			subject := "location " + strings.Join(policy.Location.Params, " ")
+			if rule.ContentType != "*" {
+				subject += " for " + rule.ContentType
+			}
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityWarning,
+				Directive:  policy.CacheControl.Name,
+				Message:    fmt.Sprintf("%s sends Cache-Control %q from expires at line %d and %q from add_header, browsers receive both", subject, rule.Expires.CacheControl(), policy.Expires.LineNumber, explicit),
+				LineNumber: policy.CacheControl.LineNumber,
+			})
+			break
+		}
+	}
+	return issues
+}
+
+// staticFileExtensions are the extensions of the static assets AutoAddCachingHeaders
+// recognizes the locations of
+var staticFileExtensions = []string{
+	"css", "js", "mjs", "map",
+	"png", "jpg", "jpeg", "gif", "ico", "svg", "webp", "avif",
+	"woff", "woff2", "ttf", "otf", "eot",
+}
+
+// dynamicSampleURIs are URIs a location for static assets does not match
+var dynamicSampleURIs = []string{"/", "/index.html", "/index.php", "/api/users"}
+
+// AutoAddCachingHeaders adds expires and add_header Cache-Control "public,
+// max-age=<seconds>" always to every regex location for static assets, e.g.
+// location ~* \.(css|js|png)$, which sets neither. Locations already setting one
+// of them keep their policy. A location with no add_header of its own first gets
+// copies of the add_header directives it inherits, which it would stop inheriting.
+// Returns the number of locations changed
+func (config *Config) AutoAddCachingHeaders(maxAge time.Duration) (int, error) {
+	if maxAge < time.Second {
+		return 0, fmt.Errorf("max age %s is shorter than a second", maxAge)
+	}
+	seconds := int64(maxAge / time.Second)
+
+	count := 0
+	for _, block := range config.FindBlocksByName("location") {
+		location := NewLocation(block)
+		if location == nil || !location.servesStaticFiles() || len(block.FindLines("expires")) > 0 || hasCacheControlHeader(block) {
+			continue
+		}
+
+		expires := block.SetDirective("expires", formatDuration(maxAge))
+		index := block.IndexOfLine(expires) + 1
+		if len(block.FindLines("add_header")) == 0 && block.ParentRef != nil {
+			inherited, _ := block.ParentRef.inheritedLines("add_header")
+			for _, line := range inherited {
+				block.InsertDirective(index, line.Name, line.Params...)
+				index++
+			}
+		}
+		block.InsertDirective(index, "add_header", "Cache-Control", fmt.Sprintf(`"public, max-age=%d"`, seconds), "always")
+		count++
+	}
+	if count > 0 {
+		config.Dirty = true
+	}
+	return count, nil
+}
+
+// servesStaticFiles reports whether the location is a regex matching files with a
+// static asset extension and none of dynamicSampleURIs
+func (location *Location) servesStaticFiles() bool {
+	if !location.IsRegex() {
+		return false
+	}
+	for _, uri := range dynamicSampleURIs {
+		if location.Matches(uri) {
+			return false
+		}
+	}
+	for _, extension := range staticFileExtensions {
+		if location.Matches("/assets/file." + extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCacheControlHeader reports whether the block adds a Cache-Control header itself
+func hasCacheControlHeader(block *Block) bool {
+	for _, line := range block.FindLines("add_header") {
+		if len(line.Params) > 0 && strings.EqualFold(unquote(line.Params[0]), "Cache-Control") {
+			return true
+		}
+	}
+	return false
+}
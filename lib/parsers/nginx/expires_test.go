@@ -0,0 +1,72 @@
+package nginx
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExpires(t *testing.T) {
+	tests := []struct {
+		params       string
+		want         Expires
+		cacheControl string
+		maxAge       string
+	}{
+		{"off", Expires{Off: true}, "", "unspecified"},
+		{"epoch", Expires{Epoch: true}, "no-cache", "no-cache"},
+		{"max", Expires{Max: true}, "max-age=315360000", "10 years"},
+		{"1h", Expires{Offset: time.Hour}, "max-age=3600", "1h0m0s"},
+		{"+30d", Expires{Offset: 30 * 24 * time.Hour}, "max-age=2592000", "720h0m0s"},
+		{`"1h30m"`, Expires{Offset: 90 * time.Minute}, "max-age=5400", "1h30m0s"},
+		{"0", Expires{}, "max-age=0", "0s"},
+		{"-1", Expires{Offset: -time.Second}, "no-cache", "no-cache"},
+		{"-1h", Expires{Offset: -time.Hour}, "no-cache", "no-cache"},
+		{"@18h30m", Expires{TimeOfDay: true, Offset: 18*time.Hour + 30*time.Minute}, "max-age=<until 18:30 daily>", "until 18:30 daily"},
+		{"@0h", Expires{TimeOfDay: true}, "max-age=<until 00:00 daily>", "until 00:00 daily"},
+		{"modified 1d", Expires{Modified: true, Offset: 24 * time.Hour}, "max-age=<24h0m0s after last modification>", "24h0m0s after last modification"},
+		{"modified -1h", Expires{Modified: true, Offset: -time.Hour}, "no-cache", "no-cache"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.params, func(t *testing.T) {
+			expires, err := ParseExpires(strings.Fields(tt.params))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expires != tt.want {
+				t.Fatalf("ParseExpires = %+v, want %+v", expires, tt.want)
+			}
+			if got := expires.CacheControl(); got != tt.cacheControl {
+				t.Errorf("CacheControl() = %q, want %q", got, tt.cacheControl)
+			}
+			if got := expires.MaxAge(); got != tt.maxAge {
+				t.Errorf("MaxAge() = %q, want %q", got, tt.maxAge)
+			}
+		})
+	}
+}
+
+func TestParseExpiresErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"soon",
+		"1h 2h 3h",
+		"later 1h",
+		"modified off",
+		"modified max",
+		"modified @18h",
+		"@24h",
+		"@-1h",
+		"@",
+		"+",
+	}
+
+	for _, params := range tests {
+		t.Run(params, func(t *testing.T) {
+			if expires, err := ParseExpires(strings.Fields(params)); err == nil {
+				t.Fatalf("ParseExpires(%q) = %+v, want an error", params, expires)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package nginx
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// filePathDirectives lists directives whose first parameter is a path on disk
+var filePathDirectives = map[string]bool{
+	"ssl_certificate":         true,
+	"ssl_certificate_key":     true,
+	"ssl_dhparam":             true,
+	"ssl_trusted_certificate": true,
+	"access_log":              true,
+	"error_log":               true,
+	"root":                    true,
+	"alias":                   true,
+	"auth_basic_user_file":    true,
+	"include":                 true,
+}
+
+// nonFilePathPrefixes mark log destinations and certificate values that are not files
+var nonFilePathPrefixes = []string{"syslog:", "memory:", "data:", "engine:", "store:"}
+
+// ExtractAllFilePaths returns the sorted, deduplicated file and directory paths the
+// configuration refers to, as written: certificates and keys, logs, document roots,
+// aliases, basic auth user files, fastcgi_param SCRIPT_FILENAME and include patterns.
+// Values built from variables, off, stderr and syslog or in-memory destinations are
+// skipped since they name no fixed file
+func (config *Config) ExtractAllFilePaths() []string {
+	seen := map[string]bool{}
+	paths := []string{}
+	for _, reference := range config.fileReferences() {
+		if !seen[reference.Path] {
+			seen[reference.Path] = true
+			paths = append(paths, reference.Path)
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// DirectivesReferencingFile returns the directives referring to a file, the inverse
+// of ExtractAllFilePaths: directives naming the path itself, root and alias
+// directives of a directory holding it, and include patterns matching it. Relative
+// paths, given or written, resolve against the directory of the configuration file
+func (config *Config) DirectivesReferencingFile(file string) []*Line {
+	baseDir := filepath.Dir(config.FilePath)
+	target := resolveIncludePath(baseDir, file)
+
+	var lines []*Line
+	for _, reference := range config.fileReferences() {
+		referenced := resolveIncludePath(baseDir, reference.Path)
+		switch {
+		case referenced == target:
+		case (reference.Line.Name == "root" || reference.Line.Name == "alias") && strings.HasPrefix(target, strings.TrimSuffix(referenced, "/")+"/"):
+		case reference.Line.Name == "include" && isGlobPattern(referenced):
+			if matched, err := path.Match(referenced, target); err != nil || !matched {
+				continue
+			}
+		default:
+			continue
+		}
+		lines = append(lines, reference.Line)
+	}
+	return lines
+}
+
+// fileReference is a file path referenced by a directive
+type fileReference struct {
+	Path string // Path as written, unquoted
+	Line *Line  // Directive referring to the path
+}
+
+// fileReferences returns the paths ExtractAllFilePaths lists with their directives,
+// in configuration order and including repeated paths
+func (config *Config) fileReferences() []fileReference {
+	var references []fileReference
+	config.WalkBlocks(func(block *Block) {
+		for _, line := range block.Lines {
+			var path string
+			switch {
+			case line.Type == LineTypeBlock || len(line.Params) == 0:
+				continue
+			case filePathDirectives[line.Name]:
+				path = unquote(line.Params[0])
+			case line.Name == "fastcgi_param" && len(line.Params) >= 2 && unquote(line.Params[0]) == "SCRIPT_FILENAME":
+				path = unquote(line.Params[1])
+			default:
+				continue
+			}
+			if isFilePath(path) {
+				references = append(references, fileReference{Path: path, Line: line})
+			}
+		}
+	})
+	return references
+}
+
+// isFilePath reports whether a directive value names a fixed file
+func isFilePath(path string) bool {
+	if path == "" || path == "off" || path == "stderr" || strings.Contains(path, "$") {
+		return false
+	}
+	for _, prefix := range nonFilePathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return true
+}
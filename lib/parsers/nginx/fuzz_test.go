@@ -0,0 +1,140 @@
+package nginx
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// addConfigSeeds adds the example configurations under configs/ to the corpus
+func addConfigSeeds(f *testing.F) {
+	f.Helper()
+	paths, err := filepath.Glob("../../../configs/*/nginx.conf")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(data))
+	}
+}
+
+// addCorpusSeeds adds the inputs saved under testdata/fuzz for another fuzz target,
+// such as the failures FuzzRoundTrip found, to the corpus
+func addCorpusSeeds(f *testing.F, target string) {
+	f.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", "fuzz", target, "*"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 2 || lines[0] != "go test fuzz v1" || !strings.HasPrefix(lines[1], "string(") {
+			f.Fatalf("%s is not a corpus entry holding a single string", path)
+		}
+		input, err := strconv.Unquote(strings.TrimSuffix(strings.TrimPrefix(lines[1], "string("), ")"))
+		if err != nil {
+			f.Fatalf("%s: %v", path, err)
+		}
+		f.Add(input)
+	}
+}
+
+// FuzzParse checks that the parser rejects malformed input with an error rather
+// than a panic, keeps to its resource limits and that lossless parsing writes the
+// input back unchanged
+func FuzzParse(f *testing.F) {
+	addConfigSeeds(f)
+	opts := ParseOptions{Lossless: true, MaxDepth: 8, MaxLineLength: 4096}
+	f.Fuzz(func(t *testing.T, input string) {
+		config, err := ParseReaderWithOptions(strings.NewReader(input), "fuzz.conf", opts)
+		if err != nil {
+			return
+		}
+		if depth := blockDepth(config.RootBlock); depth > opts.MaxDepth {
+			t.Fatalf("%q parsed to blocks nested %d levels deep, the limit is %d", input, depth, opts.MaxDepth)
+		}
+		if got := config.String(); got != input {
+			t.Fatalf("lossless parse of %q written as %q", input, got)
+		}
+	})
+}
+
+// FuzzRoundTrip checks that formatted output parses back to the same tree and
+// formats the same again
+func FuzzRoundTrip(f *testing.F) {
+	addConfigSeeds(f)
+	f.Fuzz(func(t *testing.T, input string) {
+		config, err := ParseReader(strings.NewReader(input), "fuzz.conf")
+		if err != nil {
+			return
+		}
+		formatted := config.AutoIndent(4)
+		reparsed, err := ParseReader(strings.NewReader(formatted), "fuzz.conf")
+		if err != nil {
+			t.Fatalf("formatted output of %q does not parse: %v\n%s", input, err, formatted)
+		}
+		if again := reparsed.AutoIndent(4); again != formatted {
+			t.Fatalf("formatting %q is not stable:\n%s\nthen\n%s", input, formatted, again)
+		}
+		if got, want := treeSignature(reparsed.RootBlock), treeSignature(config.RootBlock); got != want {
+			t.Fatalf("formatted output of %q parses to a different tree:\n%s\nwant\n%s", input, got, want)
+		}
+	})
+}
+
+// blockDepth returns how deep blocks are nested under block
+func blockDepth(block *Block) int {
+	depth := 0
+	for _, child := range block.Blocks {
+		if d := blockDepth(child) + 1; d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+// treeSignature describes the directives and blocks of a tree by their names and
+// parameter values, leaving out positions, comments and quoting
+func treeSignature(block *Block) string {
+	var builder strings.Builder
+	var describe func(block *Block, depth int)
+	describe = func(block *Block, depth int) {
+		for _, line := range block.Lines {
+			if line.Type == LineTypeComment {
+				continue
+			}
+			builder.WriteString(strings.Repeat("  ", depth))
+			builder.WriteString(signatureValue(line.Name))
+			for _, param := range line.Params {
+				builder.WriteString(" [" + signatureValue(param) + "]")
+			}
+			builder.WriteString("\n")
+			if line.BlockRef != nil {
+				describe(line.BlockRef, depth+1)
+			}
+		}
+	}
+	describe(block, 0)
+	return builder.String()
+}
+
+// signatureValue returns the value of a parameter with its escapes resolved as
+// nginx does. One starting with a quote that
+// closes before its end, such as `'a'b`, is not a quoted string and stands for
+// itself, the formatter writes it quoted
+func signatureValue(param string) string {
+	if _, ok := quotedParam(param); ok || param == "" || param[0] != '"' && param[0] != '\'' {
+		return unescape(param)
+	}
+	return unescape(`"` + param + `"`)
+}
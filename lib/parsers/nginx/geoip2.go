@@ -0,0 +1,84 @@
+package nginx
+
+import (
+	"strings"
+	"time"
+)
+
+// GeoIP2Variable is a variable defined inside a geoip2 block from a database lookup
+type GeoIP2Variable struct {
+	Name    string   // Variable name without the leading $
+	Default string   // Value used when the lookup fails, empty if unset
+	Source  string   // Variable holding the address to look up, the client address if empty
+	Path    []string // Lookup path in the database (e.g. ["country", "iso_code"])
+	Line    *Line    // Line defining the variable
+}
+
+// GeoIP2Database is a typed view of a geoip2 block of ngx_http_geoip2_module
+type GeoIP2Database struct {
+	Block      *Block           // Underlying geoip2 block
+	Context    string           // Enclosing context, "http" or "stream"
+	Path       string           // Path of the MaxMind database file
+	AutoReload time.Duration    // Interval at which the database is reloaded, 0 if disabled
+	Variables  []GeoIP2Variable // Variables defined from the database
+}
+
+// GeoIP2Databases returns a typed view of every geoip2 block in the configuration
+func (config *Config) GeoIP2Databases() []GeoIP2Database {
+	var databases []GeoIP2Database
+	for _, block := range config.FindBlocksByName("geoip2") {
+		databases = append(databases, NewGeoIP2Database(block))
+	}
+	return databases
+}
+
+// NewGeoIP2Database parses a geoip2 block. Unparsable auto_reload values are left as 0
+func NewGeoIP2Database(block *Block) GeoIP2Database {
+	database := GeoIP2Database{Block: block}
+	if block.ParentRef != nil {
+		database.Context = blockContext(block.ParentRef)
+	}
+	if len(block.Params) > 0 {
+		database.Path = unquote(block.Params[0])
+	}
+
+	for _, line := range block.Lines {
+		switch {
+		case line.Type != LineTypeDirective:
+			continue
+		case line.Name == "auto_reload" && len(line.Params) > 0:
+			database.AutoReload, _ = ParseDuration(line.Params[0])
+		case strings.HasPrefix(line.Name, "$"):
+			database.Variables = append(database.Variables, parseGeoIP2Variable(line))
+		}
+	}
+
+	return database
+}
+
+// parseGeoIP2Variable parses "$name [default=value] [source=$variable] path..."
+func parseGeoIP2Variable(line *Line) GeoIP2Variable {
+	variable := GeoIP2Variable{Name: strings.TrimPrefix(line.Name, "$"), Line: line}
+	for _, param := range line.Params {
+		key, value, found := strings.Cut(unquote(param), "=")
+		switch {
+		case found && key == "default":
+			variable.Default = value
+		case found && key == "source":
+			variable.Source = strings.TrimPrefix(value, "$")
+		default:
+			variable.Path = append(variable.Path, unquote(param))
+		}
+	}
+	return variable
+}
+
+// RegisterGeoIP2 adds the directives of ngx_http_geoip2_module to the registry
+func RegisterGeoIP2(registry *DirectiveRegistry) {
+	registry.Register(
+		DirectiveSpec{Name: "geoip2", Module: "geoip2", Contexts: []string{"http", "stream"}, MinArgs: 1, MaxArgs: 1, IsBlock: true, FileArg: 1},
+		DirectiveSpec{Name: "geoip2_proxy", Module: "geoip2", Contexts: []string{"http"}, MinArgs: 1, MaxArgs: 1},
+		DirectiveSpec{Name: "geoip2_proxy_recursive", Module: "geoip2", Contexts: []string{"http"}, MinArgs: 1, MaxArgs: 1},
+		DirectiveSpec{Name: "auto_reload", Module: "geoip2", Contexts: []string{"geoip2"}, MinArgs: 1, MaxArgs: 1},
+	)
+}
@@ -0,0 +1,183 @@
+package nginx
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// ValidateIncludes checks that every include directive refers to existing files,
+// resolving relative paths against baseDir. Missing literal paths are errors,
+// globs matching no files are warnings since nginx tolerates them
+func (config *Config) ValidateIncludes(baseDir string) []ValidationIssue {
+	return config.ValidateIncludesFS(rootFS{}, baseDir)
+}
+
+// ValidateIncludesFS is ValidateIncludes looking files up in fsys, which holds
+// absolute paths without their leading slash, e.g. etc/nginx/mime.types, and
+// drive-letter paths as written, e.g. C:/nginx/conf/mime.types
+func (config *Config) ValidateIncludesFS(fsys fs.FS, baseDir string) []ValidationIssue {
+	var issues []ValidationIssue
+
+	for _, line := range config.FindLinesByName("include") {
+		if len(line.Params) == 0 {
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityError,
+				Directive:  line.Name,
+				Message:    "include without a path",
+				LineNumber: line.LineNumber,
+			})
+			continue
+		}
+
+		pattern := resolveIncludePath(baseDir, unquote(line.Params[0]))
+		if isGlobPattern(pattern) {
+			matches, err := globPaths(fsys, pattern)
+			if err != nil {
+				issues = append(issues, ValidationIssue{
+					Severity:   SeverityError,
+					Directive:  line.Name,
+					Message:    fmt.Sprintf("invalid include pattern %q: %v", pattern, err),
+					LineNumber: line.LineNumber,
+				})
+			} else if len(matches) == 0 {
+				issues = append(issues, ValidationIssue{
+					Severity:   SeverityWarning,
+					Directive:  line.Name,
+					Message:    fmt.Sprintf("include pattern %q matches no files", pattern),
+					LineNumber: line.LineNumber,
+				})
+			}
+			continue
+		}
+
+		if _, err := statPath(fsys, pattern); err != nil {
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityError,
+				Directive:  line.Name,
+				Message:    fmt.Sprintf("included file %q does not exist", pattern),
+				LineNumber: line.LineNumber,
+			})
+		}
+	}
+
+	return issues
+}
+
+// DedupeIncludes removes include directives repeating an earlier include of the
+// same block, keeping the first. Paths are compared after resolving them against
+// the directory of the configuration file, without reading the included files.
+// Includes an ngonx:keep pragma protects are kept even when repeated
+func (config *Config) DedupeIncludes() {
+	baseDir := filepath.Dir(config.FilePath)
+	config.WalkBlocks(func(block *Block) {
+		seen := map[string]bool{}
+		for _, line := range block.FindLines("include") {
+			if len(line.Params) == 0 {
+				continue
+			}
+			path := resolveIncludePath(baseDir, unquote(line.Params[0]))
+			if seen[path] && !keptLine(block, line) {
+				block.RemoveLine(line)
+				config.Dirty = true
+				continue
+			}
+			seen[path] = true
+		}
+	})
+}
+
+// maxIncludeDepth limits nested includes to protect against include cycles
+const maxIncludeDepth = 16
+
+// InlineIncludes replaces every include directive with the contents of the files
+// it refers to, resolving relative paths against baseDir. Globs matching no files
+// are dropped silently like nginx does
+func (config *Config) InlineIncludes(baseDir string) error {
+	return config.InlineIncludesFS(rootFS{}, baseDir)
+}
+
+// InlineIncludesFS is InlineIncludes reading the included files from fsys, laid
+// out as for ValidateIncludesFS
+func (config *Config) InlineIncludesFS(fsys fs.FS, baseDir string) error {
+	inlined := len(config.FindLinesByName("include")) > 0
+	if err := inlineIncludes(fsys, config.RootBlock, baseDir, 0); err != nil {
+		return err
+	}
+	if inlined {
+		config.Dirty = true
+	}
+	for _, plugin := range config.plugins {
+		applyPlugin(config.RootBlock, plugin)
+	}
+	return nil
+}
+
+// inlineIncludes recursively splices included files into a block
+func inlineIncludes(fsys fs.FS, block *Block, baseDir string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("includes nested deeper than %d levels", maxIncludeDepth)
+	}
+
+	lines := []*Line{}
+	for _, line := range block.Lines {
+		if line.Type == LineTypeBlock && line.BlockRef != nil {
+			if err := inlineIncludes(fsys, line.BlockRef, baseDir, depth); err != nil {
+				return err
+			}
+		}
+		if line.Type != LineTypeInclude {
+			lines = append(lines, line)
+			continue
+		}
+
+		files, err := includeFiles(fsys, baseDir, line)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			included, err := parsePath(fsys, file)
+			if err != nil {
+				return err
+			}
+			if err := inlineIncludes(fsys, included.RootBlock, baseDir, depth+1); err != nil {
+				return err
+			}
+			for _, includedLine := range included.RootBlock.Lines {
+				if includedLine.BlockRef != nil {
+					includedLine.BlockRef.ParentRef = block
+				}
+				lines = append(lines, includedLine)
+			}
+		}
+	}
+
+	block.Lines = lines
+	block.syncBlocks()
+
+	return nil
+}
+
+// includeFiles returns the files of fsys an include directive refers to
+func includeFiles(fsys fs.FS, baseDir string, line *Line) ([]string, error) {
+	if len(line.Params) == 0 {
+		return nil, fmt.Errorf("line %d: include without a path", line.LineNumber)
+	}
+
+	pattern := resolveIncludePath(baseDir, unquote(line.Params[0]))
+	if !isGlobPattern(pattern) {
+		return []string{pattern}, nil
+	}
+
+	matches, err := globPaths(fsys, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: invalid include pattern %q: %v", line.LineNumber, pattern, err)
+	}
+	return matches, nil
+}
+
+// isGlobPattern reports whether the path contains glob metacharacters
+func isGlobPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
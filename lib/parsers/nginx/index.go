@@ -0,0 +1,36 @@
+package nginx
+
+// ConfigIndex maps block and directive names to their occurrences for constant time
+// lookups. It is a snapshot of the tree at the time it was built: call BuildIndex
+// again after modifying the configuration
+type ConfigIndex struct {
+	blocks map[string][]*Block
+	lines  map[string][]*Line
+}
+
+// BuildIndex walks the tree once and indexes every block by name and every directive
+// and include line by name, in the order FindBlocksByName and FindLinesByName return them
+func (config *Config) BuildIndex() *ConfigIndex {
+	index := &ConfigIndex{blocks: map[string][]*Block{}, lines: map[string][]*Line{}}
+	config.WalkBlocks(func(block *Block) {
+		index.blocks[block.Name] = append(index.blocks[block.Name], block)
+		for _, line := range block.Lines {
+			if line.Type == LineTypeDirective || line.Type == LineTypeInclude {
+				index.lines[line.Name] = append(index.lines[line.Name], line)
+			}
+		}
+	})
+	return index
+}
+
+// GetBlocks returns the blocks with the given name anywhere in the tree, like
+// FindBlocksByName. The returned slice must not be modified
+func (index *ConfigIndex) GetBlocks(name string) []*Block {
+	return index.blocks[name]
+}
+
+// GetLines returns the directive and include lines with the given name anywhere in
+// the tree, like FindLinesByName. The returned slice must not be modified
+func (index *ConfigIndex) GetLines(name string) []*Line {
+	return index.lines[name]
+}
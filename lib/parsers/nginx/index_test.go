@@ -0,0 +1,95 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// generatedConfig builds a configuration with the given number of servers, each
+// with a few directives and locations
+func generatedConfig(tb testing.TB, servers int) *Config {
+	tb.Helper()
+	var builder strings.Builder
+	builder.WriteString("http {\n    include mime.types;\n")
+	for i := 0; i < servers; i++ {
+		fmt.Fprintf(&builder, "    server {\n        listen %d;\n        server_name host%d.example.com;\n        root /srv/%d;\n", 8000+i%1000, i, i)
+		for j := 0; j < 5; j++ {
+			fmt.Fprintf(&builder, "        location /app%d/ {\n            proxy_pass http://127.0.0.1:%d;\n            add_header X-App %d;\n        }\n", j, 9000+j, j)
+		}
+		builder.WriteString("    }\n")
+	}
+	builder.WriteString("}\n")
+
+	config, err := ParseReader(strings.NewReader(builder.String()), "test.conf")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return config
+}
+
+func TestConfigIndex(t *testing.T) {
+	config := generatedConfig(t, 20)
+	index := config.BuildIndex()
+
+	for _, name := range []string{"http", "server", "location", "missing"} {
+		got, want := index.GetBlocks(name), config.FindBlocksByName(name)
+		same := len(got) == len(want)
+		for i := 0; same && i < len(got); i++ {
+			same = got[i] == want[i]
+		}
+		if !same {
+			t.Errorf("GetBlocks(%q) returned %d blocks, want the %d of FindBlocksByName in order", name, len(got), len(want))
+		}
+	}
+	for _, name := range []string{"include", "listen", "proxy_pass", "missing"} {
+		got, want := index.GetLines(name), config.FindLinesByName(name)
+		same := len(got) == len(want)
+		for i := 0; same && i < len(got); i++ {
+			same = got[i] == want[i]
+		}
+		if !same {
+			t.Errorf("GetLines(%q) returned %d lines, want the %d of FindLinesByName in order", name, len(got), len(want))
+		}
+	}
+}
+
+func BenchmarkFindBlocksLinear(b *testing.B) {
+	config := generatedConfig(b, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		config.FindBlocksByName("location")
+	}
+}
+
+func BenchmarkFindBlocksIndexed(b *testing.B) {
+	index := generatedConfig(b, 1000).BuildIndex()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.GetBlocks("location")
+	}
+}
+
+func BenchmarkFindLinesLinear(b *testing.B) {
+	config := generatedConfig(b, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		config.FindLinesByName("proxy_pass")
+	}
+}
+
+func BenchmarkFindLinesIndexed(b *testing.B) {
+	index := generatedConfig(b, 1000).BuildIndex()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.GetLines("proxy_pass")
+	}
+}
+
+func BenchmarkBuildIndex(b *testing.B) {
+	config := generatedConfig(b, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		config.BuildIndex()
+	}
+}
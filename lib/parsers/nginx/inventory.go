@@ -0,0 +1,254 @@
+package nginx
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DomainKind tells how a server name matches host names
+type DomainKind string
+
+const (
+	DomainExact    DomainKind = "exact"    // A single host name, e.g. example.com
+	DomainWildcard DomainKind = "wildcard" // Wildcard name, e.g. *.example.com, .example.com or www.example.*
+	DomainRegex    DomainKind = "regex"    // Regular expression, e.g. ~^www\d+\.example\.com$
+)
+
+// domainKindOrder sorts the domains of an inventory by kind
+var domainKindOrder = map[DomainKind]int{DomainExact: 0, DomainWildcard: 1, DomainRegex: 2}
+
+// VirtualHost is an http server block as listed by DomainInventory
+type VirtualHost struct {
+	Server       *Block   `json:"-"`
+	Name         string   `json:"name"`         // Server as in diff paths, e.g. "server example.com www.example.com"
+	LineNumber   int      `json:"line"`         // Line number of the server block
+	Endpoints    []string `json:"endpoints"`    // Addresses the server listens on in listen notation, with ssl or quic when TLS is terminated
+	TLS          bool     `json:"tls"`          // Some endpoint terminates TLS
+	Plaintext    bool     `json:"plaintext"`    // Some endpoint serves plain HTTP
+	Certificates []string `json:"certificates"` // ssl_certificate files in effect, as written
+}
+
+// DomainEntry is a server name and the servers serving it
+type DomainEntry struct {
+	Domain       string        `json:"domain"`            // Server name, lowercased unless it is a regex
+	Kind         DomainKind    `json:"kind"`              // How the name matches host names
+	Servers      []VirtualHost `json:"servers"`           // Servers with the name, in configuration order
+	TLS          bool          `json:"tls"`               // Served over TLS by some server
+	Plaintext    bool          `json:"plaintext"`         // Served over plain HTTP by some server
+	Certificates []string      `json:"certificates"`      // Certificate files of the servers, sorted
+	Expires      *time.Time    `json:"expires,omitempty"` // Earliest expiry of the certificates, once inspected
+}
+
+// CertificateEntry is a certificate file and the domains and servers using it
+type CertificateEntry struct {
+	Path     string        `json:"path"`                // File as written in ssl_certificate
+	NotAfter *time.Time    `json:"not_after,omitempty"` // Expiry of the certificate, once inspected
+	Error    string        `json:"error,omitempty"`     // Why the certificate could not be inspected
+	Domains  []string      `json:"domains"`             // Server names of the servers using it, in inventory order
+	Servers  []VirtualHost `json:"servers"`             // Servers using it, in configuration order
+}
+
+// DomainInventory lists the virtual hosts of a configuration by domain and by certificate
+type DomainInventory struct {
+	Domains      []DomainEntry      `json:"domains"`      // Exact names, then wildcards, then regexes, each sorted
+	Certificates []CertificateEntry `json:"certificates"` // Sorted by path
+}
+
+// DomainInventory lists every server name of the http servers with the servers
+// serving it, their endpoints and certificate files and whether plain HTTP is also
+// served, then the same servers by certificate file, answering which domains lack
+// TLS and which break when a certificate expires. Server name lists are expanded
+// and servers without a name are left out. See InspectCertificates for expiry dates
+func (config *Config) DomainInventory() *DomainInventory {
+	inventory := &DomainInventory{Domains: []DomainEntry{}, Certificates: []CertificateEntry{}}
+	domains := map[string]*DomainEntry{}
+	certificates := map[string]*CertificateEntry{}
+	var domainOrder, certificateOrder []string
+
+	for _, server := range config.FindBlocksByName("server") {
+		if serverProtocol(server) != "http" {
+			continue
+		}
+		host := virtualHost(server)
+
+		var names []string
+		for _, name := range ServerNames(server) {
+			if name == "" || name == "_" {
+				continue
+			}
+			names = appendUnique(names, name)
+		}
+		for _, name := range names {
+			entry := domains[name]
+			if entry == nil {
+				entry = &DomainEntry{Domain: name, Kind: domainKind(name), Certificates: []string{}}
+				domains[name] = entry
+				domainOrder = append(domainOrder, name)
+			}
+			entry.Servers = append(entry.Servers, host)
+			entry.TLS = entry.TLS || host.TLS
+			entry.Plaintext = entry.Plaintext || host.Plaintext
+			entry.Certificates = appendUnique(entry.Certificates, host.Certificates...)
+		}
+		for _, file := range host.Certificates {
+			entry := certificates[file]
+			if entry == nil {
+				entry = &CertificateEntry{Path: file, Domains: []string{}}
+				certificates[file] = entry
+				certificateOrder = append(certificateOrder, file)
+			}
+			entry.Servers = append(entry.Servers, host)
+			entry.Domains = appendUnique(entry.Domains, names...)
+		}
+	}
+
+	sort.Slice(domainOrder, func(i, j int) bool {
+		a, b := domains[domainOrder[i]], domains[domainOrder[j]]
+		if a.Kind != b.Kind {
+			return domainKindOrder[a.Kind] < domainKindOrder[b.Kind]
+		}
+		return a.Domain < b.Domain
+	})
+	for _, name := range domainOrder {
+		sort.Strings(domains[name].Certificates)
+		inventory.Domains = append(inventory.Domains, *domains[name])
+	}
+	position := map[string]int{}
+	for i, name := range domainOrder {
+		position[name] = i
+	}
+	sort.Strings(certificateOrder)
+	for _, file := range certificateOrder {
+		entry := certificates[file]
+		sort.Slice(entry.Domains, func(i, j int) bool {
+			return position[entry.Domains[i]] < position[entry.Domains[j]]
+		})
+		inventory.Certificates = append(inventory.Certificates, *entry)
+	}
+	return inventory
+}
+
+// InspectCertificates reads the certificate files from fsys and sets their expiry
+// dates, and on each domain the earliest of its certificates. Relative paths are
+// resolved against baseDir, then paths are looked up without their leading slash,
+// so os.DirFS("/") reads the files where nginx does. Files that cannot be read or
+// parsed, and paths built from variables, get an Error instead
+func (inventory *DomainInventory) InspectCertificates(fsys fs.FS, baseDir string) {
+	expiry := map[string]*time.Time{}
+	for i := range inventory.Certificates {
+		entry := &inventory.Certificates[i]
+		notAfter, err := certificateExpiry(fsys, baseDir, entry.Path)
+		if err != nil {
+			entry.Error = err.Error()
+			continue
+		}
+		entry.NotAfter = &notAfter
+		expiry[entry.Path] = entry.NotAfter
+	}
+
+	for i := range inventory.Domains {
+		entry := &inventory.Domains[i]
+		entry.Expires = nil
+		for _, file := range entry.Certificates {
+			if notAfter := expiry[file]; notAfter != nil && (entry.Expires == nil || notAfter.Before(*entry.Expires)) {
+				entry.Expires = notAfter
+			}
+		}
+	}
+}
+
+// virtualHost describes a server block for the inventory
+func virtualHost(server *Block) VirtualHost {
+	host := VirtualHost{Server: server, Name: blockKey(server), LineNumber: server.LineNumber, Endpoints: []string{}, Certificates: []string{}}
+
+	// The legacy ssl on directive enables TLS on every endpoint
+	legacySSL := false
+	if line := server.EffectiveDirective("ssl"); line != nil && len(line.Params) > 0 && unquote(line.Params[0]) == "on" {
+		legacySSL = true
+	}
+
+	listens := server.FindLines("listen")
+	if len(listens) == 0 {
+		host.Endpoints = append(host.Endpoints, fmt.Sprintf("*:%d", defaultHTTPPort))
+		host.TLS, host.Plaintext = legacySSL, !legacySSL
+	}
+	for _, line := range listens {
+		endpoint, err := parseListen(line.Params, "http")
+		if err != nil {
+			continue
+		}
+		address := endpoint.String()
+		switch {
+		case endpoint.UDP:
+			address += " quic"
+		case endpoint.SSL || legacySSL:
+			address += " ssl"
+		}
+		host.Endpoints = appendUnique(host.Endpoints, address)
+		if endpoint.SSL || legacySSL {
+			host.TLS = true
+		} else {
+			host.Plaintext = true
+		}
+	}
+
+	if host.TLS {
+		lines, _ := server.inheritedLines("ssl_certificate")
+		for _, line := range lines {
+			if len(line.Params) > 0 {
+				host.Certificates = appendUnique(host.Certificates, unquote(line.Params[0]))
+			}
+		}
+	}
+	return host
+}
+
+// domainKind classifies a server name
+func domainKind(name string) DomainKind {
+	switch {
+	case strings.HasPrefix(name, "~"):
+		return DomainRegex
+	case strings.HasPrefix(name, "*.") || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".*"):
+		return DomainWildcard
+	}
+	return DomainExact
+}
+
+// certificateExpiry returns the expiry of the first certificate of a PEM file
+func certificateExpiry(fsys fs.FS, baseDir, file string) (time.Time, error) {
+	if strings.Contains(file, "$") {
+		return time.Time{}, fmt.Errorf("certificate path is built from variables")
+	}
+	name := file
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(baseDir, name)
+	}
+	name = strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/")
+
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return time.Time{}, fmt.Errorf("%s: no PEM certificate", file)
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s: %v", file, err)
+		}
+		return certificate.NotAfter, nil
+	}
+}
@@ -0,0 +1,98 @@
+package nginx
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// crossplanePayload is the top level document produced by crossplane parse
+type crossplanePayload struct {
+	Status string           `json:"status"`
+	Errors []string         `json:"errors"`
+	Config []crossplaneFile `json:"config"`
+}
+
+// crossplaneFile is a single parsed file in a crossplane payload
+type crossplaneFile struct {
+	File   string                `json:"file"`
+	Status string                `json:"status"`
+	Errors []string              `json:"errors"`
+	Parsed []crossplaneDirective `json:"parsed"`
+}
+
+// crossplaneDirective is a directive, block or comment in crossplane form
+type crossplaneDirective struct {
+	Directive string                 `json:"directive"`
+	Line      int                    `json:"line"`
+	Args      []string               `json:"args"`
+	Comment   *string                `json:"comment,omitempty"`
+	Block     *[]crossplaneDirective `json:"block,omitempty"`
+}
+
+// CrossplaneJSON returns the configuration in the JSON format produced by
+// `crossplane parse`, optionally including comments
+func (config *Config) CrossplaneJSON(includeComments bool) ([]byte, error) {
+	payload := crossplanePayload{
+		Status: "ok",
+		Errors: []string{},
+		Config: []crossplaneFile{{
+			File:   config.FilePath,
+			Status: "ok",
+			Errors: []string{},
+			Parsed: crossplaneBlock(config.RootBlock, includeComments),
+		}},
+	}
+	return json.Marshal(payload)
+}
+
+// crossplaneBlock converts the lines of a block to crossplane directives
+func crossplaneBlock(block *Block, includeComments bool) []crossplaneDirective {
+	directives := []crossplaneDirective{}
+
+	for _, line := range block.Lines {
+		if line.Type == LineTypeComment {
+			if includeComments {
+				directives = append(directives, crossplaneComment(line.Comments, line.LineNumber))
+			}
+			continue
+		}
+
+		directive := crossplaneDirective{
+			Directive: line.Name,
+			Line:      line.LineNumber,
+			Args:      crossplaneArgs(line.Params),
+		}
+		if line.Type == LineTypeBlock && line.BlockRef != nil && line.BlockRef.Verbatim != "" {
+			// Like crossplane's lua support, the code of verbatim blocks is a single argument
+			directive.Args = append(crossplaneArgs(line.BlockRef.Params), line.BlockRef.Verbatim)
+		} else if line.Type == LineTypeBlock && line.BlockRef != nil {
+			directive.Args = crossplaneArgs(line.BlockRef.Params)
+			children := crossplaneBlock(line.BlockRef, includeComments)
+			directive.Block = &children
+		}
+		directives = append(directives, directive)
+
+		// Trailing comments follow their directive in crossplane output
+		if includeComments && len(line.Comments) > 0 {
+			directives = append(directives, crossplaneComment(line.Comments, line.LineNumber))
+		}
+	}
+
+	return directives
+}
+
+// crossplaneComment builds a comment entry
+func crossplaneComment(comments []string, lineNumber int) crossplaneDirective {
+	text := strings.Join(comments, " ")
+	return crossplaneDirective{Directive: "#", Line: lineNumber, Args: []string{}, Comment: &text}
+}
+
+// crossplaneArgs returns the values of parameters, with surrounding quotes removed
+// and escape sequences resolved
+func crossplaneArgs(params []string) []string {
+	args := make([]string, 0, len(params))
+	for _, param := range params {
+		args = append(args, unescape(param))
+	}
+	return args
+}
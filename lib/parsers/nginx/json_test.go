@@ -0,0 +1,50 @@
+package nginx
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCrossplaneJSONArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		directive string
+		want      []string
+	}{
+		{"unquoted", "listen 80 default_server;", []string{"80", "default_server"}},
+		{"double quotes", `add_header X-Test "a b";`, []string{"X-Test", "a b"}},
+		{"escaped quotes", `return 200 "say \"hi\"";`, []string{"200", `say "hi"`}},
+		{"escaped single quote", `add_header X-Test 'it\'s';`, []string{"X-Test", "it's"}},
+		{"escape sequences", `return 200 "a\tb\\c\n";`, []string{"200", "a\tb\\c\n"}},
+		{"unquoted escapes", `set $a a\"b;`, []string{"$a", `a"b`}},
+		{"regex backslashes", `rewrite ^/(.*)\.php$ /$1;`, []string{`^/(.*)\.php$`, "/$1"}},
+		{"empty string", `set $a "";`, []string{"$a", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseReader(strings.NewReader("server { "+tt.directive+" }"), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := config.CrossplaneJSON(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var payload crossplanePayload
+			if err := json.Unmarshal(data, &payload); err != nil {
+				t.Fatal(err)
+			}
+			server := payload.Config[0].Parsed[0]
+			if server.Block == nil || len(*server.Block) != 1 {
+				t.Fatalf("unexpected payload %s", data)
+			}
+			if got := (*server.Block)[0].Args; !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("args %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
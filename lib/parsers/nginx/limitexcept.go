@@ -0,0 +1,97 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// limitExceptMethods lists the request methods limit_except accepts
+var limitExceptMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true, "MKCOL": true,
+	"COPY": true, "MOVE": true, "OPTIONS": true, "PROPFIND": true, "PROPPATCH": true,
+	"LOCK": true, "UNLOCK": true, "PATCH": true,
+}
+
+// LimitExcept is a typed view of a limit_except block. Its access and auth directives
+// apply to every request method except the listed ones
+type LimitExcept struct {
+	Block   *Block   // Underlying limit_except block
+	Methods []string // Excepted methods as written, uppercased
+}
+
+// NewLimitExcept returns a typed view of a limit_except block, or nil if the block is not one
+func NewLimitExcept(block *Block) *LimitExcept {
+	if block == nil || block.Name != "limit_except" {
+		return nil
+	}
+
+	limit := &LimitExcept{Block: block}
+	for _, param := range block.Params {
+		limit.Methods = append(limit.Methods, strings.ToUpper(unquote(param)))
+	}
+	return limit
+}
+
+// Allows reports whether the method is excepted from the block's restrictions.
+// Allowing GET also allows HEAD
+func (limit *LimitExcept) Allows(method string) bool {
+	method = strings.ToUpper(method)
+	for _, excepted := range limit.Methods {
+		if excepted == method || (excepted == "GET" && method == "HEAD") {
+			return true
+		}
+	}
+	return false
+}
+
+// ACLRules returns the allow and deny rules of the limit_except body
+func (limit *LimitExcept) ACLRules() []ACLRule {
+	return limit.Block.ACLRules()
+}
+
+// LimitExcept returns the limit_except block of the location, nil if it has none
+func (location *Location) LimitExcept() *LimitExcept {
+	for _, child := range location.Block.Blocks {
+		if child.Name == "limit_except" {
+			return NewLimitExcept(child)
+		}
+	}
+	return nil
+}
+
+// ValidateLimitExcept reports limit_except blocks outside location context, without
+// methods or with method names nginx does not know
+func (config *Config) ValidateLimitExcept() []ValidationIssue {
+	var issues []ValidationIssue
+
+	for _, block := range config.FindBlocksByName("limit_except") {
+		report := func(message string) {
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityError,
+				Directive:  block.Name,
+				Message:    message,
+				LineNumber: block.LineNumber,
+			})
+		}
+
+		if block.ParentRef == nil || block.ParentRef.Name != "location" {
+			context := ContextMain
+			if block.ParentRef != nil && block.ParentRef.ParentRef != nil {
+				context = block.ParentRef.Name
+			}
+			report(fmt.Sprintf("not allowed in %s context", context))
+		}
+
+		limit := NewLimitExcept(block)
+		if len(limit.Methods) == 0 {
+			report("limit_except without methods")
+		}
+		for _, method := range limit.Methods {
+			if !limitExceptMethods[method] {
+				report(fmt.Sprintf("invalid method %q", method))
+			}
+		}
+	}
+
+	return issues
+}
@@ -0,0 +1,305 @@
+package nginx
+
+import (
+	"io/fs"
+	"time"
+)
+
+// LintOptions configures which checks Lint runs and how they resolve files
+type LintOptions struct {
+	BaseDir  string             // Directory relative include paths resolve against, file checks are skipped when empty
+	Registry *DirectiveRegistry // Known directives, DefaultDirectiveRegistry if nil
+	FS       fs.FS              // Files the include and file reference checks look up, the local file system if nil
+	Now      time.Time          // Time the expiry of disable pragmas is checked against, the current time if zero
+}
+
+// registry returns the directive registry to lint against
+func (opts LintOptions) registry() *DirectiveRegistry {
+	if opts.Registry == nil {
+		return DefaultDirectiveRegistry()
+	}
+	return opts.Registry
+}
+
+// fileSystem returns the file system the file checks look files up in
+func (opts LintOptions) fileSystem() fs.FS {
+	if opts.FS == nil {
+		return rootFS{}
+	}
+	return opts.FS
+}
+
+// now returns the time the expiry of disable pragmas is checked against
+func (opts LintOptions) now() time.Time {
+	if opts.Now.IsZero() {
+		return time.Now()
+	}
+	return opts.Now
+}
+
+// LintRule is a named check run by Lint
+type LintRule struct {
+	Name        string                                                   // Rule name reported with each finding
+	Description string                                                   // Short description of what the rule checks
+	Check       func(config *Config, opts LintOptions) []ValidationError // Check implementation
+}
+
+// lintRules lists the checks run by Lint, in reporting order
+var lintRules = []LintRule{
+	{
+		Name:        "ssl-weak-protocols",
+		Description: "deprecated TLS protocol versions and weak cipher suites",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.DetectLegacySSLProtocols()
+		},
+	},
+	{
+		Name:        "main-context",
+		Description: "worker_cpu_affinity masks not matching worker_processes, unavailable event methods and unwritable pid directories",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateMain(opts.BaseDir)
+		},
+	},
+	{
+		Name:        "shared-memory-zones",
+		Description: "undersized and duplicate shared memory zones",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.SharedMemoryReport(SharedMemoryOptions{}).Issues
+		},
+	},
+	{
+		Name:        "rate-limits",
+		Description: "rate limiting zones never used and limits referring to undefined zones",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateRateLimits()
+		},
+	},
+	{
+		Name:        "cache-zones",
+		Description: "cache zones defined but never used and proxy_cache using undefined zones",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateCacheZones()
+		},
+	},
+	{
+		Name:        "real-ip",
+		Description: "invalid or overly broad set_real_ip_from and rate limits keyed on proxied addresses",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateRealIP()
+		},
+	},
+	{
+		Name:        "include-targets",
+		Description: "include directives referring to missing files",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			if opts.BaseDir == "" {
+				return nil
+			}
+			return config.ValidateIncludesFS(opts.fileSystem(), opts.BaseDir)
+		},
+	},
+	{
+		Name:        "location-nesting",
+		Description: "location blocks nested where nginx rejects them",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateLocationNesting()
+		},
+	},
+	{
+		Name:        "access-rules",
+		Description: "invalid, redundant and unreachable allow/deny rules",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateACL()
+		},
+	},
+	{
+		Name:        "named-locations",
+		Description: "references to undefined named locations and unused named locations",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateNamedLocations()
+		},
+	},
+	{
+		Name:        "server-names",
+		Description: "server blocks without a server_name",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateServerNames()
+		},
+	},
+	{
+		Name:        "error-pages",
+		Description: "error_page targets matching no location of the server",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.CheckMissingErrorPages()
+		},
+	},
+	{
+		Name:        "location-reachability",
+		Description: "internal locations nothing redirects to and locations that should be internal",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateLocationReachability()
+		},
+	},
+	{
+		Name:        "auth-request",
+		Description: "auth_request targets that are missing, not internal or answer nothing",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateAuthRequests()
+		},
+	},
+	{
+		Name:        "redirect-loops",
+		Description: "return and rewrite redirects that lead back to themselves",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.DetectRedirectLoops()
+		},
+	},
+	{
+		Name:        "redirect-chains",
+		Description: "redirects leading to further redirects instead of the final target",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			chains, _ := config.redirectChains()
+			return redirectChainIssues(chains)
+		},
+	},
+	{
+		Name:        "ssl-preread",
+		Description: "stream routing on $ssl_preread_* variables without ssl_preread on",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateSSLPreread()
+		},
+	},
+	{
+		Name:        "sub-filter-types",
+		Description: "sub_filter applied to responses sub_filter_types leaves out",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateSubFilters()
+		},
+	},
+	{
+		Name:        "timeouts",
+		Description: "timeouts outside sane bounds, too aggressive or too loose",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateTimeouts(TimeoutOptions{})
+		},
+	},
+	{
+		Name:        "timeout-coherence",
+		Description: "upstream keepalive defeated by HTTP/1.0 or Connection: close",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateTimeoutCoherence(TimeoutOptions{})
+		},
+	},
+	{
+		Name:        "compression",
+		Description: "gzip and brotli without types, with costly levels or compressing compressed types",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.CompressionAudit()
+		},
+	},
+	{
+		Name:        "buffer-sizes",
+		Description: "proxy, fastcgi, uwsgi and scgi buffer sizes nginx refuses to start with",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateBuffers()
+		},
+	},
+	{
+		Name:        "proxy-cache",
+		Description: "cached locations that may share responses between users",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateCaching()
+		},
+	},
+	{
+		Name:        "browser-cache",
+		Description: "expires and add_header Cache-Control sending conflicting headers",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateBrowserCaching()
+		},
+	},
+	{
+		Name:        "cors",
+		Description: "CORS origins open to any site and preflights missing headers",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateCORS()
+		},
+	},
+	{
+		Name:        "limit-except",
+		Description: "limit_except blocks outside locations or with unknown methods",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateLimitExcept()
+		},
+	},
+	{
+		Name:        "sensitive-files",
+		Description: "dotfiles, backup files and application files next to the web root that may be served",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			if opts.BaseDir == "" {
+				return config.checkSensitiveDataExposure(nil)
+			}
+			return config.checkSensitiveDataExposure(opts.fileSystem())
+		},
+	},
+	{
+		Name:        "empty-blocks",
+		Description: "blocks without directives, left over or never filled in",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateEmptyBlocks()
+		},
+	},
+	{
+		Name:        "environment",
+		Description: "environment variables read by Lua or Perl code but not passed with env",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateEnvironment()
+		},
+	},
+	{
+		Name:        "directive-context",
+		Description: "known directives used in the wrong block or with the wrong number of arguments",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			return config.ValidateDirectives(opts.registry())
+		},
+	},
+	{
+		Name:        "file-references",
+		Description: "directives referring to missing files such as Lua sources",
+		Check: func(config *Config, opts LintOptions) []ValidationError {
+			if opts.BaseDir == "" {
+				return nil
+			}
+			return config.ValidateFileReferencesFS(opts.fileSystem(), opts.BaseDir, opts.registry())
+		},
+	},
+}
+
+// LintRules returns the checks run by Lint
+func LintRules() []LintRule {
+	return append([]LintRule{}, lintRules...)
+}
+
+// Lint runs every lint rule against the configuration and returns all findings.
+// Findings on nodes an ngonx:disable pragma covers are left out until the pragma
+// expires, and malformed pragmas are reported under the rule "pragmas"
+func (config *Config) Lint(opts LintOptions) []ValidationError {
+	suppressions := config.suppressions()
+	now := opts.now()
+
+	var findings []ValidationError
+	for _, rule := range lintRules {
+		for _, finding := range rule.Check(config, opts) {
+			finding.Rule = rule.Name
+			if suppress(suppressions, &finding, now) {
+				continue
+			}
+			findings = append(findings, finding)
+		}
+	}
+	for _, finding := range config.ValidatePragmas() {
+		finding.Rule = "pragmas"
+		findings = append(findings, finding)
+	}
+	return findings
+}
@@ -0,0 +1,207 @@
+package nginx
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// defaultHTTPPort is the port nginx binds when listen omits it or a server has no listen
+const defaultHTTPPort = 80
+
+// Endpoint represents a single address nginx binds
+type Endpoint struct {
+	Address  string // IP address or host name, "*" for all interfaces, socket path for unix sockets
+	Port     int    // Port number, 0 for unix sockets
+	Protocol string // Module serving the endpoint: "http", "stream" or "mail"
+	SSL      bool   // Whether TLS is terminated on the endpoint
+	UDP      bool   // Whether the endpoint is UDP (stream udp or http quic)
+	Unix     bool   // Whether the endpoint is a unix domain socket
+	IPv6     bool   // Whether the address is an IPv6 address
+}
+
+// String returns the endpoint in listen notation
+func (endpoint Endpoint) String() string {
+	if endpoint.Unix {
+		return "unix:" + endpoint.Address
+	}
+	if endpoint.IPv6 {
+		return fmt.Sprintf("[%s]:%d", endpoint.Address, endpoint.Port)
+	}
+	return fmt.Sprintf("%s:%d", endpoint.Address, endpoint.Port)
+}
+
+// ListenEndpoints returns every unique address the configuration binds, in order
+// of first appearance. Servers without a listen directive bind *:80
+func (config *Config) ListenEndpoints() []Endpoint {
+	var endpoints []Endpoint
+	seen := map[Endpoint]bool{}
+
+	for _, server := range config.FindBlocksByName("server") {
+		protocol := serverProtocol(server)
+		if protocol == "" {
+			continue
+		}
+
+		listens := server.FindLines("listen")
+		if len(listens) == 0 && protocol == "http" {
+			endpoint := Endpoint{Address: "*", Port: defaultHTTPPort, Protocol: protocol}
+			if !seen[endpoint] {
+				seen[endpoint] = true
+				endpoints = append(endpoints, endpoint)
+			}
+			continue
+		}
+
+		for _, line := range listens {
+			endpoint, err := parseListen(line.Params, protocol)
+			if err != nil || seen[endpoint] {
+				continue
+			}
+			seen[endpoint] = true
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	return endpoints
+}
+
+// GroupServersByPort groups the http, stream and mail server blocks by the ports
+// their listen directives bind, whatever the address, in order of appearance, so
+// the servers competing for a port can be told apart by address and server name.
+// A server listening on a port several times, e.g. on IPv4 and IPv6, is listed
+// once. http servers without a listen directive bind port 80, unix sockets have no
+// port and are left out. A listen directive that does not parse is an error
+func (config *Config) GroupServersByPort() (map[int][]*Block, error) {
+	groups := map[int][]*Block{}
+	for _, server := range config.FindBlocksByName("server") {
+		protocol := serverProtocol(server)
+		if protocol == "" {
+			continue
+		}
+
+		listens := server.FindLines("listen")
+		if len(listens) == 0 && protocol == "http" {
+			groups[defaultHTTPPort] = append(groups[defaultHTTPPort], server)
+			continue
+		}
+
+		seen := map[int]bool{}
+		for _, line := range listens {
+			endpoint, err := parseListen(line.Params, protocol)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line.LineNumber, err)
+			}
+			if endpoint.Unix || seen[endpoint.Port] {
+				continue
+			}
+			seen[endpoint.Port] = true
+			groups[endpoint.Port] = append(groups[endpoint.Port], server)
+		}
+	}
+	return groups, nil
+}
+
+// serverProtocol returns the module a server block belongs to, or "" for
+// server directives that are not virtual servers (e.g. inside upstream)
+func serverProtocol(server *Block) string {
+	for parent := server.ParentRef; parent != nil; parent = parent.ParentRef {
+		switch parent.Name {
+		case "stream", "mail":
+			return parent.Name
+		case "http":
+			return "http"
+		case "upstream":
+			return ""
+		}
+	}
+	// Server blocks at the top of an included file are assumed to be http
+	return "http"
+}
+
+// parseListen parses the parameters of a listen directive
+func parseListen(params []string, protocol string) (Endpoint, error) {
+	if len(params) == 0 {
+		return Endpoint{}, fmt.Errorf("listen without an address")
+	}
+
+	endpoint := Endpoint{Protocol: protocol}
+	for _, param := range params[1:] {
+		switch param {
+		case "ssl":
+			endpoint.SSL = true
+		case "quic":
+			endpoint.SSL = true
+			endpoint.UDP = true
+		case "udp":
+			endpoint.UDP = true
+		}
+	}
+
+	address := unquote(params[0])
+	if strings.HasPrefix(address, "unix:") {
+		endpoint.Unix = true
+		endpoint.Address = strings.TrimPrefix(address, "unix:")
+		return endpoint, nil
+	}
+
+	host, port, err := splitListenAddress(address)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	endpoint.Address = host
+	endpoint.Port = port
+	endpoint.IPv6 = strings.Contains(host, ":")
+
+	return endpoint, nil
+}
+
+// splitListenAddress splits address[:port], port and [ipv6][:port] forms,
+// defaulting to all interfaces and port 80
+func splitListenAddress(address string) (string, int, error) {
+	// Port only
+	if port, err := strconv.Atoi(address); err == nil {
+		return "*", port, validatePort(port)
+	}
+
+	// Bracketed IPv6 with optional port
+	if strings.HasPrefix(address, "[") {
+		end := strings.Index(address, "]")
+		if end < 0 {
+			return "", 0, fmt.Errorf("invalid listen address %q", address)
+		}
+		host := address[1:end]
+		rest := address[end+1:]
+		if rest == "" {
+			return host, defaultHTTPPort, nil
+		}
+		port, err := strconv.Atoi(strings.TrimPrefix(rest, ":"))
+		if err != nil || !strings.HasPrefix(rest, ":") {
+			return "", 0, fmt.Errorf("invalid listen address %q", address)
+		}
+		return host, port, validatePort(port)
+	}
+
+	host, portValue, err := net.SplitHostPort(address)
+	if err != nil {
+		// Address without a port
+		return address, defaultHTTPPort, nil
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid listen port in %q", address)
+	}
+	if host == "" {
+		host = "*"
+	}
+	return host, port, validatePort(port)
+}
+
+// validatePort checks that a port number is in the valid TCP/UDP range
+func validatePort(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LocationModifier represents the matching mode of a location block
+type LocationModifier string
+
+const (
+	LocationPrefix        LocationModifier = ""   // location /path
+	LocationExact         LocationModifier = "="  // location = /path
+	LocationPreferPrefix  LocationModifier = "^~" // location ^~ /path, skips regex checks
+	LocationRegex         LocationModifier = "~"  // location ~ regex
+	LocationRegexCaseless LocationModifier = "~*" // location ~* regex
+	LocationNamed         LocationModifier = "@"  // location @name, internal redirects only
+)
+
+// Location is a typed view of a location block
+type Location struct {
+	Block    *Block           // Underlying location block
+	Modifier LocationModifier // Matching mode
+	Pattern  string           // Path prefix, exact path, regex or name (without @)
+}
+
+// NewLocation returns a typed view of a location block, or nil if the block is not a location
+func NewLocation(block *Block) *Location {
+	if block == nil || block.Name != "location" || len(block.Params) == 0 {
+		return nil
+	}
+
+	location := &Location{Block: block}
+	if len(block.Params) > 1 {
+		location.Modifier = LocationModifier(block.Params[0])
+		location.Pattern = unquote(block.Params[1])
+		return location
+	}
+
+	// Modifiers may be written without a space (location =/path, location ~\.php$)
+	param := unquote(block.Params[0])
+	switch {
+	case strings.HasPrefix(param, "@"):
+		location.Modifier = LocationNamed
+		location.Pattern = param[1:]
+	case strings.HasPrefix(param, "="):
+		location.Modifier = LocationExact
+		location.Pattern = param[1:]
+	case strings.HasPrefix(param, "^~"):
+		location.Modifier = LocationPreferPrefix
+		location.Pattern = param[2:]
+	case strings.HasPrefix(param, "~*"):
+		location.Modifier = LocationRegexCaseless
+		location.Pattern = param[2:]
+	case strings.HasPrefix(param, "~"):
+		location.Modifier = LocationRegex
+		location.Pattern = param[1:]
+	default:
+		location.Pattern = param
+	}
+
+	return location
+}
+
+// IsRegex reports whether the location matches by regular expression
+func (location *Location) IsRegex() bool {
+	return location.Modifier == LocationRegex || location.Modifier == LocationRegexCaseless
+}
+
+// IsNamed reports whether the location is a named location (@name)
+func (location *Location) IsNamed() bool {
+	return location.Modifier == LocationNamed
+}
+
+// Regexp compiles the location's regular expression, nil for non-regex locations
+func (location *Location) Regexp() (*regexp.Regexp, error) {
+	if !location.IsRegex() {
+		return nil, nil
+	}
+	pattern := location.Pattern
+	if location.Modifier == LocationRegexCaseless {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// Matches reports whether the location matches uri on its own, ignoring the
+// precedence rules between locations
+func (location *Location) Matches(uri string) bool {
+	switch location.Modifier {
+	case LocationExact:
+		return uri == location.Pattern
+	case LocationPrefix, LocationPreferPrefix:
+		return strings.HasPrefix(uri, location.Pattern)
+	case LocationRegex, LocationRegexCaseless:
+		re, err := location.Regexp()
+		return err == nil && re.MatchString(uri)
+	}
+	return false
+}
+
+// Locations returns the typed location blocks directly inside the block
+func (block *Block) Locations() []*Location {
+	var locations []*Location
+	for _, child := range block.FindBlocks("location") {
+		if location := NewLocation(child); location != nil {
+			locations = append(locations, location)
+		}
+	}
+	return locations
+}
+
+// FindLocationByURI selects the location that handles uri within a server or
+// location block using nginx precedence: exact match, then the longest prefix
+// (searching its nested locations), then regexes in order of appearance unless
+// the longest prefix uses ^~. Named locations never match. Returns nil if nothing matches
+func (block *Block) FindLocationByURI(uri string) *Location {
+	location, _ := matchLocation(block, uri)
+	return location
+}
+
+// matchLocation implements FindLocationByURI, also reporting whether the match
+// is final (exact or regex) so outer regexes must not override it
+func matchLocation(block *Block, uri string) (*Location, bool) {
+	var longest *Location
+	for _, location := range block.Locations() {
+		switch location.Modifier {
+		case LocationExact:
+			if location.Matches(uri) {
+				return location, true
+			}
+		case LocationPrefix, LocationPreferPrefix:
+			if location.Matches(uri) && (longest == nil || len(location.Pattern) > len(longest.Pattern)) {
+				longest = location
+			}
+		}
+	}
+
+	var prefixMatch *Location
+	if longest != nil {
+		prefixMatch = longest
+		if nested, final := matchLocation(longest.Block, uri); nested != nil {
+			if final {
+				return nested, true
+			}
+			prefixMatch = nested
+		}
+		if longest.Modifier == LocationPreferPrefix {
+			return prefixMatch, true
+		}
+	}
+
+	for _, location := range block.Locations() {
+		if location.IsRegex() && location.Matches(uri) {
+			return location, true
+		}
+	}
+
+	return prefixMatch, false
+}
+
+// ValidateLocationNesting reports location blocks nginx rejects at load time because
+// of where they are nested: locations outside server and location blocks, named
+// locations below the server level, locations inside exact or named locations and
+// prefix locations that do not extend the prefix of the enclosing location
+func (config *Config) ValidateLocationNesting() []ValidationIssue {
+	var issues []ValidationIssue
+	report := func(block *Block, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{
+			Severity:   SeverityError,
+			Directive:  "location",
+			Message:    fmt.Sprintf(format, args...),
+			LineNumber: block.LineNumber,
+		})
+	}
+
+	config.WalkBlocks(func(block *Block) {
+		if block.Name != "location" {
+			return
+		}
+		location := NewLocation(block)
+		if location == nil {
+			report(block, "location without a pattern")
+			return
+		}
+
+		parent := block.ParentRef
+		if parent == nil || (parent.Name != "server" && parent.Name != "location") {
+			context := ContextMain
+			if parent != nil && parent.ParentRef != nil {
+				context = parent.Name
+			}
+			report(block, "location %q is not allowed in %s context", location.Pattern, context)
+			return
+		}
+		if location.IsNamed() && parent.Name != "server" {
+			report(block, "named location \"@%s\" can be on the server level only", location.Pattern)
+			return
+		}
+
+		outer := NewLocation(parent)
+		if outer == nil {
+			return
+		}
+		switch {
+		case outer.Modifier == LocationExact:
+			report(block, "location %q cannot be inside the exact location %q", location.Pattern, outer.Pattern)
+		case outer.IsNamed():
+			report(block, "location %q cannot be inside the named location \"@%s\"", location.Pattern, outer.Pattern)
+		case !location.IsRegex() && !strings.HasPrefix(location.Pattern, outer.Pattern):
+			// nginx compares against the enclosing pattern text, even for regex locations
+			report(block, "location %q is outside location %q", location.Pattern, outer.Pattern)
+		}
+	})
+
+	return issues
+}
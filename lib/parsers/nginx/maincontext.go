@@ -0,0 +1,360 @@
+package nginx
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Defaults of the main context and events directives
+const (
+	defaultUser              = "nobody"
+	defaultWorkerConnections = 512
+)
+
+// eventMethodPlatforms lists the operating systems, as runtime.GOOS, providing
+// each connection processing method of the use directive
+var eventMethodPlatforms = map[string][]string{
+	"epoll":     {"linux"},
+	"kqueue":    {"darwin", "freebsd", "netbsd", "openbsd", "dragonfly"},
+	"/dev/poll": {"solaris", "illumos"},
+	"eventport": {"solaris", "illumos"},
+}
+
+// WorkerProcesses is the value of worker_processes, a count or auto for one worker
+// per CPU core
+type WorkerProcesses struct {
+	Count int  // Number of workers, 0 with Auto
+	Auto  bool // One worker per available CPU core
+}
+
+// String returns the value as written in the configuration
+func (workers WorkerProcesses) String() string {
+	if workers.Auto {
+		return "auto"
+	}
+	return strconv.Itoa(workers.Count)
+}
+
+// ErrorLog is an error_log directive of the main context
+type ErrorLog struct {
+	Path  string // File, stderr, syslog:... or memory:...
+	Level string // Minimum level logged, empty for the default error
+	Line  *Line
+}
+
+// EventsContext holds the connection processing settings of the events block
+type EventsContext struct {
+	Block             *Block // events block, nil if the configuration has none
+	WorkerConnections int    // Connections per worker, 512 unless set
+	Use               string // Connection processing method, empty to let nginx pick the best
+	MultiAccept       bool   // Accept all new connections at once
+	AcceptMutex       bool   // Workers take turns accepting connections
+}
+
+// MainContext is a typed view of the global directives of a configuration and its
+// events block. Fields hold the effective values, the nginx defaults when unset.
+// Assigning a field changes the view only; the Set methods write the value to the
+// underlying directives as well, so the configuration serializes with it
+type MainContext struct {
+	config *Config
+
+	User               string          // Account workers run as, nobody unless set
+	Group              string          // Group workers run as, named like User unless set
+	PID                string          // pid file path, empty for the build default
+	WorkerProcesses    WorkerProcesses // 1 unless set
+	CPUAffinityAuto    bool            // worker_cpu_affinity auto binds workers to available CPUs
+	CPUAffinity        []string        // CPU masks of worker_cpu_affinity, limiting auto when set with it
+	WorkerRlimitNofile int64           // Open file limit of workers, 0 unless set
+	ErrorLogs          []ErrorLog      // error_log directives, empty for the build default
+	Daemon             bool            // on unless set
+	MasterProcess      bool            // on unless set
+	Events             EventsContext
+
+	Lines map[string]*Line // Directives in effect by name, missing when the default applies
+}
+
+// Main returns a typed view of the main context and events block settings
+func (config *Config) Main() *MainContext {
+	main := &MainContext{
+		config:          config,
+		User:            defaultUser,
+		Group:           defaultUser,
+		WorkerProcesses: WorkerProcesses{Count: 1},
+		Daemon:          true,
+		MasterProcess:   true,
+		Events:          EventsContext{WorkerConnections: defaultWorkerConnections},
+		Lines:           map[string]*Line{},
+	}
+
+	root := config.RootBlock
+	param := func(name string) []string {
+		lines := root.FindLines(name)
+		if len(lines) == 0 || len(lines[0].Params) == 0 {
+			return nil
+		}
+		main.Lines[name] = lines[0]
+		return unquoteParams(lines[0].Params)
+	}
+
+	if params := param("user"); params != nil {
+		main.User, main.Group = params[0], params[0]
+		if len(params) > 1 {
+			main.Group = params[1]
+		}
+	}
+	if params := param("pid"); params != nil {
+		main.PID = params[0]
+	}
+	if params := param("worker_processes"); params != nil {
+		main.WorkerProcesses = parseWorkerProcesses(params[0])
+	}
+	if params := param("worker_cpu_affinity"); params != nil {
+		main.CPUAffinityAuto = params[0] == "auto"
+		if main.CPUAffinityAuto {
+			params = params[1:]
+		}
+		main.CPUAffinity = params
+	}
+	if params := param("worker_rlimit_nofile"); params != nil {
+		main.WorkerRlimitNofile, _ = strconv.ParseInt(params[0], 10, 64)
+	}
+	if params := param("daemon"); params != nil {
+		main.Daemon = strings.EqualFold(params[0], "on")
+	}
+	if params := param("master_process"); params != nil {
+		main.MasterProcess = strings.EqualFold(params[0], "on")
+	}
+	for _, line := range root.FindLines("error_log") {
+		if len(line.Params) == 0 {
+			continue
+		}
+		params := unquoteParams(line.Params)
+		log := ErrorLog{Path: params[0], Line: line}
+		if len(params) > 1 {
+			log.Level = params[1]
+		}
+		main.ErrorLogs = append(main.ErrorLogs, log)
+	}
+
+	if blocks := root.FindBlocks("events"); len(blocks) > 0 {
+		events := blocks[0]
+		main.Events.Block = events
+		value := func(name string) string {
+			if lines := events.FindLines(name); len(lines) > 0 && len(lines[0].Params) > 0 {
+				main.Lines[name] = lines[0]
+				return unquote(lines[0].Params[0])
+			}
+			return ""
+		}
+		if connections := value("worker_connections"); connections != "" {
+			main.Events.WorkerConnections, _ = strconv.Atoi(connections)
+		}
+		main.Events.Use = value("use")
+		main.Events.MultiAccept = strings.EqualFold(value("multi_accept"), "on")
+		main.Events.AcceptMutex = strings.EqualFold(value("accept_mutex"), "on")
+	}
+	return main
+}
+
+// parseWorkerProcesses parses the value of worker_processes, a count of 0 when it
+// is neither a number nor auto
+func parseWorkerProcesses(value string) WorkerProcesses {
+	if value == "auto" {
+		return WorkerProcesses{Auto: true}
+	}
+	count, _ := strconv.Atoi(value)
+	return WorkerProcesses{Count: count}
+}
+
+// unquoteParams returns the parameters of a directive without their quotes
+func unquoteParams(params []string) []string {
+	values := make([]string, len(params))
+	for i, param := range params {
+		values[i] = unquote(param)
+	}
+	return values
+}
+
+// set writes a directive of the main context or the events block
+func (main *MainContext) set(block *Block, name string, params ...string) {
+	main.Lines[name] = block.SetDirective(name, params...)
+	main.config.Dirty = true
+}
+
+// events returns the events block, adding it ahead of the other blocks when missing
+func (main *MainContext) events() *Block {
+	if main.Events.Block == nil {
+		root := main.config.RootBlock
+		index := len(root.Lines)
+		for i, line := range root.Lines {
+			if line.Type == LineTypeBlock {
+				index = i
+				break
+			}
+		}
+		main.Events.Block = NewBlock("events")
+		root.InsertBlock(index, main.Events.Block)
+	}
+	return main.Events.Block
+}
+
+// SetUser sets the account and group workers run as, the group named like the
+// account when empty
+func (main *MainContext) SetUser(user, group string) {
+	main.User, main.Group = user, group
+	if group == "" {
+		main.Group = user
+		main.set(main.config.RootBlock, "user", user)
+		return
+	}
+	main.set(main.config.RootBlock, "user", user, group)
+}
+
+// SetPID sets the path of the pid file
+func (main *MainContext) SetPID(path string) {
+	main.PID = path
+	main.set(main.config.RootBlock, "pid", pathParam(path))
+}
+
+// SetWorkerProcesses sets the number of worker processes
+func (main *MainContext) SetWorkerProcesses(workers WorkerProcesses) {
+	main.WorkerProcesses = workers
+	main.set(main.config.RootBlock, "worker_processes", workers.String())
+}
+
+// SetCPUAffinity binds workers to CPUs, one mask per worker such as 0001 0010, or
+// with auto to the available CPUs, limited to the masks given with it
+func (main *MainContext) SetCPUAffinity(auto bool, masks ...string) {
+	main.CPUAffinityAuto, main.CPUAffinity = auto, append([]string{}, masks...)
+	params := masks
+	if auto {
+		params = append([]string{"auto"}, masks...)
+	}
+	main.set(main.config.RootBlock, "worker_cpu_affinity", params...)
+}
+
+// SetWorkerRlimitNofile sets the open file limit of the workers
+func (main *MainContext) SetWorkerRlimitNofile(limit int64) {
+	main.WorkerRlimitNofile = limit
+	main.set(main.config.RootBlock, "worker_rlimit_nofile", strconv.FormatInt(limit, 10))
+}
+
+// SetErrorLog replaces the error_log directives of the main context with one
+// logging to path, at the default level when level is empty
+func (main *MainContext) SetErrorLog(path, level string) {
+	params := []string{pathParam(path)}
+	if level != "" {
+		params = append(params, level)
+	}
+	main.set(main.config.RootBlock, "error_log", params...)
+	main.ErrorLogs = []ErrorLog{{Path: path, Level: level, Line: main.Lines["error_log"]}}
+}
+
+// SetDaemon sets whether nginx runs in the background
+func (main *MainContext) SetDaemon(on bool) {
+	main.Daemon = on
+	main.set(main.config.RootBlock, "daemon", onOff(on))
+}
+
+// SetMasterProcess sets whether nginx runs a master process with workers
+func (main *MainContext) SetMasterProcess(on bool) {
+	main.MasterProcess = on
+	main.set(main.config.RootBlock, "master_process", onOff(on))
+}
+
+// SetWorkerConnections sets the connections per worker in the events block
+func (main *MainContext) SetWorkerConnections(connections int) {
+	main.Events.WorkerConnections = connections
+	main.set(main.events(), "worker_connections", strconv.Itoa(connections))
+}
+
+// SetUse sets the connection processing method in the events block
+func (main *MainContext) SetUse(method string) {
+	main.Events.Use = method
+	main.set(main.events(), "use", method)
+}
+
+// SetMultiAccept sets whether workers accept all new connections at once
+func (main *MainContext) SetMultiAccept(on bool) {
+	main.Events.MultiAccept = on
+	main.set(main.events(), "multi_accept", onOff(on))
+}
+
+// SetAcceptMutex sets whether workers take turns accepting connections
+func (main *MainContext) SetAcceptMutex(on bool) {
+	main.Events.AcceptMutex = on
+	main.set(main.events(), "accept_mutex", onOff(on))
+}
+
+// pathParam writes a path as a parameter, quoted when it has to be
+func pathParam(path string) string {
+	if needsQuoting(path) {
+		return quoteParam(path, '"')
+	}
+	return path
+}
+
+// onOff returns the flag parameter of a boolean directive
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// ValidateMain checks the main context settings: worker_cpu_affinity masks that
+// are not binary or whose count does not match worker_processes, a use method the
+// host platform lacks (informational, the configuration may be meant for another
+// host) and, with baseDir set, a pid file whose directory is missing or not
+// writable. Relative pid paths are resolved against baseDir
+func (config *Config) ValidateMain(baseDir string) []ValidationIssue {
+	var issues []ValidationIssue
+	report := func(severity Severity, line *Line, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{
+			Severity:   severity,
+			Directive:  line.Name,
+			Message:    fmt.Sprintf(format, args...),
+			LineNumber: line.LineNumber,
+		})
+	}
+
+	main := config.Main()
+	if line := main.Lines["worker_cpu_affinity"]; line != nil {
+		valid := true
+		for _, mask := range main.CPUAffinity {
+			if strings.Trim(mask, "01") != "" {
+				report(SeverityError, line, "invalid CPU mask %q, masks are written in binary such as 0101", mask)
+				valid = false
+			}
+		}
+		workers := main.WorkerProcesses
+		switch {
+		case !valid || main.CPUAffinityAuto || workers.Auto:
+		case len(main.CPUAffinity) < workers.Count:
+			report(SeverityWarning, line, "worker_cpu_affinity has %d masks for %d worker processes, the workers past the last mask are bound to it too", len(main.CPUAffinity), workers.Count)
+		case len(main.CPUAffinity) > workers.Count:
+			report(SeverityWarning, line, "worker_cpu_affinity has %d masks for %d worker processes, the extra masks are unused", len(main.CPUAffinity), workers.Count)
+		}
+	}
+
+	if line := main.Lines["use"]; line != nil {
+		if platforms, ok := eventMethodPlatforms[main.Events.Use]; ok && !hasParam(platforms, runtime.GOOS) {
+			report(SeverityInfo, line, "use %s is only available on %s, not on this %s host", main.Events.Use, strings.Join(platforms, ", "), runtime.GOOS)
+		}
+	}
+
+	if line := main.Lines["pid"]; line != nil && baseDir != "" && main.PID != "" {
+		dir := path.Dir(resolveIncludePath(baseDir, main.PID))
+		if info, err := os.Stat(localPath(dir)); err != nil || !info.IsDir() {
+			report(SeverityWarning, line, "directory %q of the pid file does not exist", dir)
+		} else if !dirWritable(localPath(dir)) {
+			report(SeverityWarning, line, "directory %q of the pid file is not writable, nginx cannot record its process ID", dir)
+		}
+	}
+
+	return issues
+}
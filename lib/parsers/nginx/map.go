@@ -0,0 +1,215 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxVariableDepth bounds how many variables are expanded through each other
+const maxVariableDepth = 16
+
+// MapEntry is a source value of a map block and the result it maps to
+type MapEntry struct {
+	Key   string         // Source value as written, unquoted, with the ~ or ~* prefix of regexes
+	Value string         // Resulting value, may reference variables and regex captures
+	Line  *Line          // Directive defining the entry
+	File  string         // Included file defining the entry, empty for entries of the map block itself
+	Regex *regexp.Regexp // Compiled regex, nil for string and wildcard keys
+
+	isRegex bool // Whether the key is a regex, even one that does not compile
+}
+
+// Map is a typed view of a map block of ngx_http_map_module or ngx_stream_map_module
+type Map struct {
+	Block     *Block     // Underlying map block
+	Source    string     // Source expression, e.g. $ssl_preread_server_name
+	Variable  string     // Defined variable without the $
+	Default   string     // Result when no entry matches, empty unless set
+	Hostnames bool       // Keys may be wildcard host names such as *.example.com
+	Volatile  bool       // The variable is not cached, it is evaluated on every use
+	Entries   []MapEntry // Entries in order, excluding default and the special parameters
+	Includes  []*Line    // include directives of the block, their entries are listed only when resolved
+}
+
+// NewMap returns a typed view of a map block, or nil if the block is not a map.
+// Regex keys that do not compile are kept without a Regex and never match. The
+// entries of included files are left out, see NewMapWithIncludes
+func NewMap(block *Block) *Map {
+	if block == nil || block.Name != "map" || len(block.Params) < 2 {
+		return nil
+	}
+
+	m := &Map{Block: block, Source: unquote(block.Params[0]), Variable: strings.TrimPrefix(unquote(block.Params[1]), "$")}
+	for _, line := range block.Lines {
+		if line.Type == LineTypeInclude {
+			m.Includes = append(m.Includes, line)
+		}
+		m.addLine(line, "")
+	}
+	return m
+}
+
+// NewMapWithIncludes returns a typed view of a map block like NewMap, reading the
+// files its include directives refer to, resolved against baseDir, for entries
+// and the special parameters. Entries keep the order nginx reads them in
+func NewMapWithIncludes(block *Block, baseDir string) (*Map, error) {
+	m := NewMap(block)
+	if m == nil {
+		return nil, nil
+	}
+
+	m.Entries, m.Default, m.Hostnames, m.Volatile = nil, "", false, false
+	var read func(lines []*Line, file string, depth int) error
+	read = func(lines []*Line, file string, depth int) error {
+		if depth > maxIncludeDepth {
+			return fmt.Errorf("includes nested deeper than %d levels", maxIncludeDepth)
+		}
+		for _, line := range lines {
+			if line.Type != LineTypeInclude {
+				m.addLine(line, file)
+				continue
+			}
+			files, err := includeFiles(rootFS{}, baseDir, line)
+			if err != nil {
+				return err
+			}
+			for _, path := range files {
+				included, err := ParseConfig(path)
+				if err != nil {
+					return err
+				}
+				if err := read(included.RootBlock.Lines, path, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := read(block.Lines, "", 0); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// addLine records a line of the map block or of a file it includes as an entry or
+// a special parameter
+func (m *Map) addLine(line *Line, file string) {
+	if line.Type != LineTypeDirective {
+		return
+	}
+	key := unquote(line.Name)
+	switch {
+	case key == "hostnames" && len(line.Params) == 0:
+		m.Hostnames = true
+		return
+	case key == "volatile" && len(line.Params) == 0:
+		m.Volatile = true
+		return
+	case key == "default" && len(line.Params) == 1:
+		m.Default = unquote(line.Params[0])
+		return
+	case len(line.Params) != 1:
+		return
+	}
+
+	entry := MapEntry{Key: key, Value: unquote(line.Params[0]), Line: line, File: file}
+	switch {
+	case strings.HasPrefix(key, "~*"):
+		entry.isRegex = true
+		entry.Regex, _ = regexp.Compile("(?i)" + key[2:])
+	case strings.HasPrefix(key, "~"):
+		entry.isRegex = true
+		entry.Regex, _ = regexp.Compile(key[1:])
+	case strings.HasPrefix(key, "\\"):
+		// A leading backslash lets keys start with ~ or spell a special parameter
+		entry.Key = key[1:]
+	}
+	m.Entries = append(m.Entries, entry)
+}
+
+// Maps returns the typed map blocks directly inside the block
+func (block *Block) Maps() []*Map {
+	var maps []*Map
+	for _, child := range block.FindBlocks("map") {
+		if m := NewMap(child); m != nil {
+			maps = append(maps, m)
+		}
+	}
+	return maps
+}
+
+// Evaluate maps a source value like nginx: string keys match ignoring case, then
+// with hostnames the longest leading and trailing wildcard names, then regexes in
+// order of appearance. Regex captures are substituted into the result. Returns the
+// result and the matching entry, nil when the default applies
+func (m *Map) Evaluate(value string) (string, *MapEntry) {
+	lower := strings.ToLower(value)
+	if m.Hostnames {
+		lower = strings.TrimSuffix(lower, ".")
+	}
+
+	for i := range m.Entries {
+		if entry := &m.Entries[i]; !entry.isRegex && !isMapWildcardKey(m, entry.Key) && strings.ToLower(entry.Key) == lower {
+			return entry.Value, entry
+		}
+	}
+
+	if m.Hostnames {
+		var names []RefererName
+		var entries []*MapEntry
+		for i := range m.Entries {
+			if entry := &m.Entries[i]; !entry.isRegex && isMapWildcardKey(m, entry.Key) {
+				names = append(names, RefererName{Host: strings.ToLower(entry.Key)})
+				entries = append(entries, entry)
+			}
+		}
+		if name, ok := matchRefererName(names, lower); ok {
+			for i := range names {
+				if names[i].Host == name.Host {
+					return entries[i].Value, entries[i]
+				}
+			}
+		}
+	}
+
+	for i := range m.Entries {
+		entry := &m.Entries[i]
+		if entry.Regex == nil {
+			continue
+		}
+		if captures := entry.Regex.FindStringSubmatch(value); captures != nil {
+			result := rewriteCapturePattern.ReplaceAllStringFunc(entry.Value, func(reference string) string {
+				index, _ := strconv.Atoi(strings.Trim(reference, "${}"))
+				if index < len(captures) {
+					return captures[index]
+				}
+				return ""
+			})
+			return result, entry
+		}
+	}
+
+	return m.Default, nil
+}
+
+// isMapWildcardKey reports whether a key of a map with hostnames is a wildcard name
+func isMapWildcardKey(m *Map, key string) bool {
+	return m.Hostnames && (strings.HasPrefix(key, "*.") || strings.HasPrefix(key, ".") || strings.HasSuffix(key, ".*"))
+}
+
+// expandVariables replaces the $name and ${name} references of an expression with
+// their values. ok is false when lookup does not know one of the variables
+func expandVariables(expression string, lookup func(name string) (string, bool)) (string, bool) {
+	ok := true
+	expanded := variablePattern.ReplaceAllStringFunc(expression, func(reference string) string {
+		match := variablePattern.FindStringSubmatch(reference)
+		value, known := lookup(match[1] + match[2])
+		if !known {
+			ok = false
+		}
+		return value
+	})
+	return expanded, ok
+}
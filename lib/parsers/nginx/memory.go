@@ -0,0 +1,104 @@
+package nginx
+
+import "unsafe"
+
+// sizeClasses are the object sizes the Go allocator rounds small allocations up to
+var sizeClasses = []int64{
+	8, 16, 24, 32, 48, 64, 80, 96, 112, 128, 144, 160, 176, 192, 208, 224, 240, 256,
+	288, 320, 352, 384, 416, 448, 480, 512, 576, 640, 704, 768, 896, 1024, 1152, 1280,
+	1408, 1536, 1792, 2048, 2304, 2688, 3072, 3200, 3456, 4096, 4864, 5376, 6144, 6528,
+	6784, 6912, 8192, 9472, 9728, 10240, 10880, 12288, 13568, 14336, 16384, 18432, 19072,
+	20480, 21760, 24576, 27264, 28672, 32768,
+}
+
+// pageSize is the granularity of allocations larger than the largest size class
+const pageSize = 8192
+
+// EstimateMemoryUsage approximates the heap bytes held by the configuration tree:
+// every Block and Line struct, the backing arrays of their slices and their string
+// data, rounded to the allocator's size classes. Strings shared between lines, such
+// as the parameters of a block and of the line opening it, are counted once. The
+// estimate helps deciding between ParseConfig and ParseConfigStream
+func (config *Config) EstimateMemoryUsage() int64 {
+	size := allocSize(int64(unsafe.Sizeof(Config{}))) + stringSize(config.FilePath) + stringSize(config.RawTrailer)
+	if config.RootBlock != nil {
+		size += blockMemoryUsage(config.RootBlock)
+	}
+	return size
+}
+
+// blockMemoryUsage approximates the heap bytes held by a block and its contents
+func blockMemoryUsage(block *Block) int64 {
+	size := allocSize(int64(unsafe.Sizeof(Block{})))
+	size += tokenSize(block.Name) + tokensSize(block.Params) + stringsSize(block.Comments)
+	size += stringsSize(block.ClosingComments) + stringSize(block.RawClosing) + stringSize(block.Verbatim) + stringSize(block.parsedClosing)
+	size += sliceSize(cap(block.Lines), int64(unsafe.Sizeof((*Line)(nil))))
+	size += sliceSize(cap(block.Blocks), int64(unsafe.Sizeof((*Block)(nil))))
+
+	for _, line := range block.Lines {
+		size += allocSize(int64(unsafe.Sizeof(Line{})))
+		size += stringSize(line.Raw) + stringSize(line.parsedText) + stringsSize(line.Comments)
+		if line.BlockRef == nil {
+			size += tokenSize(line.Name) + tokensSize(line.Params)
+		}
+	}
+	for _, child := range block.Blocks {
+		size += blockMemoryUsage(child)
+	}
+	return size
+}
+
+// stringsSize approximates the heap bytes of a string slice and its strings
+func stringsSize(values []string) int64 {
+	size := sliceSize(cap(values), int64(unsafe.Sizeof("")))
+	for _, value := range values {
+		size += stringSize(value)
+	}
+	return size
+}
+
+// tokensSize approximates the heap bytes of the parameters of a directive, which
+// share their backing array with the directive name
+func tokensSize(values []string) int64 {
+	size := sliceSize(cap(values)+1, int64(unsafe.Sizeof("")))
+	for _, value := range values {
+		size += tokenSize(value)
+	}
+	return size
+}
+
+// tokenSize approximates the heap bytes of a name or parameter, which the parser
+// builds byte by byte in a buffer doubling its capacity
+func tokenSize(value string) int64 {
+	if value == "" {
+		return 0
+	}
+	capacity := int64(8)
+	for capacity < int64(len(value)) {
+		capacity *= 2
+	}
+	return allocSize(capacity)
+}
+
+// stringSize approximates the heap bytes of the data of a string
+func stringSize(value string) int64 {
+	return allocSize(int64(len(value)))
+}
+
+// sliceSize approximates the heap bytes of the backing array of a slice
+func sliceSize(capacity int, elementSize int64) int64 {
+	return allocSize(int64(capacity) * elementSize)
+}
+
+// allocSize rounds an allocation up to the size the Go allocator reserves for it
+func allocSize(size int64) int64 {
+	if size <= 0 {
+		return 0
+	}
+	for _, class := range sizeClasses {
+		if size <= class {
+			return class
+		}
+	}
+	return (size + pageSize - 1) / pageSize * pageSize
+}
@@ -0,0 +1,192 @@
+package nginx
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Migration is a named transformation of a configuration, typically needed when
+// upgrading nginx to a version that changed or removed a directive
+type Migration struct {
+	Name        string                                 // Name the migration is run by
+	Description string                                 // Short description of what the migration changes
+	Apply       func(config *Config) error             // Transformation, applied to a copy of the configuration
+	Validate    func(config *Config) []ValidationError // Optional check of the migrated configuration
+}
+
+// MigrationRegistry holds the migrations available by name
+type MigrationRegistry struct {
+	migrations map[string]Migration
+}
+
+// NewMigrationRegistry creates a registry holding the built-in migrations
+func NewMigrationRegistry() *MigrationRegistry {
+	registry := &MigrationRegistry{migrations: map[string]Migration{}}
+	for _, migration := range builtinMigrations {
+		registry.Register(migration)
+	}
+	return registry
+}
+
+// Register adds a migration, replacing any migration with the same name
+func (registry *MigrationRegistry) Register(m Migration) {
+	registry.migrations[m.Name] = m
+}
+
+// Migrations returns the registered migrations sorted by name
+func (registry *MigrationRegistry) Migrations() []Migration {
+	migrations := make([]Migration, 0, len(registry.migrations))
+	for _, migration := range registry.migrations {
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Name < migrations[j].Name })
+	return migrations
+}
+
+// Run applies the named migration. The migration works on a copy of the configuration,
+// which replaces the original only if the migration succeeds and its validation
+// reports no errors
+func (registry *MigrationRegistry) Run(config *Config, migrationName string) error {
+	migration, ok := registry.migrations[migrationName]
+	if !ok {
+		return fmt.Errorf("unknown migration %q", migrationName)
+	}
+
+	migrated := config.Clone()
+	if err := migration.Apply(migrated); err != nil {
+		return fmt.Errorf("migration %s: %v", migrationName, err)
+	}
+	if migration.Validate != nil {
+		for _, issue := range migration.Validate(migrated) {
+			if issue.Severity == SeverityError {
+				return fmt.Errorf("migration %s: %v", migrationName, issue)
+			}
+		}
+	}
+
+	*config = *migrated
+	return nil
+}
+
+// ApplyMigration runs one of the built-in migrations on the configuration
+func (config *Config) ApplyMigration(migrationName string) error {
+	return NewMigrationRegistry().Run(config, migrationName)
+}
+
+// builtinMigrations are the migrations every registry starts with
+var builtinMigrations = []Migration{
+	{
+		Name:        "http2-directive",
+		Description: "nginx 1.25.1: replace the http2 listen parameter with the http2 directive",
+		Apply:       migrateListenParam("http2", "http2"),
+		Validate:    validateNoListenParam("http2"),
+	},
+	{
+		Name:        "spdy-to-http2",
+		Description: "nginx 1.9.5: replace the removed SPDY module with HTTP/2",
+		Apply: func(config *Config) error {
+			config.WalkBlocks(func(block *Block) {
+				for _, line := range block.FindLines("listen") {
+					for i, param := range line.Params {
+						if i > 0 && param == "spdy" {
+							line.Params[i] = "http2"
+						}
+					}
+				}
+				for _, line := range append([]*Line{}, block.Lines...) {
+					if line.Type == LineTypeDirective && strings.HasPrefix(line.Name, "spdy_") {
+						block.RemoveLine(line)
+					}
+				}
+			})
+			return nil
+		},
+		Validate: validateNoListenParam("spdy"),
+	},
+	{
+		Name:        "ssl-directive",
+		Description: "nginx 1.15.0: replace \"ssl on\" with the ssl parameter of the listen directives",
+		Apply: func(config *Config) error {
+			for _, server := range config.FindBlocksByName("server") {
+				for _, line := range server.FindLines("ssl") {
+					server.RemoveLine(line)
+					if len(line.Params) == 0 || line.Params[0] != "on" {
+						continue
+					}
+					for _, listen := range server.FindLines("listen") {
+						if len(listen.Params) > 0 && !hasParam(listen.Params[1:], "ssl") {
+							listen.Params = append(listen.Params, "ssl")
+						}
+					}
+				}
+			}
+			return nil
+		},
+		Validate: func(config *Config) []ValidationError {
+			var issues []ValidationError
+			for _, line := range config.FindLinesByName("ssl") {
+				issues = append(issues, ValidationError{
+					Severity:   SeverityError,
+					Directive:  line.Name,
+					Message:    "the ssl directive is still present",
+					LineNumber: line.LineNumber,
+				})
+			}
+			return issues
+		},
+	},
+}
+
+// migrateListenParam returns a migration step that removes param from the listen
+// directives of every server and enables directive in the servers that used it
+func migrateListenParam(param, directive string) func(config *Config) error {
+	return func(config *Config) error {
+		for _, server := range config.FindBlocksByName("server") {
+			used := false
+			for _, listen := range server.FindLines("listen") {
+				params := listen.Params[:0:0]
+				for i, value := range listen.Params {
+					if i > 0 && value == param {
+						used = true
+						continue
+					}
+					params = append(params, value)
+				}
+				listen.Params = params
+			}
+			if used && len(server.FindLines(directive)) == 0 {
+				server.SetDirective(directive, "on")
+			}
+		}
+		return nil
+	}
+}
+
+// validateNoListenParam returns a check reporting listen directives that still carry param
+func validateNoListenParam(param string) func(config *Config) []ValidationError {
+	return func(config *Config) []ValidationError {
+		var issues []ValidationError
+		for _, listen := range config.FindLinesByName("listen") {
+			if len(listen.Params) > 1 && hasParam(listen.Params[1:], param) {
+				issues = append(issues, ValidationError{
+					Severity:   SeverityError,
+					Directive:  listen.Name,
+					Message:    fmt.Sprintf("listen still has the %s parameter", param),
+					LineNumber: listen.LineNumber,
+				})
+			}
+		}
+		return issues
+	}
+}
+
+// hasParam reports whether params contains value
+func hasParam(params []string, value string) bool {
+	for _, param := range params {
+		if param == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,324 @@
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrIndexOutOfRange is returned when a line index is outside the block's lines
+	ErrIndexOutOfRange = errors.New("line index out of range")
+
+	// ErrLineNotFound is returned when a line does not belong to the block
+	ErrLineNotFound = errors.New("line not found in block")
+
+	// ErrBlockNotFound is returned when a block path does not lead to a block
+	ErrBlockNotFound = errors.New("block not found")
+
+	// ErrDirectiveNotFound is returned when a block has no directive with the name
+	ErrDirectiveNotFound = errors.New("directive not found in block")
+)
+
+// NewBlock creates an empty block with the given name and parameters
+func NewBlock(name string, params ...string) *Block {
+	return &Block{
+		Name:   name,
+		Params: append([]string{}, params...),
+		Lines:  []*Line{},
+		Blocks: []*Block{},
+	}
+}
+
+// AddDirective appends a directive to the block and returns the new line
+func (block *Block) AddDirective(name string, params ...string) *Line {
+	lineType := LineTypeDirective
+	if name == "include" {
+		lineType = LineTypeInclude
+	}
+
+	line := &Line{
+		Name:   name,
+		Params: append([]string{}, params...),
+		Type:   lineType,
+	}
+	block.Lines = append(block.Lines, line)
+
+	return line
+}
+
+// SetDirective sets the parameters of a directive that nginx allows once per block. The
+// first existing line with the name is updated and any further ones are removed; if
+// there is none, the directive is inserted ahead of the block's child blocks
+func (block *Block) SetDirective(name string, params ...string) *Line {
+	var found *Line
+	for _, line := range block.FindLines(name) {
+		if found == nil {
+			found = line
+			found.Params = append([]string{}, params...)
+			continue
+		}
+		block.RemoveLine(line)
+	}
+	if found != nil {
+		return found
+	}
+
+	// Keep directives ahead of child blocks and the comments introducing them
+	index := len(block.Lines)
+	for i, line := range block.Lines {
+		if line.Type == LineTypeBlock {
+			index = i
+			break
+		}
+	}
+	for index > 0 && index < len(block.Lines) && block.Lines[index-1].Type == LineTypeComment {
+		index--
+	}
+	return block.InsertDirective(index, name, params...)
+}
+
+// AddBlock appends a child block together with its block line
+func (block *Block) AddBlock(child *Block) {
+	child.ParentRef = block
+	block.Blocks = append(block.Blocks, child)
+	block.Lines = append(block.Lines, &Line{
+		Name:     child.Name,
+		Params:   child.Params,
+		Comments: child.Comments,
+		Type:     LineTypeBlock,
+		BlockRef: child,
+	})
+}
+
+// InsertBlock inserts a child block at the given position in the block's lines
+func (block *Block) InsertBlock(index int, child *Block) {
+	if index < 0 {
+		index = 0
+	}
+	if index > len(block.Lines) {
+		index = len(block.Lines)
+	}
+
+	child.ParentRef = block
+	line := &Line{
+		Name:     child.Name,
+		Params:   child.Params,
+		Comments: child.Comments,
+		Type:     LineTypeBlock,
+		BlockRef: child,
+	}
+
+	block.Lines = append(block.Lines[:index], append([]*Line{line}, block.Lines[index:]...)...)
+	block.syncBlocks()
+}
+
+// RemoveBlock removes a child block and its block line, reporting whether it was found
+func (block *Block) RemoveBlock(child *Block) bool {
+	for i, line := range block.Lines {
+		if line.BlockRef == child {
+			block.Lines = append(block.Lines[:i], block.Lines[i+1:]...)
+			block.syncBlocks()
+			child.ParentRef = nil
+			return true
+		}
+	}
+	return false
+}
+
+// syncBlocks rebuilds the child block list from the block lines so both stay in the same order
+func (block *Block) syncBlocks() {
+	blocks := []*Block{}
+	for _, line := range block.Lines {
+		if line.Type == LineTypeBlock && line.BlockRef != nil {
+			blocks = append(blocks, line.BlockRef)
+		}
+	}
+	block.Blocks = blocks
+}
+
+// InsertDirective inserts a directive at the given position in the block's lines and returns the new line
+func (block *Block) InsertDirective(index int, name string, params ...string) *Line {
+	if index < 0 {
+		index = 0
+	}
+	if index > len(block.Lines) {
+		index = len(block.Lines)
+	}
+
+	line := block.AddDirective(name, params...)
+	block.Lines = block.Lines[:len(block.Lines)-1]
+	block.Lines = append(block.Lines[:index], append([]*Line{line}, block.Lines[index:]...)...)
+
+	return line
+}
+
+// RemoveLine removes a line from the block, reporting whether it was found.
+// Removing a block line also removes the child block
+func (block *Block) RemoveLine(line *Line) bool {
+	for i, candidate := range block.Lines {
+		if candidate == line {
+			block.Lines = append(block.Lines[:i], block.Lines[i+1:]...)
+			if line.Type == LineTypeBlock {
+				block.syncBlocks()
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// SwapLines swaps the lines at positions i and j
+func (block *Block) SwapLines(i, j int) error {
+	if i < 0 || i >= len(block.Lines) || j < 0 || j >= len(block.Lines) {
+		return ErrIndexOutOfRange
+	}
+
+	block.Lines[i], block.Lines[j] = block.Lines[j], block.Lines[i]
+	block.syncBlocks()
+	return nil
+}
+
+// MoveLineToIndex moves a line of the block so that it ends up at newIndex
+func (block *Block) MoveLineToIndex(line *Line, newIndex int) error {
+	index := block.IndexOfLine(line)
+	if index < 0 {
+		return ErrLineNotFound
+	}
+	if newIndex < 0 || newIndex >= len(block.Lines) {
+		return ErrIndexOutOfRange
+	}
+
+	block.Lines = append(block.Lines[:index], block.Lines[index+1:]...)
+	block.Lines = append(block.Lines[:newIndex], append([]*Line{line}, block.Lines[newIndex:]...)...)
+	block.syncBlocks()
+	return nil
+}
+
+// IndexOfLine returns the position of line in the block's lines, or -1
+func (block *Block) IndexOfLine(line *Line) int {
+	for i, candidate := range block.Lines {
+		if candidate == line {
+			return i
+		}
+	}
+	return -1
+}
+
+// PatchDirective replaces the parameters of the first directive named directiveName in
+// the block reached by following blockPath from the root and marks the configuration
+// dirty. Each path element selects the first child block with that name, or with that
+// name and parameters (e.g. "server example.com", as in blockKey); an empty path
+// selects the root block. Nothing is changed when an error is returned
+func (config *Config) PatchDirective(blockPath []string, directiveName string, newParams []string) error {
+	block := config.RootBlock
+	for _, element := range blockPath {
+		var next *Block
+		for _, child := range block.Blocks {
+			if child.Name == element || blockKey(child) == element {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return fmt.Errorf("%w: %s", ErrBlockNotFound, strings.Join(blockPath, " > "))
+		}
+		block = next
+	}
+
+	lines := block.FindLines(directiveName)
+	if len(lines) == 0 {
+		return fmt.Errorf("%w: %s", ErrDirectiveNotFound, directiveName)
+	}
+	lines[0].Params = append([]string{}, newParams...)
+	config.Dirty = true
+
+	return nil
+}
+
+// Clone returns a deep copy of the configuration
+func (config *Config) Clone() *Config {
+	clone := &Config{
+		RootBlock:  config.RootBlock.Clone(),
+		FilePath:   config.FilePath,
+		RawTrailer: config.RawTrailer,
+		Lossless:   config.Lossless,
+		Dirty:      config.Dirty,
+	}
+	if config.Comments != nil {
+		clone.Comments = make(map[int]string, len(config.Comments))
+		for lineNumber, comment := range config.Comments {
+			clone.Comments[lineNumber] = comment
+		}
+	}
+	for _, plugin := range config.plugins {
+		clone.RegisterPlugin(plugin)
+	}
+	return clone
+}
+
+// Clone returns a deep copy of the block and its children, detached from any parent
+func (block *Block) Clone() *Block {
+	clone := &Block{
+		Name:       block.Name,
+		Params:     append([]string{}, block.Params...),
+		Lines:      make([]*Line, 0, len(block.Lines)),
+		Comments:   append([]string(nil), block.Comments...),
+		Pragmas:    append([]Pragma(nil), block.Pragmas...),
+		LineNumber: block.LineNumber,
+
+		ClosingComments: append([]string(nil), block.ClosingComments...),
+		EndLineNumber:   block.EndLineNumber,
+		RawClosing:      block.RawClosing,
+		Verbatim:        block.Verbatim,
+		parsedClosing:   block.parsedClosing,
+		templated:       block.templated,
+	}
+
+	for _, line := range block.Lines {
+		lineClone := &Line{
+			Name:       line.Name,
+			Params:     append([]string{}, line.Params...),
+			Comments:   append([]string(nil), line.Comments...),
+			Pragmas:    append([]Pragma(nil), line.Pragmas...),
+			Type:       line.Type,
+			LineNumber: line.LineNumber,
+			Raw:        line.Raw,
+			Offset:     line.Offset,
+			parsedText: line.parsedText,
+			plugin:     line.plugin,
+			templated:  line.templated,
+		}
+		if line.BlockRef != nil {
+			lineClone.BlockRef = line.BlockRef.Clone()
+			lineClone.BlockRef.ParentRef = clone
+			lineClone.Params = lineClone.BlockRef.Params
+			lineClone.Comments = lineClone.BlockRef.Comments
+			lineClone.Pragmas = lineClone.BlockRef.Pragmas
+		}
+		clone.Lines = append(clone.Lines, lineClone)
+	}
+	clone.syncBlocks()
+
+	return clone
+}
+
+// ApplyToMatching runs apply on every block in the tree for which pred returns true.
+// Matching blocks are collected before any mutation runs, so blocks added by apply
+// are not visited. The configuration is marked Dirty when any block matches, as
+// apply is expected to change the blocks it runs on
+func (config *Config) ApplyToMatching(pred func(*Block) bool, apply func(*Block)) {
+	var matches []*Block
+	config.WalkBlocks(func(block *Block) {
+		if pred(block) {
+			matches = append(matches, block)
+		}
+	})
+
+	for _, block := range matches {
+		apply(block)
+	}
+	if len(matches) > 0 {
+		config.Dirty = true
+	}
+}
@@ -0,0 +1,113 @@
+package nginx
+
+import "fmt"
+
+// namedLocationReferrers lists directives whose last parameter may name a location (@name)
+var namedLocationReferrers = map[string]bool{
+	"try_files":   true,
+	"error_page":  true,
+	"post_action": true,
+}
+
+// NamedLocationReference is a directive that redirects internally to a named location
+type NamedLocationReference struct {
+	Name   string // Referenced name without the @
+	Line   *Line  // Referencing directive
+	Block  *Block // Block holding the directive
+	Server *Block // Server the name resolves in, nil outside a server
+}
+
+// NamedLocations maps the names of the named locations of a server (without the @)
+// to their locations. nginx only allows named locations at the server level
+func (block *Block) NamedLocations() map[string]*Location {
+	named := map[string]*Location{}
+	for _, location := range block.Locations() {
+		if location.IsNamed() {
+			if _, exists := named[location.Pattern]; !exists {
+				named[location.Pattern] = location
+			}
+		}
+	}
+	return named
+}
+
+// ResolveNamedLocation finds the named location a directive in block refers to by
+// name, looking in the enclosing server. Returns nil if it is not defined
+func (block *Block) ResolveNamedLocation(name string) *Location {
+	server := block
+	if server.Name != "server" {
+		server = block.Ancestor("server")
+	}
+	if server == nil {
+		return nil
+	}
+	return server.NamedLocations()[name]
+}
+
+// NamedLocationReferences returns every try_files, error_page and post_action
+// directive that redirects to a named location
+func (config *Config) NamedLocationReferences() []NamedLocationReference {
+	var references []NamedLocationReference
+	config.WalkBlocks(func(block *Block) {
+		for _, line := range block.Lines {
+			if line.Type != LineTypeDirective || !namedLocationReferrers[line.Name] {
+				continue
+			}
+			if name, ok := namedLocationTarget(line); ok {
+				server := block
+				if server.Name != "server" {
+					server = block.Ancestor("server")
+				}
+				references = append(references, NamedLocationReference{Name: name, Line: line, Block: block, Server: server})
+			}
+		}
+	})
+	return references
+}
+
+// ValidateNamedLocations reports references to named locations the server does not
+// define as errors, and named locations nothing refers to as info
+func (config *Config) ValidateNamedLocations() []ValidationIssue {
+	var issues []ValidationIssue
+	referenced := map[*Block]bool{}
+
+	for _, reference := range config.NamedLocationReferences() {
+		location := reference.Block.ResolveNamedLocation(reference.Name)
+		if location == nil {
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityError,
+				Directive:  reference.Line.Name,
+				Message:    fmt.Sprintf("named location \"@%s\" is not defined in this server", reference.Name),
+				LineNumber: reference.Line.LineNumber,
+			})
+			continue
+		}
+		referenced[location.Block] = true
+	}
+
+	for _, block := range config.FindBlocksByName("location") {
+		location := NewLocation(block)
+		if location != nil && location.IsNamed() && !referenced[block] {
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityInfo,
+				Directive:  block.Name,
+				Message:    fmt.Sprintf("named location \"@%s\" is never referenced", location.Pattern),
+				LineNumber: block.LineNumber,
+			})
+		}
+	}
+
+	return issues
+}
+
+// namedLocationTarget returns the name a directive redirects to when its last parameter is @name
+func namedLocationTarget(line *Line) (string, bool) {
+	if len(line.Params) == 0 {
+		return "", false
+	}
+	target := unquote(line.Params[len(line.Params)-1])
+	if len(target) < 2 || target[0] != '@' {
+		return "", false
+	}
+	return target[1:], true
+}
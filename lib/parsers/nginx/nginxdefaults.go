@@ -0,0 +1,216 @@
+package nginx
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/blang/semver/v4"
+)
+
+// directiveDefault is the value nginx uses for a directive left unset, and the
+// outermost context it may be written in
+type directiveDefault struct {
+	Directive string
+	Context   string // ContextMain, or the top level block: events, http or stream
+	Value     string // Default parameters, empty to take them from defaultHistory
+}
+
+// directiveDefaults lists the defaults ApplyNginxDefaults writes out. Defaults that
+// depend on build options or the platform (pid, error_log, the hash bucket sizes)
+// are left out
+var directiveDefaults = []directiveDefault{
+	{Directive: "worker_processes", Context: ContextMain, Value: "1"},
+	{Directive: "master_process", Context: ContextMain, Value: "on"},
+	{Directive: "daemon", Context: ContextMain, Value: "on"},
+	{Directive: "pcre_jit", Context: ContextMain, Value: "off"},
+
+	{Directive: "worker_connections", Context: "events", Value: "512"},
+	{Directive: "multi_accept", Context: "events", Value: "off"},
+
+	{Directive: "default_type", Context: "http", Value: "text/plain"},
+	{Directive: "root", Context: "http", Value: "html"},
+	{Directive: "index", Context: "http", Value: "index.html"},
+	{Directive: "autoindex", Context: "http", Value: "off"},
+	{Directive: "sendfile", Context: "http", Value: "off"},
+	{Directive: "sendfile_max_chunk", Context: "http"},
+	{Directive: "tcp_nopush", Context: "http", Value: "off"},
+	{Directive: "tcp_nodelay", Context: "http", Value: "on"},
+	{Directive: "keepalive_timeout", Context: "http", Value: "75s"},
+	{Directive: "keepalive_requests", Context: "http"},
+	{Directive: "keepalive_time", Context: "http"},
+	{Directive: "send_timeout", Context: "http", Value: "60s"},
+	{Directive: "client_header_timeout", Context: "http", Value: "60s"},
+	{Directive: "client_body_timeout", Context: "http", Value: "60s"},
+	{Directive: "client_max_body_size", Context: "http", Value: "1m"},
+	{Directive: "client_header_buffer_size", Context: "http", Value: "1k"},
+	{Directive: "large_client_header_buffers", Context: "http", Value: "4 8k"},
+	{Directive: "server_tokens", Context: "http", Value: "on"},
+	{Directive: "server_name_in_redirect", Context: "http", Value: "off"},
+	{Directive: "port_in_redirect", Context: "http", Value: "on"},
+	{Directive: "absolute_redirect", Context: "http", Value: "on"},
+	{Directive: "merge_slashes", Context: "http", Value: "on"},
+	{Directive: "underscores_in_headers", Context: "http", Value: "off"},
+	{Directive: "ignore_invalid_headers", Context: "http", Value: "on"},
+	{Directive: "log_not_found", Context: "http", Value: "on"},
+	{Directive: "etag", Context: "http", Value: "on"},
+	{Directive: "if_modified_since", Context: "http", Value: "exact"},
+	{Directive: "chunked_transfer_encoding", Context: "http", Value: "on"},
+	{Directive: "lingering_close", Context: "http", Value: "on"},
+	{Directive: "lingering_time", Context: "http", Value: "30s"},
+	{Directive: "lingering_timeout", Context: "http", Value: "5s"},
+	{Directive: "reset_timedout_connection", Context: "http", Value: "off"},
+	{Directive: "resolver_timeout", Context: "http", Value: "30s"},
+	{Directive: "postpone_output", Context: "http", Value: "1460"},
+	{Directive: "gzip", Context: "http", Value: "off"},
+	{Directive: "gzip_comp_level", Context: "http", Value: "1"},
+	{Directive: "gzip_min_length", Context: "http", Value: "20"},
+	{Directive: "gzip_types", Context: "http", Value: "text/html"},
+	{Directive: "proxy_http_version", Context: "http", Value: "1.0"},
+	{Directive: "proxy_connect_timeout", Context: "http", Value: "60s"},
+	{Directive: "proxy_send_timeout", Context: "http", Value: "60s"},
+	{Directive: "proxy_read_timeout", Context: "http", Value: "60s"},
+	{Directive: "proxy_buffering", Context: "http", Value: "on"},
+	{Directive: "proxy_request_buffering", Context: "http", Value: "on"},
+	{Directive: "proxy_redirect", Context: "http", Value: "default"},
+	{Directive: "proxy_ssl_protocols", Context: "http"},
+	{Directive: "ssl_protocols", Context: "http"},
+	{Directive: "ssl_ciphers", Context: "http"},
+	{Directive: "ssl_ecdh_curve", Context: "http"},
+	{Directive: "ssl_prefer_server_ciphers", Context: "http", Value: "off"},
+	{Directive: "ssl_session_timeout", Context: "http", Value: "5m"},
+
+	{Directive: "proxy_connect_timeout", Context: "stream", Value: "60s"},
+	{Directive: "proxy_timeout", Context: "stream", Value: "10m"},
+	{Directive: "preread_timeout", Context: "stream", Value: "30s"},
+	{Directive: "preread_buffer_size", Context: "stream", Value: "16k"},
+	{Directive: "ssl_preread", Context: "stream", Value: "off"},
+	{Directive: "tcp_nodelay", Context: "stream", Value: "on"},
+}
+
+// foreignDirectiveBlocks hold directives of their own or entries, not the
+// directives of their context
+var foreignDirectiveBlocks = map[string]bool{
+	"upstream":      true,
+	"map":           true,
+	"geo":           true,
+	"types":         true,
+	"split_clients": true,
+	"charset_map":   true,
+	"match":         true,
+}
+
+// nginxDefaults returns the defaults in effect in an nginx release, leaving out
+// directives the release does not have yet
+func nginxDefaults(version semver.Version) []directiveDefault {
+	release := []int{int(version.Major), int(version.Minor), int(version.Patch)}
+
+	var defaults []directiveDefault
+	for _, entry := range directiveDefaults {
+		if entry.Value == "" {
+			if entry.Value, _ = defaultAt(entry.Directive, release); entry.Value == "" {
+				continue
+			}
+		}
+		defaults = append(defaults, entry)
+	}
+	return defaults
+}
+
+// ApplyNginxDefaults returns a copy of the configuration with the default of every
+// known directive written out in its outermost context (main, events, http or
+// stream) when that block does not set it, making inherited values explicit.
+// version is the nginx release whose defaults apply; semver.ParseTolerant reads
+// release numbers such as "1.26". The configuration itself is left unchanged,
+// contexts it lacks are not added
+func (config *Config) ApplyNginxDefaults(version semver.Version) *Config {
+	clone := config.Clone()
+	for _, entry := range nginxDefaults(version) {
+		for _, block := range defaultContextBlocks(clone, entry.Context) {
+			if len(block.FindLines(entry.Directive)) == 0 {
+				block.SetDirective(entry.Directive, strings.Fields(entry.Value)...)
+				clone.Dirty = true
+			}
+		}
+	}
+	return clone
+}
+
+// defaultContextBlocks returns the blocks of a configuration standing for a
+// top level context, the root block for ContextMain
+func defaultContextBlocks(config *Config, context string) []*Block {
+	if context == ContextMain {
+		// Files without a top level context are snippets included elsewhere
+		for _, name := range []string{"events", "http", "stream", "mail"} {
+			if len(config.RootBlock.FindBlocks(name)) > 0 {
+				return []*Block{config.RootBlock}
+			}
+		}
+		return nil
+	}
+	return config.RootBlock.FindBlocks(context)
+}
+
+// StripNginxDefaults removes the directives setting the value nginx uses anyway:
+// those whose parameters are exactly the default of the release version and which
+// inherit that default once removed, so a location repeating the default to undo
+// a value of its server is kept. version is the nginx release whose defaults
+// apply, as for ApplyNginxDefaults; the zero version is rejected rather than
+// taken as a release predating every default. Returns the number of directives
+// removed
+func (config *Config) StripNginxDefaults(version semver.Version) (int, error) {
+	if version.Equals(semver.Version{}) {
+		return 0, errors.New("nginx version is required")
+	}
+	defaults := nginxDefaults(version)
+
+	removed := 0
+	config.WalkBlocks(func(block *Block) {
+		for _, entry := range defaults {
+			if !inDefaultContext(block, entry.Context) {
+				continue
+			}
+			lines := block.FindLines(entry.Directive)
+			if len(lines) != 1 || !paramsEqual(lines[0].Params, strings.Fields(entry.Value)) {
+				continue
+			}
+			if block.ParentRef != nil {
+				if inherited := block.ParentRef.cacheValue(entry.Directive, entry.Value); inherited.Line != nil && !paramsEqual(inherited.Line.Params, strings.Fields(entry.Value)) {
+					continue
+				}
+			}
+			block.RemoveLine(lines[0])
+			removed++
+		}
+	})
+	if removed > 0 {
+		config.Dirty = true
+	}
+	return removed, nil
+}
+
+// inDefaultContext reports whether a block is the top level context of a default
+// or nested in it, outside blocks whose directives mean something else
+func inDefaultContext(block *Block, context string) bool {
+	for current := block; current != nil; current = current.ParentRef {
+		if foreignDirectiveBlocks[current.Name] {
+			return false
+		}
+	}
+	if context == ContextMain {
+		return block.ParentRef == nil
+	}
+	return block.Name == context || block.Ancestor(context) != nil
+}
+
+// paramsEqual reports whether directive parameters match values once unquoted
+func paramsEqual(params, values []string) bool {
+	if len(params) != len(values) {
+		return false
+	}
+	for i, param := range params {
+		if unquote(param) != values[i] {
+			return false
+		}
+	}
+	return true
+}
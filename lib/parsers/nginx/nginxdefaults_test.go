@@ -0,0 +1,90 @@
+package nginx
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/blang/semver/v4"
+)
+
+func TestApplyNginxDefaults(t *testing.T) {
+	input := "events {\n    worker_connections 1024;\n}\nhttp {\n    server {\n        listen 80;\n    }\n}\n"
+	tests := []struct {
+		version           string
+		keepaliveRequests string
+		keepaliveTime     bool
+	}{
+		{"1.18.0", "100", false},
+		{"1.26", "1000", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			config, err := ParseReader(strings.NewReader(input), "nginx.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := config.String()
+			version, err := semver.ParseTolerant(tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defaulted := config.ApplyNginxDefaults(version)
+
+			if config.String() != before {
+				t.Fatal("ApplyNginxDefaults changed the original configuration")
+			}
+			http := defaulted.RootBlock.FindBlocks("http")[0]
+			if lines := http.FindLines("keepalive_requests"); len(lines) != 1 || lines[0].Params[0] != tt.keepaliveRequests {
+				t.Errorf("keepalive_requests = %v, want %s", lines, tt.keepaliveRequests)
+			}
+			if got := len(http.FindLines("keepalive_time")) == 1; got != tt.keepaliveTime {
+				t.Errorf("keepalive_time written = %v, want %v", got, tt.keepaliveTime)
+			}
+			events := defaulted.RootBlock.FindBlocks("events")[0]
+			if lines := events.FindLines("worker_connections"); len(lines) != 1 || lines[0].Params[0] != "1024" {
+				t.Errorf("worker_connections = %v, want the configured 1024", lines)
+			}
+			if len(defaulted.RootBlock.FindLines("worker_processes")) != 1 {
+				t.Error("worker_processes default missing from the main context")
+			}
+		})
+	}
+}
+
+func TestStripNginxDefaults(t *testing.T) {
+	input := "http {\n    keepalive_requests 1000;\n    server {\n        listen 80;\n    }\n}\n"
+	tests := []struct {
+		version string
+		removed int
+	}{
+		{"1.18.0", 0},
+		{"1.26.0", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			config, err := ParseReader(strings.NewReader(input), "nginx.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			removed, err := config.StripNginxDefaults(semver.MustParse(tt.version))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if removed != tt.removed {
+				t.Errorf("removed %d directives, want %d", removed, tt.removed)
+			}
+		})
+	}
+
+	t.Run("zero version", func(t *testing.T) {
+		config, err := ParseReader(strings.NewReader(input), "nginx.conf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := config.StripNginxDefaults(semver.Version{}); err == nil {
+			t.Fatal("expected an error for the zero version")
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package nginx
+
+// Contexts of the OpenResty directives. Context names are not qualified by their
+// parent, so directives allowed in stream server blocks are allowed in every server
+var (
+	openRestyMain     = []string{"http", "stream"}
+	openRestyHTTP     = []string{"http"}
+	openRestyServer   = []string{"http", "server"}
+	openRestyPhase    = []string{"http", "server", "location", "if"}
+	openRestyContent  = []string{"server", "location", "if"}
+	openRestySet      = []string{"server", "location", "if"}
+	openRestySocket   = []string{"http", "server", "location"}
+	openRestyUpstream = []string{"upstream"}
+)
+
+// luaHandlers maps Lua phase handlers to their contexts and whether they accept the
+// legacy string form (e.g. access_by_lua) next to the _block and _file forms
+var luaHandlers = []struct {
+	phase    string
+	contexts []string
+	legacy   bool
+}{
+	{"init", openRestyMain, true},
+	{"init_worker", openRestyMain, true},
+	{"exit_worker", openRestyHTTP, false},
+	{"server_rewrite", openRestyServer, false},
+	{"rewrite", openRestyPhase, true},
+	{"access", openRestyPhase, true},
+	{"precontent", openRestyPhase, false},
+	{"content", openRestyContent, true},
+	{"header_filter", openRestyPhase, true},
+	{"body_filter", openRestyPhase, true},
+	{"log", openRestyPhase, true},
+	{"balancer", openRestyUpstream, false},
+	{"preread", []string{"server"}, false},
+	{"ssl_client_hello", openRestyServer, false},
+	{"ssl_certificate", []string{"server"}, false},
+	{"ssl_session_fetch", openRestyHTTP, false},
+	{"ssl_session_store", openRestyHTTP, false},
+}
+
+// RegisterOpenResty adds the directives of lua-nginx-module, stream-lua-nginx-module and
+// the modules bundled with OpenResty (headers-more, set-misc, echo) to the registry
+func RegisterOpenResty(registry *DirectiveRegistry) {
+	spec := func(name string, contexts []string, minArgs, maxArgs int) DirectiveSpec {
+		return DirectiveSpec{Name: name, Module: "lua", Contexts: contexts, MinArgs: minArgs, MaxArgs: maxArgs}
+	}
+
+	for _, handler := range luaHandlers {
+		prefix := handler.phase + "_by_lua"
+		if handler.legacy {
+			registry.Register(spec(prefix, handler.contexts, 1, 1))
+		}
+		block := spec(prefix+"_block", handler.contexts, 1, 1)
+		block.IsBlock = true
+		file := spec(prefix+"_file", handler.contexts, 1, 1)
+		file.FileArg = 1
+		registry.Register(block, file)
+	}
+
+	// set_by_lua assigns the result of the code to its first argument
+	setBlock := spec("set_by_lua_block", openRestySet, 2, 2)
+	setBlock.IsBlock = true
+	setFile := spec("set_by_lua_file", openRestySet, 2, Unlimited)
+	setFile.FileArg = 2
+	registry.Register(spec("set_by_lua", openRestySet, 2, Unlimited), setBlock, setFile)
+
+	for _, name := range []string{
+		"lua_load_resty_core", "lua_capture_error_log", "lua_malloc_trim", "lua_sa_restart",
+		"lua_thread_cache_max_entries", "lua_regex_cache_max_entries", "lua_regex_match_limit",
+		"lua_max_pending_timers", "lua_max_running_timers", "lua_worker_thread_vm_pool_size",
+		"rewrite_by_lua_no_postpone", "access_by_lua_no_postpone",
+	} {
+		registry.Register(spec(name, openRestyHTTP, 1, 1))
+	}
+	for _, name := range []string{"lua_package_path", "lua_package_cpath"} {
+		registry.Register(spec(name, openRestyMain, 1, 1))
+	}
+	registry.Register(
+		spec("lua_shared_dict", openRestyMain, 2, 2),
+		spec("lua_add_variable", openRestyHTTP, 1, 1),
+	)
+	for _, name := range []string{
+		"lua_code_cache", "lua_need_request_body", "lua_use_default_type", "lua_http10_buffering",
+		"lua_transform_underscores_in_response_headers", "lua_check_client_abort",
+	} {
+		registry.Register(spec(name, openRestyPhase, 1, 1))
+	}
+
+	// Cosocket settings
+	for _, name := range []string{
+		"lua_socket_connect_timeout", "lua_socket_send_timeout", "lua_socket_send_lowat",
+		"lua_socket_read_timeout", "lua_socket_buffer_size", "lua_socket_pool_size",
+		"lua_socket_keepalive_timeout", "lua_socket_log_errors",
+		"lua_ssl_ciphers", "lua_ssl_verify_depth", "lua_ssl_certificate", "lua_ssl_certificate_key",
+	} {
+		registry.Register(spec(name, openRestySocket, 1, 1))
+	}
+	trusted := spec("lua_ssl_trusted_certificate", openRestySocket, 1, 1)
+	trusted.FileArg = 1
+	crl := spec("lua_ssl_crl", openRestySocket, 1, 1)
+	crl.FileArg = 1
+	registry.Register(
+		trusted, crl,
+		spec("lua_ssl_protocols", openRestySocket, 1, Unlimited),
+		spec("lua_ssl_conf_command", openRestySocket, 2, 2),
+	)
+
+	registerOpenRestyBundled(registry)
+}
+
+// registerOpenRestyBundled adds the directives of the non-Lua modules OpenResty ships with
+func registerOpenRestyBundled(registry *DirectiveRegistry) {
+	spec := func(module, name string, contexts []string, minArgs, maxArgs int) DirectiveSpec {
+		return DirectiveSpec{Name: name, Module: module, Contexts: contexts, MinArgs: minArgs, MaxArgs: maxArgs}
+	}
+
+	for _, name := range []string{"more_set_headers", "more_clear_headers", "more_set_input_headers", "more_clear_input_headers"} {
+		registry.Register(spec("headers_more", name, openRestyPhase, 1, Unlimited))
+	}
+
+	for _, name := range []string{
+		"set_escape_uri", "set_unescape_uri", "set_quote_sql_str", "set_quote_pgsql_str",
+		"set_quote_json_str", "set_md5", "set_sha1", "set_encode_base32", "set_decode_base32",
+		"set_encode_base64", "set_decode_base64", "set_encode_hex", "set_decode_hex",
+	} {
+		registry.Register(spec("set_misc", name, openRestySet, 1, 2))
+	}
+	registry.Register(
+		spec("set_misc", "set_if_empty", openRestySet, 2, 2),
+		spec("set_misc", "set_random", openRestySet, 3, 3),
+		spec("set_misc", "set_secure_random_alphanum", openRestySet, 2, 2),
+		spec("set_misc", "set_secure_random_lcalpha", openRestySet, 2, 2),
+		spec("set_misc", "set_hmac_sha1", openRestySet, 3, 3),
+		spec("set_misc", "set_hmac_sha256", openRestySet, 3, 3),
+		spec("set_misc", "set_local_today", openRestySet, 1, 1),
+		spec("set_misc", "set_formatted_gmt_time", openRestySet, 2, 2),
+		spec("set_misc", "set_formatted_local_time", openRestySet, 2, 2),
+	)
+
+	echo := []string{"location", "if"}
+	registry.Register(
+		spec("echo", "echo", echo, 0, Unlimited),
+		spec("echo", "echo_duplicate", echo, 2, 2),
+		spec("echo", "echo_flush", echo, 0, 0),
+		spec("echo", "echo_sleep", echo, 1, 1),
+		spec("echo", "echo_blocking_sleep", echo, 1, 1),
+		spec("echo", "echo_reset_timer", echo, 0, 0),
+		spec("echo", "echo_read_request_body", echo, 0, 0),
+		spec("echo", "echo_location", echo, 1, 2),
+		spec("echo", "echo_location_async", echo, 1, 2),
+		spec("echo", "echo_exec", echo, 1, 2),
+		spec("echo", "echo_status", echo, 1, 1),
+		spec("echo", "echo_before_body", echo, 0, Unlimited),
+		spec("echo", "echo_after_body", echo, 0, Unlimited),
+	)
+}
@@ -0,0 +1,45 @@
+package nginx
+
+// Default resource limits applied when ParseOptions leaves them unset
+const (
+	DefaultMaxDepth      = 64          // nginx configurations rarely nest deeper than a handful of levels
+	DefaultMaxLineLength = 1024 * 1024 // Long log_format and map lines stay well below this
+)
+
+// ParseOptions controls parser behaviour and the resources it may consume
+type ParseOptions struct {
+	MaxDepth      int // Maximum block nesting depth, DefaultMaxDepth if zero
+	MaxLineLength int // Maximum length of a single line in bytes, DefaultMaxLineLength if zero
+
+	// Lossless records byte offsets and the parsed state of every line so that
+	// WriteConfig reproduces the input byte for byte, regenerating only lines that
+	// were changed, added or moved. Quoting, line endings and blank lines of
+	// unchanged lines are kept as written
+	Lossless bool
+
+	// Plugins parse and serialize the directives named like them, see
+	// Config.RegisterPlugin. A directive a plugin rejects fails the parse
+	Plugins []DirectivePlugin
+
+	// SeparateComments moves every comment out of the tree into Config.Comments,
+	// keyed by line number, so that the tree only holds directives and blocks.
+	// WriteConfig then writes the configuration without its comments
+	SeparateComments bool
+
+	// TemplateDelimiters, e.g. {"{{", "}}"} for consul-template and confd, make the
+	// spans between them opaque: they are kept verbatim within the parameter or
+	// name holding them, and a span standing alone on its line is a line of type
+	// LineTypeTemplate. Spans may cover several lines. None if either is empty
+	TemplateDelimiters [2]string
+}
+
+// withDefaults returns the options with unset limits replaced by their defaults
+func (opts ParseOptions) withDefaults() ParseOptions {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = DefaultMaxDepth
+	}
+	if opts.MaxLineLength <= 0 {
+		opts.MaxLineLength = DefaultMaxLineLength
+	}
+	return opts
+}
@@ -0,0 +1,81 @@
+package nginx
+
+import "strings"
+
+// KeyValueParams returns the key=value style parameters of the line as a map
+// (e.g. weight=3 max_fails=2 in an upstream server directive)
+func (line *Line) KeyValueParams() map[string]string {
+	values := map[string]string{}
+	for _, param := range line.Params {
+		if key, value, ok := splitKeyValue(param); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// PositionalParams returns the parameters of the line that are not key=value options
+func (line *Line) PositionalParams() []string {
+	params := []string{}
+	for _, param := range line.Params {
+		if _, _, ok := splitKeyValue(param); !ok {
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
+// splitKeyValue splits a key=value parameter, rejecting quoted values and
+// forms like =404 or = that are not options
+func splitKeyValue(param string) (string, string, bool) {
+	if strings.HasPrefix(param, `"`) || strings.HasPrefix(param, "'") || strings.HasPrefix(param, "$") {
+		return "", "", false
+	}
+	key, value, found := strings.Cut(param, "=")
+	if !found || key == "" {
+		return "", "", false
+	}
+	return key, value, true
+}
+
+// unquote strips a single pair of matching surrounding quotes from a parameter
+func unquote(param string) string {
+	if len(param) >= 2 && (param[0] == '"' || param[0] == '\'') && param[len(param)-1] == param[0] {
+		return param[1 : len(param)-1]
+	}
+	return param
+}
+
+// unescape returns the value nginx reads from a parameter: surrounding quotes are
+// stripped and \", \', \\, \t, \r and \n are replaced by the characters they stand
+// for. Other backslashes are kept, as in regular expressions
+func unescape(param string) string {
+	value := unquote(param)
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+
+	var result strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			switch value[i+1] {
+			case '"', '\'', '\\':
+				i++
+			case 't':
+				result.WriteByte('\t')
+				i++
+				continue
+			case 'r':
+				result.WriteByte('\r')
+				i++
+				continue
+			case 'n':
+				result.WriteByte('\n')
+				i++
+				continue
+			}
+		}
+		result.WriteByte(value[i])
+	}
+	return result.String()
+}
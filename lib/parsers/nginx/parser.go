@@ -2,7 +2,9 @@ package nginx
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
@@ -15,40 +17,84 @@ const (
 	LineTypeInclude   LineType = "include"   // Include directive
 	LineTypeDirective LineType = "directive" // Regular directive
 	LineTypeBlock     LineType = "block"     // Starts a new block
+	LineTypeTemplate  LineType = "template"  // Template span standing alone, held in Name, see ParseOptions.TemplateDelimiters
 )
 
 // Line represents a single line in the nginx configuration
 type Line struct {
-	Name     string   // Name of the directive
-	Params   []string // Parameters for the directive
-	Comments []string // Comments associated with this line
-	Type     LineType // Type of the line
+	Name       string   // Name of the directive
+	Params     []string // Parameters for the directive
+	Comments   []string // Comments associated with this line
+	Pragmas    []Pragma // ngonx: pragmas applying to this line, from its comment or the comment lines above it
+	Type       LineType // Type of the line
+	LineNumber int      // Line number in the source file (1-based)
+	BlockRef   *Block   // Block opened by this line, nil unless Type is LineTypeBlock
+	Raw        string   // Source text of the physical line this line starts on, including preceding blank lines and line terminators. Empty for further statements on the same physical line
+	Offset     int      // Byte offset of Raw in the source, recorded in lossless mode
+
+	parsedText string          // Canonical text of the line (and statements sharing its physical line) when parsed losslessly
+	plugin     DirectivePlugin // Plugin that parsed the directive and serializes it, nil for plain directives
+	templated  bool            // Whether the name or parameters hold template spans, written as is
 }
 
 // Block represents a configuration block in nginx
 type Block struct {
-	Name      string   // Name of the block (e.g., "server", "http")
-	Params    []string // Parameters for the block (e.g., "example.com" in "server example.com")
-	Lines     []*Line  // Lines directly in this block
-	Blocks    []*Block // Child blocks
-	Comments  []string // Comments associated with this block definition
-	ParentRef *Block   // Reference to parent block, nil for root
+	Name       string   // Name of the block (e.g., "server", "http")
+	Params     []string // Parameters for the block (e.g., "example.com" in "server example.com")
+	Lines      []*Line  // Lines directly in this block
+	Blocks     []*Block // Child blocks
+	Comments   []string // Comments associated with this block definition
+	Pragmas    []Pragma // ngonx: pragmas applying to this block and everything in it
+	ParentRef  *Block   // Reference to parent block, nil for root
+	LineNumber int      // Line number of the block definition in the source file (1-based)
+
+	ClosingComments []string // Comments on or directly after the closing brace (e.g. "} # end server")
+	EndLineNumber   int      // Line number of the closing brace in the source file (1-based)
+	RawClosing      string   // Source text of the closing brace line(s), see Line.Raw
+	Verbatim        string   // Unparsed body of blocks holding foreign code (e.g. content_by_lua_block)
+
+	parsedClosing string // Canonical text of the closing brace (and statements sharing its physical line) when parsed losslessly
+	templated     bool   // Whether the name or parameters hold template spans, written as is
 }
 
 // Config represents the entire nginx configuration
 type Config struct {
-	RootBlock *Block // Root block of the configuration
-	FilePath  string // Path to the configuration file
+	RootBlock  *Block // Root block of the configuration
+	FilePath   string // Path to the configuration file
+	RawTrailer string // Source text after the last parsed statement (trailing blank lines)
+	Lossless   bool   // Whether WriteConfig keeps the source text of unchanged lines, see ParseOptions.Lossless
+	Dirty      bool   // Whether a Config method changed the configuration since it was parsed, edits made on blocks directly are not tracked
+
+	Comments map[int]string // Comment text by line number when parsed with ParseOptions.SeparateComments
+
+	plugins map[string]DirectivePlugin // Registered directive plugins by directive name
 }
 
 // ParseConfig parses the nginx configuration file
 func ParseConfig(filePath string) (*Config, error) {
+	return ParseConfigWithOptions(filePath, ParseOptions{})
+}
+
+// ParseConfigWithOptions parses the nginx configuration file using the given options
+func ParseConfigWithOptions(filePath string, opts ParseOptions) (*Config, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
+	return ParseReaderWithOptions(file, filePath, opts)
+}
+
+// ParseReader parses nginx configuration read from r, recording filePath as its origin
+func ParseReader(r io.Reader, filePath string) (*Config, error) {
+	return ParseReaderWithOptions(r, filePath, ParseOptions{})
+}
+
+// ParseReaderWithOptions parses nginx configuration read from r using the given options
+func ParseReaderWithOptions(r io.Reader, filePath string, opts ParseOptions) (*Config, error) {
+	opts = opts.withDefaults()
+
 	rootBlock := &Block{
 		Name:   "root",
 		Params: []string{},
@@ -61,75 +107,268 @@ func ParseConfig(filePath string) (*Config, error) {
 		FilePath:  filePath,
 	}
 
-	scanner := bufio.NewScanner(file)
+	rawTrailer, err := parseStatements(r, filePath, opts, rootBlock, nil)
+	if err != nil {
+		return nil, err
+	}
+	config.RawTrailer = rawTrailer
+	attachPragmas(rootBlock)
+
+	if opts.SeparateComments {
+		config.Comments = separateComments(rootBlock)
+	}
+
+	for _, plugin := range opts.Plugins {
+		if err := applyPlugin(rootBlock, plugin); err != nil {
+			return nil, fmt.Errorf("%s:%v", filePath, err)
+		}
+		config.RegisterPlugin(plugin)
+	}
+
+	if opts.Lossless {
+		config.Lossless = true
+		recordParsedState(rootBlock)
+	}
+
+	return config, nil
+}
+
+// ParseFragment parses a snippet that is not a complete configuration, such as the
+// body of a location or an include snippet, into a synthetic block named "fragment".
+// The snippet may be cut from a larger file: closing braces of blocks opened before
+// it are skipped and blocks still open at its end are kept, with EndLineNumber 0
+func ParseFragment(r io.Reader) (*Block, error) {
+	block := &Block{
+		Name:   "fragment",
+		Params: []string{},
+		Lines:  []*Line{},
+		Blocks: []*Block{},
+	}
+
+	if _, err := parseStatements(r, "fragment", ParseOptions{}.withDefaults(), block, nil); err != nil {
+		return nil, err
+	}
+	attachPragmas(block)
+
+	return block, nil
+}
+
+// parseStatements parses the statements read from r into rootBlock and returns the
+// source text following the last statement. With a handler, lines and blocks are
+// reported to it instead of being added to their parent (see ParseConfigStream)
+func parseStatements(r io.Reader, filePath string, opts ParseOptions, rootBlock *Block, handler BlockHandler) (string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), opts.MaxLineLength)
+	scanner.Split(scanRawLines)
+	pendingRaw := ""
 	currentBlock := rootBlock
 	blockStack := []*Block{rootBlock}
+	lineNumber := 0
+
+	// Quoted strings and template spans may span lines, the statement is parsed
+	// once they are closed
+	statement := ""
+	statementLine := 0
+	templateOpen := false
+
+	// Bodies of verbatim blocks are collected until their braces balance
+	var verbatimBody []string
+	verbatimDepth := 0
 
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+		lineNumber++
+		pendingRaw += scanner.Text()
+		text := strings.TrimRight(scanner.Text(), "\r\n")
+
+		if verbatimDepth > 0 {
+			body, closed := scanVerbatim(text, &verbatimDepth)
+			verbatimBody = append(verbatimBody, body)
+			if !closed {
+				continue
+			}
+			closeVerbatimBlock(currentBlock, verbatimBody, lineNumber, &pendingRaw, handler)
+			blockStack = blockStack[:len(blockStack)-1]
+			currentBlock = blockStack[len(blockStack)-1]
+
+			// Statements may follow the closing brace on the same line
+			text = trimSpace(text[len(body)+1:])
+			if text == "" {
+				continue
+			}
+		}
+
+		line := trimSpace(text)
+		if statement != "" {
+			line = statement + "\n" + strings.TrimRight(text, " \t")
+		}
 		if line == "" {
 			continue
 		}
 
-		parseLine(line, currentBlock, &blockStack)
+		// Template spans are masked so their content is not tokenized
+		masked, open := maskTemplates(line, opts.TemplateDelimiters)
+		if _, unterminated := scanComment(masked[:verbatimBodyStart(masked)]); unterminated || open {
+			templateOpen = open
+			if statement == "" {
+				statementLine = lineNumber
+			}
+			statement = line
+			if len(statement) > opts.MaxLineLength {
+				return "", fmt.Errorf("%s:%d: statement longer than %d bytes", filePath, statementLine, opts.MaxLineLength)
+			}
+			continue
+		}
+		line = masked
+		startLine := lineNumber
+		if statement != "" {
+			startLine = statementLine
+			statement = ""
+		}
+
+		if err := parseLine(line, startLine, currentBlock, &blockStack, &pendingRaw, opts.MaxDepth, handler); err != nil {
+			return "", fmt.Errorf("%s:%d: %v", filePath, startLine, err)
+		}
 
 		// Update currentBlock to be the last block in the stack
 		if len(blockStack) > 0 {
 			currentBlock = blockStack[len(blockStack)-1]
 		}
+
+		// Start collecting the body of a verbatim block opened on this line, parsing
+		// the statements following it when it closes on the same line
+		remainder := line
+		for verbatimDepth == 0 && currentBlock.EndLineNumber == 0 && isVerbatimBlock(currentBlock.Name) && currentBlock.LineNumber == startLine {
+			remainder = remainder[verbatimBodyStart(remainder):]
+			verbatimDepth = 1
+			body, closed := scanVerbatim(remainder, &verbatimDepth)
+			verbatimBody = []string{body}
+			if !closed {
+				break
+			}
+			closeVerbatimBlock(currentBlock, verbatimBody, lineNumber, &pendingRaw, handler)
+			blockStack = blockStack[:len(blockStack)-1]
+
+			remainder = remainder[len(body)+1:]
+			if err := parseLine(remainder, lineNumber, blockStack[len(blockStack)-1], &blockStack, &pendingRaw, opts.MaxDepth, handler); err != nil {
+				return "", fmt.Errorf("%s:%d: %v", filePath, lineNumber, err)
+			}
+			currentBlock = blockStack[len(blockStack)-1]
+		}
+	}
+
+	if statement != "" && templateOpen {
+		return "", fmt.Errorf("%s:%d: unterminated template span", filePath, statementLine)
+	}
+	if statement != "" {
+		return "", fmt.Errorf("%s:%d: unterminated quoted string", filePath, statementLine)
+	}
+	if verbatimDepth > 0 {
+		return "", fmt.Errorf("%s:%d: unexpected end of file in %s block", filePath, currentBlock.LineNumber, currentBlock.Name)
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		if err == bufio.ErrTooLong {
+			return "", fmt.Errorf("%s:%d: line longer than %d bytes", filePath, lineNumber+1, opts.MaxLineLength)
+		}
+		return "", err
 	}
 
-	return config, nil
+	return pendingRaw, nil
+}
+
+// scanRawLines is a bufio.SplitFunc like bufio.ScanLines that keeps line terminators
+func scanRawLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
 }
 
-// parseLine processes a single line of nginx configuration
-func parseLine(line string, currentBlock *Block, blockStack *[]*Block) {
+// takeRaw returns the pending raw source text and resets it
+func takeRaw(pendingRaw *string) string {
+	raw := *pendingRaw
+	*pendingRaw = ""
+	return raw
+}
+
+// parseLine processes a single line of nginx configuration, failing when it opens
+// blocks nested deeper than maxDepth
+func parseLine(line string, lineNumber int, currentBlock *Block, blockStack *[]*Block, pendingRaw *string, maxDepth int, handler BlockHandler) error {
+	// Code following the opening brace of a verbatim block is collected by the caller
+	line = line[:verbatimBodyStart(line)]
+
 	// Handle comments
-	commentStart := strings.Index(line, "#")
+	commentStart, unterminated := scanComment(line)
+	if unterminated {
+		return fmt.Errorf("unterminated quoted string or trailing escape")
+	}
 	var comments []string
 
 	if commentStart >= 0 {
 		commentText := strings.TrimSpace(line[commentStart+1:])
-		comments = []string{commentText}
-		line = strings.TrimSpace(line[:commentStart])
+		comments = []string{unmaskTemplates(commentText)}
+		line = trimSpace(line[:commentStart])
 	}
 
 	// Skip if it's an empty line after removing comments
 	if line == "" {
+		if len(comments) > 0 && attachClosingComment(currentBlock, comments[0], lineNumber, pendingRaw) {
+			return nil
+		}
 		if len(comments) > 0 {
 			// This is a comment-only line
-			currentBlock.Lines = append(currentBlock.Lines, &Line{
-				Type:     LineTypeComment,
-				Comments: comments,
-			})
+			addLine(currentBlock, &Line{
+				Type:       LineTypeComment,
+				Comments:   comments,
+				LineNumber: lineNumber,
+				Raw:        takeRaw(pendingRaw),
+			}, handler)
 		}
-		return
+		return nil
 	}
 
-	// Handle block end
-	if line == "}" {
-		// Pop the current block from stack
-		if len(*blockStack) > 1 {
-			*blockStack = (*blockStack)[:len(*blockStack)-1]
-		}
-		return
-	}
+	// Split by semicolons and braces to handle multiple statements in one line
+	statements := splitDirectives(line)
 
-	// Split by semicolons to handle multiple directives in one line
-	directives := splitDirectives(line)
+	// A comment after a closing brace belongs to the closed block ("} # end server"),
+	// otherwise to the first statement opening a block or directive
+	var closingComments []string
+	if statements[len(statements)-1] == "}" {
+		closingComments, comments = comments, nil
+	}
 
-	for _, directive := range directives {
-		directive = strings.TrimSpace(directive)
+	for i, directive := range statements {
+		directive = trimSpace(directive)
 		if directive == "" {
 			continue
 		}
+		currentBlock := (*blockStack)[len(*blockStack)-1]
+
+		// Handle block end
+		if directive == "}" {
+			// Pop the current block from stack
+			if len(*blockStack) > 1 {
+				if i == len(statements)-1 {
+					currentBlock.ClosingComments = closingComments
+				}
+				currentBlock.EndLineNumber = lineNumber
+				currentBlock.RawClosing = takeRaw(pendingRaw)
+				*blockStack = (*blockStack)[:len(*blockStack)-1]
+				if handler != nil {
+					handler.OnBlockEnd(currentBlock)
+				}
+			}
+			continue
+		}
 
 		// Parse the directive
-		parts := strings.Fields(directive)
+		parts := splitParams(directive)
 		if len(parts) == 0 {
 			continue
 		}
@@ -142,9 +381,12 @@ func parseLine(line string, currentBlock *Block, blockStack *[]*Block) {
 		}
 
 		// Check if it's a block start
-		if directive[len(directive)-1] == '{' {
-			// This is a block directive
+		if opensBlock(directive) {
+			// This is a block directive, the brace may be attached to the name (server{)
 			blockName := parts[0]
+			if len(parts) == 1 {
+				blockName = strings.TrimSuffix(blockName, "{")
+			}
 			blockParams := []string{}
 
 			// Remove the opening brace from the last param if it's there
@@ -160,66 +402,366 @@ func parseLine(line string, currentBlock *Block, blockStack *[]*Block) {
 				}
 			}
 
+			templated := unmaskStatement(&blockName, blockParams)
+
 			// Create new block
 			newBlock := &Block{
-				Name:      blockName,
-				Params:    blockParams,
-				Lines:     []*Line{},
-				Blocks:    []*Block{},
-				Comments:  comments,
-				ParentRef: currentBlock,
+				Name:       blockName,
+				Params:     blockParams,
+				Lines:      []*Line{},
+				Blocks:     []*Block{},
+				Comments:   comments,
+				ParentRef:  currentBlock,
+				LineNumber: lineNumber,
+				templated:  templated,
 			}
 
-			// Add to parent's blocks
-			currentBlock.Blocks = append(currentBlock.Blocks, newBlock)
-
 			// Push to stack
 			*blockStack = append(*blockStack, newBlock)
+			if len(*blockStack)-1 > maxDepth {
+				return fmt.Errorf("blocks nested deeper than %d levels", maxDepth)
+			}
 
-			// Add block line to parent
-			currentBlock.Lines = append(currentBlock.Lines, &Line{
-				Name:     blockName,
-				Params:   blockParams,
-				Comments: comments,
-				Type:     LineTypeBlock,
-			})
+			blockLine := &Line{
+				Name:       blockName,
+				Params:     blockParams,
+				Comments:   comments,
+				Type:       LineTypeBlock,
+				LineNumber: lineNumber,
+				BlockRef:   newBlock,
+				Raw:        takeRaw(pendingRaw),
+				templated:  templated,
+			}
+			if handler != nil {
+				// Streamed blocks are not kept in their parent
+				handler.OnBlockStart(newBlock)
+			} else {
+				currentBlock.Blocks = append(currentBlock.Blocks, newBlock)
+				currentBlock.Lines = append(currentBlock.Lines, blockLine)
+			}
 
 			// Clear comments as they've been used
 			comments = nil
+
+			// The body of a verbatim block is collected by the caller
+			if isVerbatimBlock(blockName) {
+				return nil
+			}
 		} else {
 			// Regular directive or include
 			lineType := LineTypeDirective
-			if name == "include" {
+			switch {
+			case name == "include":
 				lineType = LineTypeInclude
+			case isTemplateStatement(name) && len(params) == 0:
+				lineType = LineTypeTemplate
 			}
-
-			currentBlock.Lines = append(currentBlock.Lines, &Line{
-				Name:     name,
-				Params:   params,
-				Comments: comments,
-				Type:     lineType,
-			})
+			templated := unmaskStatement(&name, params)
+
+			addLine(currentBlock, &Line{
+				Name:       name,
+				Params:     params,
+				Comments:   comments,
+				Type:       lineType,
+				LineNumber: lineNumber,
+				Raw:        takeRaw(pendingRaw),
+				templated:  templated,
+			}, handler)
 
 			// Clear comments as they've been used
 			comments = nil
 		}
 	}
+
+	return nil
 }
 
-// splitDirectives splits a line by semicolons but respects quotes
+// isVerbatimBlock reports whether a block holds foreign code whose body must not be parsed,
+// such as the Lua blocks of OpenResty or embedded Perl handlers
+func isVerbatimBlock(name string) bool {
+	return strings.HasSuffix(name, "_by_lua_block") || name == "perl"
+}
+
+// verbatimBodyStart returns the position after the unquoted opening brace of the
+// verbatim block opened on the line, or the length of the line if none is opened
+func verbatimBodyStart(line string) int {
+	inQuote := false
+	quoteMark := byte(0)
+	statementStart := 0
+	for i := 0; i < len(line); i++ {
+		switch char := line[i]; {
+		case char == '\\':
+			i++
+		case inQuote:
+			if char == quoteMark {
+				inQuote = false
+			}
+		case (char == '"' || char == '\'') && atTokenStart(line[:i]):
+			inQuote = true
+			quoteMark = char
+		case char == '#' && atTokenStart(line[:i]):
+			return len(line)
+		case char == ';' || char == '}':
+			statementStart = i + 1
+		case char == '{' && i > 0 && line[i-1] == '$':
+			// ${name} variable reference
+		case char == '{':
+			if fields := splitParams(line[statementStart:i]); len(fields) > 0 && isVerbatimBlock(fields[0]) {
+				return i + 1
+			}
+			statementStart = i + 1
+		}
+	}
+	return len(line)
+}
+
+// scanVerbatim consumes a line of a verbatim block body, tracking brace depth
+// outside quoted strings. Returns the body text and whether the block closed on this line
+func scanVerbatim(text string, depth *int) (string, bool) {
+	inQuote := false
+	quoteMark := byte(0)
+	for i := 0; i < len(text); i++ {
+		switch char := text[i]; {
+		case char == '\\':
+			i++
+		case inQuote:
+			if char == quoteMark {
+				inQuote = false
+			}
+		case char == '"' || char == '\'':
+			inQuote = true
+			quoteMark = char
+		case char == '{':
+			*depth++
+		case char == '}':
+			*depth--
+			if *depth == 0 {
+				return text[:i], true
+			}
+		}
+	}
+	return text, false
+}
+
+// closeVerbatimBlock stores the collected body of a verbatim block and records its end
+func closeVerbatimBlock(block *Block, body []string, lineNumber int, pendingRaw *string, handler BlockHandler) {
+	block.Verbatim = unmaskTemplates(strings.Join(body, "\n"))
+	block.EndLineNumber = lineNumber
+	block.RawClosing = takeRaw(pendingRaw)
+	if handler != nil {
+		handler.OnBlockEnd(block)
+	}
+}
+
+// addLine appends a line to a block, or reports it to the handler of a streaming parse
+func addLine(block *Block, line *Line, handler BlockHandler) {
+	if handler != nil {
+		handler.OnLine(block, line)
+		return
+	}
+	block.Lines = append(block.Lines, line)
+}
+
+// splitParams splits a directive into its name and parameters on whitespace,
+// keeping quoted strings (including their quotes) together as one parameter
+func splitParams(directive string) []string {
+	var params []string
+	var current strings.Builder
+	inQuote := false
+	quoteMark := byte(0)
+
+	for i := 0; i < len(directive); i++ {
+		char := directive[i]
+		switch {
+		case char == '\\' && i+1 < len(directive):
+			current.WriteByte(char)
+			current.WriteByte(directive[i+1])
+			i++
+		case inQuote:
+			current.WriteByte(char)
+			if char == quoteMark {
+				inQuote = false
+			}
+		case (char == '"' || char == '\'') && atTokenStart(current.String()):
+			inQuote = true
+			quoteMark = char
+			current.WriteByte(char)
+		case strings.IndexByte(whitespace, char) >= 0:
+			if current.Len() > 0 {
+				params = append(params, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteByte(char)
+		}
+	}
+	if current.Len() > 0 {
+		params = append(params, current.String())
+	}
+
+	return params
+}
+
+// attachClosingComment attaches a comment line directly following a closing
+// brace to the closed block when it is an end marker for it ("# end server")
+func attachClosingComment(currentBlock *Block, comment string, lineNumber int, pendingRaw *string) bool {
+	if len(currentBlock.Lines) == 0 {
+		return false
+	}
+
+	closed := currentBlock.Lines[len(currentBlock.Lines)-1].BlockRef
+	if closed == nil || closed.EndLineNumber != lineNumber-1 || len(closed.ClosingComments) > 0 {
+		return false
+	}
+	if !isEndMarker(comment, closed) {
+		return false
+	}
+
+	closed.ClosingComments = []string{comment}
+	closed.RawClosing += takeRaw(pendingRaw)
+	return true
+}
+
+// isEndMarker reports whether a comment marks the end of block: "end", or "end"
+// or "end of" followed by the block name, optionally with its parameters, as in
+// "end of location /api/". Comments merely starting with the word, such as
+// "endpoints for v2", are not markers
+func isEndMarker(comment string, block *Block) bool {
+	fields := strings.Fields(strings.ToLower(comment))
+	if len(fields) == 0 || fields[0] != "end" {
+		return false
+	}
+	fields = fields[1:]
+	if len(fields) > 0 && fields[0] == "of" {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return true
+	}
+
+	marker := strings.Join(fields, " ")
+	name := strings.ToLower(block.Name)
+	return marker == name || marker == strings.ToLower(strings.Join(append([]string{block.Name}, block.Params...), " "))
+}
+
+// scanComment returns the position of the comment starting on the line (-1 if
+// none) and whether a quoted string or escape is left unterminated. Like nginx,
+// quotes and # are only special at the beginning of a token, and # not inside quotes
+func scanComment(line string) (int, bool) {
+	inQuote := false
+	quoteMark := byte(0)
+	tokenStart := true
+
+	for i := 0; i < len(line); i++ {
+		char := line[i]
+		switch {
+		case char == '\\':
+			if i == len(line)-1 {
+				return -1, true
+			}
+			i++
+			tokenStart = false
+		case inQuote:
+			if char == quoteMark {
+				inQuote = false
+			}
+		case (char == '"' || char == '\'') && tokenStart:
+			inQuote = true
+			quoteMark = char
+			tokenStart = false
+		case char == '#' && tokenStart:
+			return i, false
+		default:
+			tokenStart = isTokenSeparator(char)
+		}
+	}
+
+	return -1, inQuote
+}
+
+// whitespace holds the characters nginx separates tokens with. Others that
+// strings.TrimSpace strips, such as \v or a no-break space, are part of a token
+const whitespace = " \t\r\n"
+
+// trimSpace returns s without the leading and trailing whitespace nginx skips,
+// keeping a trailing whitespace character that is escaped
+func trimSpace(s string) string {
+	s = strings.TrimLeft(s, whitespace)
+	trimmed := strings.TrimRight(s, whitespace)
+	if len(trimmed) < len(s) && escaped(s, len(trimmed)) {
+		return s[:len(trimmed)+1]
+	}
+	return trimmed
+}
+
+// escaped reports whether the character at position i of s follows an odd number
+// of backslashes
+func escaped(s string, i int) bool {
+	backslashes := 0
+	for i--; i >= 0 && s[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+// isTokenSeparator reports whether a token may start after char
+func isTokenSeparator(char byte) bool {
+	return strings.IndexByte(whitespace, char) >= 0 || char == ';' || char == '{' || char == '}'
+}
+
+// atTokenStart reports whether the next character of a statement begins a new token,
+// following a separator that is not escaped
+func atTokenStart(statement string) bool {
+	if statement == "" {
+		return true
+	}
+	last := len(statement) - 1
+	return isTokenSeparator(statement[last]) && !escaped(statement, last)
+}
+
+// splitDirectives splits a line into statements at semicolons and braces outside
+// quotes. Opening braces stay attached to their block statement, closing braces
+// are returned as separate "}" statements, also when the directive before them
+// lacks its semicolon. The braces of ${name} variable references are kept in
+// their parameter
 func splitDirectives(line string) []string {
 	var results []string
 	var current strings.Builder
 	inQuote := false
 	quoteMark := rune(0)
+	escaped := false
+	variableBrace := false // Inside the braces of a ${name} variable reference
+	previous := rune(0)
 
 	for _, char := range line {
+		if escaped {
+			current.WriteRune(char)
+			escaped = false
+			previous = char
+			continue
+		}
+		if variableBrace && char != '}' && !isVariableNameChar(char) {
+			// Not a ${name} reference, the character is read as usual
+			variableBrace = false
+		}
+		if !inQuote && (variableBrace || (char == '{' && previous == '$')) {
+			// Braces of ${name} belong to the parameter
+			current.WriteRune(char)
+			variableBrace = char != '}'
+			previous = char
+			continue
+		}
+		previous = char
+
 		switch char {
+		case '\\':
+			escaped = true
+			current.WriteRune(char)
 		case '\'', '"':
 			if inQuote && char == quoteMark {
 				inQuote = false
 				quoteMark = rune(0)
-			} else if !inQuote {
+			} else if !inQuote && atTokenStart(current.String()) {
 				inQuote = true
 				quoteMark = char
 			}
@@ -228,15 +770,26 @@ func splitDirectives(line string) []string {
 			if inQuote {
 				current.WriteRune(char)
 			} else {
-				results = append(results, strings.TrimSpace(current.String()))
+				results = append(results, trimSpace(current.String()))
 				current.Reset()
 			}
 		case '{':
 			current.WriteRune(char)
 			if !inQuote {
-				results = append(results, strings.TrimSpace(current.String()))
+				results = append(results, trimSpace(current.String()))
+				current.Reset()
+			}
+		case '}':
+			if inQuote {
+				current.WriteRune(char)
+			} else {
+				// Generated configs may omit the semicolon before a closing brace
+				// (proxy_pass http://upstream }), the pending text is still a directive
+				if pending := trimSpace(current.String()); pending != "" {
+					results = append(results, pending)
+				}
+				results = append(results, "}")
 				current.Reset()
-				return results
 			}
 		default:
 			current.WriteRune(char)
@@ -245,73 +798,42 @@ func splitDirectives(line string) []string {
 
 	// Add any remaining content
 	if current.Len() > 0 {
-		results = append(results, strings.TrimSpace(current.String()))
+		results = append(results, trimSpace(current.String()))
 	}
 
 	return results
 }
 
-// PrintConfig prints the parsed configuration for debugging
-func PrintConfig(config *Config) {
-	printBlock(config.RootBlock, 0)
+// isVariableNameChar reports whether char may appear in a variable name
+func isVariableNameChar(char rune) bool {
+	return char == '_' || char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9'
 }
 
-// printBlock recursively prints a block with proper indentation
-func printBlock(block *Block, indent int) {
-	indentStr := strings.Repeat("  ", indent)
-
-	// Print block info
-	if block.Name != "root" {
-		blockStr := indentStr + block.Name
-		if len(block.Params) > 0 {
-			blockStr += " " + strings.Join(block.Params, " ")
-		}
-		blockStr += " {"
-		if len(block.Comments) > 0 {
-			blockStr += " # " + strings.Join(block.Comments, " ")
-		}
-		println(blockStr)
-	}
-
-	// Print lines
-	for _, line := range block.Lines {
-		if line.Type == LineTypeBlock {
-			continue // Already handled when printing blocks
-		}
-
-		lineStr := indentStr + "  " + line.Name
-		if len(line.Params) > 0 {
-			lineStr += " " + strings.Join(line.Params, " ")
-		}
-		if line.Type != LineTypeComment {
-			lineStr += ";"
-		}
-		if len(line.Comments) > 0 {
-			lineStr += " # " + strings.Join(line.Comments, " ")
-		}
-		println(lineStr)
-	}
-
-	// Print child blocks
-	for _, childBlock := range block.Blocks {
-		printBlock(childBlock, indent+1)
-	}
+// opensBlock reports whether a statement returned by splitDirectives opens a block,
+// ending with a brace that is neither escaped nor that of a ${name} reference
+func opensBlock(statement string) bool {
+	return strings.HasSuffix(statement, "{") && !strings.HasSuffix(statement, "${") && !escaped(statement, len(statement)-1)
+}
 
-	// Print closing brace for non-root blocks
-	if block.Name != "root" {
-		println(indentStr + "}")
-	}
+// PrintConfig prints the parsed configuration for debugging
+func PrintConfig(config *Config) {
+	fmt.Print(config.AutoIndent(2))
 }
 
 // PrintTree prints the configuration as a hierarchical tree with detailed information
 func (config *Config) PrintTree() {
-	fmt.Printf("Configuration File: %s\n", config.FilePath)
-	fmt.Println("└── Root")
-	printTreeBlock(config.RootBlock, "    ")
+	config.FprintTree(os.Stdout)
+}
+
+// FprintTree writes the configuration to w as a hierarchical tree, see PrintTree
+func (config *Config) FprintTree(w io.Writer) {
+	fmt.Fprintf(w, "Configuration File: %s\n", config.FilePath)
+	fmt.Fprintln(w, "└── Root")
+	printTreeBlock(w, config.RootBlock, "    ")
 }
 
 // printTreeBlock prints a block as part of the tree representation
-func printTreeBlock(block *Block, prefix string) {
+func printTreeBlock(w io.Writer, block *Block, prefix string) {
 	// Print lines
 	for i, line := range block.Lines {
 		isLast := i == len(block.Lines)-1 && len(block.Blocks) == 0
@@ -325,7 +847,7 @@ func printTreeBlock(block *Block, prefix string) {
 		// Format line representation
 		lineInfo := formatLineInfo(line)
 
-		fmt.Printf("%s%s%s\n", prefix, branch, lineInfo)
+		fmt.Fprintf(w, "%s%s%s\n", prefix, branch, lineInfo)
 
 		// Print comments if any and not included in the line info
 		if line.Type != LineTypeComment && len(line.Comments) > 0 {
@@ -341,7 +863,7 @@ func printTreeBlock(block *Block, prefix string) {
 				if j == len(line.Comments)-1 {
 					commentBranch = "└── "
 				}
-				fmt.Printf("%s%sComment: %s\n", commentPrefix, commentBranch, comment)
+				fmt.Fprintf(w, "%s%sComment: %s\n", commentPrefix, commentBranch, comment)
 			}
 		}
 	}
@@ -366,7 +888,7 @@ func printTreeBlock(block *Block, prefix string) {
 			blockInfo += fmt.Sprintf(" (Comments: %d)", len(childBlock.Comments))
 		}
 
-		fmt.Printf("%s%sBlock: %s\n", prefix, branch, blockInfo)
+		fmt.Fprintf(w, "%s%sBlock: %s\n", prefix, branch, blockInfo)
 
 		// Print block comments if any
 		nextPrefix := prefix
@@ -382,12 +904,12 @@ func printTreeBlock(block *Block, prefix string) {
 				if j == len(childBlock.Comments)-1 && len(childBlock.Lines) == 0 && len(childBlock.Blocks) == 0 {
 					commentBranch = "└── "
 				}
-				fmt.Printf("%s%sComment: %s\n", nextPrefix, commentBranch, comment)
+				fmt.Fprintf(w, "%s%sComment: %s\n", nextPrefix, commentBranch, comment)
 			}
 		}
 
 		// Recursively print child block content
-		printTreeBlock(childBlock, nextPrefix)
+		printTreeBlock(w, childBlock, nextPrefix)
 	}
 }
 
@@ -0,0 +1,175 @@
+package nginx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitDirectives(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"directive", "listen 80;", []string{"listen 80"}},
+		{"several directives", "listen 80; server_name example.com;", []string{"listen 80", "server_name example.com"}},
+		{"block", "location / {", []string{"location / {"}},
+		{"closing brace in double quotes", `return 200 "}";`, []string{`return 200 "}"`}},
+		{"closing brace in single quotes", `add_header X-Json '{"a": {}}';`, []string{`add_header X-Json '{"a": {}}'`}},
+		{"semicolon in quotes", `return 200 "a; b";`, []string{`return 200 "a; b"`}},
+		{"escaped quote", `return 200 "a \"}\" b";`, []string{`return 200 "a \"}\" b"`}},
+		{"quote inside a token", `set $a b"}`, []string{`set $a b"`, "}"}},
+		{"braced variable", "set $a ${host}_x;", []string{"set $a ${host}_x"}},
+		{"braced variable in quotes", `return 200 "${scheme}://${host}";`, []string{`return 200 "${scheme}://${host}"`}},
+		{"braced variable before a block", "if ($a = ${b}) {", []string{"if ($a = ${b}) {"}},
+		{"braced variable closing a block", "set $a ${b}}", []string{"set $a ${b}", "}"}},
+		{"dollar brace opening a block", "location ~ ^/a${ {", []string{"location ~ ^/a${ {"}},
+		{"directive and closing brace", "a;}", []string{"a", "}"}},
+		{"closing brace without semicolon", "proxy_pass http://backend }", []string{"proxy_pass http://backend", "}"}},
+		{"block on one line", "location / { root /srv; }", []string{"location / {", "root /srv", "}"}},
+		{"nested blocks on one line", "a { b { c; } }", []string{"a {", "b {", "c", "}", "}"}},
+		{"escaped brace", `return 200 a\}b;`, []string{`return 200 a\}b`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitDirectives(tt.line); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitDirectives(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSplitStatements(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "closing brace in quotes",
+			input: `location / { return 200 "}"; }`,
+			want:  "location / {\n    return 200 \"}\";\n}\n",
+		},
+		{
+			name:  "braced variable",
+			input: `server { set $a ${host}_x; return 301 "${scheme}://${host}"; }`,
+			want:  "server {\n    set $a ${host}_x;\n    return 301 \"${scheme}://${host}\";\n}\n",
+		},
+		{
+			name:  "directive and closing brace",
+			input: "events { a;}\nhttp { b }",
+			want:  "events {\n    a;\n}\n\nhttp {\n    b;\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseReader(strings.NewReader(tt.input), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.AutoIndent(4); got != tt.want {
+				t.Fatalf("parsed to\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClosingComments(t *testing.T) {
+	tests := []struct {
+		comment string
+		closing bool
+	}{
+		{"end", true},
+		{"End", true},
+		{"end location", true},
+		{"end of location", true},
+		{"END OF LOCATION /api/", true},
+		{"end location /api/", true},
+		{"endpoints for v2", false},
+		{"end-to-end tests", false},
+		{"end server", false},
+		{"end of http", false},
+		{"end location /other/", false},
+		{"ending here", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.comment, func(t *testing.T) {
+			input := "server {\n    location /api/ {\n    }\n    # " + tt.comment + "\n}\n"
+			config, err := ParseReader(strings.NewReader(input), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := config.FindBlocksByName("server")[0]
+			location := server.Blocks[0]
+
+			attached := len(location.ClosingComments) == 1 && location.ClosingComments[0] == tt.comment
+			if attached != tt.closing {
+				t.Fatalf("closing comments %q, attached %v, want %v", location.ClosingComments, attached, tt.closing)
+			}
+			if comment := len(server.Lines) == 2 && server.Lines[1].Type == LineTypeComment; comment == tt.closing {
+				t.Fatalf("comment line in the server %v, want %v", comment, !tt.closing)
+			}
+		})
+	}
+}
+
+func TestClosingCommentOnSameLine(t *testing.T) {
+	config, err := ParseReader(strings.NewReader("server {\n    location / {\n    } # endpoints for v2\n}\n"), "test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	location := config.FindBlocksByName("location")[0]
+	if len(location.ClosingComments) != 1 || location.ClosingComments[0] != "endpoints for v2" {
+		t.Fatalf("closing comments %q, want the comment after the brace", location.ClosingComments)
+	}
+}
+
+func TestDirty(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		mutate func(config *Config)
+		dirty  bool
+	}{
+		{"patch", "http {\n    gzip off;\n}\n", func(config *Config) {
+			config.PatchDirective([]string{"http"}, "gzip", []string{"on"})
+		}, true},
+		{"booleans", "http {\n    gzip ON;\n}\n", func(config *Config) { config.NormalizeBooleans() }, true},
+		{"booleans unchanged", "http {\n    gzip on;\n}\n", func(config *Config) { config.NormalizeBooleans() }, false},
+		{"reformat", "http {\n    root 'html';\n}\n", func(config *Config) {
+			config.Reformat(ReformatOptions{QuoteStyle: QuoteStyleMinimal})
+		}, true},
+		{"reformat unchanged", "http {\n    root html;\n}\n", func(config *Config) {
+			config.Reformat(ReformatOptions{QuoteStyle: QuoteStyleMinimal})
+		}, false},
+		{"dedupe includes", "http {\n    include a.conf;\n    include a.conf;\n}\n", func(config *Config) { config.DedupeIncludes() }, true},
+		{"reorder", "http {\n    server {\n    }\n    gzip on;\n}\n", func(config *Config) { config.Reorder(DefaultReorderPolicy()) }, true},
+		{"reorder unchanged", "http {\n    gzip on;\n    server {\n    }\n}\n", func(config *Config) { config.Reorder(DefaultReorderPolicy()) }, false},
+		{"try_files", "server {\n    location / {\n        if (-f $request_filename) {\n            break;\n        }\n    }\n}\n", func(config *Config) {
+			config.RewriteToTryFiles()
+		}, true},
+		{"apply to no block", "http {\n}\n", func(config *Config) {
+			config.ApplyToMatching(func(block *Block) bool { return block.Name == "server" }, func(block *Block) {})
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseReader(strings.NewReader(tt.input), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Dirty {
+				t.Fatal("configuration dirty after parsing")
+			}
+			tt.mutate(config)
+			if config.Dirty != tt.dirty {
+				t.Fatalf("Dirty = %v, want %v\n%s", config.Dirty, tt.dirty, config.String())
+			}
+		})
+	}
+}
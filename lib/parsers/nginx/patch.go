@@ -0,0 +1,335 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// patchContext is the number of unchanged lines surrounding each hunk of a unified diff
+const patchContext = 3
+
+// hunkHeaderPattern matches "@@ -start,count +start,count @@" hunk headers
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// PatchError is returned when a patch does not apply cleanly
+type PatchError struct {
+	Hunk     int    // 1-based index of the failing hunk
+	Line     int    // Line number in the serialized configuration, 0 if not applicable
+	Expected string // Line the patch expected, empty if not applicable
+	Actual   string // Line found in the configuration
+	Message  string // Description of the failure
+}
+
+// Error implements the error interface
+func (e *PatchError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("hunk %d: line %d: %s: expected %q, found %q", e.Hunk, e.Line, e.Message, e.Expected, e.Actual)
+	}
+	return fmt.Sprintf("hunk %d: %s", e.Hunk, e.Message)
+}
+
+// diffOp is a single line of an edit script: ' ' keeps, '-' removes and '+' adds a line
+type diffOp struct {
+	kind byte
+	text string
+}
+
+// hunk is a parsed section of a unified diff
+type hunk struct {
+	oldStart, oldCount int
+	newStart, newCount int
+	ops                []diffOp
+}
+
+// UnifiedDiff returns a unified diff turning the serialized form of the configuration
+// (see String) into the serialized form of other. Where Diff reports semantic changes,
+// the result of UnifiedDiff can be stored, reviewed and passed to ApplyPatch.
+// Returns an empty string when both serialize identically
+func (config *Config) UnifiedDiff(other *Config) string {
+	before := splitConfigLines(config.String())
+	after := splitConfigLines(other.String())
+	hunks := buildHunks(diffLines(before, after))
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	builder.WriteString("--- a/" + patchFileName(config.FilePath) + "\n")
+	builder.WriteString("+++ b/" + patchFileName(other.FilePath) + "\n")
+	for _, h := range hunks {
+		builder.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(h.oldStart, h.oldCount), hunkRange(h.newStart, h.newCount)))
+		for _, op := range h.ops {
+			builder.WriteString(string(op.kind) + op.text + "\n")
+		}
+	}
+	return builder.String()
+}
+
+// ApplyPatch applies a unified diff, as produced by UnifiedDiff, to the serialized
+// configuration and replaces the configuration with the re-parsed result. Returns a
+// *PatchError when a hunk does not apply cleanly, leaving the configuration unchanged
+func (config *Config) ApplyPatch(patchText string) error {
+	hunks, err := parsePatch(patchText)
+	if err != nil {
+		return err
+	}
+
+	lines := splitConfigLines(config.String())
+	var result []string
+	cursor := 0
+
+	for i, h := range hunks {
+		// A hunk without old lines inserts after its start line
+		position := h.oldStart - 1
+		if h.oldCount == 0 {
+			position = h.oldStart
+		}
+		if position < cursor || position > len(lines) {
+			return &PatchError{Hunk: i + 1, Message: fmt.Sprintf("start line %d out of range", h.oldStart)}
+		}
+		result = append(result, lines[cursor:position]...)
+		cursor = position
+
+		for _, op := range h.ops {
+			if op.kind == '+' {
+				result = append(result, op.text)
+				continue
+			}
+			if cursor >= len(lines) {
+				return &PatchError{Hunk: i + 1, Message: "patch extends past the end of the configuration"}
+			}
+			if lines[cursor] != op.text {
+				return &PatchError{Hunk: i + 1, Line: cursor + 1, Expected: op.text, Actual: lines[cursor], Message: "context does not match"}
+			}
+			if op.kind == ' ' {
+				result = append(result, op.text)
+			}
+			cursor++
+		}
+	}
+	result = append(result, lines[cursor:]...)
+
+	text := ""
+	if len(result) > 0 {
+		text = strings.Join(result, "\n") + "\n"
+	}
+	patched, err := ParseReaderWithOptions(strings.NewReader(text), config.FilePath, ParseOptions{Lossless: config.Lossless})
+	if err != nil {
+		return fmt.Errorf("patched configuration does not parse: %v", err)
+	}
+
+	*config = *patched
+	return nil
+}
+
+// parsePatch splits a unified diff into its hunks, ignoring file headers
+func parsePatch(patchText string) ([]hunk, error) {
+	var hunks []hunk
+	var current *hunk
+
+	for _, line := range strings.Split(patchText, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if match := hunkHeaderPattern.FindStringSubmatch(line); match != nil {
+			hunks = append(hunks, hunk{
+				oldStart: atoiDefault(match[1], 0),
+				oldCount: atoiDefault(match[2], 1),
+				newStart: atoiDefault(match[3], 0),
+				newCount: atoiDefault(match[4], 1),
+			})
+			current = &hunks[len(hunks)-1]
+			continue
+		}
+		if current == nil || strings.HasPrefix(line, "\\") {
+			continue
+		}
+
+		switch {
+		case line == "":
+			// Editors and mail clients often strip the space of empty context lines
+			if countOps(current.ops, '-') < current.oldCount {
+				current.ops = append(current.ops, diffOp{kind: ' '})
+			}
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			current.ops = append(current.ops, diffOp{kind: line[0], text: line[1:]})
+		default:
+			// Anything else ends the hunk, e.g. the header of the next file
+			current = nil
+		}
+	}
+
+	if len(hunks) == 0 {
+		return nil, &PatchError{Message: "no hunks found in patch"}
+	}
+	for i, h := range hunks {
+		if countOps(h.ops, '-') != h.oldCount || countOps(h.ops, '+') != h.newCount {
+			return nil, &PatchError{Hunk: i + 1, Message: "hunk line counts do not match its header"}
+		}
+	}
+	return hunks, nil
+}
+
+// countOps counts the lines of a hunk present in the old ('-') or new ('+') text
+func countOps(ops []diffOp, side byte) int {
+	count := 0
+	for _, op := range ops {
+		if op.kind == ' ' || op.kind == side {
+			count++
+		}
+	}
+	return count
+}
+
+// atoiDefault parses a hunk header number, returning fallback for an omitted value
+func atoiDefault(value string, fallback int) int {
+	if value == "" {
+		return fallback
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback
+	}
+	return number
+}
+
+// splitConfigLines splits serialized configuration text into lines without terminators
+func splitConfigLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// patchFileName returns the file name used in diff headers
+func patchFileName(filePath string) string {
+	if filePath == "" {
+		return "nginx.conf"
+	}
+	return strings.TrimPrefix(filePath, "/")
+}
+
+// hunkRange formats the start and count of a hunk side; empty sides start at the preceding line
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// buildHunks groups an edit script into hunks with patchContext lines of context.
+// Hunk start positions are 0-based; hunkRange converts them for output
+func buildHunks(ops []diffOp) []hunk {
+	// Position of each op in the old and new text
+	oldPos := make([]int, len(ops)+1)
+	newPos := make([]int, len(ops)+1)
+	var changes []int
+	for i, op := range ops {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if op.kind != '+' {
+			oldPos[i+1]++
+		}
+		if op.kind != '-' {
+			newPos[i+1]++
+		}
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+
+	var hunks []hunk
+	for len(changes) > 0 {
+		// Changes separated by less than twice the context share a hunk
+		last := 0
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*patchContext+1 {
+			last++
+		}
+
+		start := changes[0] - patchContext
+		if start < 0 {
+			start = 0
+		}
+		end := changes[last] + 1 + patchContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		h := hunk{oldStart: oldPos[start], newStart: newPos[start], ops: ops[start:end]}
+		h.oldCount = countOps(h.ops, '-')
+		h.newCount = countOps(h.ops, '+')
+		hunks = append(hunks, h)
+		changes = changes[last+1:]
+	}
+	return hunks
+}
+
+// diffLines computes a shortest edit script turning a into b using Myers' algorithm
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	for d := 0; d <= n+m; d++ {
+		// Only diagonals -d..d are needed to backtrack step d
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			x := v[offset+k-1] + 1
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(trace, a, b, d)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrackDiff walks the recorded Myers frontiers backwards to recover the edit script
+func backtrackDiff(trace [][]int, a, b []string, distance int) []diffOp {
+	var ops []diffOp
+	x, y := len(a), len(b)
+
+	for d := distance; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		previousK := k - 1
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			previousK = k + 1
+		}
+		previousX := v[d+previousK]
+		previousY := previousX - previousK
+
+		for x > previousX && y > previousY {
+			x--
+			y--
+			ops = append(ops, diffOp{kind: ' ', text: a[x]})
+		}
+		if previousK == k+1 {
+			y--
+			ops = append(ops, diffOp{kind: '+', text: b[y]})
+		} else {
+			x--
+			ops = append(ops, diffOp{kind: '-', text: a[x]})
+		}
+	}
+	for x > 0 {
+		x--
+		ops = append(ops, diffOp{kind: ' ', text: a[x]})
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
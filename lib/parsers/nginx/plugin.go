@@ -0,0 +1,66 @@
+package nginx
+
+import "fmt"
+
+// DirectivePlugin parses and serializes a directive the core parser treats as an
+// opaque name and parameter list, such as the directives of third-party modules
+type DirectivePlugin interface {
+	// Name is the directive the plugin handles
+	Name() string
+	// Parse builds the line of a directive from its parameters as written
+	Parse(params []string) (*Line, error)
+	// Serialize renders the directive statement without the terminating semicolon
+	// and comments, e.g. "content_by_lua_file app.lua"
+	Serialize(line *Line) string
+}
+
+// RegisterPlugin delegates the directives named like the plugin to it: the ones
+// already parsed, those of files spliced in by InlineIncludes and, when the
+// configuration is written, their serialization. Directives the plugin rejects
+// are kept as plain directives; set ParseOptions.Plugins to have them reported as
+// parse errors instead
+func (config *Config) RegisterPlugin(p DirectivePlugin) {
+	if config.plugins == nil {
+		config.plugins = map[string]DirectivePlugin{}
+	}
+	config.plugins[p.Name()] = p
+	applyPlugin(config.RootBlock, p)
+}
+
+// applyPlugin replaces the directives named like the plugin with the lines it parses
+// them into, keeping their position, comments and source text. Returns the first
+// directive the plugin rejects, the others are still replaced
+func applyPlugin(root *Block, p DirectivePlugin) error {
+	var firstErr error
+	walkBlock(root, func(block *Block) {
+		for i, line := range block.Lines {
+			if line.Type != LineTypeDirective || line.Name != p.Name() || line.plugin == p {
+				continue
+			}
+			parsed, err := p.Parse(append([]string{}, line.Params...))
+			if err == nil && parsed == nil {
+				err = fmt.Errorf("%s: plugin returned no line", line.Name)
+			}
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%d: %v", line.LineNumber, err)
+				}
+				continue
+			}
+
+			if parsed.Name == "" {
+				parsed.Name = line.Name
+			}
+			parsed.Type = LineTypeDirective
+			parsed.BlockRef = nil
+			parsed.Comments = line.Comments
+			parsed.LineNumber = line.LineNumber
+			parsed.Raw = line.Raw
+			parsed.Offset = line.Offset
+			parsed.parsedText = line.parsedText
+			parsed.plugin = p
+			block.Lines[i] = parsed
+		}
+	})
+	return firstErr
+}
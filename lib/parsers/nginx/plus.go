@@ -0,0 +1,145 @@
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrNotNginxPlus is returned when an operation requires an NGINX Plus configuration
+var ErrNotNginxPlus = errors.New("configuration does not use any NGINX Plus directives")
+
+// nginxPlusDirectives lists directives that are only available in NGINX Plus
+var nginxPlusDirectives = map[string]bool{
+	"api":                   true,
+	"status_zone":           true,
+	"health_check":          true,
+	"health_check_timeout":  true,
+	"match":                 true,
+	"sticky":                true,
+	"sticky_cookie_insert":  true,
+	"ntlm":                  true,
+	"queue":                 true,
+	"state":                 true,
+	"zone_sync":             true,
+	"zone_sync_server":      true,
+	"keyval":                true,
+	"keyval_zone":           true,
+	"session_log":           true,
+	"session_log_format":    true,
+	"session_log_zone":      true,
+	"auth_jwt":              true,
+	"auth_jwt_key_file":     true,
+	"auth_jwt_key_request":  true,
+	"proxy_cache_purge":     true,
+	"fastcgi_cache_purge":   true,
+	"internal_redirect":     true,
+	"mgmt":                  true,
+	"license_token":         true,
+	"ssl_certificate_cache": true,
+}
+
+// IsNginxPlus reports whether the configuration uses any NGINX Plus only directives
+func (config *Config) IsNginxPlus() bool {
+	plus := false
+	config.WalkBlocks(func(block *Block) {
+		if nginxPlusDirectives[block.Name] {
+			plus = true
+		}
+		for _, line := range block.Lines {
+			if nginxPlusDirectives[line.Name] {
+				plus = true
+			}
+		}
+	})
+	return plus
+}
+
+// ExportNginxPlusStatusConfig returns the blocks to graft for the NGINX Plus
+// monitoring API, leaving the configuration unchanged: copies of the http server
+// blocks, in configuration order, with a status_zone directive added to every
+// server and location lacking one, followed by the API and dashboard location
+// blocks. Location zones are named after their server zone and path
+func (config *Config) ExportNginxPlusStatusConfig(apiPath, allowCIDR string) ([]*Block, error) {
+	if !config.IsNginxPlus() {
+		return nil, ErrNotNginxPlus
+	}
+	if !strings.HasPrefix(apiPath, "/") {
+		return nil, fmt.Errorf("api path %q must start with /", apiPath)
+	}
+	if _, _, err := net.ParseCIDR(allowCIDR); err != nil && net.ParseIP(allowCIDR) == nil {
+		return nil, fmt.Errorf("invalid allow address %q", allowCIDR)
+	}
+
+	var blocks []*Block
+	zones := map[string]bool{}
+	for i, server := range config.Clone().FindBlocksByName("server") {
+		// Mail and stream servers have no HTTP status zones
+		if server.ParentRef != nil && (server.ParentRef.Name == "mail" || server.ParentRef.Name == "stream") {
+			continue
+		}
+		serverZone := statusZoneName(server, i)
+		if existing := server.FindLines("status_zone"); len(existing) > 0 && len(existing[0].Params) > 0 {
+			serverZone = unquote(existing[0].Params[0])
+		} else {
+			server.AddDirective("status_zone", serverZone)
+		}
+
+		var locations []*Block
+		walkBlock(server, func(block *Block) {
+			if block.Name == "location" && len(block.FindLines("status_zone")) == 0 {
+				locations = append(locations, block)
+			}
+		})
+		for _, location := range locations {
+			base := serverZone + "_" + locationZoneSuffix(location)
+			zone := base
+			for n := 2; zones[zone]; n++ {
+				zone = fmt.Sprintf("%s_%d", base, n)
+			}
+			zones[zone] = true
+			location.AddDirective("status_zone", zone)
+		}
+		blocks = append(blocks, server)
+	}
+
+	apiLocation := NewBlock("location", apiPath)
+	apiLocation.AddDirective("api", "write=off")
+	apiLocation.AddDirective("allow", allowCIDR)
+	apiLocation.AddDirective("deny", "all")
+
+	dashboardLocation := NewBlock("location", "=", "/dashboard.html")
+	dashboardLocation.AddDirective("root", "/usr/share/nginx/html")
+
+	return append(blocks, apiLocation, dashboardLocation), nil
+}
+
+// statusZoneName derives a status zone name from the server's primary name
+func statusZoneName(server *Block, index int) string {
+	for _, line := range server.FindLines("server_name") {
+		for _, name := range line.Params {
+			if name != "_" && name != `""` && !strings.HasPrefix(name, "~") {
+				return strings.TrimPrefix(name, "*.")
+			}
+		}
+	}
+	return fmt.Sprintf("server_%d", index)
+}
+
+// locationZoneSuffix derives the part of a location status zone name identifying
+// the location from its path: "root" for /, "api_v1" for /api/v1/
+func locationZoneSuffix(location *Block) string {
+	pattern := ""
+	if len(location.Params) > 0 {
+		pattern = unquote(location.Params[len(location.Params)-1])
+	}
+	suffix := strings.Trim(variableNameSanitizer.ReplaceAllString(pattern, "_"), "_")
+	for strings.Contains(suffix, "__") {
+		suffix = strings.ReplaceAll(suffix, "__", "_")
+	}
+	if suffix == "" {
+		return "root"
+	}
+	return suffix
+}
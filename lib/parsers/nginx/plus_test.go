@@ -0,0 +1,113 @@
+package nginx
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExportNginxPlusStatusConfig(t *testing.T) {
+	input := `
+http {
+    server {
+        server_name example.com;
+        status_zone example.com;
+        location / {
+            proxy_pass http://app;
+        }
+        location /api/v1/ {
+            health_check;
+            location ~ \.json$ {
+            }
+        }
+        location = /api/v1 {
+        }
+    }
+    server {
+        location /metrics {
+            status_zone metrics;
+        }
+    }
+}
+stream {
+    server {
+        listen 53 udp;
+    }
+}
+`
+	config, err := ParseReader(strings.NewReader(input), "test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := config.AutoIndent(4)
+
+	blocks, err := config.ExportNginxPlusStatusConfig("/api", "10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := config.AutoIndent(4); after != before {
+		t.Fatalf("configuration changed:\n%s", after)
+	}
+	if len(blocks) != 4 {
+		t.Fatalf("got %d blocks, want 2 servers and the api and dashboard locations", len(blocks))
+	}
+
+	zones := func(block *Block) []string {
+		var names []string
+		walkBlock(block, func(block *Block) {
+			for _, line := range block.FindLines("status_zone") {
+				names = append(names, block.Name+" "+strings.Join(line.Params, " "))
+			}
+		})
+		return names
+	}
+	want := []string{
+		"server example.com",
+		"location example.com_root",
+		"location example.com_api_v1",
+		"location example.com_json",
+		"location example.com_api_v1_2",
+	}
+	if got := zones(blocks[0]); strings.Join(got, "; ") != strings.Join(want, "; ") {
+		t.Fatalf("zones of the first server = %q, want %q", got, want)
+	}
+	want = []string{"server server_1", "location metrics"}
+	if got := zones(blocks[1]); strings.Join(got, "; ") != strings.Join(want, "; ") {
+		t.Fatalf("zones of the second server = %q, want %q", got, want)
+	}
+
+	api, dashboard := blocks[2], blocks[3]
+	if api.Name != "location" || strings.Join(api.Params, " ") != "/api" || len(api.FindLines("api")) != 1 {
+		t.Fatalf("unexpected api block %s %v", api.Name, api.Params)
+	}
+	if strings.Join(dashboard.Params, " ") != "= /dashboard.html" {
+		t.Fatalf("unexpected dashboard block %s %v", dashboard.Name, dashboard.Params)
+	}
+}
+
+func TestExportNginxPlusStatusConfigErrors(t *testing.T) {
+	plus := "http { server { health_check; } }"
+	tests := []struct {
+		name   string
+		config string
+		path   string
+		allow  string
+		want   error
+	}{
+		{"open source nginx", "http { server { listen 80; } }", "/api", "127.0.0.1", ErrNotNginxPlus},
+		{"relative api path", plus, "api", "127.0.0.1", nil},
+		{"invalid allow address", plus, "/api", "10.0.0.0/33", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseReader(strings.NewReader(tt.config), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = config.ExportNginxPlusStatusConfig(tt.path, tt.allow)
+			if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package nginx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// PlusServer is an upstream server as the servers endpoint of the NGINX Plus API
+// represents it, e.g. /api/9/http/upstreams/backend/servers
+type PlusServer struct {
+	Server      string `json:"server"`       // Address with its port, e.g. 10.0.0.1:80
+	Weight      int    `json:"weight"`       // Relative weight
+	MaxFails    int    `json:"max_fails"`    // Failed attempts marking the server unavailable
+	FailTimeout string `json:"fail_timeout"` // Failure window and unavailability time, e.g. 10s
+	SlowStart   string `json:"slow_start"`   // Weight ramp-up time on recovery, e.g. 0s
+	Backup      bool   `json:"backup"`       // Only used when the primary servers are unavailable
+	Down        bool   `json:"down"`         // Marked unavailable
+}
+
+// PlusServerState is an upstream server as the NGINX Plus API lists it, with the
+// ID the API assigned
+type PlusServerState struct {
+	ID int `json:"id"`
+	PlusServer
+}
+
+// PlusOperationKind is the kind of change to the servers of an upstream
+type PlusOperationKind string
+
+const (
+	PlusServerAdd    PlusOperationKind = "add"    // POST a new server
+	PlusServerUpdate PlusOperationKind = "update" // PATCH the changed fields of a server
+	PlusServerDelete PlusOperationKind = "delete" // DELETE a server
+)
+
+// PlusServerOperation is a call to the NGINX Plus API changing one upstream server
+type PlusServerOperation struct {
+	Kind    PlusOperationKind
+	Path    string     // Servers endpoint of the upstream, relative to the API root
+	ID      int        // Server ID the API assigned, for updates and deletions
+	Server  PlusServer // Server as configured, or as listed by the API for deletions
+	Changed []string   // JSON fields updated, for updates
+}
+
+// Method returns the HTTP method of the operation
+func (operation PlusServerOperation) Method() string {
+	switch operation.Kind {
+	case PlusServerAdd:
+		return http.MethodPost
+	case PlusServerUpdate:
+		return http.MethodPatch
+	}
+	return http.MethodDelete
+}
+
+// Target returns the path the operation is sent to, relative to the API root
+func (operation PlusServerOperation) Target() string {
+	if operation.Kind == PlusServerAdd {
+		return operation.Path
+	}
+	return fmt.Sprintf("%s/%d", operation.Path, operation.ID)
+}
+
+// Payload returns the JSON body of the operation: the whole server for additions,
+// the changed fields for updates and nothing for deletions
+func (operation PlusServerOperation) Payload() ([]byte, error) {
+	switch operation.Kind {
+	case PlusServerAdd:
+		return json.Marshal(operation.Server)
+	case PlusServerUpdate:
+		data, err := json.Marshal(operation.Server)
+		if err != nil {
+			return nil, err
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		changed := map[string]json.RawMessage{}
+		for _, name := range operation.Changed {
+			changed[name] = fields[name]
+		}
+		return json.Marshal(changed)
+	}
+	return nil, nil
+}
+
+// String describes the operation as the API call it makes
+func (operation PlusServerOperation) String() string {
+	description := fmt.Sprintf("%s %s: %s %s", operation.Method(), operation.Target(), operation.Kind, operation.Server.Server)
+	if operation.Kind == PlusServerUpdate {
+		description += " " + strings.Join(operation.Changed, ", ")
+	}
+	return description
+}
+
+// PlusAPIPath returns the servers endpoint of the upstream in the NGINX Plus API,
+// relative to the API root, under /stream for upstreams of the stream module
+func (upstream *Upstream) PlusAPIPath() string {
+	module := "http"
+	if upstream.Block.Ancestor("stream") != nil {
+		module = "stream"
+	}
+	return fmt.Sprintf("/%s/upstreams/%s/servers", module, url.PathEscape(upstream.Name))
+}
+
+// PlusServers converts the servers of the upstream to NGINX Plus API servers, with
+// the defaults nginx applies for the parameters left unset and port 80 added to
+// addresses without one, as the API lists them
+func (upstream *Upstream) PlusServers() []PlusServer {
+	servers := []PlusServer{}
+	for _, server := range upstream.Servers {
+		servers = append(servers, PlusServer{
+			Server:      plusServerAddress(server.Address),
+			Weight:      server.Weight,
+			MaxFails:    server.MaxFails,
+			FailTimeout: plusDuration(server.FailTimeout),
+			SlowStart:   plusDuration(server.SlowStart),
+			Backup:      server.Backup,
+			Down:        server.Down,
+		})
+	}
+	return servers
+}
+
+// PlusUpstreamPayloads returns the JSON array of NGINX Plus API servers of every
+// upstream block, keyed by the servers endpoint of the upstream (see PlusAPIPath)
+func (config *Config) PlusUpstreamPayloads() (map[string][]byte, error) {
+	payloads := map[string][]byte{}
+	for _, upstream := range config.Upstreams() {
+		data, err := json.MarshalIndent(upstream.PlusServers(), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		payloads[upstream.PlusAPIPath()] = data
+	}
+	return payloads, nil
+}
+
+// DiffPlusServers compares the servers of the upstream with the ones the NGINX Plus
+// API lists, current being the JSON array returned by its servers endpoint, and
+// returns the operations converging the API to the configuration. Servers are
+// matched by address, the fields of each matched pair that differ are updated and
+// the others are added or deleted. The API cannot make an existing server a backup
+// or a primary one, such servers are deleted and added again. Operations are
+// ordered so the upstream never runs short of servers: deletions of servers added
+// again, additions, updates, then the remaining deletions
+func (upstream *Upstream) DiffPlusServers(current []byte) ([]PlusServerOperation, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(current, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", upstream.PlusAPIPath(), err)
+	}
+	states := make([]PlusServerState, len(raw))
+	for i, data := range raw {
+		// Fields left out by the API have their nginx defaults
+		states[i] = PlusServerState{PlusServer: PlusServer{Weight: 1, MaxFails: 1, FailTimeout: "10s", SlowStart: "0s"}}
+		if err := json.Unmarshal(data, &states[i]); err != nil {
+			return nil, fmt.Errorf("%s: %v", upstream.PlusAPIPath(), err)
+		}
+	}
+
+	path := upstream.PlusAPIPath()
+	var replaced, added, updated, deleted []PlusServerOperation
+	matched := make([]bool, len(states))
+	for _, server := range upstream.PlusServers() {
+		index := -1
+		for i, state := range states {
+			if !matched[i] && plusServerAddress(state.Server) == server.Server {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			added = append(added, PlusServerOperation{Kind: PlusServerAdd, Path: path, Server: server})
+			continue
+		}
+		matched[index] = true
+
+		state := states[index]
+		if state.Backup != server.Backup {
+			replaced = append(replaced, PlusServerOperation{Kind: PlusServerDelete, Path: path, ID: state.ID, Server: state.PlusServer})
+			added = append(added, PlusServerOperation{Kind: PlusServerAdd, Path: path, Server: server})
+			continue
+		}
+		if changed := plusChangedFields(state.PlusServer, server); len(changed) > 0 {
+			updated = append(updated, PlusServerOperation{Kind: PlusServerUpdate, Path: path, ID: state.ID, Server: server, Changed: changed})
+		}
+	}
+	for i, state := range states {
+		if !matched[i] {
+			deleted = append(deleted, PlusServerOperation{Kind: PlusServerDelete, Path: path, ID: state.ID, Server: state.PlusServer})
+		}
+	}
+
+	operations := append(replaced, added...)
+	operations = append(operations, updated...)
+	return append(operations, deleted...), nil
+}
+
+// PlusAPIClient sends requests to the NGINX Plus API. path is relative to the API
+// root including its version, e.g. http://127.0.0.1:8080/api/9, and body is nil for
+// requests without one. The response body is returned for successful requests
+type PlusAPIClient interface {
+	Do(method, path string, body []byte) ([]byte, error)
+}
+
+// SyncPlusServers reads the servers of the upstream from the NGINX Plus API and
+// applies the operations of DiffPlusServers, stopping at the first one failing.
+// The operations applied are returned. The upstream must have a zone for the API
+// to manage it
+func (upstream *Upstream) SyncPlusServers(client PlusAPIClient) ([]PlusServerOperation, error) {
+	current, err := client.Do(http.MethodGet, upstream.PlusAPIPath(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v", http.MethodGet, upstream.PlusAPIPath(), err)
+	}
+	operations, err := upstream.DiffPlusServers(current)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, operation := range operations {
+		payload, err := operation.Payload()
+		if err != nil {
+			return operations[:i], err
+		}
+		if _, err := client.Do(operation.Method(), operation.Target(), payload); err != nil {
+			return operations[:i], fmt.Errorf("%s: %v", operation, err)
+		}
+	}
+	return operations, nil
+}
+
+// plusChangedFields returns the JSON names of the fields of the configured server
+// that differ from the API state, comparing durations by value
+func plusChangedFields(state, server PlusServer) []string {
+	var changed []string
+	if state.Weight != server.Weight {
+		changed = append(changed, "weight")
+	}
+	if state.MaxFails != server.MaxFails {
+		changed = append(changed, "max_fails")
+	}
+	if !sameDuration(state.FailTimeout, server.FailTimeout) {
+		changed = append(changed, "fail_timeout")
+	}
+	if !sameDuration(state.SlowStart, server.SlowStart) {
+		changed = append(changed, "slow_start")
+	}
+	if state.Down != server.Down {
+		changed = append(changed, "down")
+	}
+	return changed
+}
+
+// sameDuration reports whether two nginx time values are equal, e.g. 10 and 10s
+func sameDuration(a, b string) bool {
+	durationA, errA := ParseDuration(a)
+	durationB, errB := ParseDuration(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return durationA == durationB
+}
+
+// plusServerAddress adds the default port 80 to an upstream server address without
+// one, as the NGINX Plus API lists it. Unix sockets are returned as is
+func plusServerAddress(address string) string {
+	if strings.HasPrefix(address, "unix:") {
+		return address
+	}
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	if strings.HasPrefix(address, "[") && strings.HasSuffix(address, "]") {
+		return address + ":80"
+	}
+	return net.JoinHostPort(address, "80")
+}
+
+// plusDuration formats a duration the way the NGINX Plus API does, in seconds or
+// in milliseconds when it is not a whole number of seconds
+func plusDuration(duration time.Duration) string {
+	if duration%time.Second == 0 {
+		return fmt.Sprintf("%ds", duration/time.Second)
+	}
+	return fmt.Sprintf("%dms", duration/time.Millisecond)
+}
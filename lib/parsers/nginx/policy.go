@@ -0,0 +1,75 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PolicyViolation is a directive using a value a policy forbids
+type PolicyViolation struct {
+	Directive  string   // Directive name
+	Value      string   // Forbidden value the directive uses, as written in the rules
+	Params     []string // Parameters of the directive
+	Path       []string // Enclosing blocks from the outermost, empty in the main context
+	LineNumber int      // Line number of the directive
+	Line       *Line
+}
+
+// String describes the violation with its location
+func (violation PolicyViolation) String() string {
+	location := strings.Join(violation.Path, " > ")
+	if location == "" {
+		location = "main context"
+	}
+	return fmt.Sprintf("line %d: %s uses forbidden value %q in %s", violation.LineNumber, formatStatement(violation.Directive, violation.Params), violation.Value, location)
+}
+
+// ForbidValues reports every directive using a forbidden value, rules mapping a
+// directive name to the parameter values it must not have, e.g. autoindex: [on] or
+// ssl_protocols: [SSLv3, TLSv1]. Each parameter is compared whole once unquoted and
+// case-insensitively, as nginx reads on and off, so TLSv1 does not match TLSv1.2.
+// A directive using several forbidden values is reported once for each. Entries of
+// map, geo, upstream and similar blocks are not directives and are not checked
+func (config *Config) ForbidValues(rules map[string][]string) []PolicyViolation {
+	var violations []PolicyViolation
+	var check func(block *Block, path []string)
+	check = func(block *Block, path []string) {
+		if foreignDirectiveBlocks[block.Name] {
+			return
+		}
+		for _, line := range block.Lines {
+			switch line.Type {
+			case LineTypeDirective:
+				for _, value := range rules[line.Name] {
+					if forbiddenParam(line.Params, value) {
+						violations = append(violations, PolicyViolation{
+							Directive:  line.Name,
+							Value:      value,
+							Params:     line.Params,
+							Path:       path,
+							LineNumber: line.LineNumber,
+							Line:       line,
+						})
+					}
+				}
+			case LineTypeBlock:
+				if line.BlockRef != nil {
+					check(line.BlockRef, appendPath(path, blockKey(line.BlockRef)))
+				}
+			}
+		}
+	}
+	check(config.RootBlock, nil)
+
+	return violations
+}
+
+// forbiddenParam reports whether one of the parameters is the forbidden value
+func forbiddenParam(params []string, value string) bool {
+	for _, param := range params {
+		if strings.EqualFold(unquote(param), value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,260 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pragmaPrefix starts the comments read as pragmas, e.g. "# ngonx:disable server-names"
+const pragmaPrefix = "ngonx:"
+
+// Pragma names
+const (
+	PragmaDisable = "disable" // Suppresses lint rules for the node, all of them when none is named
+	PragmaKeep    = "keep"    // Protects the node from normalizing and consolidating transforms
+)
+
+// pragmaExpiryLayout is the date format of the until= argument of disable
+const pragmaExpiryLayout = "2006-01-02"
+
+// Pragma is an instruction to ngonx written in a comment on the line above a
+// directive or block, or trailing it:
+//
+//	# ngonx:disable ssl-weak-protocols until=2025-07-01
+//	ssl_protocols TLSv1 TLSv1.2;
+//	server { # ngonx:keep
+//
+// A pragma on a block header applies to the whole block. Several comment lines
+// may precede the node, without blank lines in between
+type Pragma struct {
+	Name       string    // Pragma name, e.g. disable or keep, unknown ones as written
+	Rules      []string  // Lint rules disabled, all when empty
+	Until      time.Time // Last day a disable pragma applies, zero if it does not expire
+	LineNumber int       // Line number of the comment holding the pragma
+	Text       string    // Comment text as written
+
+	problem string // Why the pragma is malformed, reported by ValidatePragmas
+}
+
+// parsePragma reads a pragma from the text of a comment
+func parsePragma(comment string, lineNumber int) (Pragma, bool) {
+	if !strings.HasPrefix(comment, pragmaPrefix) {
+		return Pragma{}, false
+	}
+	fields := strings.Fields(strings.TrimPrefix(comment, pragmaPrefix))
+	if len(fields) == 0 {
+		return Pragma{LineNumber: lineNumber, Text: comment, problem: "pragma without a name"}, true
+	}
+
+	pragma := Pragma{Name: fields[0], LineNumber: lineNumber, Text: comment}
+	switch pragma.Name {
+	case PragmaDisable:
+		for _, field := range fields[1:] {
+			if date, ok := strings.CutPrefix(field, "until="); ok {
+				until, err := time.Parse(pragmaExpiryLayout, date)
+				if err != nil {
+					pragma.problem = fmt.Sprintf("invalid expiry date %q, expected YYYY-MM-DD", date)
+					continue
+				}
+				pragma.Until = until
+				continue
+			}
+			for _, rule := range strings.Split(field, ",") {
+				if rule != "" {
+					pragma.Rules = appendUnique(pragma.Rules, rule)
+				}
+			}
+		}
+	case PragmaKeep:
+		if len(fields) > 1 {
+			pragma.problem = "ngonx:keep takes no arguments"
+		}
+	default:
+		pragma.problem = fmt.Sprintf("unknown pragma %s%s", pragmaPrefix, pragma.Name)
+	}
+	return pragma, true
+}
+
+// commentPragmas returns the pragmas of the comments of a line
+func commentPragmas(comments []string, lineNumber int) []Pragma {
+	var pragmas []Pragma
+	for _, comment := range comments {
+		if pragma, ok := parsePragma(comment, lineNumber); ok {
+			pragmas = append(pragmas, pragma)
+		}
+	}
+	return pragmas
+}
+
+// pragmaTarget returns the line the comment line at index i of the block gives its
+// pragmas to: the first directive or block after the comment lines directly
+// following it, nil when a blank line or the end of the block comes first
+func pragmaTarget(block *Block, i int) *Line {
+	for j := i + 1; j < len(block.Lines); j++ {
+		line, previous := block.Lines[j], block.Lines[j-1]
+		if line.LineNumber > previous.LineNumber+1 {
+			return nil
+		}
+		if line.Type != LineTypeComment {
+			return line
+		}
+	}
+	return nil
+}
+
+// attachPragmas parses the pragmas of the comments under root and sets them on the
+// lines and blocks they apply to
+func attachPragmas(root *Block) {
+	walkBlock(root, func(block *Block) {
+		for _, line := range block.Lines {
+			if line.Type != LineTypeComment {
+				line.Pragmas = commentPragmas(line.Comments, line.LineNumber)
+			}
+		}
+		for i, line := range block.Lines {
+			if line.Type != LineTypeComment {
+				continue
+			}
+			if target := pragmaTarget(block, i); target != nil {
+				target.Pragmas = append(target.Pragmas, commentPragmas(line.Comments, line.LineNumber)...)
+			}
+		}
+		for _, line := range block.Lines {
+			if line.BlockRef != nil {
+				line.BlockRef.Pragmas = line.Pragmas
+			}
+		}
+	})
+}
+
+// hasPragma reports whether the pragmas hold one with the given name
+func hasPragma(pragmas []Pragma, name string) bool {
+	for _, pragma := range pragmas {
+		if pragma.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// kept reports whether an ngonx:keep pragma protects the block, on it or on a
+// block enclosing it
+func (block *Block) kept() bool {
+	for current := block; current != nil; current = current.ParentRef {
+		if hasPragma(current.Pragmas, PragmaKeep) {
+			return true
+		}
+	}
+	return false
+}
+
+// keptLine reports whether an ngonx:keep pragma protects a line of the block
+func keptLine(block *Block, line *Line) bool {
+	return hasPragma(line.Pragmas, PragmaKeep) || block.kept()
+}
+
+// disables reports whether the pragma disables a lint rule
+func (pragma Pragma) disables(rule string) bool {
+	if pragma.Name != PragmaDisable {
+		return false
+	}
+	if len(pragma.Rules) == 0 {
+		return true
+	}
+	return hasParam(pragma.Rules, rule)
+}
+
+// Expired reports whether a disable pragma no longer applies at the given time,
+// the day after its until= date
+func (pragma Pragma) Expired(now time.Time) bool {
+	return !pragma.Until.IsZero() && !now.Before(pragma.Until.AddDate(0, 0, 1))
+}
+
+// suppression is a disable pragma and the lines it covers
+type suppression struct {
+	pragma   Pragma
+	from, to int
+}
+
+// suppressions lists the disable pragmas of the configuration with the lines they
+// cover: the line of a directive, the lines of a block down to its closing brace
+func (config *Config) suppressions() []suppression {
+	var suppressions []suppression
+	config.WalkBlocks(func(block *Block) {
+		for _, line := range block.Lines {
+			for _, pragma := range line.Pragmas {
+				if pragma.Name != PragmaDisable || pragma.problem != "" {
+					continue
+				}
+				covered := suppression{pragma: pragma, from: line.LineNumber, to: line.LineNumber}
+				if line.BlockRef != nil && line.BlockRef.EndLineNumber > line.LineNumber {
+					covered.to = line.BlockRef.EndLineNumber
+				}
+				suppressions = append(suppressions, covered)
+			}
+		}
+	})
+	return suppressions
+}
+
+// suppress reports whether a disable pragma in effect covers the finding. A finding
+// only covered by expired pragmas is re-raised with a note on the expiry
+func suppress(suppressions []suppression, finding *ValidationError, now time.Time) bool {
+	var expired *Pragma
+	for i := range suppressions {
+		covered := &suppressions[i]
+		if finding.LineNumber < covered.from || finding.LineNumber > covered.to || !covered.pragma.disables(finding.Rule) {
+			continue
+		}
+		if !covered.pragma.Expired(now) {
+			return true
+		}
+		expired = &covered.pragma
+	}
+	if expired != nil {
+		finding.Message += fmt.Sprintf(" (suppressed until %s by the pragma at line %d)", expired.Until.Format(pragmaExpiryLayout), expired.LineNumber)
+	}
+	return false
+}
+
+// ValidatePragmas reports malformed pragmas: unknown pragma names, typos included,
+// lint rules that do not exist, invalid expiry dates and pragmas on comment lines
+// not followed by a directive or block they could apply to
+func (config *Config) ValidatePragmas() []ValidationError {
+	rules := map[string]bool{}
+	for _, rule := range LintRules() {
+		rules[rule.Name] = true
+	}
+
+	var issues []ValidationError
+	report := func(pragma Pragma, message string) {
+		issues = append(issues, ValidationError{
+			Severity:   SeverityWarning,
+			Directive:  pragmaPrefix + pragma.Name,
+			Message:    message,
+			LineNumber: pragma.LineNumber,
+		})
+	}
+
+	config.WalkBlocks(func(block *Block) {
+		for i, line := range block.Lines {
+			pragmas := commentPragmas(line.Comments, line.LineNumber)
+			if line.Type == LineTypeComment && len(pragmas) > 0 && pragmaTarget(block, i) == nil {
+				report(pragmas[0], "pragma is not followed by a directive or block it could apply to")
+				continue
+			}
+			for _, pragma := range pragmas {
+				if pragma.problem != "" {
+					report(pragma, pragma.problem)
+				}
+				for _, rule := range pragma.Rules {
+					if !rules[rule] {
+						report(pragma, fmt.Sprintf("unknown lint rule %q", rule))
+					}
+				}
+			}
+		}
+	})
+	return issues
+}
@@ -0,0 +1,81 @@
+package nginx
+
+// WalkBlocks calls fn for every block in the tree in depth-first order, starting with the root
+func (config *Config) WalkBlocks(fn func(block *Block)) {
+	walkBlock(config.RootBlock, fn)
+}
+
+// walkBlock recursively visits a block and its children
+func walkBlock(block *Block, fn func(block *Block)) {
+	fn(block)
+	for _, child := range block.Blocks {
+		walkBlock(child, fn)
+	}
+}
+
+// FindBlocksByName returns all blocks with the given name anywhere in the tree
+func (config *Config) FindBlocksByName(name string) []*Block {
+	var blocks []*Block
+	config.WalkBlocks(func(block *Block) {
+		if block.Name == name {
+			blocks = append(blocks, block)
+		}
+	})
+	return blocks
+}
+
+// FindLinesByName returns all directive and include lines with the given name anywhere in the tree
+func (config *Config) FindLinesByName(name string) []*Line {
+	var lines []*Line
+	config.WalkBlocks(func(block *Block) {
+		lines = append(lines, block.FindLines(name)...)
+	})
+	return lines
+}
+
+// FindLines returns the directive and include lines with the given name directly in this block
+func (block *Block) FindLines(name string) []*Line {
+	var lines []*Line
+	for _, line := range block.Lines {
+		if line.Type != LineTypeDirective && line.Type != LineTypeInclude {
+			continue
+		}
+		if line.Name == name {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// FindBlocks returns the direct child blocks with the given name
+func (block *Block) FindBlocks(name string) []*Block {
+	var blocks []*Block
+	for _, child := range block.Blocks {
+		if child.Name == name {
+			blocks = append(blocks, child)
+		}
+	}
+	return blocks
+}
+
+// Ancestor returns the closest enclosing block with the given name, or nil
+func (block *Block) Ancestor(name string) *Block {
+	for parent := block.ParentRef; parent != nil; parent = parent.ParentRef {
+		if parent.Name == name {
+			return parent
+		}
+	}
+	return nil
+}
+
+// EffectiveDirective returns the directive that applies to the block through
+// inheritance: the last occurrence in the block itself, otherwise the closest
+// enclosing block that sets it. Returns nil if the directive is never set
+func (block *Block) EffectiveDirective(name string) *Line {
+	for current := block; current != nil; current = current.ParentRef {
+		if lines := current.FindLines(name); len(lines) > 0 {
+			return lines[len(lines)-1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package nginx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// rateLimitDirectives maps the directives applying a zone to the directives defining it
+var rateLimitDirectives = map[string]string{
+	"limit_req":  "limit_req_zone",
+	"limit_conn": "limit_conn_zone",
+}
+
+// RateLimitUsage is a limit_req or limit_conn directive applying a zone
+type RateLimitUsage struct {
+	Line    *Line
+	Path    []string // Enclosing blocks from the outermost (e.g. ["http", "server example.com", "location /api/"])
+	Burst   int      // limit_req burst, 0 if unset
+	Delay   int      // limit_req delay, requests beyond it are delayed, 0 if unset
+	NoDelay bool     // limit_req nodelay
+	Conn    int      // limit_conn maximum number of connections
+}
+
+// RateLimit is a limit_req_zone or limit_conn_zone definition and the directives using it
+type RateLimit struct {
+	Zone      string           // Zone name
+	Module    string           // Defining directive, limit_req_zone or limit_conn_zone
+	Context   string           // http or stream, zones of the two are distinct
+	Key       string           // Key the requests or connections are counted by, e.g. $binary_remote_addr
+	Size      int64            // Zone size in bytes, 0 if not specified
+	Rate      string           // limit_req_zone rate as written, e.g. 10r/s
+	PerSecond float64          // Rate in requests per second, 0 for limit_conn_zone
+	Line      *Line            // Defining directive, nil when the zone is used but never defined
+	Usages    []RateLimitUsage // Directives applying the zone in configuration order
+}
+
+// RateLimits pairs limit_req_zone and limit_conn_zone definitions with the
+// limit_req and limit_conn directives using them. Zones are listed in definition
+// order, followed by zones that are used but never defined
+func (config *Config) RateLimits() []RateLimit {
+	var limits []*RateLimit
+	index := map[string]*RateLimit{}
+	lookup := func(module, context, zone string) *RateLimit {
+		key := module + " " + context + " " + zone
+		if index[key] == nil {
+			index[key] = &RateLimit{Zone: zone, Module: module, Context: context}
+			limits = append(limits, index[key])
+		}
+		return index[key]
+	}
+
+	var collect func(block *Block, path []string, context string)
+	collect = func(block *Block, path []string, context string) {
+		if block.Name == "http" || block.Name == "stream" {
+			context = block.Name
+		}
+		for _, line := range block.Lines {
+			if line.Type != LineTypeDirective {
+				continue
+			}
+			if line.Name == "limit_req_zone" || line.Name == "limit_conn_zone" {
+				zone, ok := parseZoneParam(line, "zone", limitZoneStatesPerMB)
+				if !ok {
+					continue
+				}
+				limit := lookup(line.Name, context, zone.Name)
+				limit.Line, limit.Size = line, zone.Size
+				if len(line.Params) > 0 {
+					limit.Key = unquote(line.Params[0])
+				}
+				if rate, ok := line.KeyValueParams()["rate"]; ok {
+					limit.Rate = rate
+					limit.PerSecond, _ = parseRate(rate)
+				}
+				continue
+			}
+
+			module, ok := rateLimitDirectives[line.Name]
+			if !ok || len(line.Params) == 0 {
+				continue
+			}
+			usage := RateLimitUsage{Line: line, Path: path}
+			zone := unquote(line.Params[0])
+			if line.Name == "limit_req" {
+				params := line.KeyValueParams()
+				zone = params["zone"]
+				usage.Burst, _ = strconv.Atoi(params["burst"])
+				usage.Delay, _ = strconv.Atoi(params["delay"])
+				usage.NoDelay = hasParam(line.Params, "nodelay")
+			} else if len(line.Params) > 1 {
+				usage.Conn, _ = strconv.Atoi(line.Params[1])
+			}
+			if zone == "" {
+				continue
+			}
+			limit := lookup(module, context, zone)
+			limit.Usages = append(limit.Usages, usage)
+		}
+		for _, child := range block.Blocks {
+			collect(child, appendPath(path, blockKey(child)), context)
+		}
+	}
+	collect(config.RootBlock, nil, "")
+
+	var defined, undefined []RateLimit
+	for _, limit := range limits {
+		if limit.Line != nil {
+			defined = append(defined, *limit)
+		} else {
+			undefined = append(undefined, *limit)
+		}
+	}
+	return append(defined, undefined...)
+}
+
+// ValidateRateLimits reports zones defined but never used and limit_req or
+// limit_conn directives referring to zones that are not defined, which nginx
+// rejects at startup
+func (config *Config) ValidateRateLimits() []ValidationIssue {
+	var issues []ValidationIssue
+	for _, limit := range config.RateLimits() {
+		switch {
+		case limit.Line == nil:
+			for _, usage := range limit.Usages {
+				issues = append(issues, ValidationIssue{
+					Severity:   SeverityError,
+					Directive:  usage.Line.Name,
+					Message:    fmt.Sprintf("zone %q is not defined by %s", limit.Zone, limit.Module),
+					LineNumber: usage.Line.LineNumber,
+				})
+			}
+		case len(limit.Usages) == 0:
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityWarning,
+				Directive:  limit.Module,
+				Message:    fmt.Sprintf("zone %q is defined but never used", limit.Zone),
+				LineNumber: limit.Line.LineNumber,
+			})
+		}
+	}
+	return issues
+}
+
+// parseRate converts a limit_req_zone rate such as 10r/s or 30r/m to requests per second
+func parseRate(rate string) (float64, error) {
+	count, unit, ok := strings.Cut(rate, "r/")
+	if !ok {
+		return 0, fmt.Errorf("invalid rate %q", rate)
+	}
+	value, err := strconv.ParseFloat(count, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", rate)
+	}
+	switch unit {
+	case "s":
+		return value, nil
+	case "m":
+		return value / 60, nil
+	}
+	return 0, fmt.Errorf("invalid rate %q", rate)
+}
@@ -0,0 +1,243 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Reachability classifies how requests can reach a location
+type Reachability string
+
+const (
+	ReachabilityExternal    Reachability = "external"    // Client requests select the location
+	ReachabilityInternal    Reachability = "internal"    // Only internal redirects reach the location
+	ReachabilityUnreachable Reachability = "unreachable" // Internal, and nothing redirects to it
+)
+
+// xAccelHandlers maps upstream content handlers to the directive that makes them
+// ignore the X-Accel-Redirect header of upstream responses
+var xAccelHandlers = map[string]string{
+	"proxy_pass":   "proxy_ignore_headers",
+	"fastcgi_pass": "fastcgi_ignore_headers",
+	"uwsgi_pass":   "uwsgi_ignore_headers",
+	"scgi_pass":    "scgi_ignore_headers",
+}
+
+// internalAuthDirectives lists access checks that are pointless in internal locations
+// when they are meant to protect against client requests
+var internalAuthDirectives = []string{"auth_basic", "auth_request", "auth_jwt"}
+
+// xAccelTargetHints are path words suggesting a location serves X-Accel-Redirect responses
+var xAccelTargetHints = []string{"protected", "private", "internal", "secure"}
+
+// LocationReachability describes how requests reach a location and which directives
+// make it reachable
+type LocationReachability struct {
+	Location     *Location
+	Server       *Block       // Server holding the location
+	Reachability Reachability // Classification of the location
+	Internal     *Line        // internal directive, nil for external and named locations
+	Redirects    []*Line      // Directives redirecting internally to the location
+	XAccel       []*Line      // Upstream handlers whose X-Accel-Redirect responses may reach the location
+}
+
+// LocationReachability classifies every location of every server. Locations without
+// internal are externally reachable. Named locations and locations marked internal
+// are reachable through error_page, try_files, auth_request, mirror and rewrite
+// directives whose literal target selects them, and through X-Accel-Redirect
+// responses of the upstream handlers of their server; otherwise they are unreachable
+func (config *Config) LocationReachability() []LocationReachability {
+	var results []LocationReachability
+	references := config.NamedLocationReferences()
+
+	for _, server := range config.FindBlocksByName("server") {
+		if serverProtocol(server) != "http" {
+			continue
+		}
+		sources, handlers := internalRedirectSources(server)
+
+		walkBlock(server, func(block *Block) {
+			location := NewLocation(block)
+			if location == nil {
+				return
+			}
+
+			result := LocationReachability{Location: location, Server: server, Reachability: ReachabilityExternal}
+			if lines := block.FindLines("internal"); len(lines) > 0 {
+				result.Internal = lines[0]
+			}
+			if result.Internal == nil && !location.IsNamed() {
+				results = append(results, result)
+				return
+			}
+
+			if location.IsNamed() {
+				for _, reference := range references {
+					if reference.Server == server && reference.Name == location.Pattern {
+						result.Redirects = append(result.Redirects, reference.Line)
+					}
+				}
+			} else {
+				for _, line := range sources {
+					if target, ok := internalRedirectTarget(line); ok {
+						if selected := server.FindLocationByURI(target); selected != nil && selected.Block == block {
+							result.Redirects = append(result.Redirects, line)
+						}
+					}
+				}
+			}
+			result.XAccel = handlers
+
+			result.Reachability = ReachabilityInternal
+			if len(result.Redirects) == 0 && len(result.XAccel) == 0 {
+				result.Reachability = ReachabilityUnreachable
+			}
+			results = append(results, result)
+		})
+	}
+
+	return results
+}
+
+// ValidateLocationReachability reports internal locations nothing redirects to,
+// authentication in internal locations, which never runs for client requests, and
+// locations that look like X-Accel-Redirect targets but are not marked internal.
+// Unreferenced named locations are left to ValidateNamedLocations
+func (config *Config) ValidateLocationReachability() []ValidationIssue {
+	var issues []ValidationIssue
+
+	for _, result := range config.LocationReachability() {
+		block := result.Location.Block
+		name := strings.Join(block.Params, " ")
+
+		switch result.Reachability {
+		case ReachabilityUnreachable:
+			if !result.Location.IsNamed() {
+				issues = append(issues, ValidationIssue{
+					Severity:   SeverityWarning,
+					Directive:  block.Name,
+					Message:    fmt.Sprintf("internal location %s is never the target of an internal redirect", name),
+					LineNumber: block.LineNumber,
+				})
+			}
+		case ReachabilityExternal:
+			if isXAccelTargetLike(result.Location) && len(upstreamHandlers(result.Server, false)) > 0 {
+				issues = append(issues, ValidationIssue{
+					Severity:   SeverityWarning,
+					Directive:  block.Name,
+					Message:    fmt.Sprintf("location %s looks like an X-Accel-Redirect target but is not internal, clients can request it directly", name),
+					LineNumber: block.LineNumber,
+				})
+			}
+			continue
+		}
+
+		for _, directive := range internalAuthDirectives {
+			for _, line := range block.FindLines(directive) {
+				if len(line.Params) > 0 && line.Params[0] == "off" {
+					continue
+				}
+				issues = append(issues, ValidationIssue{
+					Severity:   SeverityInfo,
+					Directive:  line.Name,
+					Message:    fmt.Sprintf("%s in internal location %s never runs for client requests, which are rejected before it", line.Name, name),
+					LineNumber: line.LineNumber,
+				})
+			}
+		}
+	}
+
+	return issues
+}
+
+// internalRedirectSources returns the directives of a server that may redirect
+// internally to a URI, including error_page directives it inherits, and its upstream
+// handlers that honor X-Accel-Redirect
+func internalRedirectSources(server *Block) (sources, handlers []*Line) {
+	for parent := server.ParentRef; parent != nil; parent = parent.ParentRef {
+		sources = append(sources, parent.FindLines("error_page")...)
+	}
+	walkBlock(server, func(block *Block) {
+		for _, line := range block.Lines {
+			if _, ok := internalRedirectTarget(line); ok {
+				sources = append(sources, line)
+			}
+		}
+	})
+	return sources, upstreamHandlers(server, true)
+}
+
+// internalRedirectTarget returns the literal URI path a directive redirects to
+// internally. ok is false for other directives and targets built from variables
+func internalRedirectTarget(line *Line) (string, bool) {
+	if line.Type != LineTypeDirective || len(line.Params) == 0 {
+		return "", false
+	}
+
+	var target string
+	switch line.Name {
+	case "error_page", "try_files":
+		target = unquote(line.Params[len(line.Params)-1])
+	case "auth_request", "mirror":
+		target = unquote(line.Params[0])
+	case "rewrite":
+		if len(line.Params) < 2 || isRedirectRewrite(line) {
+			return "", false
+		}
+		target = unquote(line.Params[1])
+	default:
+		return "", false
+	}
+
+	target, _, _ = strings.Cut(target, "?")
+	if !strings.HasPrefix(target, "/") || strings.Contains(target, "$") {
+		return "", false
+	}
+	return target, true
+}
+
+// upstreamHandlers returns the proxy_pass, fastcgi_pass, uwsgi_pass and scgi_pass
+// directives of a server, only those honoring X-Accel-Redirect if honoring is set
+func upstreamHandlers(server *Block, honoring bool) []*Line {
+	var lines []*Line
+	walkBlock(server, func(block *Block) {
+		for _, line := range block.Lines {
+			ignoreDirective, ok := xAccelHandlers[line.Name]
+			if !ok || line.Type != LineTypeDirective || (honoring && ignoresXAccel(block, ignoreDirective)) {
+				continue
+			}
+			lines = append(lines, line)
+		}
+	})
+	return lines
+}
+
+// ignoresXAccel reports whether the *_ignore_headers directive in effect in scope
+// makes upstream handlers ignore X-Accel-Redirect headers
+func ignoresXAccel(scope *Block, ignoreDirective string) bool {
+	line := scope.EffectiveDirective(ignoreDirective)
+	if line == nil {
+		return false
+	}
+	for _, param := range line.Params {
+		if strings.EqualFold(unquote(param), "X-Accel-Redirect") {
+			return true
+		}
+	}
+	return false
+}
+
+// isXAccelTargetLike reports whether the path of a location suggests it serves files
+// handed out through X-Accel-Redirect
+func isXAccelTargetLike(location *Location) bool {
+	if location.IsRegex() || location.IsNamed() {
+		return false
+	}
+	path := strings.ToLower(location.Pattern)
+	for _, hint := range xAccelTargetHints {
+		if strings.Contains(path, hint) {
+			return len(location.Block.FindLines("alias")) > 0 || len(location.Block.FindLines("root")) > 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package nginx
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+// Access rights checked by CheckFilesReadable, as "other" permission bits
+const (
+	accessRead    os.FileMode = 04
+	accessExecute os.FileMode = 01
+)
+
+// CheckFilesReadable verifies that every file the configuration refers to (see
+// ExtractAllFilePaths) exists and can be opened, resolving relative paths against
+// the directory of the configuration file. Log files may be missing as long as
+// their directory exists, nginx creates them. With runAsUser, the permission bits
+// of each file and its parent directories must also let that user read it, as
+// nginx workers do, since this process may run with more privileges
+func (config *Config) CheckFilesReadable(runAsUser string) []ValidationError {
+	var account *fileAccount
+	if runAsUser != "" {
+		u, err := user.Lookup(runAsUser)
+		if err == nil {
+			account, err = newFileAccount(u)
+		}
+		if err != nil {
+			return []ValidationError{{Severity: SeverityError, Message: fmt.Sprintf("cannot check permissions of user %q: %v", runAsUser, err)}}
+		}
+	}
+
+	var issues []ValidationError
+	report := func(line *Line, format string, args ...interface{}) {
+		issues = append(issues, ValidationError{
+			Severity:   SeverityError,
+			Directive:  line.Name,
+			Message:    fmt.Sprintf(format, args...),
+			LineNumber: line.LineNumber,
+		})
+	}
+
+	baseDir := filepath.Dir(config.FilePath)
+	checked := map[string]bool{}
+	for _, reference := range config.fileReferences() {
+		paths := []string{resolveIncludePath(baseDir, reference.Path)}
+		if isGlobPattern(paths[0]) {
+			// Globs matching no files are tolerated by nginx
+			paths, _ = globPaths(rootFS{}, paths[0])
+		}
+
+		for _, path := range paths {
+			if checked[path] {
+				continue
+			}
+			checked[path] = true
+
+			local := localPath(path)
+			info, err := os.Stat(local)
+			if os.IsNotExist(err) && (reference.Line.Name == "access_log" || reference.Line.Name == "error_log") {
+				if _, err := os.Stat(filepath.Dir(local)); err != nil {
+					report(reference.Line, "directory of log file %q does not exist", path)
+				}
+				continue
+			}
+			if err != nil {
+				report(reference.Line, "file %q does not exist", path)
+				continue
+			}
+			file, err := os.Open(local)
+			if err != nil {
+				report(reference.Line, "file %q cannot be read: %v", path, err)
+				continue
+			}
+			file.Close()
+
+			if account != nil {
+				if denied := account.deniedDirectory(local); denied != "" {
+					report(reference.Line, "user %s cannot reach %q, directory %q is not searchable", runAsUser, path, denied)
+				} else if !account.permits(info, accessRead) || (info.IsDir() && !account.permits(info, accessExecute)) {
+					report(reference.Line, "user %s cannot read %q (mode %s)", runAsUser, path, info.Mode().Perm())
+				}
+			}
+		}
+	}
+
+	return issues
+}
+
+// deniedDirectory returns the first parent directory of path the account may not
+// search, empty if it may reach the path
+func (account *fileAccount) deniedDirectory(path string) string {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	var parents []string
+	for dir := filepath.Dir(absolute); ; dir = filepath.Dir(dir) {
+		parents = append([]string{dir}, parents...)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	for _, dir := range parents {
+		info, err := os.Stat(dir)
+		if err == nil && !account.permits(info, accessExecute) {
+			return dir
+		}
+	}
+	return ""
+}
@@ -0,0 +1,28 @@
+//go:build !unix
+
+package nginx
+
+import (
+	"os"
+	"os/user"
+)
+
+// fileAccount is the identity file permissions are checked for. Permission bits
+// are not checked on platforms without Unix file ownership
+type fileAccount struct{}
+
+// newFileAccount returns an account granted every access
+func newFileAccount(u *user.User) (*fileAccount, error) {
+	return &fileAccount{}, nil
+}
+
+// permits reports whether the account may access the file, always true here
+func (account *fileAccount) permits(info os.FileInfo, access os.FileMode) bool {
+	return true
+}
+
+// dirWritable reports whether this process may create files in a directory, always
+// true here
+func dirWritable(dir string) bool {
+	return true
+}
@@ -0,0 +1,60 @@
+//go:build unix
+
+package nginx
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// fileAccount is the identity file permissions are checked for
+type fileAccount struct {
+	uid    uint32
+	groups map[uint32]bool
+}
+
+// newFileAccount resolves the user and group IDs of a user account
+func newFileAccount(u *user.User) (*fileAccount, error) {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	account := &fileAccount{uid: uint32(uid), groups: map[uint32]bool{}}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		groupIDs = nil
+	}
+	for _, id := range append(groupIDs, u.Gid) {
+		if gid, err := strconv.ParseUint(id, 10, 32); err == nil {
+			account.groups[uint32(gid)] = true
+		}
+	}
+	return account, nil
+}
+
+// permits reports whether the permission bits of a file grant the account the
+// access, given as "other" bits. root is granted everything
+func (account *fileAccount) permits(info os.FileInfo, access os.FileMode) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || account.uid == 0 {
+		return true
+	}
+
+	mode := info.Mode().Perm()
+	switch {
+	case stat.Uid == account.uid:
+		return mode&(access<<6) != 0
+	case account.groups[stat.Gid]:
+		return mode&(access<<3) != 0
+	}
+	return mode&access != 0
+}
+
+// dirWritable reports whether this process may create files in a directory
+func dirWritable(dir string) bool {
+	const writeAccess = 2 // W_OK of access(2)
+	return syscall.Access(dir, writeAccess) == nil
+}
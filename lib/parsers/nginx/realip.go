@@ -0,0 +1,217 @@
+package nginx
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// defaultRealIPHeader is the header real_ip_header defaults to
+const defaultRealIPHeader = "X-Real-IP"
+
+// RealIPSource is a set_real_ip_from directive: a proxy trusted to report the client address
+type RealIPSource struct {
+	Address string     // Address as written: an address, a CIDR network, a host name or "unix:"
+	Network *net.IPNet // Network trusted, nil for "unix:", host names and invalid addresses
+	Line    *Line
+}
+
+// RealIP is the ngx_http_realip_module configuration in effect in a block
+type RealIP struct {
+	Trusted   []RealIPSource // Trusted proxies, inherited as a whole from the closest block defining any
+	Header    string         // Header the client address is taken from, or "proxy_protocol"
+	Recursive bool           // Whether trusted addresses are skipped when walking the header from the right
+	Line      *Line          // real_ip_header in effect, nil for the default X-Real-IP
+}
+
+// RealIP returns the real_ip configuration in effect in the block, nil when no
+// set_real_ip_from applies and the client address is the connection address
+func (block *Block) RealIP() *RealIP {
+	lines, _ := block.inheritedLines("set_real_ip_from")
+	if len(lines) == 0 {
+		return nil
+	}
+
+	realIP := &RealIP{Header: defaultRealIPHeader}
+	for _, line := range lines {
+		if len(line.Params) == 0 {
+			continue
+		}
+		rule := parseACLRule(line)
+		realIP.Trusted = append(realIP.Trusted, RealIPSource{Address: rule.Address, Network: rule.Network, Line: line})
+	}
+	if line := block.EffectiveDirective("real_ip_header"); line != nil && len(line.Params) > 0 {
+		realIP.Header = unquote(line.Params[0])
+		realIP.Line = line
+	}
+	if line := block.EffectiveDirective("real_ip_recursive"); line != nil && len(line.Params) > 0 {
+		realIP.Recursive = unquote(line.Params[0]) == "on"
+	}
+	return realIP
+}
+
+// Trusts reports whether an address is a trusted proxy. An empty address stands
+// for a client connected over a UNIX socket
+func (realIP *RealIP) Trusts(address string) bool {
+	ip := net.ParseIP(address)
+	for _, source := range realIP.Trusted {
+		switch {
+		case source.Address == "unix:" && address == "":
+			return true
+		case source.Network != nil && ip != nil && source.Network.Contains(ip):
+			return true
+		}
+	}
+	return false
+}
+
+// EffectiveClientAddr predicts the client address nginx logs and keys rate limits
+// on for a connection from remoteAddr (with or without a port) carrying the values
+// of the real_ip_header header, or the PROXY protocol address for proxy_protocol.
+// Connections from untrusted addresses keep their address. Otherwise the header
+// is read from the right: the last address, or with real_ip_recursive the last one
+// that is not trusted, the first when all are. An invalid address leaves the
+// connection address in place
+func (realIP *RealIP) EffectiveClientAddr(remoteAddr string, xff []string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+	if !realIP.Trusts(remoteAddr) {
+		return remoteAddr
+	}
+
+	var addresses []string
+	for _, value := range xff {
+		for _, address := range strings.Split(value, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return remoteAddr
+	}
+	if !strings.EqualFold(realIP.Header, "X-Forwarded-For") {
+		// Other headers and the PROXY protocol carry a single address
+		addresses = addresses[len(addresses)-1:]
+	}
+
+	for i := len(addresses) - 1; i >= 0; i-- {
+		ip := net.ParseIP(addresses[i])
+		if ip == nil {
+			return remoteAddr
+		}
+		if i == 0 || !realIP.Recursive || !realIP.Trusts(addresses[i]) {
+			return ip.String()
+		}
+	}
+	return remoteAddr
+}
+
+// ValidateRealIP checks set_real_ip_from addresses, trusting every address, which
+// lets any client choose its address, and rate limits keyed on the client address
+// of servers behind a load balancer: with the balancer trusted the key comes from a
+// header, without it every client shares the balancer's bucket
+func (config *Config) ValidateRealIP() []ValidationIssue {
+	var issues []ValidationIssue
+	for _, line := range config.FindLinesByName("set_real_ip_from") {
+		if len(line.Params) == 0 {
+			continue
+		}
+		rule := parseACLRule(line)
+		switch {
+		case rule.Address == "unix:":
+		case rule.Network == nil && !strings.Contains(rule.Address, "/"):
+			// Host names are resolved when nginx starts
+		case rule.Network == nil:
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityError,
+				Directive:  line.Name,
+				Message:    fmt.Sprintf("invalid address %q", rule.Address),
+				LineNumber: line.LineNumber,
+			})
+		case isAnyNetwork(rule.Network):
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityWarning,
+				Directive:  line.Name,
+				Message:    fmt.Sprintf("set_real_ip_from %s trusts every client, anyone can set the address logged and rate limited", rule.Address),
+				LineNumber: line.LineNumber,
+			})
+		case !rule.Network.IP.Equal(networkAddress(rule.Address)):
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityWarning,
+				Directive:  line.Name,
+				Message:    fmt.Sprintf("low address bits of %s are meaningless, the rule trusts %s", rule.Address, rule.Network),
+				LineNumber: line.LineNumber,
+			})
+		}
+	}
+
+	zoneKeys := map[string]string{}
+	for _, line := range config.FindLinesByName("limit_req_zone") {
+		if zone, ok := parseZoneParam(line, "zone", limitZoneStatesPerMB); ok && len(line.Params) > 0 {
+			zoneKeys[zone.Name] = unquote(line.Params[0])
+		}
+	}
+	config.WalkBlocks(func(block *Block) {
+		for _, line := range block.FindLines("limit_req") {
+			key := zoneKeys[line.KeyValueParams()["zone"]]
+			if (key != "$binary_remote_addr" && key != "$remote_addr") || block.Ancestor("http") == nil {
+				continue
+			}
+			var message string
+			realIP := block.RealIP()
+			switch {
+			case realIP != nil && realIP.Header != "proxy_protocol":
+				message = fmt.Sprintf("limit_req keys on %s, which real_ip takes from %s sent through the trusted proxies, clients can pick their bucket unless the proxies overwrite the header", key, realIP.Header)
+			case realIP == nil && behindProxy(block):
+				message = fmt.Sprintf("limit_req keys on %s behind a load balancer that set_real_ip_from does not trust, every client shares the balancer's bucket", key)
+			default:
+				continue
+			}
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityWarning,
+				Directive:  line.Name,
+				Message:    message,
+				LineNumber: line.LineNumber,
+			})
+		}
+	})
+
+	return issues
+}
+
+// behindProxy reports whether the servers a block applies to receive their traffic
+// through a proxy: they accept the PROXY protocol or name a real_ip_header
+func behindProxy(block *Block) bool {
+	if block.EffectiveDirective("real_ip_header") != nil {
+		return true
+	}
+	servers := []*Block{block}
+	if block.Name != "server" {
+		if server := block.Ancestor("server"); server != nil {
+			servers = []*Block{server}
+		} else {
+			servers = nil
+			walkBlock(block, func(child *Block) {
+				if child.Name == "server" {
+					servers = append(servers, child)
+				}
+			})
+		}
+	}
+	for _, server := range servers {
+		for _, listen := range server.FindLines("listen") {
+			if hasParam(listen.Params, "proxy_protocol") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isAnyNetwork reports whether a network holds every IPv4 or every IPv6 address
+func isAnyNetwork(network *net.IPNet) bool {
+	ones, _ := network.Mask.Size()
+	return ones == 0
+}
@@ -0,0 +1,485 @@
+package nginx
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// redirectCodes lists the return codes that send the client to another URL
+var redirectCodes = map[string]bool{"301": true, "302": true, "303": true, "307": true, "308": true}
+
+// maxRedirectHops bounds how many redirects DetectRedirectLoops follows from one directive
+const maxRedirectHops = 16
+
+// samePath stands for the path of the redirected request when it is not known
+// statically, samePathTail for that path without its leading slash
+const (
+	samePath     = "\x00"
+	samePathTail = "\x01"
+)
+
+// universalRewrites maps rewrite regexes that match every URI to the value of their
+// first capture, empty when they have none
+var universalRewrites = map[string]string{
+	"^": "", ".*": "", "^.*": "", "^.*$": "", "^/": "", "^/.*": "", "^/.*$": "",
+	"(.*)": samePath, "^(.*)": samePath, "^(.*)$": samePath,
+	"^/(.*)": samePathTail, "^/(.*)$": samePathTail,
+}
+
+// rewriteCapturePattern matches references to regex captures in a rewrite replacement
+var rewriteCapturePattern = regexp.MustCompile(`\$(?:\{(\d)\}|(\d))`)
+
+// redirectHop is a request reaching a server during redirect analysis
+type redirectHop struct {
+	server *Block // Server handling the request
+	port   int    // Port the request arrives on
+	https  bool   // Whether the request arrives over TLS
+	path   string // Request path, samePath when only its location is known
+
+	// Server and location the unknown path is known to select, nil when the path is literal
+	pathServer   *Block
+	pathLocation *Block
+}
+
+// DetectRedirectLoops follows the external redirects issued by return 30x and by
+// rewrite ... redirect/permanent directives and reports redirects that lead back to
+// themselves, either directly or through other servers and locations. The analysis
+// is conservative: directives inside if blocks are ignored, and redirects whose
+// target depends on variables other than the request URI and host are not followed
+func (config *Config) DetectRedirectLoops() []ValidationIssue {
+	var issues []ValidationIssue
+	_, cycles := config.redirectChains()
+	for _, cycle := range cycles {
+		issues = append(issues, redirectLoopIssue(cycle))
+	}
+	return issues
+}
+
+// CheckRedirectChains follows redirects like DetectRedirectLoops and warns about
+// chains of several redirects, such as /a to /b to /c: every hop costs the client a
+// round trip and browsers give up after a limited number of them, so the first
+// redirect should point at the final target. Redirect cycles are reported as errors
+func (config *Config) CheckRedirectChains() []ValidationError {
+	chains, cycles := config.redirectChains()
+	issues := redirectChainIssues(chains)
+	for _, cycle := range cycles {
+		issues = append(issues, redirectLoopIssue(cycle))
+	}
+	return issues
+}
+
+// redirectChains follows the redirects starting in every http server and location
+// and returns the chains of more than one redirect, leaving out those that are the
+// tail of a longer one, and the redirect loops
+func (config *Config) redirectChains() (chains, cycles [][]*Line) {
+	reported := map[string]bool{}
+	followed := map[*Line]bool{}
+
+	for _, server := range config.FindBlocksByName("server") {
+		if serverProtocol(server) != "http" {
+			continue
+		}
+
+		scopes := []*Block{server}
+		walkBlock(server, func(block *Block) {
+			if block.Name == "location" {
+				scopes = append(scopes, block)
+			}
+		})
+
+		for _, endpoint := range serverEndpoints(server) {
+			for _, scope := range scopes {
+				start := redirectHop{server: server, port: endpoint.Port, https: endpoint.SSL, path: samePath, pathServer: server}
+				if scope != server {
+					start.pathLocation = scope
+				}
+				chain, loop := config.followRedirects(start)
+				for i := 1; i < len(chain); i++ {
+					followed[chain[i]] = true
+				}
+
+				switch {
+				case loop >= 0:
+					cycle := chain[loop:]
+					if key := cycleKey(cycle); !reported[key] {
+						reported[key] = true
+						cycles = append(cycles, cycle)
+					}
+				case len(chain) > 1:
+					if key := chainKey(chain); !reported[key] {
+						reported[key] = true
+						chains = append(chains, chain)
+					}
+				}
+			}
+		}
+	}
+
+	var maximal [][]*Line
+	for _, chain := range chains {
+		if !followed[chain[0]] {
+			maximal = append(maximal, chain)
+		}
+	}
+	return maximal, cycles
+}
+
+// followRedirects applies redirects starting from a request until the chain ends
+// or a request repeats, returning the directives applied and the index the loop
+// starts at, -1 when the chain ends
+func (config *Config) followRedirects(hop redirectHop) ([]*Line, int) {
+	seen := map[redirectHop]int{}
+	var lines []*Line
+
+	for len(lines) < maxRedirectHops {
+		if index, ok := seen[hop]; ok {
+			return lines, index
+		}
+		seen[hop] = len(lines)
+
+		line, next, ok := config.redirectStep(hop)
+		if !ok {
+			break
+		}
+		lines = append(lines, line)
+		hop = next
+	}
+	return lines, -1
+}
+
+// redirectStep returns the directive that redirects the request and the request it
+// redirects to. Server level directives run before the location is selected
+func (config *Config) redirectStep(hop redirectHop) (*Line, redirectHop, bool) {
+	line, target, decided := scopeRedirect(hop.server, hop.path)
+	if !decided {
+		var location *Block
+		switch {
+		case hop.path != samePath:
+			if selected := hop.server.FindLocationByURI(hop.path); selected != nil {
+				location = selected.Block
+			}
+		case hop.server == hop.pathServer:
+			location = hop.pathLocation
+		}
+		if location == nil {
+			return nil, hop, false
+		}
+		if line, target, decided = scopeRedirect(location, hop.path); !decided {
+			return nil, hop, false
+		}
+	}
+	if target == "" {
+		return nil, hop, false
+	}
+
+	next, ok := config.resolveRedirect(hop, target)
+	return line, next, ok
+}
+
+// scopeRedirect finds the first return or rewrite directive written directly in block
+// that handles a request with path. decided is false when no directive is known to
+// handle it; target is empty when the directive handles the request without an
+// external redirect
+func scopeRedirect(block *Block, path string) (line *Line, target string, decided bool) {
+	for _, line := range block.Lines {
+		if line.Type != LineTypeDirective || len(line.Params) == 0 {
+			continue
+		}
+		switch line.Name {
+		case "return":
+			return line, returnTarget(line), true
+		case "rewrite":
+			target, matched, known := rewriteTarget(line, path)
+			if !known {
+				return nil, "", false
+			}
+			if matched {
+				return line, target, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// returnTarget returns the URL a return directive redirects to, empty if it does not redirect
+func returnTarget(line *Line) string {
+	switch {
+	case len(line.Params) == 2 && redirectCodes[line.Params[0]]:
+		return unquote(line.Params[1])
+	case len(line.Params) == 1 && isAbsoluteRedirect(unquote(line.Params[0])):
+		return unquote(line.Params[0])
+	}
+	return ""
+}
+
+// rewriteTarget applies a rewrite directive to path. matched reports whether its regex
+// matches, known whether that can be decided. target is empty when the rewrite does
+// not redirect externally
+func rewriteTarget(line *Line, path string) (target string, matched, known bool) {
+	if len(line.Params) < 2 {
+		return "", false, true
+	}
+	pattern, replacement := unquote(line.Params[0]), unquote(line.Params[1])
+
+	var captures []string
+	if path == samePath {
+		capture, ok := universalRewrites[pattern]
+		if !ok {
+			return "", false, false
+		}
+		captures = []string{samePath}
+		if capture != "" {
+			captures = append(captures, capture)
+		}
+	} else {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", false, false
+		}
+		if captures = re.FindStringSubmatch(path); captures == nil {
+			return "", false, true
+		}
+	}
+
+	if !isRedirectRewrite(line) {
+		return "", true, true
+	}
+
+	target = rewriteCapturePattern.ReplaceAllStringFunc(replacement, func(reference string) string {
+		index, _ := strconv.Atoi(strings.Trim(reference, "${}"))
+		if index < len(captures) {
+			return captures[index]
+		}
+		return ""
+	})
+	return target, true, true
+}
+
+// isRedirectRewrite reports whether a rewrite directive redirects the client instead
+// of changing the URI internally
+func isRedirectRewrite(line *Line) bool {
+	if len(line.Params) < 2 {
+		return false
+	}
+	if len(line.Params) > 2 && (line.Params[2] == "redirect" || line.Params[2] == "permanent") {
+		return true
+	}
+	return isAbsoluteRedirect(unquote(line.Params[1]))
+}
+
+// isAbsoluteRedirect reports whether a return or rewrite target is an absolute URL,
+// which makes nginx redirect externally without an explicit code or flag
+func isAbsoluteRedirect(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") || strings.HasPrefix(target, "$scheme://")
+}
+
+// resolveRedirect determines the request a client makes when following a redirect
+// to target. ok is false when the target server or path cannot be determined
+func (config *Config) resolveRedirect(hop redirectHop, target string) (redirectHop, bool) {
+	target, _, _ = strings.Cut(target, "?")
+	for _, suffix := range []string{"$is_args$args", "$is_args$query_string"} {
+		target = strings.TrimSuffix(target, suffix)
+	}
+
+	next := hop
+	if !strings.HasPrefix(target, "/") {
+		var rest string
+		switch {
+		case strings.HasPrefix(target, "$scheme://"):
+			rest = strings.TrimPrefix(target, "$scheme://")
+		case strings.HasPrefix(target, "https://"):
+			next.https, rest = true, strings.TrimPrefix(target, "https://")
+		case strings.HasPrefix(target, "http://"):
+			next.https, rest = false, strings.TrimPrefix(target, "http://")
+		default:
+			return hop, false
+		}
+
+		// The host ends where the path starts, which may be a variable such as $request_uri
+		end := strings.IndexAny(rest, "/$"+samePath)
+		for _, variable := range []string{"$host", "$server_name", "$http_host"} {
+			if strings.HasPrefix(rest, variable) {
+				end = len(variable)
+			}
+		}
+		host := rest
+		target = "/"
+		if end >= 0 && end < len(rest) {
+			host, target = rest[:end], rest[end:]
+		}
+
+		server, ok := config.redirectServer(hop, host, next.https)
+		if !ok {
+			return hop, false
+		}
+		next.server, next.port = server.block, server.port
+	}
+
+	switch target {
+	case "$request_uri", "$uri", "$document_uri", samePath:
+		next.path = hop.path
+	case "/" + samePathTail:
+		next.path = samePath
+	default:
+		if strings.ContainsAny(target, "$"+samePath+samePathTail) {
+			return hop, false
+		}
+		next.path = target
+	}
+
+	if next.path != samePath {
+		next.pathServer, next.pathLocation = nil, nil
+	}
+	return next, true
+}
+
+// redirectDestination is the server and port a redirect target resolves to
+type redirectDestination struct {
+	block *Block
+	port  int
+}
+
+// redirectServer selects the server a redirect to host (as written in the target URL)
+// reaches. $host and $server_name keep the name of the current server
+func (config *Config) redirectServer(hop redirectHop, host string, https bool) (redirectDestination, bool) {
+	port := defaultHTTPPort
+	if https {
+		port = 443
+	}
+
+	name := ""
+	sameName := true
+	switch host {
+	case "$host", "$server_name":
+	case "$http_host":
+		// The Host header carries the port the request arrived on
+		if https == hop.https {
+			port = hop.port
+		}
+	default:
+		if strings.Contains(host, "$") || host == "" {
+			return redirectDestination{}, false
+		}
+		sameName = false
+		name = host
+		if literal, portText, err := net.SplitHostPort(host); err == nil {
+			number, err := strconv.Atoi(portText)
+			if err != nil {
+				return redirectDestination{}, false
+			}
+			name, port = literal, number
+		}
+	}
+
+	var server *Block
+	if sameName {
+		if listensOn(hop.server, port, https) {
+			return redirectDestination{hop.server, port}, true
+		}
+		for _, serverName := range ServerNames(hop.server) {
+			if !strings.ContainsAny(serverName, "*~") {
+				name = serverName
+				break
+			}
+		}
+	}
+	if server, _ = config.SelectServer(name, port); server == nil || !listensOn(server, port, https) {
+		return redirectDestination{}, false
+	}
+	return redirectDestination{server, port}, true
+}
+
+// serverEndpoints returns the ports a server accepts requests on and whether they use TLS
+func serverEndpoints(server *Block) []Endpoint {
+	listens := server.FindLines("listen")
+	if len(listens) == 0 {
+		return []Endpoint{{Address: "*", Port: defaultHTTPPort, Protocol: "http"}}
+	}
+
+	var endpoints []Endpoint
+	seen := map[Endpoint]bool{}
+	for _, line := range listens {
+		endpoint, err := parseListen(line.Params, "http")
+		if err != nil || endpoint.Unix {
+			continue
+		}
+		endpoint = Endpoint{Address: "*", Port: endpoint.Port, Protocol: "http", SSL: endpoint.SSL}
+		if !seen[endpoint] {
+			seen[endpoint] = true
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// listensOn reports whether a server accepts requests on port, over TLS or not
+func listensOn(server *Block, port int, https bool) bool {
+	for _, endpoint := range serverEndpoints(server) {
+		if endpoint.Port == port && endpoint.SSL == https {
+			return true
+		}
+	}
+	return false
+}
+
+// cycleKey identifies a redirect loop independently of the directive it was found from
+func cycleKey(cycle []*Line) string {
+	var keys []string
+	for _, line := range cycle {
+		keys = append(keys, fmt.Sprintf("%p", line))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// chainKey identifies a redirect chain by its directives in order
+func chainKey(chain []*Line) string {
+	var keys []string
+	for _, line := range chain {
+		keys = append(keys, fmt.Sprintf("%p", line))
+	}
+	return strings.Join(keys, ">")
+}
+
+// redirectChainIssues describes redirect chains, each reported at its first directive
+func redirectChainIssues(chains [][]*Line) []ValidationIssue {
+	var issues []ValidationIssue
+	for _, chain := range chains {
+		var steps []string
+		for _, line := range chain {
+			steps = append(steps, fmt.Sprintf("%s (line %d)", formatStatement(line.Name, line.Params), line.LineNumber))
+		}
+		first := chain[0]
+		issues = append(issues, ValidationIssue{
+			Severity:   SeverityWarning,
+			Directive:  first.Name,
+			Message:    fmt.Sprintf("redirect chain of %d hops: %s; redirect straight to the final target", len(chain), strings.Join(steps, " -> ")),
+			LineNumber: first.LineNumber,
+		})
+	}
+	return issues
+}
+
+// redirectLoopIssue describes a redirect loop, reported at its first directive
+func redirectLoopIssue(cycle []*Line) ValidationIssue {
+	first := cycle[0]
+	message := fmt.Sprintf("redirect loop: %s redirects back to itself", formatStatement(first.Name, first.Params))
+	if len(cycle) > 1 {
+		var steps []string
+		for _, line := range cycle {
+			steps = append(steps, fmt.Sprintf("%s (line %d)", formatStatement(line.Name, line.Params), line.LineNumber))
+		}
+		message = fmt.Sprintf("redirect loop: %s -> back to line %d", strings.Join(steps, " -> "), first.LineNumber)
+	}
+
+	return ValidationIssue{
+		Severity:   SeverityError,
+		Directive:  first.Name,
+		Message:    message,
+		LineNumber: first.LineNumber,
+	}
+}
@@ -0,0 +1,177 @@
+package nginx
+
+import (
+	"regexp"
+	"strings"
+)
+
+// minRefererLength is the length of the shortest Referer nginx inspects, "http://i.ru"
+const minRefererLength = len("http://i.ru")
+
+// RefererName is a host name entry of valid_referers, optionally restricted to a URI prefix
+type RefererName struct {
+	Host string // Exact name, or wildcard name such as *.example.com, www.example.* or .example.com
+	URI  string // URI prefix the referer must start with after the host, empty for any
+}
+
+// ValidReferers is a typed view of a valid_referers directive of ngx_http_referer_module,
+// which sets $invalid_referer to "1" for requests whose Referer is not listed
+type ValidReferers struct {
+	Line        *Line            // Underlying valid_referers directive
+	Server      *Block           // Server whose names server_names expands to, nil if none
+	None        bool             // Requests without a Referer header are valid
+	Blocked     bool             // Referers without an http:// or https:// scheme are valid
+	ServerNames bool             // The server_names parameter is present
+	Names       []RefererName    // Exact and wildcard entries, including expanded server names
+	Regexes     []*regexp.Regexp // ~ entries, matched case-insensitively against the referer after its scheme
+	HostRegexes []*regexp.Regexp // Regex server names, matched against the referer host
+}
+
+// NewValidReferers parses a valid_referers directive. The server_names parameter
+// expands to the names of server, which may be nil when the directive is not inside one.
+// Regexes that do not compile are skipped
+func NewValidReferers(line *Line, server *Block) *ValidReferers {
+	if line == nil || line.Name != "valid_referers" {
+		return nil
+	}
+
+	referers := &ValidReferers{Line: line, Server: server}
+	for _, param := range line.Params {
+		switch value := unquote(param); {
+		case value == "none":
+			referers.None = true
+		case value == "blocked":
+			referers.Blocked = true
+		case value == "server_names":
+			referers.ServerNames = true
+			if server != nil {
+				referers.addServerNames(ServerNames(server))
+			}
+		case strings.HasPrefix(value, "~"):
+			if re, err := regexp.Compile("(?i)" + value[1:]); err == nil {
+				referers.Regexes = append(referers.Regexes, re)
+			}
+		default:
+			host, uri, _ := strings.Cut(value, "/")
+			if uri != "" {
+				uri = "/" + uri
+			}
+			referers.Names = append(referers.Names, RefererName{Host: strings.ToLower(host), URI: uri})
+		}
+	}
+	return referers
+}
+
+// addServerNames adds the names of a server_name directive as referer entries
+func (referers *ValidReferers) addServerNames(names []string) {
+	for _, name := range names {
+		switch {
+		case name == "" || name == "_":
+		case strings.HasPrefix(name, "~"):
+			if re, err := regexp.Compile(name[1:]); err == nil {
+				referers.HostRegexes = append(referers.HostRegexes, re)
+			}
+		default:
+			referers.Names = append(referers.Names, RefererName{Host: name})
+		}
+	}
+}
+
+// ValidReferers returns the valid_referers directive in effect in the block, with
+// server_names expanded against the enclosing server. Returns nil if none applies
+func (block *Block) ValidReferers() *ValidReferers {
+	line := block.EffectiveDirective("valid_referers")
+	if line == nil {
+		return nil
+	}
+	server := block
+	if server.Name != "server" {
+		server = block.Ancestor("server")
+	}
+	return NewValidReferers(line, server)
+}
+
+// Evaluate reports whether a request with the given Referer header is valid, that
+// is whether nginx leaves $invalid_referer empty. An empty referer stands for a
+// request without the header. host is the name the request was addressed to and
+// stands in for the server names when server_names is used outside a server block
+func (referers *ValidReferers) Evaluate(referer string, host string) bool {
+	if referer == "" {
+		return referers.None
+	}
+
+	rest := ""
+	lower := strings.ToLower(referer)
+	switch {
+	case len(referer) < minRefererLength:
+		return referers.Blocked
+	case strings.HasPrefix(lower, "http://"):
+		rest = referer[len("http://"):]
+	case strings.HasPrefix(lower, "https://"):
+		rest = referer[len("https://"):]
+	default:
+		return referers.Blocked
+	}
+
+	// The host ends at the first slash or colon, the port is not stripped from the URI
+	end := strings.IndexAny(rest, "/:")
+	if end < 0 {
+		end = len(rest)
+	}
+	refererHost, uri := strings.ToLower(rest[:end]), rest[end:]
+
+	names := referers.Names
+	if referers.ServerNames && referers.Server == nil && host != "" {
+		names = append(append([]RefererName{}, names...), RefererName{Host: strings.ToLower(host)})
+	}
+	if entry, ok := matchRefererName(names, refererHost); ok {
+		return entry.URI == "" || strings.HasPrefix(uri, entry.URI)
+	}
+
+	for _, re := range referers.Regexes {
+		if re.MatchString(rest) {
+			return true
+		}
+	}
+	for _, re := range referers.HostRegexes {
+		if re.MatchString(refererHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRefererName finds the entry matching a referer host with the precedence of
+// nginx's combined hash: exact names, then the longest leading wildcard, then the
+// longest trailing wildcard
+func matchRefererName(names []RefererName, host string) (RefererName, bool) {
+	var leading, trailing *RefererName
+	for i := range names {
+		name := names[i].Host
+		switch {
+		case name == host:
+			return names[i], true
+		case strings.HasPrefix(name, "*.") || strings.HasPrefix(name, "."):
+			suffix := strings.TrimPrefix(name, "*")
+			matches := strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+			if name[0] == '.' && host == name[1:] {
+				matches = true
+			}
+			if matches && (leading == nil || len(name) > len(leading.Host)) {
+				leading = &names[i]
+			}
+		case strings.HasSuffix(name, ".*"):
+			prefix := strings.TrimSuffix(name, "*")
+			if strings.HasPrefix(host, prefix) && len(host) > len(prefix) && (trailing == nil || len(name) > len(trailing.Host)) {
+				trailing = &names[i]
+			}
+		}
+	}
+	switch {
+	case leading != nil:
+		return *leading, true
+	case trailing != nil:
+		return *trailing, true
+	}
+	return RefererName{}, false
+}
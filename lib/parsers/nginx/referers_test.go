@@ -0,0 +1,103 @@
+package nginx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidReferersEvaluate(t *testing.T) {
+	tests := []struct {
+		name      string
+		referers  string
+		serverRef string // server_name of the enclosing server, no server block if empty
+		referer   string
+		host      string
+		valid     bool
+	}{
+		{"none allows missing header", "none", "", "", "", true},
+		{"missing header without none", "example.com", "", "", "", false},
+		{"none does not allow other referers", "none", "", "http://example.com/", "", false},
+		{"blocked allows referer without scheme", "blocked", "", "example.com/page", "", true},
+		{"blocked allows stripped referer", "blocked", "", "*************", "", true},
+		{"blocked allows short referer", "blocked", "", "http://a.b", "", true},
+		{"blocked does not allow http referers", "blocked", "", "http://example.com/", "", false},
+		{"short referer without blocked", "example.com", "", "http://a.b", "", false},
+		{"exact name", "example.com", "", "http://example.com/page", "", true},
+		{"exact name is case-insensitive", "example.com", "", "HTTPS://Example.COM/page", "", true},
+		{"exact name with port", "example.com", "", "http://example.com:8080/", "", true},
+		{"exact name does not match subdomain", "example.com", "", "http://www.example.com/", "", false},
+		{"uri prefix", "example.com/galleries/", "", "http://example.com/galleries/1", "", true},
+		{"uri prefix mismatch", "example.com/galleries/", "", "http://example.com/other", "", false},
+		{"leading wildcard", "*.example.com", "", "http://www.example.com/", "", true},
+		{"leading wildcard needs a subdomain", "*.example.com", "", "http://example.com/", "", false},
+		{"dot wildcard matches the domain", ".example.com", "", "http://example.com/", "", true},
+		{"dot wildcard matches subdomains", ".example.com", "", "http://a.b.example.com/", "", true},
+		{"trailing wildcard", "www.example.*", "", "http://www.example.org/", "", true},
+		{"trailing wildcard mismatch", "www.example.*", "", "http://example.org/", "", false},
+		{"exact name before wildcard uri", "*.example.com/a/ www.example.com", "", "http://www.example.com/b", "", true},
+		{"longest wildcard wins", "*.example.com *.b.example.com/only/", "", "http://a.b.example.com/other", "", false},
+		{"regex", `~\.google\.`, "", "https://www.google.com/search", "", true},
+		{"regex is case-insensitive", `~\.google\.`, "", "https://www.GOOGLE.com/", "", true},
+		{"regex matches after the scheme", `~^www\.`, "", "http://www.example.com/", "", true},
+		{"regex mismatch", `~\.google\.`, "", "https://bing.com/", "", false},
+		{"server_names", "server_names", "example.com *.example.org", "http://example.com/", "", true},
+		{"server_names wildcard", "server_names", "example.com *.example.org", "http://www.example.org/", "", true},
+		{"server_names mismatch", "server_names", "example.com", "http://other.com/", "", false},
+		{"server_names regex", "server_names", `~^api\d+\.example\.com$`, "http://api2.example.com/", "", true},
+		{"server_names outside server uses host", "server_names", "", "http://example.net/", "example.net", true},
+		{"server_names outside server mismatch", "server_names", "", "http://example.net/", "example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "valid_referers " + tt.referers + ";"
+			if tt.serverRef != "" {
+				input = "server { server_name " + tt.serverRef + "; location / { " + input + " } }"
+			} else {
+				input = "location / { " + input + " }"
+			}
+			config, err := ParseReader(strings.NewReader(input), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var location *Block
+			for _, block := range config.FindBlocksByName("location") {
+				location = block
+			}
+
+			referers := location.ValidReferers()
+			if referers == nil {
+				t.Fatal("no valid_referers in effect")
+			}
+			if got := referers.Evaluate(tt.referer, tt.host); got != tt.valid {
+				t.Fatalf("Evaluate(%q) = %v, want %v", tt.referer, got, tt.valid)
+			}
+		})
+	}
+}
+
+func TestNewValidReferers(t *testing.T) {
+	config, err := ParseReader(strings.NewReader(`server {
+    server_name example.com _ ~^www\d+\.example\.com$;
+    valid_referers none blocked server_names "*.example.org" ~\.google\. ~[invalid;
+}`), "test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := config.FindBlocksByName("server")[0]
+
+	referers := server.ValidReferers()
+	if !referers.None || !referers.Blocked || !referers.ServerNames {
+		t.Fatalf("none, blocked and server_names not all recognized: %+v", referers)
+	}
+	var names []string
+	for _, name := range referers.Names {
+		names = append(names, name.Host)
+	}
+	if got, want := strings.Join(names, " "), "example.com *.example.org"; got != want {
+		t.Fatalf("names %q, want %q", got, want)
+	}
+	if len(referers.Regexes) != 1 || len(referers.HostRegexes) != 1 {
+		t.Fatalf("got %d regexes and %d host regexes, want 1 each, skipping the invalid one", len(referers.Regexes), len(referers.HostRegexes))
+	}
+}
@@ -0,0 +1,197 @@
+package nginx
+
+import (
+	"regexp"
+	"strings"
+)
+
+// bracedVariablePattern matches ${name} references, which nginx reads without quotes
+var bracedVariablePattern = regexp.MustCompile(`\$\{[A-Za-z0-9_]+\}`)
+
+// QuoteStyle selects how Reformat quotes directive parameters
+type QuoteStyle string
+
+const (
+	QuoteStyleDouble  QuoteStyle = "double"  // Quote every parameter with double quotes
+	QuoteStyleSingle  QuoteStyle = "single"  // Quote every parameter with single quotes
+	QuoteStyleMinimal QuoteStyle = "minimal" // Quote only parameters nginx would otherwise split or misread
+)
+
+// ReformatOptions configures Reformat
+type ReformatOptions struct {
+	QuoteStyle QuoteStyle // Quoting applied to parameters, left unchanged if empty
+}
+
+// Reformat rewrites the parameters of every directive and block to a consistent
+// quoting style. Parameter values are preserved: quotes inside a value are escaped
+// or unescaped as the new quoting requires. Verbatim code blocks and nodes an
+// ngonx:keep pragma protects are left untouched
+func (config *Config) Reformat(opts ReformatOptions) {
+	if opts.QuoteStyle == "" {
+		return
+	}
+
+	config.WalkBlocks(func(block *Block) {
+		if block.kept() {
+			return
+		}
+		if requoteParams(block.Params, opts.QuoteStyle) {
+			config.Dirty = true
+		}
+		for _, line := range block.Lines {
+			// Block lines share their parameters with the block, handled when it is visited
+			if line.Type != LineTypeBlock && !hasPragma(line.Pragmas, PragmaKeep) && requoteParams(line.Params, opts.QuoteStyle) {
+				config.Dirty = true
+			}
+		}
+	})
+}
+
+// requoteParams rewrites each parameter in place using the given style, reporting
+// whether any parameter changed
+func requoteParams(params []string, style QuoteStyle) bool {
+	changed := false
+	for i, param := range params {
+		value := paramValue(param)
+		switch style {
+		case QuoteStyleDouble:
+			params[i] = quoteParam(value, '"')
+		case QuoteStyleSingle:
+			params[i] = quoteParam(value, '\'')
+		case QuoteStyleMinimal:
+			params[i] = value
+			if needsQuoting(value) {
+				params[i] = quoteValue(value)
+			}
+		}
+		if params[i] != param {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// paramValue strips the quotes of a parameter and resolves escaped quotes. Other
+// escape sequences such as \\ or \n are kept as written since nginx resolves them
+// the same way in quoted and unquoted parameters. A parameter whose quote closes
+// before its end, such as 'a'b or three single quotes, is not a quoted string and
+// keeps its quotes
+func paramValue(param string) string {
+	if _, quoted := quotedParam(param); quoted {
+		param = param[1 : len(param)-1]
+	}
+	if !strings.Contains(param, `\`) {
+		return param
+	}
+
+	var value strings.Builder
+	for i := 0; i < len(param); i++ {
+		if param[i] == '\\' && i+1 < len(param) {
+			if param[i+1] != '"' && param[i+1] != '\'' {
+				value.WriteByte('\\')
+			}
+			value.WriteByte(param[i+1])
+			i++
+			continue
+		}
+		value.WriteByte(param[i])
+	}
+	return value.String()
+}
+
+// quoteParam wraps a value in the given quote mark, escaping occurrences of the mark
+// and a trailing backslash, which would otherwise escape the closing quote. Carriage
+// returns are written as \r, and line breaks as \n after a space or tab, as lines
+// are read back without their trailing whitespace
+func quoteParam(value string, mark byte) string {
+	var quoted strings.Builder
+	quoted.WriteByte(mark)
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value) && value[i+1] != '\r':
+			quoted.WriteString(value[i : i+2])
+			i++
+		case value[i] == '\\':
+			quoted.WriteString(`\\`)
+		case value[i] == '\n' && i > 0 && (value[i-1] == ' ' || value[i-1] == '\t'):
+			quoted.WriteString(`\n`)
+		case value[i] == '\r':
+			quoted.WriteString(`\r`)
+		case value[i] == mark:
+			quoted.WriteByte('\\')
+			quoted.WriteByte(mark)
+		default:
+			quoted.WriteByte(value[i])
+		}
+	}
+	quoted.WriteByte(mark)
+	return quoted.String()
+}
+
+// quoteValue quotes a value with single quotes when it holds double quotes but no
+// single ones, saving escapes, and with double quotes otherwise
+func quoteValue(value string) string {
+	if strings.Contains(value, `"`) && !strings.Contains(value, "'") {
+		return quoteParam(value, '\'')
+	}
+	return quoteParam(value, '"')
+}
+
+// formatParam renders a parameter for output. Quoted strings and valid unquoted
+// tokens are kept as written, preserving their quote style. Any other parameter is
+// taken as a value and quoted, e.g. one set to a JSON object, an empty string, { or
+// ; or a value ending with a backslash
+func formatParam(param string) string {
+	if mark, ok := quotedParam(param); ok {
+		// Requoting only escapes the line breaks that would not read back
+		return quoteParam(param[1:len(param)-1], mark)
+	}
+	if !needsQuoting(param) {
+		return param
+	}
+	return quoteValue(param)
+}
+
+// quotedParam returns the quote mark of a parameter written as a quoted string,
+// closed by its own mark with nothing after it
+func quotedParam(param string) (byte, bool) {
+	if param == "" || param[0] != '"' && param[0] != '\'' {
+		return 0, false
+	}
+	mark := param[0]
+	for i := 1; i < len(param); i++ {
+		switch param[i] {
+		case '\\':
+			i++
+		case mark:
+			return mark, i == len(param)-1
+		}
+	}
+	return 0, false
+}
+
+// needsQuoting reports whether a value cannot be written as an unquoted parameter
+func needsQuoting(value string) bool {
+	if value == "" || strings.ContainsAny(bracedVariablePattern.ReplaceAllString(value, "$$"), " \t\r\n;{}") {
+		return true
+	}
+	// A trailing backslash would escape the character after the parameter
+	escaped := false
+	for i := 0; i < len(value); i++ {
+		escaped = !escaped && value[i] == '\\'
+	}
+	if escaped {
+		return true
+	}
+	// A token may start after the closing brace of ${name}, as a quoted string or comment
+	for _, match := range bracedVariablePattern.FindAllStringIndex(value, -1) {
+		if match[1] < len(value) && strings.IndexByte(`"'#`, value[match[1]]) >= 0 {
+			return true
+		}
+	}
+	switch value[0] {
+	case '"', '\'', '#':
+		return true
+	}
+	return false
+}
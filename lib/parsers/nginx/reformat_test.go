@@ -0,0 +1,39 @@
+package nginx
+
+import (
+	"strings"
+	"testing"
+)
+
+// FuzzReformat checks that every quoting style preserves the parsed tree: the
+// reformatted output parses back to the same directives and parameter values
+func FuzzReformat(f *testing.F) {
+	addConfigSeeds(f)
+	addCorpusSeeds(f, "FuzzRoundTrip")
+	f.Add(`add_header X-Test "it's \"quoted\"" always;`)
+	f.Add(`set $a 'a b'; set $b "${a}c"; return 200 "x\\";`)
+	f.Fuzz(func(t *testing.T, input string) {
+		original, err := ParseReader(strings.NewReader(input), "fuzz.conf")
+		if err != nil {
+			return
+		}
+		want := treeSignature(original.RootBlock)
+
+		for _, style := range []QuoteStyle{QuoteStyleDouble, QuoteStyleSingle, QuoteStyleMinimal} {
+			config, err := ParseReader(strings.NewReader(input), "fuzz.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.Reformat(ReformatOptions{QuoteStyle: style})
+			formatted := config.AutoIndent(4)
+
+			reparsed, err := ParseReader(strings.NewReader(formatted), "fuzz.conf")
+			if err != nil {
+				t.Fatalf("%s quoting of %q does not parse: %v\n%s", style, input, err, formatted)
+			}
+			if got := treeSignature(reparsed.RootBlock); got != want {
+				t.Fatalf("%s quoting of %q parses to a different tree:\n%s\nwant\n%s", style, input, got, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package nginx
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// ContextMain is the context name of directives at the top level of the configuration
+const ContextMain = "main"
+
+// Unlimited marks a DirectiveSpec without an upper bound on its arguments
+const Unlimited = -1
+
+// DirectiveSpec describes where a directive may appear and which arguments it takes
+type DirectiveSpec struct {
+	Name     string   // Directive name
+	Module   string   // Module providing the directive
+	Contexts []string // Blocks the directive is allowed in, ContextMain for the top level
+	MinArgs  int      // Minimum number of arguments
+	MaxArgs  int      // Maximum number of arguments, Unlimited for no limit
+	IsBlock  bool     // Whether the directive opens a block
+	FileArg  int      // 1-based position of an argument naming a file that must exist, 0 if none
+}
+
+// DirectiveRegistry is a dictionary of known directives
+type DirectiveRegistry struct {
+	specs map[string]DirectiveSpec
+}
+
+// NewDirectiveRegistry creates an empty directive registry
+func NewDirectiveRegistry() *DirectiveRegistry {
+	return &DirectiveRegistry{specs: map[string]DirectiveSpec{}}
+}
+
+// DefaultDirectiveRegistry creates a registry holding the directives of the bundled modules
+func DefaultDirectiveRegistry() *DirectiveRegistry {
+	registry := NewDirectiveRegistry()
+	RegisterPerl(registry)
+	RegisterGeoIP2(registry)
+	return registry
+}
+
+// Register adds directives to the registry, replacing earlier specs with the same name
+func (registry *DirectiveRegistry) Register(specs ...DirectiveSpec) {
+	for _, spec := range specs {
+		registry.specs[spec.Name] = spec
+	}
+}
+
+// Lookup returns the spec of a directive and whether it is known
+func (registry *DirectiveRegistry) Lookup(name string) (DirectiveSpec, bool) {
+	spec, ok := registry.specs[name]
+	return spec, ok
+}
+
+// Names returns the names of all registered directives in sorted order
+func (registry *DirectiveRegistry) Names() []string {
+	names := make([]string, 0, len(registry.specs))
+	for name := range registry.specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AllowedIn reports whether the directive may appear in the given context
+func (spec DirectiveSpec) AllowedIn(context string) bool {
+	for _, allowed := range spec.Contexts {
+		if allowed == context {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterPerl adds the directives of ngx_http_perl_module to the registry
+func RegisterPerl(registry *DirectiveRegistry) {
+	registry.Register(
+		DirectiveSpec{Name: "perl_modules", Module: "perl", Contexts: []string{"http"}, MinArgs: 1, MaxArgs: 1},
+		DirectiveSpec{Name: "perl_require", Module: "perl", Contexts: []string{"http"}, MinArgs: 1, MaxArgs: 1},
+		DirectiveSpec{Name: "perl_set", Module: "perl", Contexts: []string{"http"}, MinArgs: 2, MaxArgs: 2},
+		DirectiveSpec{Name: "perl", Module: "perl", Contexts: []string{"location", "limit_except"}, MinArgs: 1, MaxArgs: 1},
+	)
+}
+
+// blockContext returns the context name directives inside the block are checked against
+func blockContext(block *Block) string {
+	if block.ParentRef == nil {
+		return ContextMain
+	}
+	return block.Name
+}
+
+// ValidateDirectives checks every registered directive in the configuration against its
+// allowed contexts and argument count. Directives unknown to the registry are skipped
+func (config *Config) ValidateDirectives(registry *DirectiveRegistry) []ValidationError {
+	var issues []ValidationError
+
+	config.WalkBlocks(func(block *Block) {
+		context := blockContext(block)
+		for _, line := range block.Lines {
+			if line.Type == LineTypeComment {
+				continue
+			}
+			name, args := line.Name, len(line.Params)
+			if line.BlockRef != nil {
+				name, args = line.BlockRef.Name, len(line.BlockRef.Params)
+				if isVerbatimBlock(name) {
+					// The code of a verbatim block stands in for its last argument
+					args++
+				}
+			}
+
+			spec, ok := registry.Lookup(name)
+			if !ok {
+				continue
+			}
+			if !spec.AllowedIn(context) {
+				issues = append(issues, ValidationError{
+					Severity:   SeverityError,
+					Directive:  name,
+					Message:    fmt.Sprintf("not allowed in %s context, expected one of: %s", context, strings.Join(spec.Contexts, ", ")),
+					LineNumber: line.LineNumber,
+				})
+			}
+			if args < spec.MinArgs || (spec.MaxArgs != Unlimited && args > spec.MaxArgs) {
+				issues = append(issues, ValidationError{
+					Severity:   SeverityError,
+					Directive:  name,
+					Message:    fmt.Sprintf("invalid number of arguments (%d)", args),
+					LineNumber: line.LineNumber,
+				})
+			}
+		}
+	})
+
+	return issues
+}
+
+// ValidateFileReferences checks that files named by directive arguments exist, for
+// every directive the registry declares a FileArg for. Relative paths resolve against
+// baseDir and arguments containing variables are skipped since they vary per request
+func (config *Config) ValidateFileReferences(baseDir string, registry *DirectiveRegistry) []ValidationIssue {
+	return config.ValidateFileReferencesFS(rootFS{}, baseDir, registry)
+}
+
+// ValidateFileReferencesFS is ValidateFileReferences looking files up in fsys, laid
+// out as for ValidateIncludesFS
+func (config *Config) ValidateFileReferencesFS(fsys fs.FS, baseDir string, registry *DirectiveRegistry) []ValidationIssue {
+	var issues []ValidationIssue
+
+	config.WalkBlocks(func(block *Block) {
+		for _, line := range block.Lines {
+			if line.Type == LineTypeComment || line.Type == LineTypeInclude {
+				continue
+			}
+			name, params := line.Name, line.Params
+			if line.BlockRef != nil {
+				name, params = line.BlockRef.Name, line.BlockRef.Params
+			}
+			spec, ok := registry.Lookup(name)
+			if !ok || spec.FileArg == 0 || len(params) < spec.FileArg {
+				continue
+			}
+
+			path := unquote(params[spec.FileArg-1])
+			if strings.Contains(path, "$") {
+				continue
+			}
+			path = resolveIncludePath(baseDir, path)
+			if _, err := statPath(fsys, path); err != nil {
+				issues = append(issues, ValidationIssue{
+					Severity:   SeverityError,
+					Directive:  name,
+					Message:    fmt.Sprintf("referenced file %q does not exist", path),
+					LineNumber: line.LineNumber,
+				})
+			}
+		}
+	})
+
+	return issues
+}
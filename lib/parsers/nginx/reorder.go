@@ -0,0 +1,196 @@
+package nginx
+
+import (
+	"sort"
+	"strings"
+)
+
+// ReorderPolicy describes the canonical order Reorder puts the content of blocks in
+type ReorderPolicy struct {
+	// First lists directives moved to the top of each block, or of the part of it
+	// following the last include, in this order. An entry may include parameters
+	// to match only those, e.g. "deny all"
+	First []string
+
+	// Last lists directives moved to the end of each block, in this order, after
+	// child blocks. Entries match like those of First
+	Last []string
+
+	// SortMiddle sorts the remaining directives alphabetically. Child blocks follow
+	// them in their original order. Otherwise the remaining content keeps its order
+	SortMiddle bool
+}
+
+// DefaultReorderPolicy puts load_module, worker_processes and error_log first,
+// deny all last and sorts the other directives alphabetically
+func DefaultReorderPolicy() ReorderPolicy {
+	return ReorderPolicy{
+		First:      []string{"worker_processes", "error_log"},
+		Last:       []string{"deny all"},
+		SortMiddle: true,
+	}
+}
+
+// orderedGroups maps directives whose relative order changes their meaning to the
+// group they keep their order in: access rules are checked first-match and the
+// rewrite module runs its directives, if blocks included, in order
+var orderedGroups = map[string]string{
+	"allow":   "allow",
+	"deny":    "allow",
+	"set":     "rewrite",
+	"rewrite": "rewrite",
+	"return":  "rewrite",
+	"break":   "rewrite",
+	"if":      "rewrite",
+}
+
+// Ranks of the parts of a reordered block
+const (
+	rankFirst = iota
+	rankMiddle
+	rankBlocks
+	rankLast
+)
+
+// reorderUnit is a statement with the comment lines introducing it
+type reorderUnit struct {
+	lines []*Line
+	group string // Order-sensitive group, or the directive name
+	rank  int
+	index int // Position within the policy list, or 0 in the middle
+}
+
+// Reorder sorts the content of every block into the order of the policy, making
+// the output deterministic for diffs. Ordering constraints are respected: allow and
+// deny rules, and the rewrite module directives with their if blocks, move as a
+// group keeping their order, placed by their first member matching the policy.
+// Directives with the same name keep their order, child blocks keep theirs, and
+// load_module stays ahead of everything in the main context. Includes stay where
+// they are and nothing moves across them, as the included files may hold servers,
+// locations or rewrites whose order matters: the lines between two includes are
+// sorted on their own, whatever the policy says about include. Comment lines move
+// with the statement they precede. Blocks holding entries rather than directives
+// (map, geo, types, upstream, split_clients) are left as they are
+func (config *Config) Reorder(policy ReorderPolicy) {
+	config.WalkBlocks(func(block *Block) {
+		if foreignDirectiveBlocks[block.Name] {
+			return
+		}
+		if policy.reorderBlock(block) {
+			config.Dirty = true
+		}
+	})
+}
+
+// reorderBlock sorts the lines of a block between its includes, reporting whether
+// any line moved
+func (policy ReorderPolicy) reorderBlock(block *Block) bool {
+	lines := make([]*Line, 0, len(block.Lines))
+	var segment []*Line
+	for _, line := range block.Lines {
+		segment = append(segment, line)
+		if line.Type == LineTypeInclude {
+			lines = append(lines, policy.reorderLines(block, segment[:len(segment)-1])...)
+			lines = append(lines, line)
+			segment = nil
+		}
+	}
+	lines = append(lines, policy.reorderLines(block, segment)...)
+
+	moved := false
+	for i, line := range lines {
+		if block.Lines[i] != line {
+			moved = true
+			break
+		}
+	}
+	block.Lines = lines
+	block.syncBlocks()
+	return moved
+}
+
+// reorderLines sorts lines of a block holding no include. Comments after the last
+// statement stay at the end, ahead of the include or closing brace following them
+func (policy ReorderPolicy) reorderLines(block *Block, blockLines []*Line) []*Line {
+	var units []*reorderUnit
+	var pending []*Line
+	for _, line := range blockLines {
+		pending = append(pending, line)
+		if line.Type == LineTypeComment {
+			continue
+		}
+		units = append(units, policy.newUnit(block, pending))
+		pending = nil
+	}
+
+	// Members of a group take the rank of the one the policy places first
+	groups := map[string]*reorderUnit{}
+	for _, unit := range units {
+		leader := groups[unit.group]
+		switch {
+		case leader == nil || (leader.rank == rankMiddle && unit.rank != rankMiddle):
+			groups[unit.group] = unit
+		case unit.rank != rankMiddle && (unit.rank < leader.rank || (unit.rank == leader.rank && unit.index < leader.index)):
+			groups[unit.group] = unit
+		}
+	}
+	for _, unit := range units {
+		if _, ordered := orderedGroups[unit.lines[len(unit.lines)-1].Name]; ordered {
+			unit.rank, unit.index = groups[unit.group].rank, groups[unit.group].index
+		}
+	}
+
+	sort.SliceStable(units, func(i, j int) bool {
+		a, b := units[i], units[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if a.index != b.index {
+			return a.index < b.index
+		}
+		return a.rank == rankMiddle && policy.SortMiddle && a.group < b.group
+	})
+
+	lines := make([]*Line, 0, len(blockLines))
+	for _, unit := range units {
+		lines = append(lines, unit.lines...)
+	}
+	return append(lines, pending...)
+}
+
+// newUnit classifies a statement, the last of lines, by the policy
+func (policy ReorderPolicy) newUnit(block *Block, lines []*Line) *reorderUnit {
+	line := lines[len(lines)-1]
+	unit := &reorderUnit{lines: lines, group: line.Name, rank: rankMiddle}
+	if group, ok := orderedGroups[line.Name]; ok {
+		unit.group = group
+	}
+
+	switch {
+	case line.Name == "load_module" && block.ParentRef == nil:
+		unit.rank, unit.index = rankFirst, -1
+	case matchesReorderEntry(line, policy.First) >= 0:
+		unit.rank, unit.index = rankFirst, matchesReorderEntry(line, policy.First)
+	case matchesReorderEntry(line, policy.Last) >= 0:
+		unit.rank, unit.index = rankLast, matchesReorderEntry(line, policy.Last)
+	case line.Type == LineTypeBlock && line.Name != "if":
+		// Child blocks keep their order, regex locations are matched in it
+		unit.rank, unit.group = rankBlocks, ""
+	}
+	return unit
+}
+
+// matchesReorderEntry returns the position of the first policy entry matching a
+// line, by name or by name and parameters, -1 if none does
+func matchesReorderEntry(line *Line, entries []string) int {
+	for i, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 || fields[0] != line.Name {
+			continue
+		}
+		if len(fields) == 1 || paramsEqual(line.Params, fields[1:]) {
+			return i
+		}
+	}
+	return -1
+}
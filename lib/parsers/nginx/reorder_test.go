@@ -0,0 +1,87 @@
+package nginx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReorderIncludeBarrier(t *testing.T) {
+	input := `http {
+    server {
+        listen 80;
+    }
+    include conf.d/*.conf;
+    server {
+        listen 8080;
+        rewrite ^/old/(.*)$ /new/$1 permanent;
+        include snippets/rewrites.conf;
+        location ~ \.php$ {
+            fastcgi_pass unix:/run/php.sock;
+        }
+        include snippets/locations.conf;
+        location ~ \.(php|html)$ {
+            root /srv;
+        }
+    }
+}
+`
+	config, err := ParseReader(strings.NewReader(input), "test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := config.AutoIndent(4)
+
+	config.Reorder(DefaultReorderPolicy())
+	if after := config.AutoIndent(4); after != before {
+		t.Fatalf("reordering moved lines across includes:\n%s\nwant\n%s", after, before)
+	}
+}
+
+func TestReorder(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  []string
+	}{
+		{
+			name:  "first, middle and last",
+			block: "deny all; root /srv; error_log off; index index.html; location / { }",
+			want:  []string{"error_log", "index", "root", "location", "deny"},
+		},
+		{
+			name:  "access rules keep their order",
+			block: "allow 10.0.0.0/8; root /srv; deny all;",
+			want:  []string{"root", "allow", "deny"},
+		},
+		{
+			name:  "sorted between includes",
+			block: "root /srv; error_log off; include a.conf; index index.html; autoindex on; include b.conf; gzip on;",
+			want:  []string{"error_log", "root", "include", "autoindex", "index", "include", "gzip"},
+		},
+		{
+			name:  "include in the first list is still a barrier",
+			block: "root /srv; include a.conf; error_log off;",
+			want:  []string{"root", "include", "error_log"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseReader(strings.NewReader("server { "+tt.block+" }"), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			policy := DefaultReorderPolicy()
+			policy.First = append(policy.First, "include")
+			config.Reorder(policy)
+
+			var names []string
+			for _, line := range config.FindBlocksByName("server")[0].Lines {
+				names = append(names, line.Name)
+			}
+			if got, want := strings.Join(names, " "), strings.Join(tt.want, " "); got != want {
+				t.Fatalf("reordered to %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package nginx
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// sensitiveFileURIs are requests for version control data and secrets kept next to
+// web content, which nginx serves like any other file
+var sensitiveFileURIs = []string{"/.env", "/.git/config", "/.svn/entries", "/.htaccess"}
+
+// backupFileNames are backup and dump files directory listings reveal, dotfiles
+// being left out of listings by autoindex
+var backupFileNames = []string{"config.php.bak", "config.php.old", "config.php~", "backup.sql", "backup.zip"}
+
+// CheckSensitiveDataExposure looks for http servers that may expose sensitive
+// files: servers answering requests for /.env, /.git, /.svn or /.htaccess with the
+// files themselves, lacking a location such as ~ /\.(env|git|svn|htaccess) that
+// denies them, autoindex on listing backup and dump files that no location denies,
+// and a root holding a public/ directory, a sign the web root is set one level too
+// high. Locations passing requests to a backend are not checked. Only absolute
+// roots without variables are looked up on the local file system
+func (config *Config) CheckSensitiveDataExposure() []ValidationError {
+	return config.checkSensitiveDataExposure(rootFS{})
+}
+
+// checkSensitiveDataExposure implements CheckSensitiveDataExposure, looking roots
+// up in fsys, or not at all when it is nil
+func (config *Config) checkSensitiveDataExposure(fsys fs.FS) []ValidationError {
+	var issues []ValidationError
+	seen := map[*Line]bool{}
+	for _, server := range config.FindBlocksByName("server") {
+		if serverProtocol(server) != "http" {
+			continue
+		}
+
+		var exposed []string
+		for _, uri := range sensitiveFileURIs {
+			if servesStaticFile(server, uri) {
+				exposed = append(exposed, uri)
+			}
+		}
+		if len(exposed) > 0 {
+			issues = append(issues, ValidationError{
+				Severity:   SeverityWarning,
+				Directive:  "server",
+				Message:    fmt.Sprintf("requests for %s are served as static files, deny them with location ~ /\\.(env|git|svn|htaccess) { deny all; }", strings.Join(exposed, ", ")),
+				LineNumber: server.LineNumber,
+			})
+		}
+
+		// The server and its locations, with the root directives set in them
+		var scopes []*Block
+		var roots []*Line
+		walkBlock(server, func(block *Block) {
+			if block == server || block.Name == "location" {
+				scopes = append(scopes, block)
+			}
+			roots = append(roots, block.FindLines("root")...)
+		})
+
+		for _, scope := range scopes {
+			line := scope.EffectiveDirective("autoindex")
+			if line == nil || seen[line] || len(line.Params) == 0 || unquote(line.Params[0]) != "on" {
+				continue
+			}
+			dir := "/"
+			if location := NewLocation(scope); location != nil {
+				if location.Modifier != LocationPrefix && location.Modifier != LocationPreferPrefix {
+					continue
+				}
+				dir = strings.TrimSuffix(location.Pattern, "/") + "/"
+			}
+			var listed []string
+			for _, name := range backupFileNames {
+				if uri := dir + name; servesStaticFile(server, uri) && listsDirectory(server, uri) {
+					listed = append(listed, uri)
+				}
+			}
+			if len(listed) == 0 {
+				continue
+			}
+			seen[line] = true
+			issues = append(issues, ValidationError{
+				Severity:   SeverityWarning,
+				Directive:  "autoindex",
+				Message:    fmt.Sprintf("directory listings reveal and serve backup files such as %s, deny them with location ~ (\\.(bak|old|sql|zip)|~)$ { deny all; }", strings.Join(listed, ", ")),
+				LineNumber: line.LineNumber,
+			})
+		}
+
+		if fsys == nil {
+			continue
+		}
+		if inherited := server.EffectiveDirective("root"); inherited != nil {
+			roots = append(roots, inherited)
+		}
+		for _, line := range roots {
+			if seen[line] || len(line.Params) == 0 {
+				continue
+			}
+			seen[line] = true
+			root := configPath(unquote(line.Params[0]))
+			if strings.Contains(root, "$") || !isAbsConfigPath(root) || path.Base(root) == "public" {
+				continue
+			}
+			if info, err := statPath(fsys, path.Join(root, "public")); err == nil && info.IsDir() {
+				issues = append(issues, ValidationError{
+					Severity:   SeverityWarning,
+					Directive:  "root",
+					Message:    fmt.Sprintf("root %s holds a public/ directory, the web root is likely one level too high and serves the application files next to it", root),
+					LineNumber: line.LineNumber,
+				})
+			}
+		}
+	}
+	return issues
+}
+
+// servesStaticFile reports whether the server answers a request for uri with a file
+// from its root: the location handling it neither denies access nor passes the
+// request to a backend or returns
+func servesStaticFile(server *Block, uri string) bool {
+	scope := server
+	if location := server.FindLocationByURI(uri); location != nil {
+		scope = location.Block
+	}
+	if len(scope.FindLines("internal")) > 0 {
+		return false
+	}
+	denies, _ := scope.inheritedLines("deny")
+	for _, line := range denies {
+		if hasParam(line.Params, "all") {
+			return false
+		}
+	}
+	for _, line := range scope.Lines {
+		if line.Type == LineTypeDirective && (line.Name == "return" || backendDirectives[line.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// listsDirectory reports whether autoindex is on in the location handling uri
+func listsDirectory(server *Block, uri string) bool {
+	scope := server
+	if location := server.FindLocationByURI(uri); location != nil {
+		scope = location.Block
+	}
+	line := scope.EffectiveDirective("autoindex")
+	return line != nil && len(line.Params) > 0 && unquote(line.Params[0]) == "on"
+}
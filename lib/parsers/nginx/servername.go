@@ -0,0 +1,31 @@
+package nginx
+
+// ServersWithoutName returns the http server blocks without a server_name
+// directive of their own. Their name is "", so besides requests without a Host
+// header they receive every request for an unknown host on the ports they are the
+// default server of, the first server listening on a port being the default unless
+// another has default_server
+func (config *Config) ServersWithoutName() []*Block {
+	var servers []*Block
+	for _, server := range config.FindBlocksByName("server") {
+		if serverProtocol(server) == "http" && len(server.FindLines("server_name")) == 0 {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// ValidateServerNames warns about the server blocks returned by ServersWithoutName,
+// to be reviewed as they may catch requests meant for other servers
+func (config *Config) ValidateServerNames() []ValidationError {
+	var issues []ValidationError
+	for _, server := range config.ServersWithoutName() {
+		issues = append(issues, ValidationError{
+			Severity:   SeverityWarning,
+			Directive:  "server",
+			Message:    `server block has no server_name, its name is "" and it may catch requests for unknown hosts as a default server`,
+			LineNumber: server.LineNumber,
+		})
+	}
+	return issues
+}
@@ -0,0 +1,183 @@
+package nginx
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ServiceConfig is the part of the configuration serving one service
+type ServiceConfig struct {
+	ServerNames   []string `json:"server_names"`   // server_name values of the servers of the service
+	Ports         []int    `json:"ports"`          // Ports the servers listen on, ascending
+	Upstreams     []string `json:"upstreams"`      // Upstream blocks requests are passed to
+	LocationPaths []string `json:"location_paths"` // Locations of the servers, with their modifier for exact and regex matches
+}
+
+// ExportServiceMap groups the http and stream servers and upstreams by service,
+// for tools that think in services rather than nginx blocks. A server belongs to
+// the service named after its primary server name (the first that is neither a
+// regex nor "_", without a leading wildcard), so the plain and TLS servers of a
+// host merge. Servers without a name are named after the upstream they pass to,
+// or "port:N" after the first port they listen on when they route to several. Upstreams no server refers to
+// form services of their own
+func (config *Config) ExportServiceMap() map[string]*ServiceConfig {
+	upstreams := map[string]bool{}
+	for _, upstream := range config.Upstreams() {
+		upstreams[upstream.Name] = true
+	}
+
+	services := map[string]*ServiceConfig{}
+	service := func(name string) *ServiceConfig {
+		if services[name] == nil {
+			services[name] = &ServiceConfig{}
+		}
+		return services[name]
+	}
+
+	referenced := map[string]bool{}
+	for _, server := range config.FindBlocksByName("server") {
+		protocol := serverProtocol(server)
+		if protocol != "http" && protocol != "stream" {
+			continue
+		}
+
+		var names []string
+		if protocol == "http" {
+			names = ServerNames(server)
+		}
+		targets := serverUpstreams(server, protocol, upstreams)
+		ports := serverPorts(server, protocol)
+
+		name := primaryServiceName(names)
+		switch {
+		case name != "":
+		case len(targets) == 1:
+			name = targets[0]
+		case len(ports) > 0:
+			name = fmt.Sprintf("port:%d", ports[0])
+		default:
+			name = fmt.Sprintf("server:%d", server.LineNumber)
+		}
+
+		entry := service(name)
+		entry.ServerNames = appendUnique(entry.ServerNames, names...)
+		entry.Upstreams = appendUnique(entry.Upstreams, targets...)
+		entry.Ports = appendPorts(entry.Ports, ports...)
+		walkBlock(server, func(block *Block) {
+			location := NewLocation(block)
+			if location == nil || location.IsNamed() {
+				return
+			}
+			path := location.Pattern
+			if location.Modifier != LocationPrefix {
+				path = string(location.Modifier) + " " + path
+			}
+			entry.LocationPaths = appendUnique(entry.LocationPaths, path)
+		})
+		for _, target := range targets {
+			referenced[target] = true
+		}
+	}
+
+	for _, upstream := range config.Upstreams() {
+		if !referenced[upstream.Name] {
+			entry := service(upstream.Name)
+			entry.Upstreams = appendUnique(entry.Upstreams, upstream.Name)
+		}
+	}
+
+	return services
+}
+
+// primaryServiceName returns the first server name usable as a service name, "" if none
+func primaryServiceName(names []string) string {
+	for _, name := range names {
+		if name == "" || name == "_" || strings.HasPrefix(name, "~") {
+			continue
+		}
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "*"), ".")
+		if name = strings.TrimSuffix(name, ".*"); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// serverUpstreams returns the upstreams a server passes requests or connections to,
+// in order, including those a map selects when the target is a map variable
+func serverUpstreams(server *Block, protocol string, upstreams map[string]bool) []string {
+	var targets []string
+	walkBlock(server, func(block *Block) {
+		for _, line := range block.Lines {
+			if line.Type != LineTypeDirective || !strings.HasSuffix(line.Name, "_pass") || len(line.Params) == 0 {
+				continue
+			}
+			target := unquote(line.Params[0])
+			if protocol == "http" && (line.Name == "proxy_pass" || line.Name == "grpc_pass") {
+				if parsed, err := url.Parse(target); err == nil && parsed.Host != "" {
+					target = parsed.Host
+				}
+			}
+			candidates := []string{target}
+			if strings.HasPrefix(target, "$") {
+				// A map choosing the upstream, as in SNI routing, may pass to any of its values
+				candidates = nil
+				if m := block.variableMap(target[1:]); m != nil {
+					for _, entry := range m.Entries {
+						candidates = append(candidates, entry.Value)
+					}
+					candidates = append(candidates, m.Default)
+				}
+			}
+			for _, candidate := range candidates {
+				if upstreams[candidate] {
+					targets = appendUnique(targets, candidate)
+				}
+			}
+		}
+	})
+	return targets
+}
+
+// serverPorts returns the ports a server listens on, ascending. http servers
+// without a listen directive listen on port 80
+func serverPorts(server *Block, protocol string) []int {
+	var ports []int
+	listens := server.FindLines("listen")
+	if len(listens) == 0 && protocol == "http" {
+		return []int{defaultHTTPPort}
+	}
+	for _, line := range listens {
+		endpoint, err := parseListen(line.Params, protocol)
+		if err == nil && !endpoint.Unix {
+			ports = appendPorts(ports, endpoint.Port)
+		}
+	}
+	return ports
+}
+
+// appendUnique appends the values missing from list, keeping their order
+func appendUnique(list []string, values ...string) []string {
+next:
+	for _, value := range values {
+		for _, existing := range list {
+			if existing == value {
+				continue next
+			}
+		}
+		list = append(list, value)
+	}
+	return list
+}
+
+// appendPorts adds the ports missing from a sorted list, keeping it sorted
+func appendPorts(ports []int, values ...int) []int {
+	for _, port := range values {
+		if i := sort.SearchInts(ports, port); i == len(ports) || ports[i] != port {
+			ports = append(ports[:i], append([]int{port}, ports[i:]...)...)
+		}
+	}
+	return ports
+}
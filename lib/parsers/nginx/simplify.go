@@ -0,0 +1,256 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Suggestion describes a rewrite that needs human review before being applied
+type Suggestion struct {
+	Message     string // Why the rewrite is suggested
+	LineNumber  int    // Line number of the first affected directive
+	Replacement string // Proposed configuration text
+}
+
+// returnIf is an `if ($var = value) { return code url; }` block
+type returnIf struct {
+	block    *Block
+	variable string
+	value    string
+	code     string
+	url      string
+}
+
+// variableNameSanitizer matches characters not allowed in generated variable names
+var variableNameSanitizer = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// SimplifyMultipleReturnDirectives finds runs of `if ($var = value) { return ...; }`
+// blocks in the same server or location and replaces them with a map in the http
+// block plus a single return. Runs that cannot be rewritten safely are returned as
+// suggestions, such as runs testing values that differ only in case, which map keys
+// match alike. Comparisons with variables and if blocks an ngonx:keep pragma
+// protects end a run and stay as written
+func (config *Config) SimplifyMultipleReturnDirectives() (modified int, suggestions []Suggestion) {
+	var parents []*Block
+	config.WalkBlocks(func(block *Block) {
+		if block.Name == "server" || block.Name == "location" {
+			parents = append(parents, block)
+		}
+	})
+
+	for _, parent := range parents {
+		for _, run := range returnIfRuns(parent) {
+			if len(run) < 2 {
+				continue
+			}
+			if reason := simplifyBlocker(parent, run); reason != "" {
+				suggestions = append(suggestions, Suggestion{
+					Message:     fmt.Sprintf("%d if/return blocks could be replaced by a map (%s)", len(run), reason),
+					LineNumber:  run[0].block.LineNumber,
+					Replacement: returnMapText(run),
+				})
+				continue
+			}
+			applyReturnMap(parent, run)
+			modified++
+		}
+	}
+	if modified > 0 {
+		config.Dirty = true
+	}
+
+	return modified, suggestions
+}
+
+// returnIfRuns groups consecutive if/return blocks testing the same variable
+func returnIfRuns(parent *Block) [][]returnIf {
+	var runs [][]returnIf
+	var current []returnIf
+
+	for _, line := range parent.Lines {
+		if line.Type == LineTypeComment {
+			continue
+		}
+		if line.Type == LineTypeBlock && line.BlockRef != nil {
+			if candidate, ok := parseReturnIf(line.BlockRef); ok && !line.BlockRef.kept() {
+				if len(current) > 0 && current[0].variable != candidate.variable {
+					runs = append(runs, current)
+					current = nil
+				}
+				current = append(current, candidate)
+				continue
+			}
+		}
+		if len(current) > 0 {
+			runs = append(runs, current)
+			current = nil
+		}
+	}
+	if len(current) > 0 {
+		runs = append(runs, current)
+	}
+
+	return runs
+}
+
+// parseReturnIf recognizes an if block whose only content is a return
+func parseReturnIf(block *Block) (returnIf, bool) {
+	if block.Name != "if" || len(block.Blocks) > 0 {
+		return returnIf{}, false
+	}
+
+	condition := strings.Join(block.Params, " ")
+	if !strings.HasPrefix(condition, "(") || !strings.HasSuffix(condition, ")") {
+		return returnIf{}, false
+	}
+	variable, value, found := strings.Cut(strings.TrimSpace(condition[1:len(condition)-1]), " = ")
+	if !found || !strings.HasPrefix(variable, "$") {
+		return returnIf{}, false
+	}
+	// A value holding variables is compared once they are expanded, a map key is not
+	value = unquote(strings.TrimSpace(value))
+	if strings.Contains(value, "$") {
+		return returnIf{}, false
+	}
+
+	var ret *Line
+	for _, line := range block.Lines {
+		if line.Type == LineTypeComment {
+			continue
+		}
+		if line.Name != "return" || ret != nil {
+			return returnIf{}, false
+		}
+		ret = line
+	}
+	if ret == nil || len(ret.Params) == 0 || len(ret.Params) > 2 {
+		return returnIf{}, false
+	}
+
+	candidate := returnIf{
+		block:    block,
+		variable: strings.TrimSpace(variable),
+		value:    value,
+		code:     ret.Params[0],
+	}
+	if len(ret.Params) == 2 {
+		candidate.url = ret.Params[1]
+	}
+
+	return candidate, true
+}
+
+// simplifyBlocker explains why a run cannot be rewritten automatically, or returns ""
+func simplifyBlocker(parent *Block, run []returnIf) string {
+	// Map keys match case-insensitively, unlike the = of if
+	values := map[string]string{}
+	for _, candidate := range run {
+		if candidate.code != run[0].code {
+			return "return codes differ"
+		}
+		if candidate.url == "" {
+			return "return without a target"
+		}
+		key := strings.ToLower(candidate.value)
+		previous, ok := values[key]
+		if ok && previous == candidate.value {
+			return fmt.Sprintf("value %q is tested more than once", candidate.value)
+		}
+		if ok {
+			return fmt.Sprintf("values %q and %q differ only in case, which map keys do not tell apart", previous, candidate.value)
+		}
+		values[key] = candidate.value
+	}
+
+	http := parent.Ancestor("http")
+	if http == nil {
+		return "no enclosing http block to hold the map"
+	}
+	for _, existing := range http.FindBlocks("map") {
+		if len(existing.Params) > 1 && existing.Params[1] == returnMapVariable(run) {
+			return fmt.Sprintf("map variable %s is already defined", returnMapVariable(run))
+		}
+	}
+
+	return ""
+}
+
+// applyReturnMap replaces the run with a map in the http block and a single if/return
+func applyReturnMap(parent *Block, run []returnIf) {
+	target := returnMapVariable(run)
+
+	mapBlock := NewBlock("map", run[0].variable, target)
+	mapBlock.AddDirective("default", `""`)
+	for _, candidate := range run {
+		mapBlock.AddDirective(quoteMapKey(candidate.value), candidate.url)
+	}
+
+	http := parent.Ancestor("http")
+	index := len(http.Lines)
+	for i, line := range http.Lines {
+		if line.Type == LineTypeBlock && line.Name == "server" {
+			index = i
+			break
+		}
+	}
+	http.InsertBlock(index, mapBlock)
+
+	ifBlock := NewBlock("if", "("+target+")")
+	ifBlock.AddDirective("return", run[0].code, target)
+
+	position := 0
+	for i, line := range parent.Lines {
+		if line.BlockRef == run[0].block {
+			position = i
+			break
+		}
+	}
+	for _, candidate := range run {
+		parent.RemoveBlock(candidate.block)
+	}
+	parent.InsertBlock(position, ifBlock)
+}
+
+// returnMapVariable derives the map target variable name from the tested variable
+func returnMapVariable(run []returnIf) string {
+	name := variableNameSanitizer.ReplaceAllString(strings.TrimPrefix(run[0].variable, "$"), "_")
+	return "$" + name + "_return_target"
+}
+
+// returnMapText renders the map and if/return that would replace the run
+func returnMapText(run []returnIf) string {
+	target := returnMapVariable(run)
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "map %s %s {\n", run[0].variable, target)
+	fmt.Fprintf(&builder, "  default \"\";\n")
+	for _, candidate := range run {
+		url := candidate.url
+		if url == "" {
+			url = candidate.code
+		}
+		fmt.Fprintf(&builder, "  %s %s;\n", quoteMapKey(candidate.value), url)
+	}
+	fmt.Fprintf(&builder, "}\n")
+	fmt.Fprintf(&builder, "if (%s) {\n  return %s %s;\n}\n", target, run[0].code, target)
+
+	return builder.String()
+}
+
+// quoteMapKey escapes map keys that would otherwise be read as map parameters or regexes
+func quoteMapKey(value string) string {
+	switch value {
+	case "":
+		return `""`
+	case "default", "hostnames", "include", "volatile":
+		return `\` + value
+	}
+	if strings.HasPrefix(value, "~") {
+		value = `\` + value
+	}
+	if strings.ContainsAny(value, " ;{}#") {
+		return `"` + value + `"`
+	}
+	return value
+}
@@ -0,0 +1,63 @@
+package nginx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSimplifyMultipleReturnDirectives(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifs         string
+		modified    int
+		suggestions int
+	}{
+		{
+			name: "literal values",
+			ifs: `if ($host = a.example.com) { return 301 https://a.example.org; }
+			      if ($host = b.example.com) { return 301 https://b.example.org; }`,
+			modified: 1,
+		},
+		{
+			name: "variable operand",
+			ifs: `if ($host = $server_name) { return 301 https://a.example.org; }
+			      if ($host = b.example.com) { return 301 https://b.example.org; }`,
+		},
+		{
+			name: "braced variable operand",
+			ifs: `if ($arg_v = "${http_x}1") { return 301 https://a.example.org; }
+			      if ($arg_v = 2) { return 301 https://b.example.org; }`,
+		},
+		{
+			name: "values differing in case",
+			ifs: `if ($arg_lang = EN) { return 302 /en/; }
+			      if ($arg_lang = en) { return 302 /en-us/; }`,
+			suggestions: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "http { server { " + tt.ifs + " } }"
+			config, err := ParseReader(strings.NewReader(input), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			modified, suggestions := config.SimplifyMultipleReturnDirectives()
+			if modified != tt.modified || len(suggestions) != tt.suggestions {
+				t.Fatalf("modified %d with %d suggestions, want %d and %d: %+v", modified, len(suggestions), tt.modified, tt.suggestions, suggestions)
+			}
+			maps := config.FindBlocksByName("map")
+			if tt.modified == 0 && len(maps) > 0 {
+				t.Fatalf("map added:\n%s", config.AutoIndent(4))
+			}
+			for _, block := range maps {
+				for _, line := range block.Lines {
+					if strings.Contains(line.Name, "$") {
+						t.Fatalf("map key %s holds a variable", line.Name)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package nginx
+
+import "strings"
+
+// SourceText reconstructs the configuration text from the raw source segments
+// recorded while parsing. For an unmodified tree the result is byte-identical
+// to the parsed input, unlike WriteConfig which regenerates normalized text.
+// Lines and blocks added programmatically have no source and are rendered in
+// canonical form; edits to parameters of parsed lines are not reflected
+func (config *Config) SourceText() string {
+	var builder strings.Builder
+	writeSource(&builder, config.RootBlock, 0)
+	builder.WriteString(config.RawTrailer)
+	return builder.String()
+}
+
+// writeSource writes the raw source of a block's lines, recursing into child blocks
+func writeSource(builder *strings.Builder, block *Block, depth int) {
+	prefix := strings.Repeat(defaultIndent, depth)
+
+	for _, line := range block.Lines {
+		if line.LineNumber > 0 {
+			builder.WriteString(line.Raw)
+		} else {
+			builder.WriteString(prefix + formatLine(line) + "\n")
+		}
+
+		if line.Type != LineTypeBlock {
+			continue
+		}
+		child := blockOf(line)
+		writeSource(builder, child, depth+1)
+		if child.LineNumber > 0 {
+			builder.WriteString(child.RawClosing)
+		} else {
+			builder.WriteString(prefix + formatClosing(child) + "\n")
+		}
+	}
+}
+
+// sourceElement is a statement or a closing brace of the tree in source order
+type sourceElement struct {
+	line    *Line  // Statement, nil for a closing brace
+	closing *Block // Block closed by the brace, nil for a statement
+	indent  string // Indentation used when the element is regenerated
+}
+
+// raw returns the source text of the element. The code of verbatim blocks is part
+// of the statement opening them
+func (element sourceElement) raw() string {
+	if element.closing != nil {
+		return element.closing.RawClosing
+	}
+	if element.line.Type == LineTypeBlock && blockOf(element.line).Verbatim != "" {
+		return element.line.Raw + blockOf(element.line).RawClosing
+	}
+	return element.line.Raw
+}
+
+// leadingRaw returns the source text of the line the element starts on, empty when
+// the element shares that line with earlier elements
+func (element sourceElement) leadingRaw() string {
+	if element.closing != nil {
+		return element.closing.RawClosing
+	}
+	return element.line.Raw
+}
+
+// endLine returns the source line the element ends on, which differs from its first
+// line for verbatim blocks
+func (element sourceElement) endLine() int {
+	if element.line != nil && element.line.Type == LineTypeBlock && element.line.LineNumber > 0 {
+		if child := blockOf(element.line); child.Verbatim != "" {
+			return child.EndLineNumber
+		}
+	}
+	return element.lineNumber()
+}
+
+// lineNumber returns the source line the element starts on, 0 for elements added programmatically
+func (element sourceElement) lineNumber() int {
+	if element.closing != nil {
+		if element.closing.LineNumber == 0 {
+			return 0
+		}
+		return element.closing.EndLineNumber
+	}
+	return element.line.LineNumber
+}
+
+// text returns the canonical text of the element
+func (element sourceElement) text() string {
+	if element.closing != nil {
+		return formatClosing(element.closing)
+	}
+	text := formatLine(element.line)
+	if element.line.Type == LineTypeBlock {
+		text += blockOf(element.line).Verbatim
+	}
+	return text
+}
+
+// sourceElements lists the statements and closing braces of a block in source order,
+// with the indentation of their parsed siblings
+func sourceElements(block *Block, prefix string, elements []sourceElement) []sourceElement {
+	// New lines follow the indentation of their parsed siblings
+	for _, line := range block.Lines {
+		if line.Raw != "" {
+			prefix = rawIndent(line.Raw)
+			break
+		}
+	}
+
+	for _, line := range block.Lines {
+		indent := prefix
+		if line.Raw != "" {
+			indent = rawIndent(line.Raw)
+		}
+		elements = append(elements, sourceElement{line: line, indent: indent})
+		if line.Type != LineTypeBlock {
+			continue
+		}
+
+		child := blockOf(line)
+		if child.Verbatim != "" {
+			continue
+		}
+		elements = sourceElements(child, indent+defaultIndent, elements)
+		if child.LineNumber > 0 && child.EndLineNumber == 0 {
+			// The source ended before the block was closed
+			continue
+		}
+		elements = append(elements, sourceElement{closing: child, indent: indent})
+	}
+	return elements
+}
+
+// sourceGroup returns the element at index i and the following elements that start
+// on the physical line the previous one ends on and therefore share its raw source text
+func sourceGroup(elements []sourceElement, i int) []sourceElement {
+	end := i + 1
+	if last := elements[i].endLine(); elements[i].leadingRaw() != "" && elements[i].lineNumber() > 0 {
+		for end < len(elements) && last > 0 && elements[end].lineNumber() == last && elements[end].leadingRaw() == "" {
+			last = elements[end].endLine()
+			end++
+		}
+	}
+	return elements[i:end]
+}
+
+// groupText returns the canonical text of the elements of a group
+func groupText(group []sourceElement) string {
+	texts := make([]string, 0, len(group))
+	for _, element := range group {
+		texts = append(texts, element.text())
+	}
+	return strings.Join(texts, "\n")
+}
+
+// parsedGroupText returns the canonical text of a group recorded when it was parsed
+func parsedGroupText(group []sourceElement) string {
+	if group[0].closing != nil {
+		return group[0].closing.parsedClosing
+	}
+	return group[0].line.parsedText
+}
+
+// recordParsedState stores the canonical text of every physical source line and the
+// byte offset of every statement, so lossless writing can tell which lines changed
+func recordParsedState(root *Block) {
+	elements := sourceElements(root, "", nil)
+	offset := 0
+	for i := 0; i < len(elements); i++ {
+		group := sourceGroup(elements, i)
+		if group[0].closing != nil {
+			group[0].closing.parsedClosing = groupText(group)
+		} else {
+			group[0].line.parsedText = groupText(group)
+		}
+
+		for _, element := range group {
+			if element.line != nil {
+				element.line.Offset = offset
+			}
+			offset += len(element.raw())
+		}
+		i += len(group) - 1
+	}
+}
+
+// losslessText writes the configuration keeping the source text of unchanged lines
+func (config *Config) losslessText() string {
+	var builder strings.Builder
+	writeLossless(&builder, sourceElements(config.RootBlock, "", nil), sourceLineEnding(config.RootBlock))
+	builder.WriteString(config.RawTrailer)
+	return builder.String()
+}
+
+// writeLossless writes statements and closing braces, using their raw source text when
+// the physical line they were parsed from is unchanged and regenerating them with the
+// indentation of the surrounding source otherwise
+func writeLossless(builder *strings.Builder, elements []sourceElement, ending string) {
+	for i := 0; i < len(elements); i++ {
+		group := sourceGroup(elements, i)
+		i += len(group) - 1
+
+		if group[0].leadingRaw() != "" && group[0].lineNumber() > 0 && groupText(group) == parsedGroupText(group) {
+			for _, element := range group {
+				builder.WriteString(element.raw())
+			}
+			continue
+		}
+
+		for _, element := range group {
+			startLine(builder, ending)
+			if element.closing != nil {
+				builder.WriteString(leadingBlankLines(element.closing.RawClosing) + element.indent + formatClosing(element.closing) + ending)
+				continue
+			}
+
+			line := element.line
+			builder.WriteString(leadingBlankLines(line.Raw) + element.indent + formatLine(line))
+			if child := blockOf(line); line.Type == LineTypeBlock && child.Verbatim != "" {
+				builder.WriteString(formatVerbatim(child.Verbatim, element.indent) + formatClosing(child))
+			}
+			builder.WriteString(ending)
+		}
+	}
+}
+
+// startLine terminates the output written so far if it ends in the middle of a line,
+// which happens when the source does not end with a line terminator
+func startLine(builder *strings.Builder, ending string) {
+	text := builder.String()
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		builder.WriteString(ending)
+	}
+}
+
+// sourceLineEnding returns the line terminator used by the parsed source, "\n" by default
+func sourceLineEnding(root *Block) string {
+	ending := "\n"
+	found := false
+	walkBlock(root, func(block *Block) {
+		for _, line := range block.Lines {
+			if !found && strings.Contains(line.Raw, "\n") {
+				found = true
+				if strings.Contains(line.Raw, "\r\n") {
+					ending = "\r\n"
+				}
+			}
+		}
+	})
+	return ending
+}
+
+// leadingBlankLines returns the blank lines at the start of a raw source segment
+func leadingBlankLines(raw string) string {
+	end := 0
+	for end < len(raw) {
+		next := strings.IndexByte(raw[end:], '\n')
+		if next < 0 || strings.TrimSpace(raw[end:end+next]) != "" {
+			break
+		}
+		end += next + 1
+	}
+	return raw[:end]
+}
+
+// rawIndent returns the indentation of the first non-blank line of a raw source segment
+func rawIndent(raw string) string {
+	content := raw[len(leadingBlankLines(raw)):]
+	return content[:len(content)-len(strings.TrimLeft(content, " \t"))]
+}
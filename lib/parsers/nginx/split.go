@@ -0,0 +1,119 @@
+package nginx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// fileNameSanitizer matches characters that are unsafe in generated file names
+var fileNameSanitizer = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// SplitLargeFile writes every server block to its own file in outputDir, named
+// after the server's primary server_name, and writes outputDir/nginx.conf with
+// the remaining configuration including those files in place of the server
+// blocks. Returns the per-server configurations keyed by file name
+func (config *Config) SplitLargeFile(outputDir string) (map[string]*Config, error) {
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	root := config.Clone()
+	root.FilePath = filepath.Join(outputDir, "nginx.conf")
+
+	files := map[string]*Config{}
+	// A server named nginx must not replace the main file
+	used := map[string]bool{"nginx.conf": true}
+	var servers []*Block
+	root.WalkBlocks(func(block *Block) {
+		if block.Name == "server" {
+			servers = append(servers, block)
+		}
+	})
+
+	for i, server := range servers {
+		fileName := uniqueFileName(serverFileName(server, i+1), used)
+		used[fileName] = true
+
+		serverConfig := &Config{
+			RootBlock: NewBlock("root"),
+			FilePath:  filepath.Join(outputDir, fileName),
+		}
+
+		parent := server.ParentRef
+		index := -1
+		for j, line := range parent.Lines {
+			if line.BlockRef == server {
+				index = j
+				break
+			}
+		}
+		parent.RemoveBlock(server)
+		parent.InsertDirective(index, "include", fileName)
+		serverConfig.RootBlock.AddBlock(server)
+
+		if err := writeConfigFile(serverConfig); err != nil {
+			return nil, err
+		}
+		files[fileName] = serverConfig
+	}
+
+	if err := writeConfigFile(root); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// serverFileName derives a file name from the server's primary server_name
+func serverFileName(server *Block, index int) string {
+	for _, name := range ServerNames(server) {
+		if name == "" || name == "_" || strings.HasPrefix(name, "~") {
+			continue
+		}
+		name = strings.TrimPrefix(strings.ReplaceAll(name, "*", "wildcard"), ".")
+		if sanitized := strings.Trim(fileNameSanitizer.ReplaceAllString(name, "_"), "._"); sanitized != "" {
+			return sanitized + ".conf"
+		}
+	}
+	return fmt.Sprintf("server_%d.conf", index)
+}
+
+// uniqueFileName appends a counter to fileName until it is not in use
+func uniqueFileName(fileName string, used map[string]bool) string {
+	if !used[fileName] {
+		return fileName
+	}
+	base := strings.TrimSuffix(fileName, ".conf")
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s_%d.conf", base, i)
+		if !used[candidate] {
+			return candidate
+		}
+	}
+}
+
+// writeConfigFile serializes a configuration to its FilePath
+func writeConfigFile(config *Config) error {
+	return os.WriteFile(config.FilePath, []byte(config.String()), 0o644)
+}
+
+// ConsolidateConfdFiles inlines every included file (conf.d/*.conf, sites-enabled/*,
+// snippets) into a copy of the configuration and writes it to outputPath as a
+// single file. Relative includes resolve against the directory of the configuration file.
+// This is the inverse of SplitLargeFile
+func (config *Config) ConsolidateConfdFiles(outputPath string) (*Config, error) {
+	consolidated := config.Clone()
+	if err := consolidated.InlineIncludes(filepath.Dir(config.FilePath)); err != nil {
+		return nil, err
+	}
+
+	consolidated.FilePath = outputPath
+	if err := writeConfigFile(consolidated); err != nil {
+		return nil, err
+	}
+
+	return consolidated, nil
+}
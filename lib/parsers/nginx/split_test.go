@@ -0,0 +1,67 @@
+package nginx
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSplitLargeFile(t *testing.T) {
+	input := `
+http {
+    server {
+        server_name nginx;
+    }
+    server {
+        server_name example.com;
+    }
+    server {
+        server_name Example.com;
+    }
+    server {
+        listen 8080;
+    }
+}
+`
+	config, err := ParseReader(strings.NewReader(input), "nginx.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	files, err := config.SplitLargeFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	want := []string{"example.com.conf", "example.com_2.conf", "nginx_2.conf", "server_4.conf"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("files = %v, want %v", names, want)
+	}
+
+	main, err := ParseConfig(filepath.Join(dir, "nginx.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var includes []string
+	for _, line := range main.FindLinesByName("include") {
+		includes = append(includes, line.Params[0])
+	}
+	if want := "nginx_2.conf example.com.conf example.com_2.conf server_4.conf"; strings.Join(includes, " ") != want {
+		t.Fatalf("main file includes %v, want %s", includes, want)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "nginx_2.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "server_name nginx;") {
+		t.Fatalf("nginx_2.conf holds\n%s", data)
+	}
+}
@@ -0,0 +1,108 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// deprecatedSSLProtocols lists protocol versions that should no longer be enabled
+var deprecatedSSLProtocols = map[string]bool{
+	"SSLv2":   true,
+	"SSLv3":   true,
+	"TLSv1":   true,
+	"TLSv1.1": true,
+}
+
+// weakCipherKeywords are cipher string components that select weak algorithms
+var weakCipherKeywords = []string{"RC4", "DES", "MD5", "EXPORT"}
+
+// weakCipherSuites lists well-known OpenSSL cipher suite names considered weak
+var weakCipherSuites = map[string]bool{
+	"RC4-MD5":                 true,
+	"RC4-SHA":                 true,
+	"ECDHE-RSA-RC4-SHA":       true,
+	"ECDHE-ECDSA-RC4-SHA":     true,
+	"ECDH-RSA-RC4-SHA":        true,
+	"ECDH-ECDSA-RC4-SHA":      true,
+	"ADH-RC4-MD5":             true,
+	"PSK-RC4-SHA":             true,
+	"DES-CBC-SHA":             true,
+	"DES-CBC3-SHA":            true,
+	"DES-CBC3-MD5":            true,
+	"EDH-RSA-DES-CBC-SHA":     true,
+	"EDH-DSS-DES-CBC-SHA":     true,
+	"EDH-RSA-DES-CBC3-SHA":    true,
+	"EDH-DSS-DES-CBC3-SHA":    true,
+	"RC2-CBC-MD5":             true,
+	"IDEA-CBC-MD5":            true,
+	"EXP-RC4-MD5":             true,
+	"EXP-RC2-CBC-MD5":         true,
+	"EXP-DES-CBC-SHA":         true,
+	"EXP-EDH-RSA-DES-CBC-SHA": true,
+	"EXP-EDH-DSS-DES-CBC-SHA": true,
+	"EXP-ADH-RC4-MD5":         true,
+	"NULL-MD5":                true,
+	"NULL-SHA":                true,
+}
+
+// DetectLegacySSLProtocols reports ssl_protocols directives enabling deprecated
+// protocol versions and ssl_ciphers directives selecting weak cipher suites
+func (config *Config) DetectLegacySSLProtocols() []ValidationError {
+	var issues []ValidationError
+
+	for _, line := range config.FindLinesByName("ssl_protocols") {
+		for _, param := range line.Params {
+			protocol := strings.Trim(param, `"'`)
+			if deprecatedSSLProtocols[protocol] {
+				issues = append(issues, ValidationError{
+					Severity:   SeverityWarning,
+					Directive:  line.Name,
+					Message:    fmt.Sprintf("protocol %s is deprecated and insecure; use TLSv1.2 or TLSv1.3", protocol),
+					LineNumber: line.LineNumber,
+				})
+			}
+		}
+	}
+
+	for _, line := range config.FindLinesByName("ssl_ciphers") {
+		for _, cipher := range weakCiphers(strings.Join(line.Params, "")) {
+			issues = append(issues, ValidationError{
+				Severity:   SeverityWarning,
+				Directive:  line.Name,
+				Message:    fmt.Sprintf("cipher %s is considered weak", cipher),
+				LineNumber: line.LineNumber,
+			})
+		}
+	}
+
+	return issues
+}
+
+// weakCiphers returns the enabled components of an OpenSSL cipher string that select weak ciphers
+func weakCiphers(cipherString string) []string {
+	var weak []string
+
+	cipherString = strings.Trim(cipherString, `"'`)
+	for _, cipher := range strings.FieldsFunc(cipherString, func(r rune) bool {
+		return r == ':' || r == ',' || r == ' '
+	}) {
+		// Excluded ciphers (!RC4, -MD5) are fine
+		if strings.HasPrefix(cipher, "!") || strings.HasPrefix(cipher, "-") {
+			continue
+		}
+		cipher = strings.TrimPrefix(cipher, "+")
+
+		if weakCipherSuites[strings.ToUpper(cipher)] {
+			weak = append(weak, cipher)
+			continue
+		}
+		for _, keyword := range weakCipherKeywords {
+			if strings.EqualFold(cipher, keyword) {
+				weak = append(weak, cipher)
+				break
+			}
+		}
+	}
+
+	return weak
+}
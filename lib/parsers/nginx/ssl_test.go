@@ -0,0 +1,72 @@
+package nginx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetectLegacySSLProtocols(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "modern protocols",
+			input: "ssl_protocols TLSv1.2 TLSv1.3;",
+		},
+		{
+			name:  "deprecated protocols",
+			input: "ssl_protocols SSLv2 SSLv3 TLSv1 TLSv1.1 TLSv1.2;",
+			want: []string{
+				"protocol SSLv2 is deprecated and insecure; use TLSv1.2 or TLSv1.3",
+				"protocol SSLv3 is deprecated and insecure; use TLSv1.2 or TLSv1.3",
+				"protocol TLSv1 is deprecated and insecure; use TLSv1.2 or TLSv1.3",
+				"protocol TLSv1.1 is deprecated and insecure; use TLSv1.2 or TLSv1.3",
+			},
+		},
+		{
+			name:  "quoted protocol",
+			input: `ssl_protocols "TLSv1" TLSv1.2;`,
+			want:  []string{"protocol TLSv1 is deprecated and insecure; use TLSv1.2 or TLSv1.3"},
+		},
+		{
+			name:  "strong ciphers",
+			input: "ssl_ciphers HIGH:!aNULL:!MD5;",
+		},
+		{
+			name:  "weak keywords",
+			input: "ssl_ciphers 'HIGH:RC4:+DES:md5';",
+			want:  []string{"cipher RC4 is considered weak", "cipher DES is considered weak", "cipher md5 is considered weak"},
+		},
+		{
+			name:  "weak suites",
+			input: "ssl_ciphers ECDHE-RSA-AES128-GCM-SHA256:DES-CBC3-SHA:EXP-RC4-MD5;",
+			want:  []string{"cipher DES-CBC3-SHA is considered weak", "cipher EXP-RC4-MD5 is considered weak"},
+		},
+		{
+			name:  "excluded ciphers",
+			input: "ssl_ciphers HIGH:!RC4:-EXPORT:!DES-CBC3-SHA;",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseReader(strings.NewReader("http {\n    server {\n        "+tt.input+"\n    }\n}\n"), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, issue := range config.DetectLegacySSLProtocols() {
+				if issue.Severity != SeverityWarning || issue.LineNumber != 3 || issue.Directive != strings.Fields(tt.input)[0] {
+					t.Errorf("unexpected finding %+v", issue)
+				}
+				got = append(got, issue.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("findings %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// prereadVariables lists the variables ngx_stream_ssl_preread_module fills from the
+// TLS ClientHello, empty unless ssl_preread is on
+var prereadVariables = map[string]bool{
+	"ssl_preread_server_name":    true,
+	"ssl_preread_alpn_protocols": true,
+	"ssl_preread_protocol":       true,
+}
+
+// StreamTrace describes how nginx would route a TLS connection in the stream module
+type StreamTrace struct {
+	SNI       string   // Server name sent by the client
+	Port      int      // Port the connection arrives on
+	Server    *Block   // Selected stream server, nil if no server listens on the port
+	Preread   bool     // Whether ssl_preread is on in the selected server
+	ProxyPass *Line    // proxy_pass directive of the server, nil if it has none
+	Backend   string   // Address or upstream the connection is proxied to, empty if unknown
+	Upstream  *Block   // Stream upstream named by the backend, nil for addresses
+	Problem   string   // Misconfiguration affecting the routing, empty if none
+	Steps     []string // Human-readable routing decisions in order
+}
+
+// TraceStreamConnection follows the routing of a TLS connection with the given SNI
+// arriving on port at the stream module: it selects the stream server listening on
+// the port and evaluates its proxy_pass, expanding variables through the map chain
+// fed by $ssl_preread_server_name. Using the preread variables while ssl_preread is
+// off is reported as a problem, as they are always empty then
+func (config *Config) TraceStreamConnection(sni string, port int) (*StreamTrace, error) {
+	if err := validatePort(port); err != nil {
+		return nil, err
+	}
+
+	trace := &StreamTrace{SNI: sni, Port: port}
+	server, reason := config.selectStreamServer(sni, port)
+	if server == nil {
+		trace.addStep("no stream server listens on port %d", port)
+		return trace, nil
+	}
+	trace.Server = server
+	trace.addStep("selected stream server at line %d (%s)", server.LineNumber, reason)
+
+	if line := server.EffectiveDirective("ssl_preread"); line != nil && len(line.Params) > 0 && line.Params[0] == "on" {
+		trace.Preread = true
+		trace.addStep("ssl_preread is on (line %d)", line.LineNumber)
+	}
+
+	lines := server.FindLines("proxy_pass")
+	if len(lines) == 0 || len(lines[0].Params) == 0 {
+		trace.addStep("server has no proxy_pass")
+		return trace, nil
+	}
+	trace.ProxyPass = lines[0]
+
+	backend, ok := expandVariables(unquote(lines[0].Params[0]), func(name string) (string, bool) {
+		return trace.variable(server, name, 0)
+	})
+	if !ok {
+		trace.addStep("cannot evaluate proxy_pass %s at line %d", strings.Join(lines[0].Params, " "), lines[0].LineNumber)
+		return trace, nil
+	}
+	trace.Backend = backend
+	if backend == "" {
+		trace.addStep("proxy_pass at line %d evaluates to an empty address, the connection is closed", lines[0].LineNumber)
+		return trace, nil
+	}
+
+	for _, upstream := range config.FindBlocksByName("upstream") {
+		if serverProtocol(upstream) == "stream" && len(upstream.Params) > 0 && unquote(upstream.Params[0]) == backend {
+			trace.Upstream = upstream
+			trace.addStep("proxied to upstream %s at line %d", backend, upstream.LineNumber)
+			return trace, nil
+		}
+	}
+	trace.addStep("proxied to %s", backend)
+	return trace, nil
+}
+
+// variable returns the value of a variable for the traced connection, evaluating the
+// stream maps defining it. ok is false for variables the tracer does not know
+func (trace *StreamTrace) variable(scope *Block, name string, depth int) (string, bool) {
+	if prereadVariables[name] {
+		if !trace.Preread {
+			trace.Problem = fmt.Sprintf("$%s is used but ssl_preread is off, it is always empty", name)
+			trace.addStep("$%s is empty because ssl_preread is off", name)
+			return "", true
+		}
+		if name == "ssl_preread_server_name" {
+			return trace.SNI, true
+		}
+		return "", false
+	}
+	if name == "server_port" {
+		return fmt.Sprint(trace.Port), true
+	}
+
+	m := streamMap(scope, name)
+	if m == nil || depth >= maxVariableDepth {
+		return "", false
+	}
+	source, ok := expandVariables(m.Source, func(name string) (string, bool) {
+		return trace.variable(scope, name, depth+1)
+	})
+	if !ok {
+		return "", false
+	}
+
+	result, entry := m.Evaluate(source)
+	if entry != nil {
+		trace.addStep("map %s at line %d maps %q to %q (line %d)", m.Source, m.Block.LineNumber, source, result, entry.Line.LineNumber)
+	} else {
+		trace.addStep("map %s at line %d maps %q to default %q", m.Source, m.Block.LineNumber, source, result)
+	}
+	return expandVariables(result, func(name string) (string, bool) {
+		return trace.variable(scope, name, depth+1)
+	})
+}
+
+// addStep records a routing decision
+func (trace *StreamTrace) addStep(format string, args ...interface{}) {
+	trace.Steps = append(trace.Steps, fmt.Sprintf(format, args...))
+}
+
+// selectStreamServer picks the stream server handling TCP connections on port,
+// preferring a server_name matching the SNI, then the default server, then the
+// first server listening on the port
+func (config *Config) selectStreamServer(sni string, port int) (*Block, string) {
+	var first, defaultServer *Block
+	for _, server := range config.FindBlocksByName("server") {
+		if serverProtocol(server) != "stream" {
+			continue
+		}
+		for _, line := range server.FindLines("listen") {
+			endpoint, err := parseListen(line.Params, "stream")
+			if err != nil || endpoint.Unix || endpoint.UDP || endpoint.Port != port {
+				continue
+			}
+			for _, name := range ServerNames(server) {
+				if sni != "" && name == strings.ToLower(sni) {
+					return server, "exact server_name " + name
+				}
+			}
+			if first == nil {
+				first = server
+			}
+			if defaultServer == nil && (hasParam(line.Params[1:], "default_server") || hasParam(line.Params[1:], "default")) {
+				defaultServer = server
+			}
+		}
+	}
+
+	if defaultServer != nil {
+		return defaultServer, fmt.Sprintf("default_server for port %d", port)
+	}
+	if first != nil {
+		return first, fmt.Sprintf("first stream server listening on port %d", port)
+	}
+	return nil, ""
+}
+
+// streamMap finds the map defining a variable for a stream server, nil if there is none
+func streamMap(server *Block, name string) *Map {
+	for parent := server.ParentRef; parent != nil; parent = parent.ParentRef {
+		for _, m := range parent.Maps() {
+			if m.Variable == name {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateSSLPreread reports stream servers whose proxy_pass depends, directly or
+// through maps, on $ssl_preread_* variables while ssl_preread is off
+func (config *Config) ValidateSSLPreread() []ValidationIssue {
+	var issues []ValidationIssue
+
+	for _, server := range config.FindBlocksByName("server") {
+		if serverProtocol(server) != "stream" {
+			continue
+		}
+		if line := server.EffectiveDirective("ssl_preread"); line != nil && len(line.Params) > 0 && line.Params[0] == "on" {
+			continue
+		}
+		for _, line := range server.FindLines("proxy_pass") {
+			if len(line.Params) == 0 {
+				continue
+			}
+			if variable := prereadDependency(server, unquote(line.Params[0]), 0); variable != "" {
+				issues = append(issues, ValidationIssue{
+					Severity:   SeverityError,
+					Directive:  line.Name,
+					Message:    fmt.Sprintf("proxy_pass depends on $%s but ssl_preread is off, the variable is always empty", variable),
+					LineNumber: line.LineNumber,
+				})
+			}
+		}
+	}
+
+	return issues
+}
+
+// prereadDependency returns the first $ssl_preread_* variable an expression depends
+// on through the maps of a stream server, empty if there is none
+func prereadDependency(server *Block, expression string, depth int) string {
+	if depth >= maxVariableDepth {
+		return ""
+	}
+	for _, match := range variablePattern.FindAllStringSubmatch(expression, -1) {
+		name := match[1] + match[2]
+		if prereadVariables[name] {
+			return name
+		}
+		m := streamMap(server, name)
+		if m == nil {
+			continue
+		}
+		expressions := []string{m.Source, m.Default}
+		for _, entry := range m.Entries {
+			expressions = append(expressions, entry.Value)
+		}
+		for _, expression := range expressions {
+			if variable := prereadDependency(server, expression, depth+1); variable != "" {
+				return variable
+			}
+		}
+	}
+	return ""
+}
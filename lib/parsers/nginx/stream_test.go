@@ -0,0 +1,149 @@
+package nginx
+
+import (
+	"strings"
+	"testing"
+)
+
+const streamConfig = `
+stream {
+    map $ssl_preread_server_name $backend {
+        hostnames;
+        default       fallback;
+        api.example.com api_pool;
+        *.example.org 10.0.0.9:443;
+        empty.example.com "";
+    }
+    map $ssl_preread_server_name $unread {
+        default unread_pool;
+    }
+    upstream api_pool {
+        server 10.0.0.1:443;
+    }
+    upstream fallback {
+        server 10.0.0.2:443;
+    }
+    server {
+        listen 443;
+        ssl_preread on;
+        proxy_pass $backend;
+    }
+    server {
+        listen 8443;
+        proxy_pass $unread;
+    }
+    server {
+        listen 9000;
+        server_name first.example.com;
+        proxy_pass 10.0.1.1:9000;
+    }
+    server {
+        listen 9000 default_server;
+        server_name second.example.com;
+        proxy_pass 10.0.1.2:9000;
+    }
+    server {
+        listen 9001;
+    }
+    server {
+        listen 9002;
+        proxy_pass backend_$server_port;
+    }
+    server {
+        listen 9003;
+        ssl_preread on;
+        proxy_pass $ssl_preread_alpn_protocols;
+    }
+    server {
+        listen 9004 udp;
+        proxy_pass 10.0.1.4:9004;
+    }
+}
+http {
+    server {
+        listen 9005;
+    }
+}
+`
+
+func TestTraceStreamConnection(t *testing.T) {
+	tests := []struct {
+		name     string
+		sni      string
+		port     int
+		listen   string // Parameters of the listen directive of the selected server, empty for none
+		backend  string
+		upstream bool
+		problem  bool
+	}{
+		{name: "map to upstream", sni: "api.example.com", port: 443, listen: "443", backend: "api_pool", upstream: true},
+		{name: "map is case-insensitive", sni: "API.example.com", port: 443, listen: "443", backend: "api_pool", upstream: true},
+		{name: "map wildcard to address", sni: "www.example.org", port: 443, listen: "443", backend: "10.0.0.9:443"},
+		{name: "map default", sni: "other.example.net", port: 443, listen: "443", backend: "fallback", upstream: true},
+		{name: "map to empty address", sni: "empty.example.com", port: 443, listen: "443"},
+		{name: "preread off", sni: "api.example.com", port: 8443, listen: "8443", backend: "unread_pool", problem: true},
+		{name: "server_name match", sni: "first.example.com", port: 9000, listen: "9000", backend: "10.0.1.1:9000"},
+		{name: "default server", sni: "unknown.example.com", port: 9000, listen: "9000 default_server", backend: "10.0.1.2:9000"},
+		{name: "no proxy_pass", port: 9001, listen: "9001"},
+		{name: "server_port variable", port: 9002, listen: "9002", backend: "backend_9002"},
+		{name: "unknown preread variable", port: 9003, listen: "9003"},
+		{name: "udp listener", port: 9004},
+		{name: "http server", port: 9005},
+		{name: "no listener", port: 9999},
+	}
+
+	config, err := ParseReader(strings.NewReader(streamConfig), "test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace, err := config.TraceStreamConnection(tt.sni, tt.port)
+			if err != nil {
+				t.Fatal(err)
+			}
+			steps := strings.Join(trace.Steps, "\n")
+			listen := ""
+			if trace.Server != nil {
+				listen = strings.Join(trace.Server.FindLines("listen")[0].Params, " ")
+			}
+			if listen != tt.listen {
+				t.Fatalf("selected server listening on %q, want %q\n%s", listen, tt.listen, steps)
+			}
+			if trace.Backend != tt.backend {
+				t.Fatalf("backend %q, want %q\n%s", trace.Backend, tt.backend, steps)
+			}
+			if (trace.Upstream != nil) != tt.upstream {
+				t.Fatalf("upstream %v, want %v\n%s", trace.Upstream != nil, tt.upstream, steps)
+			}
+			if (trace.Problem != "") != tt.problem {
+				t.Fatalf("problem %q, want one: %v\n%s", trace.Problem, tt.problem, steps)
+			}
+		})
+	}
+}
+
+func TestTraceStreamConnectionInvalidPort(t *testing.T) {
+	config, err := ParseReader(strings.NewReader(streamConfig), "test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.TraceStreamConnection("example.com", 70000); err == nil {
+		t.Fatal("expected an error for port 70000")
+	}
+}
+
+func TestValidateSSLPreread(t *testing.T) {
+	config, err := ParseReader(strings.NewReader(streamConfig), "test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issues := config.ValidateSSLPreread()
+	if len(issues) != 1 {
+		t.Fatalf("got %d issues, want 1: %+v", len(issues), issues)
+	}
+	if issues[0].LineNumber != 26 || !strings.Contains(issues[0].Message, "$ssl_preread_server_name") {
+		t.Fatalf("unexpected issue %+v", issues[0])
+	}
+}
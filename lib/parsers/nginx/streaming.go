@@ -0,0 +1,104 @@
+package nginx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// BlockHandler receives the contents of a configuration parsed by ParseConfigStream
+// in source order
+type BlockHandler interface {
+	OnBlockStart(b *Block)    // A block was opened, its ParentRef chain holds the enclosing blocks
+	OnBlockEnd(b *Block)      // A block was closed, with its closing brace and verbatim body recorded
+	OnLine(b *Block, l *Line) // A directive, include or comment line was read inside b
+}
+
+// ParseConfigStream parses a configuration file without building the configuration
+// tree: blocks and lines are reported to handler as they are read and are not kept
+// in their parent, so memory use is bounded by the nesting depth rather than the
+// size of the configuration. The root block is reported first and last.
+//
+// Include directives are reported and then followed, the lines and blocks of the
+// included files are reported inside the block holding the include. Relative paths
+// resolve against the directory of filePath. Comments on the line after a closing
+// brace are reported as comment lines, since the closed block is no longer kept
+func ParseConfigStream(filePath string, handler BlockHandler) error {
+	rootBlock := &Block{
+		Name:   "root",
+		Params: []string{},
+		Lines:  []*Line{},
+		Blocks: []*Block{},
+	}
+
+	handler.OnBlockStart(rootBlock)
+	stream := &includeStream{handler: handler, baseDir: filepath.Dir(filePath)}
+	if err := stream.parseFile(filePath, rootBlock); err != nil {
+		return err
+	}
+	handler.OnBlockEnd(rootBlock)
+	return nil
+}
+
+// includeStream is the BlockHandler of a streaming parse that follows include
+// directives before passing lines on to the caller's handler
+type includeStream struct {
+	handler BlockHandler
+	baseDir string
+	depth   int
+	err     error // First error following an include, the rest of the input is ignored
+}
+
+// parseFile streams the statements of a file into block
+func (stream *includeStream) parseFile(filePath string, block *Block) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := parseStatements(file, filePath, ParseOptions{}.withDefaults(), block, stream); err != nil {
+		return err
+	}
+	return stream.err
+}
+
+func (stream *includeStream) OnBlockStart(b *Block) {
+	if stream.err == nil {
+		stream.handler.OnBlockStart(b)
+	}
+}
+
+func (stream *includeStream) OnBlockEnd(b *Block) {
+	if stream.err == nil {
+		stream.handler.OnBlockEnd(b)
+	}
+}
+
+func (stream *includeStream) OnLine(b *Block, l *Line) {
+	if stream.err != nil {
+		return
+	}
+	stream.handler.OnLine(b, l)
+	if l.Type != LineTypeInclude {
+		return
+	}
+
+	if stream.depth >= maxIncludeDepth {
+		stream.err = fmt.Errorf("includes nested deeper than %d levels", maxIncludeDepth)
+		return
+	}
+	files, err := includeFiles(rootFS{}, stream.baseDir, l)
+	if err != nil {
+		stream.err = err
+		return
+	}
+	stream.depth++
+	defer func() { stream.depth-- }()
+	for _, file := range files {
+		if err := stream.parseFile(file, b); err != nil {
+			stream.err = err
+			return
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package nginx
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// defaultSubFilterType is the MIME type sub_filter always processes
+const defaultSubFilterType = "text/html"
+
+// subFilterExtensionTypes maps file extensions in location patterns to the MIME types
+// their responses carry, the first type being the one to suggest
+var subFilterExtensionTypes = map[string][]string{
+	"json": {"application/json"},
+	"js":   {"application/javascript", "text/javascript", "application/x-javascript"},
+	"css":  {"text/css"},
+	"xml":  {"text/xml", "application/xml"},
+	"txt":  {"text/plain"},
+	"svg":  {"image/svg+xml"},
+}
+
+// locationExtensionPattern finds file extensions in plain and regex location patterns
+var locationExtensionPattern = regexp.MustCompile(`\\?\.([A-Za-z]+)\b`)
+
+// SubFilter is a sub_filter directive replacing a string in response bodies
+type SubFilter struct {
+	Line        *Line    // Underlying sub_filter directive
+	Pattern     string   // String to replace, matched ignoring ASCII case
+	Replacement string   // Replacement text, empty to delete the pattern
+	Variables   []string // Variables used in the pattern or replacement, without the $
+}
+
+// IsDynamic reports whether the pattern or replacement depends on request variables
+func (filter SubFilter) IsDynamic() bool {
+	return len(filter.Variables) > 0
+}
+
+// SubFilterChain is the sub_filter configuration in effect in a block
+type SubFilterChain struct {
+	Block        *Block      // Block the chain applies to
+	Filters      []SubFilter // Replacements in order, inherited as a whole from the closest block defining any
+	Once         bool        // Each string is replaced only once per response, the default
+	LastModified bool        // The Last-Modified header of the original response is kept
+	Types        []string    // MIME types processed besides text/html, "*" for any
+}
+
+// SubFilters returns the sub_filter chain in effect in the block. Like nginx, a block
+// without sub_filter directives of its own inherits those of the closest enclosing
+// block, while sub_filter_once, sub_filter_types and sub_filter_last_modified are
+// inherited individually
+func (block *Block) SubFilters() *SubFilterChain {
+	chain := &SubFilterChain{Block: block, Once: true}
+
+	for current := block; current != nil; current = current.ParentRef {
+		lines := current.FindLines("sub_filter")
+		if len(lines) == 0 {
+			continue
+		}
+		for _, line := range lines {
+			if len(line.Params) != 2 || unescape(line.Params[0]) == "" {
+				continue
+			}
+			// Quotes and escapes are resolved, an empty replacement deletes the pattern
+			filter := SubFilter{Line: line, Pattern: unescape(line.Params[0]), Replacement: unescape(line.Params[1])}
+			for _, match := range variablePattern.FindAllStringSubmatch(filter.Pattern+" "+filter.Replacement, -1) {
+				filter.Variables = append(filter.Variables, match[1]+match[2])
+			}
+			chain.Filters = append(chain.Filters, filter)
+		}
+		break
+	}
+
+	if line := block.EffectiveDirective("sub_filter_once"); line != nil && len(line.Params) > 0 {
+		chain.Once = unquote(line.Params[0]) != "off"
+	}
+	if line := block.EffectiveDirective("sub_filter_last_modified"); line != nil && len(line.Params) > 0 {
+		chain.LastModified = unquote(line.Params[0]) == "on"
+	}
+	if line := block.EffectiveDirective("sub_filter_types"); line != nil {
+		for _, param := range line.Params {
+			chain.Types = append(chain.Types, strings.ToLower(unquote(param)))
+		}
+	}
+	return chain
+}
+
+// Processes reports whether responses of the given Content-Type pass through the
+// chain. Parameters such as charset are ignored
+func (chain *SubFilterChain) Processes(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == defaultSubFilterType {
+		return true
+	}
+	for _, allowed := range chain.Types {
+		if allowed == "*" || allowed == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply performs the replacements of the chain on a response body the way nginx
+// does: the body is scanned once from the start, at each position the first filter
+// whose string matches ignoring ASCII case is replaced, and replaced text is not
+// scanned again. With sub_filter_once each filter replaces its first match only.
+// Filters whose string contains variables are skipped since it is not known what
+// they match, replacements are inserted as written with their variables unexpanded
+func (chain *SubFilterChain) Apply(contentType string, body []byte) []byte {
+	if len(chain.Filters) == 0 || !chain.Processes(contentType) {
+		return body
+	}
+
+	var patterns [][]byte
+	var filters []SubFilter
+	for _, filter := range chain.Filters {
+		if variablePattern.MatchString(filter.Pattern) {
+			continue
+		}
+		patterns = append(patterns, asciiLower([]byte(filter.Pattern)))
+		filters = append(filters, filter)
+	}
+
+	lower := asciiLower(body)
+	done := make([]bool, len(filters))
+	var result bytes.Buffer
+	for i := 0; i < len(body); {
+		matched := false
+		for j, pattern := range patterns {
+			if done[j] || !bytes.HasPrefix(lower[i:], pattern) {
+				continue
+			}
+			result.WriteString(filters[j].Replacement)
+			i += len(pattern)
+			done[j] = chain.Once
+			matched = true
+			break
+		}
+		if !matched {
+			result.WriteByte(body[i])
+			i++
+		}
+	}
+	return result.Bytes()
+}
+
+// ApplySubFilters previews the body nginx would send for a response of the given
+// Content-Type served by the location, see SubFilterChain.Apply
+func ApplySubFilters(location *Location, contentType string, body []byte) []byte {
+	return location.Block.SubFilters().Apply(contentType, body)
+}
+
+// asciiLower lowercases ASCII letters, keeping the length of the input
+func asciiLower(data []byte) []byte {
+	lower := make([]byte, len(data))
+	for i, char := range data {
+		if 'A' <= char && char <= 'Z' {
+			char += 'a' - 'A'
+		}
+		lower[i] = char
+	}
+	return lower
+}
+
+// ValidateSubFilters reports sub_filter chains whose sub_filter_types leave out the
+// responses they clearly target: locations for .json, .js, .css, .xml, .txt or .svg
+// files or with such a default_type, and JSON strings in the replacements
+func (config *Config) ValidateSubFilters() []ValidationIssue {
+	var issues []ValidationIssue
+	reported := map[string]bool{}
+
+	report := func(chain *SubFilterChain, types []string, line int, directive, target string) {
+		for _, mediaType := range types {
+			if chain.Processes(mediaType) {
+				return
+			}
+		}
+		key := fmt.Sprintf("%d %s", line, types[0])
+		if reported[key] {
+			return
+		}
+		reported[key] = true
+		issues = append(issues, ValidationIssue{
+			Severity:   SeverityWarning,
+			Directive:  directive,
+			Message:    fmt.Sprintf("sub_filter is applied to %s but sub_filter_types does not include %s, those responses are not filtered", target, types[0]),
+			LineNumber: line,
+		})
+	}
+
+	config.WalkBlocks(func(block *Block) {
+		if serverProtocol(block) != "http" || (block.Name != "location" && block.Name != "server" && block.Name != "http") {
+			return
+		}
+		chain := block.SubFilters()
+		if len(chain.Filters) == 0 {
+			return
+		}
+
+		if location := NewLocation(block); location != nil && !location.IsNamed() {
+			for _, match := range locationExtensionPattern.FindAllStringSubmatch(location.Pattern, -1) {
+				if types, ok := subFilterExtensionTypes[strings.ToLower(match[1])]; ok {
+					report(chain, types, block.LineNumber, block.Name, fmt.Sprintf("location %s", strings.Join(block.Params, " ")))
+				}
+			}
+			if line := block.EffectiveDirective("default_type"); line != nil && len(line.Params) > 0 {
+				mediaType := strings.ToLower(unquote(line.Params[0]))
+				if mediaType != "application/octet-stream" {
+					report(chain, []string{mediaType}, line.LineNumber, line.Name, fmt.Sprintf("%s responses", mediaType))
+				}
+			}
+		}
+
+		// JSON strings are checked where the filters are written
+		if len(block.FindLines("sub_filter")) == 0 {
+			return
+		}
+		for _, filter := range chain.Filters {
+			if strings.Contains(filter.Pattern, `":`) || strings.Contains(filter.Replacement, `":`) {
+				report(chain, subFilterExtensionTypes["json"], filter.Line.LineNumber, filter.Line.Name, "JSON strings")
+			}
+		}
+	})
+
+	return issues
+}
@@ -0,0 +1,230 @@
+package nginx
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Summary describes what the configuration does in a few sentences of plain English,
+// e.g. "Serves 3 virtual hosts on ports 80, 443. example.com proxies /api to upstream
+// 'backend' and serves static files from /var/www. Logging to /var/log/nginx/access.log."
+func (config *Config) Summary() string {
+	var httpServers, streamServers []*Block
+	for _, server := range config.FindBlocksByName("server") {
+		switch serverProtocol(server) {
+		case "http":
+			httpServers = append(httpServers, server)
+		case "stream":
+			streamServers = append(streamServers, server)
+		}
+	}
+
+	var sentences []string
+	if len(httpServers) > 0 {
+		sentences = append(sentences, fmt.Sprintf("Serves %s on %s.", plural(len(httpServers), "virtual host"), describeServerPorts(httpServers)))
+	}
+	upstreams := map[string]bool{}
+	for _, upstream := range config.FindBlocksByName("upstream") {
+		if len(upstream.Params) > 0 {
+			upstreams[unquote(upstream.Params[0])] = true
+		}
+	}
+	for _, server := range httpServers {
+		if sentence := summarizeServer(server, upstreams); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+	}
+	if len(streamServers) > 0 {
+		sentences = append(sentences, fmt.Sprintf("Proxies %s on %s.", plural(len(streamServers), "TCP/UDP server"), describeServerPorts(streamServers)))
+	}
+
+	if logs := logTargets(config, "access_log"); len(logs) > 0 {
+		sentences = append(sentences, "Logging to "+strings.Join(logs, ", ")+".")
+	}
+	if logs := logTargets(config, "error_log"); len(logs) > 0 {
+		sentences = append(sentences, "Errors are logged to "+strings.Join(logs, ", ")+".")
+	}
+
+	if len(sentences) == 0 {
+		return "Defines no servers."
+	}
+	return strings.Join(sentences, " ")
+}
+
+// summarizeServer describes how a server handles requests in one sentence
+func summarizeServer(server *Block, upstreams map[string]bool) string {
+	name := fmt.Sprintf("The server at line %d", server.LineNumber)
+	if names := ServerNames(server); len(names) > 0 {
+		name = names[0]
+	}
+
+	if lines := server.FindLines("return"); len(lines) > 0 {
+		return fmt.Sprintf("%s %s.", name, describeReturn(lines[0], "all requests"))
+	}
+
+	var clauses, roots []string
+	addRoot := func(scope *Block) {
+		root := "html"
+		if line := scope.EffectiveDirective("root"); line != nil && len(line.Params) > 0 {
+			root = unquote(line.Params[0])
+		}
+		for _, existing := range roots {
+			if existing == root {
+				return
+			}
+		}
+		roots = append(roots, root)
+	}
+
+	var locations []*Location
+	var collect func(block *Block)
+	collect = func(block *Block) {
+		for _, location := range block.Locations() {
+			locations = append(locations, location)
+			collect(location.Block)
+		}
+	}
+	collect(server)
+
+	for _, location := range locations {
+		if location.IsNamed() {
+			continue
+		}
+		if clause := describeHandler(location, upstreams); clause != "" {
+			if auth := location.Block.AuthRequest(); auth != nil {
+				clause += " behind auth_request " + auth.URI
+			}
+			clauses = append(clauses, clause)
+		} else if len(location.Block.Locations()) == 0 {
+			addRoot(location.Block)
+		}
+	}
+	if len(locations) == 0 {
+		addRoot(server)
+	}
+	if len(roots) > 0 {
+		clauses = append(clauses, "serves static files from "+strings.Join(roots, ", "))
+	}
+
+	if len(clauses) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s %s.", name, joinClauses(clauses))
+}
+
+// describeHandler describes the content handler of a location, "" when it serves static files
+func describeHandler(location *Location, upstreams map[string]bool) string {
+	path := location.Pattern
+	block := location.Block
+
+	if lines := block.FindLines("return"); len(lines) > 0 {
+		return describeReturn(lines[0], path)
+	}
+	if lines := block.FindLines("rewrite"); len(lines) > 0 && isRedirectRewrite(lines[0]) {
+		return fmt.Sprintf("redirects %s to %s", path, unquote(lines[0].Params[1]))
+	}
+	for _, directive := range []string{"proxy_pass", "grpc_pass", "uwsgi_pass", "scgi_pass", "fastcgi_pass", "memcached_pass"} {
+		lines := block.FindLines(directive)
+		if len(lines) == 0 || len(lines[0].Params) == 0 {
+			continue
+		}
+		target := unquote(lines[0].Params[0])
+		protocol := strings.TrimSuffix(directive, "_pass")
+
+		if directive == "proxy_pass" || directive == "grpc_pass" {
+			if parsed, err := url.Parse(target); err == nil && upstreams[parsed.Host] {
+				return fmt.Sprintf("proxies %s to upstream '%s'", path, parsed.Host)
+			}
+			return fmt.Sprintf("proxies %s to %s", path, target)
+		}
+		if upstreams[target] {
+			target = fmt.Sprintf("upstream '%s'", target)
+		}
+		return fmt.Sprintf("passes %s to %s at %s", path, strings.ToUpper(protocol), target)
+	}
+	return ""
+}
+
+// describeReturn describes a return directive applied to subject, a path or "all requests"
+func describeReturn(line *Line, subject string) string {
+	if target := returnTarget(line); target != "" {
+		return fmt.Sprintf("redirects %s to %s", subject, target)
+	}
+	if len(line.Params) == 0 {
+		return "returns a response for " + subject
+	}
+	if code, err := strconv.Atoi(line.Params[0]); err == nil {
+		return fmt.Sprintf("returns %d for %s", code, subject)
+	}
+	return "returns a response for " + subject
+}
+
+// describeServerPorts lists the ports a group of servers listens on
+func describeServerPorts(servers []*Block) string {
+	ports := map[int]bool{}
+	for _, server := range servers {
+		for _, line := range server.FindLines("listen") {
+			if endpoint, err := parseListen(line.Params, serverProtocol(server)); err == nil && !endpoint.Unix {
+				ports[endpoint.Port] = true
+			}
+		}
+		if len(server.FindLines("listen")) == 0 && serverProtocol(server) == "http" {
+			ports[defaultHTTPPort] = true
+		}
+	}
+
+	numbers := make([]int, 0, len(ports))
+	for port := range ports {
+		numbers = append(numbers, port)
+	}
+	sort.Ints(numbers)
+
+	values := make([]string, 0, len(numbers))
+	for _, port := range numbers {
+		values = append(values, strconv.Itoa(port))
+	}
+	switch len(values) {
+	case 0:
+		return "no TCP ports"
+	case 1:
+		return "port " + values[0]
+	}
+	return "ports " + strings.Join(values, ", ")
+}
+
+// logTargets returns the distinct files or destinations of a log directive, excluding off
+func logTargets(config *Config, directive string) []string {
+	var targets []string
+	seen := map[string]bool{}
+	for _, line := range config.FindLinesByName(directive) {
+		if len(line.Params) == 0 {
+			continue
+		}
+		target := unquote(line.Params[0])
+		if target == "off" || seen[target] {
+			continue
+		}
+		seen[target] = true
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// joinClauses joins sentence fragments as "a, b and c"
+func joinClauses(clauses []string) string {
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+	return strings.Join(clauses[:len(clauses)-1], ", ") + " and " + clauses[len(clauses)-1]
+}
+
+// plural formats a count with a noun, adding an s unless the count is one
+func plural(count int, noun string) string {
+	if count == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
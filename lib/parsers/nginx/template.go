@@ -0,0 +1,42 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateAgainstTemplate checks that the configuration contains every block and
+// directive of template, which acts as a required minimum. Anything the
+// configuration adds on top of the template is allowed
+func (config *Config) ValidateAgainstTemplate(template *Config) []ValidationIssue {
+	var issues []ValidationIssue
+
+	for _, change := range template.Diff(config) {
+		location := strings.Join(change.Path, " > ")
+		if location == "" {
+			location = "main context"
+		}
+
+		switch change.Kind {
+		case ChangeRemoved:
+			subject := formatStatement(change.Name, change.Before)
+			if change.IsBlock {
+				subject += " { ... }"
+			}
+			issues = append(issues, ValidationIssue{
+				Severity:  SeverityError,
+				Directive: change.Name,
+				Message:   fmt.Sprintf("missing %q in %s (required by template line %d)", subject, location, change.LineBefore),
+			})
+		case ChangeModified:
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityError,
+				Directive:  change.Name,
+				Message:    fmt.Sprintf("%s is %q in %s, template requires %q", change.Name, strings.Join(change.After, " "), location, strings.Join(change.Before, " ")),
+				LineNumber: change.LineAfter,
+			})
+		}
+	}
+
+	return issues
+}
@@ -0,0 +1,114 @@
+package nginx
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// Placeholders standing for template spans while a line is tokenized. The span is
+// encoded between a start marker and templateEnd, characters from the private use
+// area the tokenizer keeps within a token
+const (
+	templateInline    = "\uE000" // Starts a span inside a statement, e.g. server {{ .Address }};
+	templateStatement = "\uE001" // Starts a span standing as a statement, e.g. {{ range service "web" }}
+	templateEnd       = "\uE002" // Ends a span
+)
+
+// maskTemplates replaces the spans between the delimiters of a line by placeholders
+// and reports whether a span is left open at its end. A span alone on its line, or
+// followed by another span or a comment, becomes a statement of its own
+func maskTemplates(line string, delimiters [2]string) (string, bool) {
+	left, right := delimiters[0], delimiters[1]
+	if left == "" || right == "" || !strings.Contains(line, left) {
+		return line, false
+	}
+
+	var masked strings.Builder
+	for {
+		start := strings.Index(line, left)
+		if start < 0 {
+			masked.WriteString(line)
+			return masked.String(), false
+		}
+		end := strings.Index(line[start+len(left):], right)
+		if end < 0 {
+			masked.WriteString(line)
+			return masked.String(), true
+		}
+		end += start + len(left) + len(right)
+
+		masked.WriteString(line[:start])
+		span, rest := line[start:end], line[end:]
+		before := strings.TrimRight(masked.String(), " \t\r\n")
+		after := strings.TrimLeft(rest, " \t\r\n")
+		standalone := (before == "" || strings.ContainsAny(before[len(before)-1:], ";{}")) &&
+			(after == "" || strings.HasPrefix(after, "#") || strings.HasPrefix(after, left))
+
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(span))
+		if standalone {
+			masked.WriteString(templateStatement + encoded + templateEnd + ";")
+		} else {
+			masked.WriteString(templateInline + encoded + templateEnd)
+		}
+		line = rest
+	}
+}
+
+// unmaskTemplates restores the template spans of a masked string
+func unmaskTemplates(text string) string {
+	if !strings.Contains(text, templateEnd) {
+		return text
+	}
+
+	var restored strings.Builder
+	for {
+		start := strings.IndexAny(text, templateInline+templateStatement)
+		if start < 0 {
+			restored.WriteString(text)
+			return restored.String()
+		}
+		end := strings.Index(text[start:], templateEnd)
+		if end < 0 {
+			restored.WriteString(text)
+			return restored.String()
+		}
+		// Both start markers have the same length
+		span, err := base64.RawURLEncoding.DecodeString(text[start+len(templateInline) : start+end])
+		if err != nil {
+			restored.WriteString(text[:start+end+len(templateEnd)])
+		} else {
+			restored.WriteString(text[:start])
+			restored.Write(span)
+		}
+		text = text[start+end+len(templateEnd):]
+	}
+}
+
+// unmaskStatement restores the template spans of the name and parameters of a
+// statement in place, reporting whether it held any
+func unmaskStatement(name *string, params []string) bool {
+	templated := false
+	restore := func(text string) string {
+		restored := unmaskTemplates(text)
+		templated = templated || restored != text
+		return restored
+	}
+	*name = restore(*name)
+	for i, param := range params {
+		params[i] = restore(param)
+	}
+	return templated
+}
+
+// unmaskComments restores the template spans of comments in place
+func unmaskComments(comments []string) {
+	for i, comment := range comments {
+		comments[i] = unmaskTemplates(comment)
+	}
+}
+
+// isTemplateStatement reports whether a masked statement name is a template span
+// standing as a statement of its own
+func isTemplateStatement(name string) bool {
+	return strings.HasPrefix(name, templateStatement) && strings.HasSuffix(name, templateEnd)
+}
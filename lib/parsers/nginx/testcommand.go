@@ -0,0 +1,57 @@
+package nginx
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// GenerateNginxTestCommand returns the shell command validating the configuration
+// file, nginx -t -c with its absolute path. When include directives refer to files
+// outside the directory of the file, -p sets the prefix to the closest directory
+// holding the file and every included path
+func (config *Config) GenerateNginxTestCommand() string {
+	path, err := filepath.Abs(config.FilePath)
+	if err != nil {
+		path = config.FilePath
+	}
+	dir := filepath.Dir(path)
+
+	command := "nginx -t -c " + shellQuote(path)
+	prefix := dir
+	for _, line := range config.FindLinesByName("include") {
+		if len(line.Params) == 0 {
+			continue
+		}
+		included := filepath.Dir(filepath.FromSlash(resolveIncludePath(dir, unquote(line.Params[0]))))
+		for isGlobPattern(included) {
+			included = filepath.Dir(included)
+		}
+		prefix = commonDirectory(prefix, included)
+	}
+	if prefix != dir {
+		command += " -p " + shellQuote(prefix)
+	}
+	return command
+}
+
+// commonDirectory returns the deepest directory holding both absolute directories
+func commonDirectory(a, b string) string {
+	for {
+		if rel, err := filepath.Rel(a, b); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return a
+		}
+		if parent := filepath.Dir(a); parent != a {
+			a = parent
+			continue
+		}
+		return a
+	}
+}
+
+// shellQuote quotes a word for POSIX shells unless it only holds safe characters
+func shellQuote(word string) string {
+	if word != "" && strings.Trim(word, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_./-+:@,=") == "" {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
@@ -0,0 +1,215 @@
+package nginx
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// reportedTimeouts lists the timeouts TimeoutReport shows with their nginx defaults,
+// the client side first
+var reportedTimeouts = []struct {
+	Name    string
+	Default string
+}{
+	{"client_header_timeout", "60s"},
+	{"client_body_timeout", "60s"},
+	{"send_timeout", "60s"},
+	{"keepalive_timeout", "75s"},
+	{"proxy_connect_timeout", "60s"},
+	{"proxy_send_timeout", "60s"},
+	{"proxy_read_timeout", "60s"},
+}
+
+// defaultUpstreamKeepaliveTimeout is how long idle connections to an upstream with
+// keepalive are kept unless the upstream sets keepalive_timeout
+const defaultUpstreamKeepaliveTimeout = "60s"
+
+// TimeoutValue is the effective value of a timeout and the block it is inherited from
+type TimeoutValue struct {
+	CacheValue
+	Directive string        // Directive name
+	Duration  time.Duration // Parsed value, 0 when the value does not parse
+}
+
+// TimeoutScope holds the effective timeouts of a server or location
+type TimeoutScope struct {
+	Block             *Block         // Server or location block
+	Path              []string       // Enclosing blocks from the outermost, ending with the block
+	Values            []TimeoutValue // Client side and proxy timeouts in effect
+	Upstream          *Upstream      // Upstream proxy_pass sends requests to, nil if none
+	UpstreamKeepalive *TimeoutValue  // keepalive_timeout of idle upstream connections, nil unless the upstream keeps connections alive
+}
+
+// Value returns the effective value of a timeout of the scope, nil if it is not reported
+func (scope *TimeoutScope) Value(name string) *TimeoutValue {
+	for i := range scope.Values {
+		if scope.Values[i].Directive == name {
+			return &scope.Values[i]
+		}
+	}
+	return nil
+}
+
+// TimeoutReport lists the client side timeouts (client_header_timeout,
+// client_body_timeout, send_timeout, keepalive_timeout) and the proxy timeouts in
+// effect in every http server and location, each annotated with the block it is
+// inherited from. Locations proxying to an upstream that keeps connections alive
+// also report the upstream keepalive_timeout
+func (config *Config) TimeoutReport() []TimeoutScope {
+	upstreams := map[string]*Upstream{}
+	for _, upstream := range config.Upstreams() {
+		upstreams[upstream.Name] = upstream
+	}
+
+	var report []TimeoutScope
+	var collect func(block *Block, path []string)
+	collect = func(block *Block, path []string) {
+		if (block.Name == "server" && serverProtocol(block) == "http") || (block.Name == "location" && block.Ancestor("http") != nil) {
+			report = append(report, block.timeoutScope(path, upstreams))
+		}
+		for _, child := range block.Blocks {
+			collect(child, appendPath(path, blockKey(child)))
+		}
+	}
+	collect(config.RootBlock, nil)
+
+	return report
+}
+
+// timeoutScope collects the effective timeouts of a server or location block
+func (block *Block) timeoutScope(path []string, upstreams map[string]*Upstream) TimeoutScope {
+	scope := TimeoutScope{Block: block, Path: path}
+	for _, timeout := range reportedTimeouts {
+		scope.Values = append(scope.Values, newTimeoutValue(timeout.Name, block.cacheValue(timeout.Name, timeout.Default)))
+	}
+
+	scope.Upstream = proxiedUpstream(block, upstreams)
+	if scope.Upstream != nil && len(scope.Upstream.Block.FindLines("keepalive")) > 0 {
+		// The upstream keepalive_timeout is unrelated to the client side one of http
+		value := CacheValue{Value: defaultUpstreamKeepaliveTimeout}
+		if lines := scope.Upstream.Block.FindLines("keepalive_timeout"); len(lines) > 0 {
+			line := lines[len(lines)-1]
+			value = CacheValue{Value: strings.Join(line.Params, " "), Line: line, Block: scope.Upstream.Block}
+		}
+		keepalive := newTimeoutValue("keepalive_timeout", value)
+		scope.UpstreamKeepalive = &keepalive
+	}
+	return scope
+}
+
+// newTimeoutValue parses the duration of an effective timeout. keepalive_timeout
+// takes its first parameter, the second sets the Keep-Alive header
+func newTimeoutValue(name string, value CacheValue) TimeoutValue {
+	timeout := TimeoutValue{CacheValue: value, Directive: name}
+	if fields := strings.Fields(value.Value); len(fields) > 0 {
+		timeout.Duration, _ = ParseDuration(unquote(fields[0]))
+	}
+	return timeout
+}
+
+// proxiedUpstream returns the upstream the proxy_pass of a location names, nil if
+// it passes requests elsewhere or not at all
+func proxiedUpstream(block *Block, upstreams map[string]*Upstream) *Upstream {
+	lines := block.FindLines("proxy_pass")
+	if block.Name != "location" || len(lines) == 0 || len(lines[0].Params) == 0 {
+		return nil
+	}
+	parsed, err := url.Parse(unquote(lines[0].Params[0]))
+	if err != nil {
+		return nil
+	}
+	return upstreams[parsed.Host]
+}
+
+// ValidateTimeoutCoherence flags timeouts that contradict each other across the
+// hops of a request. With opts.LoadBalancerIdleTimeout, the idle timeout of a load
+// balancer in front of nginx (60s for an AWS ALB, 600s for a GCP load balancer),
+// a keepalive_timeout not longer than it lets nginx close connections the balancer
+// is about to reuse, which the balancer answers with 502. Upstreams keeping
+// connections alive are flagged when locations proxying to them leave
+// proxy_http_version at 1.0 or do not clear the Connection header, which makes
+// every request open and close a connection
+func (config *Config) ValidateTimeoutCoherence(opts TimeoutOptions) []ValidationIssue {
+	var issues []ValidationIssue
+	reported := map[*Line]bool{}
+	report := func(line *Line, lineNumber int, directive, format string, args ...interface{}) {
+		if line != nil {
+			if reported[line] {
+				return
+			}
+			reported[line] = true
+			lineNumber = line.LineNumber
+		}
+		issues = append(issues, ValidationIssue{
+			Severity:   SeverityWarning,
+			Directive:  directive,
+			Message:    fmt.Sprintf(format, args...),
+			LineNumber: lineNumber,
+		})
+	}
+
+	for _, scope := range config.TimeoutReport() {
+		where := strings.Join(scope.Path, " > ")
+		keepalive := scope.Value("keepalive_timeout")
+		if idle := opts.LoadBalancerIdleTimeout; idle > 0 && keepalive.Duration <= idle && (keepalive.Line != nil || scope.Block.Name == "server") {
+			if keepalive.Duration == 0 {
+				report(keepalive.Line, scope.Block.LineNumber, "keepalive_timeout", "%s: keepalive_timeout 0 closes every client connection after one request, the load balancer in front has to reconnect for each request",
+					where)
+			} else {
+				report(keepalive.Line, scope.Block.LineNumber, "keepalive_timeout", "%s: keepalive_timeout %s (%s) is not longer than the %s idle timeout of the load balancer in front, nginx closes idle connections the balancer still reuses and the balancer answers 502",
+					where, keepalive.Value, keepalive.Origin(), idle)
+			}
+		}
+
+		if scope.UpstreamKeepalive == nil {
+			continue
+		}
+		upstream := scope.Upstream.Name
+		version := scope.Block.cacheValue("proxy_http_version", "1.0")
+		if unquote(version.Value) != "1.1" {
+			report(nil, scope.Block.LineNumber, "proxy_http_version", "%s: upstream %s keeps connections alive but proxy_http_version is %s (%s), HTTP/1.0 requests close the connection after each response and keepalive never takes effect",
+				where, upstream, version.Value, version.Origin())
+			continue
+		}
+		if problem := connectionHeaderProblem(scope.Block); problem != "" {
+			report(nil, scope.Block.LineNumber, "proxy_set_header", "%s: upstream %s keeps connections alive but %s, the upstream closes the connection after each response and keepalive never takes effect",
+				where, upstream, problem)
+		}
+	}
+
+	return issues
+}
+
+// connectionHeaderProblem explains why the Connection header passed upstream in a
+// block defeats upstream keepalive, empty when it is cleared for plain requests
+func connectionHeaderProblem(block *Block) string {
+	lines, _ := block.inheritedLines("proxy_set_header")
+	for _, line := range lines {
+		if len(line.Params) < 2 || !strings.EqualFold(unquote(line.Params[0]), "Connection") {
+			continue
+		}
+		value := unquote(line.Params[1])
+		if value == "" {
+			return ""
+		}
+		if strings.HasPrefix(value, "$") {
+			m := block.variableMap(strings.Trim(value[1:], "{}"))
+			if m == nil {
+				return ""
+			}
+			for _, entry := range m.Entries {
+				if strings.EqualFold(entry.Value, "close") {
+					return fmt.Sprintf("map $%s (line %d) sends Connection: close when %s is %q", m.Variable, entry.Line.LineNumber, m.Source, entry.Key)
+				}
+			}
+			if strings.EqualFold(m.Default, "close") {
+				return fmt.Sprintf("map $%s (line %d) sends Connection: close by default", m.Variable, m.Block.LineNumber)
+			}
+			return ""
+		}
+		return fmt.Sprintf("proxy_set_header Connection %q at line %d is passed upstream", value, line.LineNumber)
+	}
+	return "the Connection header is not cleared with proxy_set_header Connection \"\", nginx sends Connection: close"
+}
@@ -0,0 +1,153 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeoutBounds is the range of values considered sane for a timeout
+type TimeoutBounds struct {
+	Min time.Duration // Shorter values are aggressive, 0 for no lower bound
+	Max time.Duration // Longer values are loose, 0 for no upper bound
+}
+
+// defaultTimeoutBounds holds the bounds used unless TimeoutOptions overrides them.
+// Keys are directive names, suffixes starting with an underscore matching a family
+// of directives, or "*" for every other timeout
+var defaultTimeoutBounds = map[string]TimeoutBounds{
+	"_connect_timeout":      {Min: time.Second, Max: 75 * time.Second}, // nginx caps connection timeouts at 75s
+	"_read_timeout":         {Min: time.Second, Max: 10 * time.Minute},
+	"_send_timeout":         {Min: time.Second, Max: 10 * time.Minute},
+	"client_body_timeout":   {Min: time.Second, Max: 5 * time.Minute},
+	"client_header_timeout": {Min: time.Second, Max: 5 * time.Minute},
+	"send_timeout":          {Min: time.Second, Max: 5 * time.Minute},
+	"keepalive_timeout":     {Min: time.Second, Max: 5 * time.Minute},
+	"lingering_timeout":     {Min: time.Second, Max: time.Minute},
+	"resolver_timeout":      {Min: time.Second, Max: 30 * time.Second},
+	"ssl_session_timeout":   {Min: time.Minute, Max: 24 * time.Hour},
+	"*":                     {Min: 100 * time.Millisecond, Max: time.Hour},
+}
+
+// disablingZeroTimeouts lists timeouts where 0 turns the limit or the feature off
+// rather than setting an aggressive value
+var disablingZeroTimeouts = []string{"keepalive_timeout", "_next_upstream_timeout"}
+
+// TimeoutOptions configures the timeout audit
+type TimeoutOptions struct {
+	// Bounds overrides the default sane ranges, keyed like the defaults by directive
+	// name, by suffix starting with an underscore (e.g. "_read_timeout") or by "*"
+	Bounds map[string]TimeoutBounds
+
+	// LoadBalancerIdleTimeout is the idle timeout of the load balancer in front of
+	// nginx, checked against keepalive_timeout by ValidateTimeoutCoherence. 0 when unknown
+	LoadBalancerIdleTimeout time.Duration
+}
+
+// TimeoutSetting is a *_timeout directive and its parsed value
+type TimeoutSetting struct {
+	Directive string        // Directive name, e.g. proxy_read_timeout
+	Value     string        // Value as written
+	Duration  time.Duration // Parsed value
+	Path      []string      // Enclosing blocks from the outermost (e.g. ["http", "server example.com", "location /api/"])
+	Line      *Line         // Underlying directive
+	Warning   string        // Why the value is outside its sane bounds, empty if it is within them
+}
+
+// Timeouts collects every *_timeout directive with its parsed value, flagging values
+// outside the default sane bounds
+func (config *Config) Timeouts() []TimeoutSetting {
+	return config.TimeoutsWithOptions(TimeoutOptions{})
+}
+
+// TimeoutsWithOptions collects every *_timeout directive with its parsed value,
+// flagging values outside the bounds of opts. Values that do not parse as nginx
+// times, such as variables, are skipped. keepalive_timeout reports its first parameter
+func (config *Config) TimeoutsWithOptions(opts TimeoutOptions) []TimeoutSetting {
+	bounds := map[string]TimeoutBounds{}
+	for key, value := range defaultTimeoutBounds {
+		bounds[key] = value
+	}
+	for key, value := range opts.Bounds {
+		bounds[key] = value
+	}
+
+	var settings []TimeoutSetting
+	var collect func(block *Block, path []string)
+	collect = func(block *Block, path []string) {
+		for _, line := range block.Lines {
+			if line.Type != LineTypeDirective || !strings.HasSuffix(line.Name, "_timeout") || len(line.Params) == 0 {
+				continue
+			}
+			value := unquote(line.Params[0])
+			duration, err := ParseDuration(value)
+			if err != nil {
+				continue
+			}
+			setting := TimeoutSetting{Directive: line.Name, Value: value, Duration: duration, Path: path, Line: line}
+			setting.Warning = timeoutWarning(line.Name, value, duration, bounds)
+			settings = append(settings, setting)
+		}
+		for _, child := range block.Blocks {
+			collect(child, appendPath(path, blockKey(child)))
+		}
+	}
+	collect(config.RootBlock, nil)
+
+	return settings
+}
+
+// ValidateTimeouts reports the timeouts outside the bounds of opts as warnings
+func (config *Config) ValidateTimeouts(opts TimeoutOptions) []ValidationIssue {
+	var issues []ValidationIssue
+	for _, setting := range config.TimeoutsWithOptions(opts) {
+		if setting.Warning == "" {
+			continue
+		}
+		issues = append(issues, ValidationIssue{
+			Severity:   SeverityWarning,
+			Directive:  setting.Directive,
+			Message:    setting.Warning,
+			LineNumber: setting.Line.LineNumber,
+		})
+	}
+	return issues
+}
+
+// timeoutWarning explains why a timeout is outside its bounds, empty if it is within them
+func timeoutWarning(name, value string, duration time.Duration, bounds map[string]TimeoutBounds) string {
+	if duration == 0 {
+		for _, disabling := range disablingZeroTimeouts {
+			if name == disabling || (strings.HasPrefix(disabling, "_") && strings.HasSuffix(name, disabling)) {
+				return ""
+			}
+		}
+	}
+
+	limits := timeoutBounds(name, bounds)
+	switch {
+	case limits.Min > 0 && duration < limits.Min:
+		return fmt.Sprintf("%s %s is aggressive, below %s", name, value, limits.Min)
+	case limits.Max > 0 && duration > limits.Max:
+		return fmt.Sprintf("%s %s is loose, above %s", name, value, limits.Max)
+	}
+	return ""
+}
+
+// timeoutBounds finds the bounds of a directive: by exact name, then by the longest
+// matching suffix, then the "*" fallback
+func timeoutBounds(name string, bounds map[string]TimeoutBounds) TimeoutBounds {
+	if limits, ok := bounds[name]; ok {
+		return limits
+	}
+	suffix := ""
+	for key := range bounds {
+		if strings.HasPrefix(key, "_") && strings.HasSuffix(name, key) && len(key) > len(suffix) {
+			suffix = key
+		}
+	}
+	if suffix != "" {
+		return bounds[suffix]
+	}
+	return bounds["*"]
+}
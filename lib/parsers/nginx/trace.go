@@ -0,0 +1,467 @@
+package nginx
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// contentHandlers lists directives that produce the response of a location, in precedence order
+var contentHandlers = []string{"return", "proxy_pass", "fastcgi_pass", "uwsgi_pass", "scgi_pass", "grpc_pass", "memcached_pass", "try_files"}
+
+// Trace describes how nginx would route a request
+type Trace struct {
+	Method   string    // Request method
+	URL      string    // Traced URL
+	Referer  string    // Referer header, empty for a request without one
+	Host     string    // Host the request is addressed to
+	Port     int       // Port the request arrives on
+	Path     string    // Request URI path
+	Server   *Block    // Selected server block, nil if no server listens on the port
+	Location *Location // Selected location, nil if no location matches
+	Handler  *Line     // Directive producing the response, nil if static files are served
+	Steps    []string  // Human-readable routing decisions in order
+
+	LimitExcept *LimitExcept // limit_except block restricting the request method, nil if the method is not restricted
+	AuthRequest *AuthRequest // auth_request subrequest the request must pass, nil if the location is not protected
+	Fallback    *Location    // Named location try_files redirects to when no file matches, nil if none
+	Access      *Line        // allow or deny rule deciding whether the client may access, nil if none matches or no client address was given
+	Denied      bool         // Whether the access rules reject the client with 403
+}
+
+// TraceRequest follows the server and location selection nginx performs for a GET request to rawURL
+func (config *Config) TraceRequest(rawURL string) (*Trace, error) {
+	return config.TraceMethodRequest("GET", rawURL)
+}
+
+// TraceOptions describes the traced request beyond its URL
+type TraceOptions struct {
+	Method  string // Request method, GET if empty
+	Referer string // Referer header, empty for a request without one
+
+	// ClientAddr is the client address checked against allow and deny rules, "unix:"
+	// for a client connected over a UNIX socket. Access rules are not evaluated when empty
+	ClientAddr string
+}
+
+// TraceMethodRequest follows the server and location selection nginx performs for a
+// request with the given method to rawURL
+func (config *Config) TraceMethodRequest(method, rawURL string) (*Trace, error) {
+	return config.TraceRequestWithOptions(rawURL, TraceOptions{Method: method})
+}
+
+// TraceRequestWithOptions follows the server and location selection nginx performs
+// for a request to rawURL, evaluating the if blocks of the server and location
+// whose conditions depend only on known request properties
+func (config *Config) TraceRequestWithOptions(rawURL string, opts TraceOptions) (*Trace, error) {
+	if opts.Method == "" {
+		opts.Method = "GET"
+	}
+	var clientAddr netip.Addr
+	if opts.ClientAddr != "" && opts.ClientAddr != "unix:" {
+		addr, err := netip.ParseAddr(opts.ClientAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client address %q", opts.ClientAddr)
+		}
+		clientAddr = addr
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("url %q has no host", rawURL)
+	}
+
+	trace := &Trace{Method: strings.ToUpper(opts.Method), URL: rawURL, Referer: opts.Referer, Host: strings.ToLower(parsed.Hostname()), Path: parsed.EscapedPath()}
+	if trace.Path == "" {
+		trace.Path = "/"
+	}
+	trace.Port = 80
+	if parsed.Scheme == "https" {
+		trace.Port = 443
+	}
+	if parsed.Port() != "" {
+		if trace.Port, err = strconv.Atoi(parsed.Port()); err != nil {
+			return nil, fmt.Errorf("invalid port in url %q", rawURL)
+		}
+	}
+
+	server, reason := config.SelectServer(trace.Host, trace.Port)
+	if server == nil {
+		trace.addStep("no server listens on port %d", trace.Port)
+		return trace, nil
+	}
+	trace.Server = server
+	trace.addStep("selected server at line %d (%s)", server.LineNumber, reason)
+	if trace.traceConditions(server) {
+		return trace, nil
+	}
+
+	trace.Location = server.FindLocationByURI(trace.Path)
+	if trace.Location == nil {
+		trace.addStep("no location matches %s", trace.Path)
+	} else {
+		trace.addStep("selected location %s at line %d", strings.Join(trace.Location.Block.Params, " "), trace.Location.Block.LineNumber)
+	}
+
+	scope := server
+	if trace.Location != nil {
+		scope = trace.Location.Block
+		trace.traceLimitExcept()
+		if trace.traceConditions(scope) {
+			return trace, nil
+		}
+	}
+	if opts.ClientAddr != "" && trace.traceAccess(scope, clientAddr, opts.ClientAddr) {
+		return trace, nil
+	}
+	trace.traceAuthRequest(scope)
+	trace.traceErrorPages(scope)
+	for _, name := range contentHandlers {
+		if lines := scope.FindLines(name); len(lines) > 0 {
+			trace.Handler = lines[0]
+			trace.addStep("handled by %s at line %d", formatStatement(name, lines[0].Params), lines[0].LineNumber)
+			trace.traceFallback(scope, lines[0])
+			return trace, nil
+		}
+	}
+	if root := scope.EffectiveDirective("root"); root != nil {
+		trace.addStep("serves static files from root %s (line %d)", strings.Join(root.Params, " "), root.LineNumber)
+	} else {
+		trace.addStep("serves static files from the default root html")
+	}
+
+	return trace, nil
+}
+
+// traceLimitExcept records whether the limit_except block of the selected location restricts the method
+func (trace *Trace) traceLimitExcept() {
+	limit := trace.Location.LimitExcept()
+	if limit == nil {
+		return
+	}
+	if limit.Allows(trace.Method) {
+		trace.addStep("method %s is excepted by limit_except %s at line %d", trace.Method, strings.Join(limit.Methods, " "), limit.Block.LineNumber)
+		return
+	}
+
+	trace.LimitExcept = limit
+	var rules []string
+	for _, rule := range limit.Block.EffectiveACL() {
+		rules = append(rules, formatStatement(rule.Line.Name, rule.Line.Params))
+	}
+	if len(rules) == 0 {
+		trace.addStep("method %s is restricted by limit_except %s at line %d", trace.Method, strings.Join(limit.Methods, " "), limit.Block.LineNumber)
+		return
+	}
+	trace.addStep("method %s is restricted by limit_except %s at line %d: %s", trace.Method,
+		strings.Join(limit.Methods, " "), limit.Block.LineNumber, strings.Join(rules, "; "))
+}
+
+// traceAccess checks the client against the allow and deny rules in effect, those
+// of the limit_except block when it restricts the method, and reports whether the
+// request is rejected with 403
+func (trace *Trace) traceAccess(scope *Block, addr netip.Addr, client string) bool {
+	if trace.LimitExcept != nil {
+		scope = trace.LimitExcept.Block
+	}
+	acl := scope.ACL()
+	if acl == nil {
+		trace.addStep("no allow or deny rules apply to client %s", client)
+		return false
+	}
+
+	allowed, rule := acl.Permits(addr)
+	if rule == nil {
+		trace.addStep("no access rule of the %s block at line %d matches client %s, access is allowed", acl.Block.Name, acl.Block.LineNumber, client)
+		return false
+	}
+	trace.Access = rule
+	switch {
+	case allowed:
+		trace.addStep("%s at line %d allows client %s", formatStatement(rule.Name, rule.Params), rule.LineNumber, client)
+		return false
+	}
+
+	trace.Denied = true
+	if satisfy := scope.EffectiveDirective("satisfy"); satisfy != nil && len(satisfy.Params) > 0 && unquote(satisfy.Params[0]) == "any" {
+		trace.addStep("%s at line %d denies client %s, but satisfy any (line %d) still grants access if authentication passes",
+			formatStatement(rule.Name, rule.Params), rule.LineNumber, client, satisfy.LineNumber)
+		return false
+	}
+	trace.Handler = rule
+	trace.addStep("%s at line %d denies client %s, the request is answered with 403", formatStatement(rule.Name, rule.Params), rule.LineNumber, client)
+	return true
+}
+
+// traceAuthRequest records the auth_request subrequest the request passes through
+// before reaching its content handler, as a branch on the subrequest status
+func (trace *Trace) traceAuthRequest(scope *Block) {
+	auth := scope.AuthRequest()
+	if auth == nil {
+		return
+	}
+	trace.AuthRequest = auth
+	trace.addStep("%s", auth.Describe())
+	switch {
+	case auth.Target == nil:
+		trace.addStep("the subrequest fails with 404, the request is answered with 500")
+		return
+	case auth.Handler == nil:
+		trace.addStep("the subrequest is served as a static file, the request fails with 500 unless that returns 2xx")
+		return
+	}
+	for _, variable := range auth.Variables {
+		trace.addStep("auth_request_set at line %d sets $%s from %s", variable.Line.LineNumber, variable.Name, variable.Value)
+	}
+	trace.addStep("if the subrequest returns 2xx the request continues, 401 and 403 are returned to the client, other statuses fail with 500")
+}
+
+// traceConditions evaluates the if blocks directly inside scope in order and reports
+// whether one of them handles the request with a return directive
+func (trace *Trace) traceConditions(scope *Block) bool {
+	for _, block := range scope.FindBlocks("if") {
+		condition := strings.Join(block.Params, " ")
+		matched, ok := trace.evaluateCondition(condition, scope)
+		switch {
+		case !ok:
+			trace.addStep("cannot evaluate if %s at line %d", condition, block.LineNumber)
+			continue
+		case !matched:
+			trace.addStep("if %s at line %d is false", condition, block.LineNumber)
+			continue
+		}
+
+		trace.addStep("if %s at line %d is true", condition, block.LineNumber)
+		if lines := block.FindLines("return"); len(lines) > 0 {
+			trace.Handler = lines[0]
+			trace.addStep("handled by %s at line %d", formatStatement("return", lines[0].Params), lines[0].LineNumber)
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateCondition evaluates an if condition of the forms ($var), ($var = value) and
+// ($var != value). ok is false when the condition uses another form or an unknown variable
+func (trace *Trace) evaluateCondition(condition string, scope *Block) (matched bool, ok bool) {
+	condition = strings.TrimSpace(condition)
+	if !strings.HasPrefix(condition, "(") || !strings.HasSuffix(condition, ")") {
+		return false, false
+	}
+	fields := strings.Fields(condition[1 : len(condition)-1])
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "$") {
+		return false, false
+	}
+	value, ok := trace.variable(fields[0][1:], scope)
+	if !ok {
+		return false, false
+	}
+
+	switch {
+	case len(fields) == 1:
+		return value != "" && value != "0", true
+	case len(fields) == 3 && fields[1] == "=":
+		return value == unquote(fields[2]), true
+	case len(fields) == 3 && fields[1] == "!=":
+		return value != unquote(fields[2]), true
+	}
+	return false, false
+}
+
+// variable returns the value a variable has for the traced request in scope.
+// ok is false for variables the tracer does not know
+func (trace *Trace) variable(name string, scope *Block) (string, bool) {
+	switch name {
+	case "request_method":
+		return trace.Method, true
+	case "host":
+		return trace.Host, true
+	case "uri", "document_uri":
+		return trace.Path, true
+	case "server_port":
+		return strconv.Itoa(trace.Port), true
+	case "http_referer":
+		return trace.Referer, true
+	case "invalid_referer":
+		referers := scope.ValidReferers()
+		if referers == nil {
+			return "", true
+		}
+		referer := "referer " + strconv.Quote(trace.Referer)
+		if trace.Referer == "" {
+			referer = "a request without a referer"
+		}
+		if referers.Evaluate(trace.Referer, trace.Host) {
+			trace.addStep("valid_referers at line %d accepts %s", referers.Line.LineNumber, referer)
+			return "", true
+		}
+		trace.addStep("valid_referers at line %d rejects %s", referers.Line.LineNumber, referer)
+		return "1", true
+	}
+	return "", false
+}
+
+// traceErrorPages records the error_page directives in effect that redirect to named locations
+func (trace *Trace) traceErrorPages(scope *Block) {
+	for current := scope; current != nil; current = current.ParentRef {
+		lines := current.FindLines("error_page")
+		if len(lines) == 0 {
+			continue
+		}
+		// error_page directives are inherited only when the block defines none
+		for _, line := range lines {
+			name, ok := namedLocationTarget(line)
+			if !ok {
+				continue
+			}
+			codes := formatStatement("error_page", line.Params[:len(line.Params)-1])
+			if location := scope.ResolveNamedLocation(name); location != nil {
+				trace.addStep("%s (line %d) redirects errors to location @%s at line %d", codes, line.LineNumber, name, location.Block.LineNumber)
+			} else {
+				trace.addStep("%s (line %d) redirects errors to undefined location @%s", codes, line.LineNumber, name)
+			}
+		}
+		return
+	}
+}
+
+// traceFallback follows try_files into the named location it falls back to
+func (trace *Trace) traceFallback(scope *Block, handler *Line) {
+	name, ok := namedLocationTarget(handler)
+	if handler.Name != "try_files" || !ok {
+		return
+	}
+
+	location := scope.ResolveNamedLocation(name)
+	if location == nil {
+		trace.addStep("if no file matches, try_files redirects to undefined location @%s", name)
+		return
+	}
+	trace.Fallback = location
+	trace.addStep("if no file matches, redirects internally to location @%s at line %d", name, location.Block.LineNumber)
+	for _, directive := range contentHandlers {
+		if lines := location.Block.FindLines(directive); len(lines) > 0 {
+			trace.addStep("@%s is handled by %s at line %d", name, formatStatement(directive, lines[0].Params), lines[0].LineNumber)
+			return
+		}
+	}
+}
+
+// addStep records a routing decision
+func (trace *Trace) addStep(format string, args ...interface{}) {
+	trace.Steps = append(trace.Steps, fmt.Sprintf(format, args...))
+}
+
+// SelectServer picks the http server block that handles requests for host on
+// port using nginx precedence: exact name, longest leading wildcard, longest
+// trailing wildcard, first matching regex, then the default server for the port.
+// The second return value explains the choice
+func (config *Config) SelectServer(host string, port int) (*Block, string) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	var candidates []*Block
+	var defaultServer *Block
+	for _, server := range config.FindBlocksByName("server") {
+		if serverProtocol(server) != "http" {
+			continue
+		}
+		listens, isDefault := serverListenPorts(server)
+		if !listens[port] {
+			continue
+		}
+		candidates = append(candidates, server)
+		if isDefault[port] && defaultServer == nil {
+			defaultServer = server
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ""
+	}
+
+	var leading, trailing *Block
+	leadingLength, trailingLength := 0, 0
+	for _, server := range candidates {
+		for _, name := range ServerNames(server) {
+			switch {
+			case name == host:
+				return server, "exact server_name " + name
+			case strings.HasPrefix(name, "*.") || strings.HasPrefix(name, "."):
+				suffix := strings.TrimPrefix(name, "*")
+				if (strings.HasSuffix(host, suffix) || (name[0] == '.' && host == name[1:])) && len(name) > leadingLength {
+					leading, leadingLength = server, len(name)
+				}
+			case strings.HasSuffix(name, ".*"):
+				if strings.HasPrefix(host, strings.TrimSuffix(name, "*")) && len(name) > trailingLength {
+					trailing, trailingLength = server, len(name)
+				}
+			}
+		}
+	}
+	if leading != nil {
+		return leading, "leading wildcard server_name"
+	}
+	if trailing != nil {
+		return trailing, "trailing wildcard server_name"
+	}
+
+	for _, server := range candidates {
+		for _, name := range ServerNames(server) {
+			if !strings.HasPrefix(name, "~") {
+				continue
+			}
+			if re, err := regexp.Compile(name[1:]); err == nil && re.MatchString(host) {
+				return server, "regex server_name " + name
+			}
+		}
+	}
+
+	if defaultServer != nil {
+		return defaultServer, fmt.Sprintf("default_server for port %d", port)
+	}
+	return candidates[0], fmt.Sprintf("first server listening on port %d", port)
+}
+
+// ServerNames returns the lowercased, unquoted server_name values of a server block
+func ServerNames(server *Block) []string {
+	var names []string
+	for _, line := range server.FindLines("server_name") {
+		for _, param := range line.Params {
+			name := unquote(param)
+			if !strings.HasPrefix(name, "~") {
+				name = strings.ToLower(name)
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// serverListenPorts returns the ports a server listens on and which of them it is the default server for
+func serverListenPorts(server *Block) (map[int]bool, map[int]bool) {
+	ports := map[int]bool{}
+	defaults := map[int]bool{}
+
+	listens := server.FindLines("listen")
+	if len(listens) == 0 {
+		ports[defaultHTTPPort] = true
+	}
+	for _, line := range listens {
+		endpoint, err := parseListen(line.Params, "http")
+		if err != nil || endpoint.Unix {
+			continue
+		}
+		ports[endpoint.Port] = true
+		for _, param := range line.Params[1:] {
+			if param == "default_server" || param == "default" {
+				defaults[endpoint.Port] = true
+			}
+		}
+	}
+
+	return ports, defaults
+}
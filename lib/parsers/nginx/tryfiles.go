@@ -0,0 +1,154 @@
+package nginx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tryFilesConversion is an `if (-f $request_filename) { break; }` block RewriteToTryFiles
+// replaces, with the fallback of the try_files taking its place
+type tryFilesConversion struct {
+	ifBlock  *Block
+	fallback string // Last try_files parameter, the URI or =code requests for missing files get
+	handler  *Line  // rewrite or return handling requests for missing files, folded into the fallback
+}
+
+// RewriteToTryFiles replaces the `if (-f $request_filename) { break; }` antipattern
+// with try_files and returns the number of conversions. A rewrite to a fixed URI or
+// a return of a status code following the if handles the requests for missing files,
+// it becomes the try_files fallback: `rewrite ^ /index.php last;` turns into
+// `try_files $uri $uri/ /index.php;`. Without one the fallback is =404. Blocks whose
+// location has other rewrite or return directives, or a try_files already, are left
+// alone. Nothing is changed when an if is in a context try_files is not allowed in
+func (config *Config) RewriteToTryFiles() (modified int, err error) {
+	var conversions []tryFilesConversion
+	var failed error
+	config.WalkBlocks(func(block *Block) {
+		if failed != nil || !isFileExistsBreak(block) {
+			return
+		}
+		parent := block.ParentRef
+		if parent.Name != "server" && parent.Name != "location" {
+			failed = fmt.Errorf("line %d: try_files is not allowed in %q context", block.LineNumber, parent.Name)
+			return
+		}
+		if conversion, ok := planTryFiles(parent, block); ok {
+			conversions = append(conversions, conversion)
+		}
+	})
+	if failed != nil {
+		return 0, failed
+	}
+
+	for _, conversion := range conversions {
+		parent := conversion.ifBlock.ParentRef
+		index := -1
+		for i, line := range parent.Lines {
+			if line.BlockRef == conversion.ifBlock {
+				index = i
+				break
+			}
+		}
+		parent.RemoveBlock(conversion.ifBlock)
+		if conversion.handler != nil {
+			parent.RemoveLine(conversion.handler)
+		}
+		parent.InsertDirective(index, "try_files", "$uri", "$uri/", conversion.fallback)
+		modified++
+	}
+	if modified > 0 {
+		config.Dirty = true
+	}
+
+	return modified, nil
+}
+
+// planTryFiles works out the try_files replacing the if block in parent, reporting
+// false when the requests for missing files are handled in a way try_files cannot
+// express
+func planTryFiles(parent, ifBlock *Block) (tryFilesConversion, bool) {
+	conversion := tryFilesConversion{ifBlock: ifBlock, fallback: "=404"}
+	if len(parent.FindLines("try_files")) > 0 {
+		return conversion, false
+	}
+
+	after := false
+	for _, line := range parent.Lines {
+		if line.BlockRef == ifBlock {
+			after = true
+			continue
+		}
+		if line.Type == LineTypeBlock && line.Name == "if" {
+			return conversion, false
+		}
+		if line.Type != LineTypeDirective || line.Name != "rewrite" && line.Name != "return" {
+			continue
+		}
+		fallback, ok := tryFilesFallback(line)
+		if !after || !ok || conversion.handler != nil {
+			return conversion, false
+		}
+		conversion.fallback = fallback
+		conversion.handler = line
+	}
+	return conversion, true
+}
+
+// tryFilesFallback returns the try_files fallback equivalent to a rewrite of every
+// URI to a fixed one without redirecting, or to a return of a bare status code
+func tryFilesFallback(line *Line) (string, bool) {
+	if line.Name == "return" {
+		if len(line.Params) != 1 {
+			return "", false
+		}
+		code, err := strconv.Atoi(unquote(line.Params[0]))
+		if err != nil || code < 100 || code > 599 {
+			return "", false
+		}
+		return "=" + strconv.Itoa(code), true
+	}
+
+	if len(line.Params) < 2 || len(line.Params) > 3 {
+		return "", false
+	}
+	if _, universal := universalRewrites[unquote(line.Params[0])]; !universal {
+		return "", false
+	}
+	if len(line.Params) == 3 {
+		if flag := unquote(line.Params[2]); flag != "last" && flag != "break" {
+			return "", false
+		}
+	}
+	target := unquote(line.Params[1])
+	if !strings.HasPrefix(target, "/") || rewriteCapturePattern.MatchString(target) {
+		return "", false
+	}
+	return target, true
+}
+
+// isFileExistsBreak recognizes `if (-f $request_filename) { break; }`
+func isFileExistsBreak(block *Block) bool {
+	if block.Name != "if" || len(block.Blocks) > 0 {
+		return false
+	}
+
+	condition := strings.Join(block.Params, " ")
+	condition = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(condition, "("), ")"))
+	if condition != "-f $request_filename" && condition != "-e $request_filename" {
+		return false
+	}
+
+	directives := 0
+	for _, line := range block.Lines {
+		if line.Type == LineTypeComment {
+			continue
+		}
+		if line.Name != "break" || len(line.Params) > 0 {
+			return false
+		}
+		directives++
+	}
+
+	return directives == 1
+}
@@ -0,0 +1,109 @@
+package nginx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteToTryFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		want     string
+	}{
+		{
+			name:     "no handler",
+			location: "if (-f $request_filename) { break; }",
+			want:     "try_files $uri $uri/ =404;",
+		},
+		{
+			name:     "front controller",
+			location: "if (-f $request_filename) { break; } rewrite ^ /index.php last;",
+			want:     "try_files $uri $uri/ /index.php;",
+		},
+		{
+			name:     "front controller with query",
+			location: "if (-e $request_filename) { break; } rewrite ^(.*)$ /index.php?route=$uri;",
+			want:     "try_files $uri $uri/ /index.php?route=$uri;",
+		},
+		{
+			name:     "status code",
+			location: "if (-f $request_filename) { break; } return 403;",
+			want:     "try_files $uri $uri/ =403;",
+		},
+		{
+			name:     "capture in target",
+			location: "if (-f $request_filename) { break; } rewrite ^/(.*)$ /index.php?q=$1 last;",
+		},
+		{
+			name:     "redirect",
+			location: "if (-f $request_filename) { break; } rewrite ^ /index.php permanent;",
+		},
+		{
+			name:     "rewrite before if",
+			location: "rewrite ^ /app/index.php; if (-f $request_filename) { break; }",
+		},
+		{
+			name:     "several handlers",
+			location: "if (-f $request_filename) { break; } rewrite ^ /index.php last; return 404;",
+		},
+		{
+			name:     "existing try_files",
+			location: "try_files $uri =404; if (-f $request_filename) { break; } rewrite ^ /index.php last;",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "server { location / { " + tt.location + " } }"
+			config, err := ParseReader(strings.NewReader(input), "test.conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := config.AutoIndent(4)
+
+			modified, err := config.RewriteToTryFiles()
+			if err != nil {
+				t.Fatal(err)
+			}
+			location := config.RootBlock.Blocks[0].Blocks[0]
+			if tt.want == "" {
+				if modified != 0 || config.AutoIndent(4) != before {
+					t.Fatalf("converted a block try_files cannot replace:\n%s", config.AutoIndent(4))
+				}
+				return
+			}
+			if modified != 1 {
+				t.Fatalf("modified = %d, want 1", modified)
+			}
+			if len(location.Lines) != 1 || formatLine(location.Lines[0]) != tt.want {
+				t.Fatalf("location holds\n%s\nwant %s", config.AutoIndent(4), tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteToTryFilesContextError(t *testing.T) {
+	input := `
+server {
+    location / {
+        if (-f $request_filename) { break; }
+    }
+}
+http {
+    if (-f $request_filename) { break; }
+}
+`
+	config, err := ParseReader(strings.NewReader(input), "test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := config.AutoIndent(4)
+
+	if _, err := config.RewriteToTryFiles(); err == nil {
+		t.Fatal("expected an error for an if in the http context")
+	}
+	if after := config.AutoIndent(4); after != before {
+		t.Fatalf("configuration changed despite the error:\n%s", after)
+	}
+}
@@ -0,0 +1,113 @@
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeUnits maps nginx time suffixes to their length; a value without suffix is in seconds
+var timeUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+	"M":  30 * 24 * time.Hour,
+	"y":  365 * 24 * time.Hour,
+}
+
+// formatDuration writes a duration as an nginx time value in the largest unit up to
+// days that divides it, e.g. 30d, 90s or 1500ms
+func formatDuration(duration time.Duration) string {
+	for _, unit := range []string{"d", "h", "m", "s"} {
+		if duration%timeUnits[unit] == 0 {
+			return fmt.Sprintf("%d%s", duration/timeUnits[unit], unit)
+		}
+	}
+	return fmt.Sprintf("%dms", duration/time.Millisecond)
+}
+
+// ParseSize converts an nginx size value (e.g. "512", "64k", "10m", "1g") to bytes.
+// Like nginx it rejects sizes that do not fit in a signed 64-bit integer
+func ParseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	digits, multiplier := value, int64(1)
+	switch value[len(value)-1] {
+	case 'k', 'K':
+		multiplier = 1024
+	case 'm', 'M':
+		multiplier = 1024 * 1024
+	case 'g', 'G':
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier != 1 {
+		digits = value[:len(value)-1]
+	}
+
+	number, err := strconv.ParseInt(digits, 10, 64)
+	if errors.Is(err, strconv.ErrRange) || err == nil && number > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %q is too large", value)
+	}
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	return number * multiplier, nil
+}
+
+// ParseDuration converts an nginx time value (e.g. "30", "500ms", "1h30m") to a duration.
+// Times longer than a time.Duration holds, about 292 years, are rejected
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty time")
+	}
+
+	var total time.Duration
+	rest := value
+	for rest != "" {
+		digits := 0
+		for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+		if digits == 0 {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		number, err := strconv.ParseInt(rest[:digits], 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("time %q is too large", value)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		rest = rest[digits:]
+
+		unitLength := 0
+		for unitLength < len(rest) && (rest[unitLength] < '0' || rest[unitLength] > '9') {
+			unitLength++
+		}
+		unit := time.Second
+		if unitLength > 0 {
+			var ok bool
+			if unit, ok = timeUnits[rest[:unitLength]]; !ok {
+				return 0, fmt.Errorf("invalid time unit %q in %q", rest[:unitLength], value)
+			}
+		}
+		rest = rest[unitLength:]
+		if time.Duration(number) > (math.MaxInt64-total)/unit {
+			return 0, fmt.Errorf("time %q is too large", value)
+		}
+		total += time.Duration(number) * unit
+	}
+
+	return total, nil
+}
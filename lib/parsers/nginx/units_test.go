@@ -0,0 +1,112 @@
+package nginx
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{" 64k ", 64 * 1024},
+		{"64K", 64 * 1024},
+		{"10m", 10 * 1024 * 1024},
+		{"1G", 1024 * 1024 * 1024},
+		{"8589934591g", 8589934591 * 1024 * 1024 * 1024},
+		{"9223372036854775807", math.MaxInt64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSize(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("ParseSize(%q) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSizeErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"k",
+		"-1",
+		"1.5m",
+		"1t",
+		"10 m",
+		"99999999999g",
+		"8589934592g",
+		"9007199254740992k",
+		"9223372036854775808",
+	}
+
+	for _, value := range tests {
+		t.Run(value, func(t *testing.T) {
+			if size, err := ParseSize(value); err == nil {
+				t.Fatalf("ParseSize(%q) = %d, want an error", value, size)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"30", 30 * time.Second},
+		{"500ms", 500 * time.Millisecond},
+		{"1h30m", 90 * time.Minute},
+		{"1d12h", 36 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"1M", 30 * 24 * time.Hour},
+		{"1y", 365 * 24 * time.Hour},
+		{"292y", 292 * 365 * 24 * time.Hour},
+		{"9223372036s", 9223372036 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseDuration(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("ParseDuration(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+
+	for _, value := range []string{"", "h", "1x", "1.5h", "-1s", "999999999999y", "293y", "292y1y", "9223372036854775808", "9223372036854775807s", "9223372037s", "1s9223372036s"} {
+		if got, err := ParseDuration(value); err == nil {
+			t.Errorf("ParseDuration(%q) = %s, want an error", value, got)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		want     string
+	}{
+		{30 * 24 * time.Hour, "30d"},
+		{36 * time.Hour, "36h"},
+		{90 * time.Second, "90s"},
+		{5 * time.Minute, "5m"},
+		{1500 * time.Millisecond, "1500ms"},
+	}
+
+	for _, tt := range tests {
+		if got := formatDuration(tt.duration); got != tt.want {
+			t.Errorf("formatDuration(%s) = %q, want %q", tt.duration, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package nginx
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BalancingMethod is the load balancing method of an upstream
+type BalancingMethod string
+
+const (
+	BalancingRoundRobin BalancingMethod = "round_robin" // Weighted round robin, the default
+	BalancingIPHash     BalancingMethod = "ip_hash"     // Client address affinity
+	BalancingHash       BalancingMethod = "hash"        // Affinity on a key, optionally consistent
+	BalancingLeastConn  BalancingMethod = "least_conn"  // Fewest active connections
+	BalancingLeastTime  BalancingMethod = "least_time"  // Lowest response time (NGINX Plus)
+	BalancingRandom     BalancingMethod = "random"      // Weighted random, optionally the best of two
+)
+
+// UpstreamServer is a server directive of an upstream block
+type UpstreamServer struct {
+	Address     string        // Address as written, e.g. 10.0.0.1:8080 or unix:/run/app.sock
+	Weight      int           // Relative weight, 1 unless set
+	MaxFails    int           // Failed attempts within FailTimeout marking the server unavailable, 1 unless set, 0 disables
+	FailTimeout time.Duration // Window counting failures and time the server stays unavailable, 10s unless set
+	SlowStart   time.Duration // Time to ramp the weight up when the server recovers (NGINX Plus), 0 unless set
+	Down        bool          // Marked permanently unavailable
+	Backup      bool          // Only used when the primary servers are unavailable
+	Line        *Line         // Underlying server directive
+}
+
+// Upstream is a typed view of an upstream block
+type Upstream struct {
+	Block   *Block           // Underlying upstream block
+	Name    string           // Upstream name
+	Method  BalancingMethod  // Load balancing method
+	HashKey string           // Key of the hash method, empty for other methods
+	Servers []UpstreamServer // Members in order, including down and backup servers
+}
+
+// ServerShare is the steady-state fraction of requests an upstream member receives
+type ServerShare struct {
+	Server       UpstreamServer // Upstream member
+	Share        float64        // Fraction of requests between 0 and 1
+	FailoverOnly bool           // Backup server, receiving requests only when no primary server is available
+}
+
+// NewUpstream returns a typed view of an upstream block, or nil if the block is not one
+func NewUpstream(block *Block) *Upstream {
+	if block == nil || block.Name != "upstream" || len(block.Params) == 0 {
+		return nil
+	}
+
+	upstream := &Upstream{Block: block, Name: unquote(block.Params[0]), Method: BalancingRoundRobin}
+	for _, line := range block.Lines {
+		if line.Type != LineTypeDirective {
+			continue
+		}
+		switch line.Name {
+		case "ip_hash":
+			upstream.Method = BalancingIPHash
+		case "hash":
+			upstream.Method = BalancingHash
+			if len(line.Params) > 0 {
+				upstream.HashKey = unquote(line.Params[0])
+			}
+		case "least_conn":
+			upstream.Method = BalancingLeastConn
+		case "least_time":
+			upstream.Method = BalancingLeastTime
+		case "random":
+			upstream.Method = BalancingRandom
+		case "server":
+			if len(line.Params) == 0 {
+				continue
+			}
+			server := UpstreamServer{Address: unquote(line.Params[0]), Weight: 1, MaxFails: 1, FailTimeout: 10 * time.Second, Line: line}
+			for _, param := range line.Params[1:] {
+				switch {
+				case param == "down":
+					server.Down = true
+				case param == "backup":
+					server.Backup = true
+				case strings.HasPrefix(param, "weight="):
+					if weight, err := strconv.Atoi(strings.TrimPrefix(param, "weight=")); err == nil && weight > 0 {
+						server.Weight = weight
+					}
+				case strings.HasPrefix(param, "max_fails="):
+					if maxFails, err := strconv.Atoi(strings.TrimPrefix(param, "max_fails=")); err == nil && maxFails >= 0 {
+						server.MaxFails = maxFails
+					}
+				case strings.HasPrefix(param, "fail_timeout="):
+					if timeout, err := ParseDuration(strings.TrimPrefix(param, "fail_timeout=")); err == nil {
+						server.FailTimeout = timeout
+					}
+				case strings.HasPrefix(param, "slow_start="):
+					if slowStart, err := ParseDuration(strings.TrimPrefix(param, "slow_start=")); err == nil {
+						server.SlowStart = slowStart
+					}
+				}
+			}
+			upstream.Servers = append(upstream.Servers, server)
+		}
+	}
+	return upstream
+}
+
+// Upstreams returns the typed upstream blocks of the configuration
+func (config *Config) Upstreams() []*Upstream {
+	var upstreams []*Upstream
+	for _, block := range config.FindBlocksByName("upstream") {
+		if upstream := NewUpstream(block); upstream != nil {
+			upstreams = append(upstreams, upstream)
+		}
+	}
+	return upstreams
+}
+
+// Distribution computes the steady-state share of requests of each available member,
+// proportional to the weights of the servers that are neither down, backups nor
+// excluded. Excluding servers by address (or by host without the port) answers how
+// traffic shifts when they are pulled. Backup servers are listed separately as
+// failover-only with no share, unless no primary server is left, when they share
+// the traffic by weight. Down and excluded servers are omitted.
+//
+// The shares are exact for round robin and random. ip_hash and hash split traffic
+// by weight only on average over many clients or keys: each client or key sticks to
+// one server. least_conn and least_time depend on runtime connection counts and
+// response times, they are approximated as if every server were equally fast, which
+// makes them converge to the weighted split. See DistributionCaveat
+func (upstream *Upstream) Distribution(exclude ...string) []ServerShare {
+	var primaries, backups []UpstreamServer
+	for _, server := range upstream.Servers {
+		if server.Down || serverExcluded(server.Address, exclude) {
+			continue
+		}
+		if server.Backup {
+			backups = append(backups, server)
+		} else {
+			primaries = append(primaries, server)
+		}
+	}
+
+	var shares []ServerShare
+	active, failover := primaries, backups
+	if len(primaries) == 0 {
+		active, failover = backups, nil
+	}
+
+	total := 0
+	for _, server := range active {
+		total += server.Weight
+	}
+	for _, server := range active {
+		shares = append(shares, ServerShare{Server: server, Share: float64(server.Weight) / float64(total)})
+	}
+	for _, server := range failover {
+		shares = append(shares, ServerShare{Server: server, FailoverOnly: true})
+	}
+	return shares
+}
+
+// DistributionCaveat explains how far the balancing method lets Distribution
+// predict the actual traffic split, empty when the shares are exact
+func (upstream *Upstream) DistributionCaveat() string {
+	switch upstream.Method {
+	case BalancingIPHash:
+		return "ip_hash keeps each client on one server, the shares hold on average over many client networks"
+	case BalancingHash:
+		return "hash keeps each key on one server, the shares hold on average over many distinct keys"
+	case BalancingLeastConn:
+		return "least_conn depends on active connections at runtime, the shares are an equal-weight approximation assuming equally fast servers"
+	case BalancingLeastTime:
+		return "least_time depends on response times at runtime, the shares are an equal-weight approximation assuming equally fast servers"
+	}
+	return ""
+}
+
+// serverExcluded reports whether an upstream server address is in the exclude list,
+// either as written or by its host without the port
+func serverExcluded(address string, exclude []string) bool {
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+	for _, excluded := range exclude {
+		if excluded == address || excluded == host {
+			return true
+		}
+	}
+	return false
+}
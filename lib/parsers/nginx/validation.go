@@ -0,0 +1,32 @@
+package nginx
+
+import "fmt"
+
+// Severity represents how serious a validation finding is
+type Severity string
+
+const (
+	SeverityError   Severity = "error"   // Configuration will not work as intended
+	SeverityWarning Severity = "warning" // Configuration works but is likely a mistake
+	SeverityInfo    Severity = "info"    // Informational finding
+)
+
+// ValidationError represents a single finding produced by a configuration check
+type ValidationError struct {
+	Severity   Severity `json:"severity"`       // Severity of the finding
+	Rule       string   `json:"rule,omitempty"` // Name of the lint rule that produced the finding
+	Directive  string   `json:"directive"`      // Name of the directive or block the finding refers to
+	Message    string   `json:"message"`        // Human-readable description of the finding
+	LineNumber int      `json:"line,omitempty"` // Line number in the source file, 0 if unknown
+}
+
+// Error implements the error interface
+func (e ValidationError) Error() string {
+	if e.LineNumber > 0 {
+		return fmt.Sprintf("%s: line %d: %s: %s", e.Severity, e.LineNumber, e.Directive, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Severity, e.Directive, e.Message)
+}
+
+// ValidationIssue is an alias of ValidationError used by pre-flight and structural checks
+type ValidationIssue = ValidationError
@@ -0,0 +1,176 @@
+package nginx
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// variableDefiners maps directives that define a variable to the index of the parameter naming it
+var variableDefiners = map[string]int{
+	"set":              0,
+	"map":              1,
+	"geo":              -1, // geo [$address] $variable: the variable is always the last parameter
+	"split_clients":    1,
+	"perl_set":         0,
+	"js_set":           0,
+	"auth_request_set": 0,
+	"set_by_lua":       0,
+	"set_by_lua_block": 0,
+	"set_by_lua_file":  0,
+}
+
+// codeDirectives hold embedded code whose parameters are not scanned for variable references
+var codeDirectives = map[string]bool{
+	"perl":     true,
+	"perl_set": true,
+}
+
+// headerDirectives maps directives setting request or response headers to the index of
+// the parameter naming the header, -1 when each parameter is a "Name: value" pair
+var headerDirectives = map[string]int{
+	"add_header":             0,
+	"add_trailer":            0,
+	"proxy_set_header":       0,
+	"grpc_set_header":        0,
+	"more_set_headers":       -1,
+	"more_set_input_headers": -1,
+}
+
+// variablePattern matches $name and ${name} references
+var variablePattern = regexp.MustCompile(`\$(?:\{([A-Za-z0-9_]+)\}|([A-Za-z0-9_]+))`)
+
+// VariableDefinition is a place where a configuration defines a variable
+type VariableDefinition struct {
+	Name       string // Variable name without the leading $
+	Directive  string // Directive defining the variable
+	Block      *Block // Block holding the definition
+	LineNumber int    // Line number of the definition
+}
+
+// VariableReference is a place where a configuration uses a variable
+type VariableReference struct {
+	Name       string // Variable name without the leading $
+	Directive  string // Directive using the variable
+	Block      *Block // Block holding the reference
+	LineNumber int    // Line number of the reference
+	Header     string // Header whose value uses the variable, empty outside header directives
+}
+
+// VariableIndex lists the variables defined and referenced in a configuration
+type VariableIndex struct {
+	Definitions map[string][]VariableDefinition
+	References  map[string][]VariableReference
+}
+
+// Names returns the names of all defined variables in sorted order
+func (index *VariableIndex) Names() []string {
+	names := make([]string, 0, len(index.Definitions))
+	for name := range index.Definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Variables builds an index of the variables defined and referenced in the configuration
+func (config *Config) Variables() *VariableIndex {
+	index := &VariableIndex{
+		Definitions: map[string][]VariableDefinition{},
+		References:  map[string][]VariableReference{},
+	}
+
+	config.WalkBlocks(func(block *Block) {
+		for _, line := range block.Lines {
+			if line.Type == LineTypeComment {
+				continue
+			}
+			name, params := line.Name, line.Params
+			if line.BlockRef != nil {
+				name, params = line.BlockRef.Name, line.BlockRef.Params
+			}
+
+			defined := -1
+			if block.Name == "geoip2" && strings.HasPrefix(name, "$") {
+				// Lines of geoip2 blocks are named after the variable they define
+				variable := strings.TrimPrefix(name, "$")
+				index.Definitions[variable] = append(index.Definitions[variable], VariableDefinition{
+					Name:       variable,
+					Directive:  block.Name,
+					Block:      block,
+					LineNumber: line.LineNumber,
+				})
+			} else if position, ok := variableDefiners[name]; ok && len(params) > 0 {
+				defined = position
+				if position < 0 || position >= len(params) {
+					defined = len(params) - 1
+				}
+				variable := strings.TrimPrefix(unquote(params[defined]), "$")
+				index.Definitions[variable] = append(index.Definitions[variable], VariableDefinition{
+					Name:       variable,
+					Directive:  name,
+					Block:      block,
+					LineNumber: line.LineNumber,
+				})
+			}
+
+			for i, param := range params {
+				if i == defined || (codeDirectives[name] && i == len(params)-1) {
+					continue
+				}
+				header := headerName(name, params, i)
+				for _, match := range variablePattern.FindAllStringSubmatch(param, -1) {
+					variable := match[1] + match[2]
+					index.References[variable] = append(index.References[variable], VariableReference{
+						Name:       variable,
+						Directive:  name,
+						Block:      block,
+						LineNumber: line.LineNumber,
+						Header:     header,
+					})
+				}
+			}
+		}
+	})
+
+	return index
+}
+
+// HeaderReferences returns the references to variables in header values, such as
+// add_header X-Upstream $upstream_addr, sorted by line number
+func (index *VariableIndex) HeaderReferences() []VariableReference {
+	var references []VariableReference
+	for _, list := range index.References {
+		for _, reference := range list {
+			if reference.Header != "" {
+				references = append(references, reference)
+			}
+		}
+	}
+	sort.SliceStable(references, func(i, j int) bool {
+		if references[i].LineNumber != references[j].LineNumber {
+			return references[i].LineNumber < references[j].LineNumber
+		}
+		return references[i].Name < references[j].Name
+	})
+	return references
+}
+
+// headerName returns the header set by the value at index i of the parameters of a
+// header directive, empty for other directives and for the header name parameter
+func headerName(directive string, params []string, i int) string {
+	position, ok := headerDirectives[directive]
+	switch {
+	case !ok:
+		return ""
+	case position < 0:
+		name, _, found := strings.Cut(unquote(params[i]), ":")
+		if !found {
+			return ""
+		}
+		return strings.TrimSpace(name)
+	case i <= position:
+		return ""
+	}
+	return unquote(params[position])
+}
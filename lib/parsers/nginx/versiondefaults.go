@@ -0,0 +1,192 @@
+package nginx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// versionedDefault is the default value of a directive from a release on
+type versionedDefault struct {
+	Directive string
+	Since     string // First release using the value, "0" for the oldest known default
+	Value     string // Default value, empty when the directive does not exist yet
+}
+
+// defaultHistory lists the defaults that changed between nginx releases, in release
+// order per directive
+var defaultHistory = []versionedDefault{
+	{Directive: "ssl_protocols", Since: "0", Value: "SSLv3 TLSv1"},
+	{Directive: "ssl_protocols", Since: "1.1.13", Value: "SSLv3 TLSv1 TLSv1.1 TLSv1.2"},
+	{Directive: "ssl_protocols", Since: "1.9.1", Value: "TLSv1 TLSv1.1 TLSv1.2"},
+	{Directive: "ssl_protocols", Since: "1.23.4", Value: "TLSv1 TLSv1.1 TLSv1.2 TLSv1.3"},
+	{Directive: "ssl_protocols", Since: "1.27.3", Value: "TLSv1.2 TLSv1.3"},
+	{Directive: "ssl_ciphers", Since: "0", Value: "ALL:!ADH:RC4+RSA:+HIGH:+MEDIUM:+LOW:+SSLv2:+EXP"},
+	{Directive: "ssl_ciphers", Since: "1.0.5", Value: "HIGH:!aNULL:!MD5"},
+	{Directive: "ssl_ecdh_curve", Since: "1.1.0", Value: "prime256v1"},
+	{Directive: "ssl_ecdh_curve", Since: "1.11.0", Value: "auto"},
+	{Directive: "proxy_ssl_protocols", Since: "1.5.6", Value: "TLSv1 TLSv1.1 TLSv1.2"},
+	{Directive: "proxy_ssl_protocols", Since: "1.23.4", Value: "TLSv1 TLSv1.1 TLSv1.2 TLSv1.3"},
+	{Directive: "proxy_ssl_protocols", Since: "1.27.3", Value: "TLSv1.2 TLSv1.3"},
+	{Directive: "keepalive_requests", Since: "0", Value: "100"},
+	{Directive: "keepalive_requests", Since: "1.19.10", Value: "1000"},
+	{Directive: "keepalive_time", Since: "1.19.10", Value: "1h"},
+	{Directive: "sendfile_max_chunk", Since: "0", Value: "0"},
+	{Directive: "sendfile_max_chunk", Since: "1.21.4", Value: "2m"},
+}
+
+// versionSensitiveScopes selects the blocks in which the default of a directive
+// matters, those the directive is checked in
+var versionSensitiveScopes = map[string]func(block *Block) bool{
+	"ssl_protocols":       isSSLServer,
+	"ssl_ciphers":         isSSLServer,
+	"ssl_ecdh_curve":      isSSLServer,
+	"proxy_ssl_protocols": proxiesToHTTPS,
+	"keepalive_requests":  isHTTPServer,
+	"keepalive_time":      isHTTPServer,
+	"sendfile_max_chunk": func(block *Block) bool {
+		line := block.EffectiveDirective("sendfile")
+		return isHTTPServer(block) && line != nil && len(line.Params) > 0 && line.Params[0] == "on"
+	},
+}
+
+// VersionSensitiveDefaults reports directives left unset whose default differs
+// between the nginx releases from and to, such as ssl_protocols dropping TLSv1 and
+// TLSv1.1 in 1.27.3, in the blocks where the default applies: TLS servers for the
+// ssl_* directives, blocks proxying to https:// for proxy_ssl_protocols and http
+// servers for keepalive and sendfile limits. Versions are release numbers such as
+// "1.18.0" or "1.26"
+func (config *Config) VersionSensitiveDefaults(from, to string) []ValidationIssue {
+	fromVersion, err := parseNginxVersion(from)
+	if err != nil {
+		return []ValidationIssue{{Severity: SeverityError, Message: err.Error()}}
+	}
+	toVersion, err := parseNginxVersion(to)
+	if err != nil {
+		return []ValidationIssue{{Severity: SeverityError, Message: err.Error()}}
+	}
+
+	var issues []ValidationIssue
+	directives := []string{}
+	seen := map[string]bool{}
+	for _, entry := range defaultHistory {
+		if !seen[entry.Directive] {
+			seen[entry.Directive] = true
+			directives = append(directives, entry.Directive)
+		}
+	}
+
+	for _, directive := range directives {
+		before, _ := defaultAt(directive, fromVersion)
+		after, changed := defaultAt(directive, toVersion)
+		if before == after {
+			continue
+		}
+		if compareVersions(toVersion, fromVersion) < 0 {
+			// Downgrading, the change is the one of the newer release
+			_, changed = defaultAt(directive, fromVersion)
+		}
+
+		config.WalkBlocks(func(block *Block) {
+			if !versionSensitiveScopes[directive](block) || block.EffectiveDirective(directive) != nil {
+				return
+			}
+			issues = append(issues, ValidationIssue{
+				Severity:   SeverityWarning,
+				Directive:  directive,
+				Message:    fmt.Sprintf("%s is not set, its default is %s in nginx %s but %s in nginx %s (changed in %s)", directive, describeDefault(before), from, describeDefault(after), to, changed),
+				LineNumber: block.LineNumber,
+			})
+		})
+	}
+
+	return issues
+}
+
+// defaultAt returns the default of a directive in a release and the release that
+// introduced it
+func defaultAt(directive string, version []int) (string, string) {
+	value, since := "", ""
+	for _, entry := range defaultHistory {
+		if entry.Directive != directive {
+			continue
+		}
+		entryVersion, _ := parseNginxVersion(entry.Since)
+		if compareVersions(entryVersion, version) > 0 {
+			break
+		}
+		value, since = entry.Value, entry.Since
+	}
+	return value, since
+}
+
+// describeDefault quotes a default value, or explains that the directive does not exist
+func describeDefault(value string) string {
+	if value == "" {
+		return "absent (the directive does not exist)"
+	}
+	return strconv.Quote(value)
+}
+
+// parseNginxVersion parses a release number such as 1.25.3 into its components
+func parseNginxVersion(version string) ([]int, error) {
+	var parts []int
+	for _, field := range strings.Split(strings.TrimPrefix(strings.TrimSpace(version), "nginx/"), ".") {
+		part, err := strconv.Atoi(field)
+		if err != nil || part < 0 {
+			return nil, fmt.Errorf("invalid nginx version %q", version)
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// compareVersions orders two parsed versions, missing components counting as zero
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// isHTTPServer reports whether a block is a server of the http module
+func isHTTPServer(block *Block) bool {
+	return block.Name == "server" && serverProtocol(block) == "http"
+}
+
+// isSSLServer reports whether a block is an http or stream server terminating TLS
+func isSSLServer(block *Block) bool {
+	if block.Name != "server" || (serverProtocol(block) != "http" && serverProtocol(block) != "stream") {
+		return false
+	}
+	for _, endpoint := range serverEndpoints(block) {
+		if endpoint.SSL {
+			return true
+		}
+	}
+	// "ssl on" enabled TLS on every listen socket before nginx 1.25.1
+	line := block.EffectiveDirective("ssl")
+	return line != nil && len(line.Params) > 0 && line.Params[0] == "on"
+}
+
+// proxiesToHTTPS reports whether a block holds a proxy_pass to an https:// address
+func proxiesToHTTPS(block *Block) bool {
+	for _, line := range block.FindLines("proxy_pass") {
+		if len(line.Params) > 0 && strings.HasPrefix(strings.ToLower(unquote(line.Params[0])), "https://") {
+			return true
+		}
+	}
+	return false
+}
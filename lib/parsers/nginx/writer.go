@@ -0,0 +1,194 @@
+package nginx
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultIndent is the indentation used per nesting level when writing configurations
+const defaultIndent = "    "
+
+// defaultMaxWidth is the longest line a block collapsed by CompactShortBlocks may produce
+const defaultMaxWidth = 80
+
+// FormatOptions controls how Format writes a configuration
+type FormatOptions struct {
+	Indent string // Indentation per nesting level, none if empty
+
+	// CompactShortBlocks writes blocks holding a single directive on one line, e.g.
+	// "location = /favicon.ico { log_not_found off; }", when the line fits in
+	// MaxWidth. Blocks with comments stay expanded
+	CompactShortBlocks bool
+	MaxWidth           int // Longest collapsed line including indentation, 80 if zero
+}
+
+// WriteConfig writes the configuration to w in canonical nginx format. Configurations
+// parsed with ParseOptions.Lossless keep the source text of unchanged lines instead
+func (config *Config) WriteConfig(w io.Writer) error {
+	_, err := io.WriteString(w, config.String())
+	return err
+}
+
+// String returns the configuration as written by WriteConfig
+func (config *Config) String() string {
+	if config.Lossless {
+		return config.losslessText()
+	}
+
+	return config.Format(FormatOptions{Indent: defaultIndent})
+}
+
+// Format serializes the configuration in canonical format as the options ask,
+// regardless of how the source was written
+func (config *Config) Format(opts FormatOptions) string {
+	if opts.MaxWidth <= 0 {
+		opts.MaxWidth = defaultMaxWidth
+	}
+
+	var builder strings.Builder
+	writeBlockBody(&builder, config.RootBlock, 0, opts)
+	return builder.String()
+}
+
+// AutoIndent serializes the configuration in canonical format with exactly
+// indentSize spaces per nesting level, regardless of how the source was indented
+func (config *Config) AutoIndent(indentSize int) string {
+	if indentSize < 0 {
+		indentSize = 0
+	}
+
+	return config.Format(FormatOptions{Indent: strings.Repeat(" ", indentSize)})
+}
+
+// writeBlockBody writes the lines of a block, recursing into child blocks
+func writeBlockBody(builder *strings.Builder, block *Block, depth int, opts FormatOptions) {
+	prefix := strings.Repeat(opts.Indent, depth)
+
+	for i, line := range block.Lines {
+		if i > 0 && needsBlankLine(block.Lines[i-1], line) {
+			builder.WriteString("\n")
+		}
+
+		builder.WriteString(prefix + formatLine(line))
+		if line.Type == LineTypeBlock {
+			child := blockOf(line)
+			if child.Verbatim != "" {
+				builder.WriteString(formatVerbatim(child.Verbatim, prefix) + formatClosing(child) + "\n")
+				continue
+			}
+			if compact := compactBlock(child); opts.CompactShortBlocks && compact != "" && utf8.RuneCountInString(prefix+formatLine(line)+compact) <= opts.MaxWidth {
+				builder.WriteString(compact + "\n")
+				continue
+			}
+			builder.WriteString("\n")
+			writeBlockBody(builder, child, depth+1, opts)
+			builder.WriteString(prefix + formatClosing(child))
+		}
+		builder.WriteString("\n")
+	}
+}
+
+// formatLine renders a single line; block lines render as their opening "name params {"
+func formatLine(line *Line) string {
+	switch line.Type {
+	case LineTypeComment:
+		return formatComments(line.Comments)
+	case LineTypeBlock:
+		child := blockOf(line)
+		text := formatStatement(child.Name, child.Params) + " {"
+		if child.templated {
+			text = strings.Join(append([]string{child.Name}, child.Params...), " ") + " {"
+		}
+		if len(child.Comments) > 0 && child.Verbatim == "" {
+			text += " " + formatComments(child.Comments)
+		}
+		return text
+	case LineTypeTemplate:
+		text := line.Name
+		if len(line.Comments) > 0 {
+			text += " " + formatComments(line.Comments)
+		}
+		return text
+	default:
+		text := formatStatement(line.Name, line.Params) + ";"
+		if line.templated {
+			// Template spans are written as is, they hold whitespace and quotes
+			text = strings.Join(append([]string{line.Name}, line.Params...), " ") + ";"
+		}
+		if line.plugin != nil {
+			text = line.plugin.Serialize(line) + ";"
+		}
+		if len(line.Comments) > 0 {
+			text += " " + formatComments(line.Comments)
+		}
+		return text
+	}
+}
+
+// compactBlock renders the body and closing brace of a block holding a single
+// directive as " directive; }", empty if the block cannot be written on one line
+func compactBlock(block *Block) string {
+	if len(block.Lines) != 1 || len(block.Comments) > 0 || len(block.ClosingComments) > 0 {
+		return ""
+	}
+	line := block.Lines[0]
+	if line.Type == LineTypeComment || line.Type == LineTypeBlock || line.Type == LineTypeTemplate || len(line.Comments) > 0 {
+		return ""
+	}
+	return " " + formatLine(line) + " }"
+}
+
+// formatVerbatim renders the body of a verbatim block as written, aligning the
+// closing brace with the block when the body ends on its own line
+func formatVerbatim(body, prefix string) string {
+	end := strings.LastIndex(body, "\n")
+	if end >= 0 && strings.TrimSpace(body[end:]) == "" {
+		return body[:end+1] + prefix
+	}
+	return body
+}
+
+// formatClosing renders the closing brace of a block with its closing comments
+func formatClosing(block *Block) string {
+	if len(block.ClosingComments) > 0 {
+		return "} " + formatComments(block.ClosingComments)
+	}
+	return "}"
+}
+
+// blockOf returns the block opened by a block line, synthesizing one for lines without a reference
+func blockOf(line *Line) *Block {
+	if line.BlockRef != nil {
+		return line.BlockRef
+	}
+	return &Block{Name: line.Name, Params: line.Params, Comments: line.Comments}
+}
+
+// needsBlankLine reports whether a blank line separates two consecutive lines:
+// blocks are set apart from their siblings, but comments stay attached to what follows them
+func needsBlankLine(previous, current *Line) bool {
+	if current.Type == LineTypeBlock {
+		return previous.Type != LineTypeComment
+	}
+	return previous.Type == LineTypeBlock
+}
+
+// formatStatement joins a directive or block name with its parameters, quoting
+// those that would not read back as written (see formatParam)
+func formatStatement(name string, params []string) string {
+	text := name
+	for _, param := range params {
+		text += " " + formatParam(param)
+	}
+	return text
+}
+
+// formatComments renders comment text back into # comment form
+func formatComments(comments []string) string {
+	text := strings.Join(comments, " ")
+	if text == "" || strings.HasPrefix(text, "#") {
+		return "#" + text
+	}
+	return "# " + text
+}
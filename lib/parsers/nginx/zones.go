@@ -0,0 +1,206 @@
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Approximate number of entries one megabyte of shared memory holds, as documented by nginx
+const (
+	limitZoneStatesPerMB = 8000 // limit_req_zone / limit_conn_zone states (128-byte states)
+	cacheKeysPerMB       = 8000 // proxy_cache_path keys_zone keys
+	sslSessionsPerMB     = 4000 // ssl_session_cache sessions
+
+	// minSharedZoneSize is the smallest zone nginx accepts (8 pages of 4k)
+	minSharedZoneSize = 32 * 1024
+
+	// minLuaSharedDictSize is the smallest lua_shared_dict OpenResty accepts
+	minLuaSharedDictSize = 8 * 1024
+
+	// defaultAverageObjectSize is the assumed average cached response size
+	defaultAverageObjectSize = 64 * 1024
+)
+
+// cachePathDirectives lists the directives that declare a cache keys_zone
+var cachePathDirectives = map[string]bool{
+	"proxy_cache_path":   true,
+	"fastcgi_cache_path": true,
+	"uwsgi_cache_path":   true,
+	"scgi_cache_path":    true,
+}
+
+// SharedMemoryZone describes a single shared memory zone declared by the configuration
+type SharedMemoryZone struct {
+	Name     string // Zone name
+	Module   string // Directive that declares the zone (e.g. "limit_req_zone", "upstream")
+	Size     int64  // Zone size in bytes, 0 if not specified
+	Capacity int64  // Approximate number of keys/sessions/states the zone holds, 0 if not applicable
+	MaxSize  int64  // Cache max_size in bytes, 0 if unset or not a cache zone
+	Line     *Line  // Directive declaring the zone
+}
+
+// SharedMemoryOptions configures the shared memory report
+type SharedMemoryOptions struct {
+	AverageObjectSize int64 // Assumed average cached object size in bytes, defaults to 64k
+}
+
+// SharedMemoryReport summarizes the shared memory zones requested by the configuration
+type SharedMemoryReport struct {
+	Zones      []SharedMemoryZone // All zone declarations in configuration order
+	TotalBytes int64              // Total shared memory requested at startup
+	Issues     []ValidationError  // Undersized, oversubscribed and duplicate zones
+}
+
+// SharedMemoryReport parses every shared memory zone declaration, estimates its
+// capacity and flags zones that are too small or declared more than once
+func (config *Config) SharedMemoryReport(opts SharedMemoryOptions) *SharedMemoryReport {
+	if opts.AverageObjectSize <= 0 {
+		opts.AverageObjectSize = defaultAverageObjectSize
+	}
+
+	report := &SharedMemoryReport{}
+	for _, zone := range config.sharedMemoryZones() {
+		report.Zones = append(report.Zones, zone)
+		report.Issues = append(report.Issues, checkZoneSize(zone, opts)...)
+	}
+
+	// Zones are identified by name; nginx allocates a reused zone only once
+	seen := map[string]SharedMemoryZone{}
+	for _, zone := range report.Zones {
+		previous, duplicate := seen[zone.Name]
+		if !duplicate {
+			seen[zone.Name] = zone
+			report.TotalBytes += zone.Size
+			continue
+		}
+
+		switch {
+		case previous.Module != zone.Module:
+			report.Issues = append(report.Issues, zoneIssue(SeverityError, zone,
+				fmt.Sprintf("zone %q is already declared by %s on line %d for a different use",
+					zone.Name, previous.Module, previous.Line.LineNumber)))
+		case zone.Module == "ssl_session_cache" && previous.Size == zone.Size:
+			// Sharing one session cache between servers is the intended use
+		default:
+			report.Issues = append(report.Issues, zoneIssue(SeverityError, zone,
+				fmt.Sprintf("duplicate zone %q, first declared on line %d", zone.Name, previous.Line.LineNumber)))
+		}
+	}
+
+	return report
+}
+
+// sharedMemoryZones collects every zone declaration in the configuration
+func (config *Config) sharedMemoryZones() []SharedMemoryZone {
+	var zones []SharedMemoryZone
+
+	config.WalkBlocks(func(block *Block) {
+		for _, line := range block.Lines {
+			if line.Type != LineTypeDirective {
+				continue
+			}
+
+			switch {
+			case line.Name == "limit_req_zone" || line.Name == "limit_conn_zone":
+				if zone, ok := parseZoneParam(line, "zone", limitZoneStatesPerMB); ok {
+					zones = append(zones, zone)
+				}
+			case cachePathDirectives[line.Name]:
+				if zone, ok := parseZoneParam(line, "keys_zone", cacheKeysPerMB); ok {
+					if maxSize, err := ParseSize(line.KeyValueParams()["max_size"]); err == nil {
+						zone.MaxSize = maxSize
+					}
+					zones = append(zones, zone)
+				}
+			case line.Name == "ssl_session_cache":
+				zones = append(zones, parseSSLSessionCache(line)...)
+			case line.Name == "zone" && block.Name == "upstream" && len(line.Params) > 0:
+				zone := SharedMemoryZone{Name: line.Params[0], Module: "upstream", Line: line}
+				if len(line.Params) > 1 {
+					zone.Size, _ = ParseSize(line.Params[1])
+				}
+				zones = append(zones, zone)
+			case line.Name == "lua_shared_dict" && len(line.Params) > 0:
+				zone := SharedMemoryZone{Name: line.Params[0], Module: line.Name, Line: line}
+				if len(line.Params) > 1 {
+					zone.Size, _ = ParseSize(line.Params[1])
+				}
+				zones = append(zones, zone)
+			}
+		}
+	})
+
+	return zones
+}
+
+// parseZoneParam parses a name:size zone parameter such as zone=one:10m
+func parseZoneParam(line *Line, key string, entriesPerMB int64) (SharedMemoryZone, bool) {
+	value, ok := line.KeyValueParams()[key]
+	if !ok {
+		return SharedMemoryZone{}, false
+	}
+
+	name, sizeValue, _ := strings.Cut(value, ":")
+	zone := SharedMemoryZone{Name: name, Module: line.Name, Line: line}
+	if size, err := ParseSize(sizeValue); err == nil {
+		zone.Size = size
+		zone.Capacity = size * entriesPerMB / (1024 * 1024)
+	}
+
+	return zone, true
+}
+
+// parseSSLSessionCache parses the shared:NAME:size forms of ssl_session_cache
+func parseSSLSessionCache(line *Line) []SharedMemoryZone {
+	var zones []SharedMemoryZone
+	for _, param := range line.Params {
+		parts := strings.Split(param, ":")
+		if len(parts) != 3 || parts[0] != "shared" {
+			continue
+		}
+		zone := SharedMemoryZone{Name: parts[1], Module: line.Name, Line: line}
+		if size, err := ParseSize(parts[2]); err == nil {
+			zone.Size = size
+			zone.Capacity = size * sslSessionsPerMB / (1024 * 1024)
+		}
+		zones = append(zones, zone)
+	}
+	return zones
+}
+
+// checkZoneSize flags zones below the nginx minimum and caches whose keys_zone
+// cannot index max_size worth of objects
+func checkZoneSize(zone SharedMemoryZone, opts SharedMemoryOptions) []ValidationError {
+	var issues []ValidationError
+
+	minSize := int64(minSharedZoneSize)
+	if zone.Module == "lua_shared_dict" {
+		minSize = minLuaSharedDictSize
+	}
+	if zone.Size > 0 && zone.Size < minSize {
+		issues = append(issues, zoneIssue(SeverityError, zone,
+			fmt.Sprintf("zone %q is too small (%d bytes), nginx requires at least %d bytes",
+				zone.Name, zone.Size, minSize)))
+	}
+
+	if zone.MaxSize > 0 && zone.Capacity > 0 {
+		objects := zone.MaxSize / opts.AverageObjectSize
+		if objects > zone.Capacity {
+			issues = append(issues, zoneIssue(SeverityWarning, zone,
+				fmt.Sprintf("keys_zone %q indexes about %d keys but max_size holds about %d objects of %d bytes; cache will evict before it is full",
+					zone.Name, zone.Capacity, objects, opts.AverageObjectSize)))
+		}
+	}
+
+	return issues
+}
+
+// zoneIssue builds a validation error for a zone declaration
+func zoneIssue(severity Severity, zone SharedMemoryZone, message string) ValidationError {
+	return ValidationError{
+		Severity:   severity,
+		Directive:  zone.Module,
+		Message:    message,
+		LineNumber: zone.Line.LineNumber,
+	}
+}